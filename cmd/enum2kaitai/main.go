@@ -8,6 +8,7 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -15,17 +16,23 @@ import (
 	"strconv"
 	"strings"
 	"text/tabwriter"
-	"unicode"
 
 	"golang.org/x/tools/go/packages"
+
+	"github.com/mewrev/tools/internal/naming"
 )
 
 var (
 	typeNames   = flag.String("type", "", "comma-separated list of type names; must be set")
-	output      = flag.String("output", "", "output file name; default srcdir/<type>_string.go")
+	output      = flag.String("output", "", "output file name; default srcdir/<type>_enum.ksy")
 	trimprefix  = flag.String("trimprefix", "", "trim the `prefix` from the generated constant names")
 	linecomment = flag.Bool("linecomment", false, "use line comment text as printed text when present")
 	buildTags   = flag.String("tags", "", "comma-separated list of build tags to apply")
+	hexValues   = flag.Bool("hex", false, "format enum values as hexadecimal")
+	docs        = flag.Bool("doc", false, "output doc comments of constants as enum doc strings")
+	dedup       = flag.Bool("dedup", false, "omit constants with duplicate values, keeping the first")
+	flags       = flag.Bool("flags", false, "format enum values as hexadecimal and document their bit positions")
+	tagSets     = flag.String("tagsets", "", "semicolon-separated list of build configurations to union constants across; each a comma-separated list of build tags and KEY=VALUE environment variables (e.g. \"GOOS=linux;GOOS=windows,cgo\")")
 )
 
 // Usage is a replacement usage function for the flags package.
@@ -59,11 +66,15 @@ func main() {
 		args = []string{"."}
 	}
 
-	// Parse the package once.
+	// Parse the package, once per build configuration.
 	var dir string
 	g := Generator{
 		trimPrefix:  *trimprefix,
 		lineComment: *linecomment,
+		hex:         *hexValues,
+		doc:         *docs,
+		dedup:       *dedup,
+		flags:       *flags,
 	}
 	// TODO(suzmue): accept other patterns for packages (directories, list of files, import paths, etc).
 	if len(args) == 1 && isDirectory(args[0]) {
@@ -75,7 +86,27 @@ func main() {
 		dir = filepath.Dir(args[0])
 	}
 
-	g.parsePackage(args, tags)
+	if len(*tagSets) == 0 {
+		g.parsePackage(args, tags, nil)
+	} else {
+		// Load the package once per build configuration.
+		for _, set := range strings.Split(*tagSets, ";") {
+			setTags := append([]string(nil), tags...)
+			var env []string
+			for _, item := range strings.Split(set, ",") {
+				item = strings.TrimSpace(item)
+				switch {
+				case len(item) == 0:
+					// skip empty item.
+				case strings.Contains(item, "="):
+					env = append(env, item)
+				default:
+					setTags = append(setTags, item)
+				}
+			}
+			g.parsePackage(args, setTags, env)
+		}
+	}
 
 	// Print the header and package clause.
 	g.Printf("# Code generated by \"enum2kaitai %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
@@ -114,11 +145,15 @@ func isDirectory(name string) bool {
 // Generator holds the state of the analysis. Primarily used to buffer
 // the output for format.Source.
 type Generator struct {
-	buf bytes.Buffer // Accumulated output.
-	pkg *Package     // Package we are scanning.
+	buf  bytes.Buffer // Accumulated output.
+	pkgs []*Package   // Package we are scanning, once per build configuration.
 
 	trimPrefix  string
 	lineComment bool
+	hex         bool // Format enum values as hexadecimal.
+	doc         bool // Output doc comments as enum doc strings.
+	dedup       bool // Omit constants with duplicate values.
+	flags       bool // Document bit positions of enum values.
 }
 
 func (g *Generator) Printf(format string, args ...interface{}) {
@@ -141,11 +176,14 @@ type Package struct {
 	name  string
 	defs  map[*ast.Ident]types.Object
 	files []*File
+	// Build configuration of the package; e.g. "GOOS=linux,cgo".
+	config string
 }
 
-// parsePackage analyzes the single package constructed from the patterns and tags.
+// parsePackage analyzes the single package constructed from the patterns and
+// tags, using the given additional environment variables (e.g. GOOS=linux).
 // parsePackage exits if there is an error.
-func (g *Generator) parsePackage(patterns []string, tags []string) {
+func (g *Generator) parsePackage(patterns []string, tags []string, env []string) {
 	cfg := &packages.Config{
 		Mode: packages.LoadSyntax,
 		// TODO: Need to think about constants in test files. Maybe write type_string_test.go
@@ -153,6 +191,9 @@ func (g *Generator) parsePackage(patterns []string, tags []string) {
 		Tests:      false,
 		BuildFlags: []string{fmt.Sprintf("-tags=%s", strings.Join(tags, " "))},
 	}
+	if len(env) > 0 {
+		cfg.Env = append(os.Environ(), env...)
+	}
 	pkgs, err := packages.Load(cfg, patterns...)
 	if err != nil {
 		log.Fatal(err)
@@ -161,45 +202,93 @@ func (g *Generator) parsePackage(patterns []string, tags []string) {
 		log.Fatalf("error: %d packages found", len(pkgs))
 	}
 	g.addPackage(pkgs[0])
+	g.pkgs[len(g.pkgs)-1].config = strings.Join(append(env, tags...), ",")
 }
 
 // addPackage adds a type checked Package and its syntax files to the generator.
 func (g *Generator) addPackage(pkg *packages.Package) {
-	g.pkg = &Package{
+	p := &Package{
 		name:  pkg.Name,
 		defs:  pkg.TypesInfo.Defs,
 		files: make([]*File, len(pkg.Syntax)),
 	}
 
 	for i, file := range pkg.Syntax {
-		g.pkg.files[i] = &File{
+		p.files[i] = &File{
 			file:        file,
-			pkg:         g.pkg,
+			pkg:         p,
 			trimPrefix:  g.trimPrefix,
 			lineComment: g.lineComment,
 		}
 	}
+	g.pkgs = append(g.pkgs, p)
 }
 
 // generate produces the String method for the named type.
 func (g *Generator) generate(typeName string) {
-	values := make([]Value, 0, 100)
-	for _, file := range g.pkg.files {
-		// Set the state for this run of the walker.
-		file.typeName = typeName
-		file.values = nil
-		if file.file != nil {
-			ast.Inspect(file.file, file.genDecl)
-			values = append(values, file.values...)
+	var values []Value
+	for _, pkg := range g.pkgs {
+		var pkgValues []Value
+		for _, file := range pkg.files {
+			// Set the state for this run of the walker.
+			file.typeName = typeName
+			file.values = nil
+			if file.file != nil {
+				ast.Inspect(file.file, file.genDecl)
+				pkgValues = append(pkgValues, file.values...)
+			}
 		}
+		values = g.mergeValues(typeName, values, pkgValues, pkg.config)
 	}
 
 	if len(values) == 0 {
 		log.Fatalf("no values defined for type %s", typeName)
 	}
+	if len(g.pkgs) > 1 {
+		// Record the build configurations of constants not defined in all.
+		for i := range values {
+			if len(values[i].configs) == len(g.pkgs) {
+				values[i].configs = nil
+			}
+		}
+	}
 	g.outputEnums(typeName, values)
 }
 
+// mergeValues returns the union of the constant values of the given type
+// defined in previous build configurations and in the named build
+// configuration. Constants defined with different values in different build
+// configurations are reported as conflicts, keeping the first value; the build
+// configurations of conflicting values are not recorded as defining the
+// constant.
+func (g *Generator) mergeValues(typeName string, values, newValues []Value, config string) []Value {
+	if len(g.pkgs) == 1 {
+		return newValues
+	}
+	index := make(map[string]int)
+	for i, value := range values {
+		index[value.originalName] = i
+	}
+	for _, value := range newValues {
+		i, ok := index[value.originalName]
+		if !ok {
+			value.configs = []string{config}
+			index[value.originalName] = len(values)
+			values = append(values, value)
+			continue
+		}
+		prev := &values[i]
+		if prev.value != value.value {
+			log.Printf("warning: conflicting values of constant %s of type %s: %s (%s), %s (%s); using %s", value.originalName, typeName, prev.str, strings.Join(prev.configs, "; "), value.str, config, prev.str)
+			value.configs = []string{config}
+			prev.conflicts = append(prev.conflicts, value)
+			continue
+		}
+		prev.configs = append(prev.configs, config)
+	}
+	return values
+}
+
 // Value represents a declared constant.
 type Value struct {
 	originalName string // The name of the constant.
@@ -212,6 +301,14 @@ type Value struct {
 	value  uint64 // Will be converted to int64 when needed.
 	signed bool   // Whether the constant is a signed type.
 	str    string // The string representation given by the "go/constant" package.
+	doc    string // Doc comment of the constant.
+	hex    bool   // Whether the constant is declared in hex or as a bit flag.
+	// Build configurations defining the constant, if not defined in all
+	// configurations specified by -tagsets.
+	configs []string
+	// Conflicting values of the constant in other build configurations, with
+	// the build configuration of each value.
+	conflicts []Value
 }
 
 func (v *Value) String() string {
@@ -242,12 +339,18 @@ func (f *File) genDecl(node ast.Node) bool {
 	// The name of the type of the constants we are declaring.
 	// Can change if this is a multi-element declaration.
 	typ := ""
+	// The value expressions of the most recent ValueSpec with values, which
+	// carry down to subsequent ValueSpecs without values.
+	var valueExprs []ast.Expr
 	// Loop over the elements of the declaration. Each element is a ValueSpec:
 	// a list of names possibly followed by a type, possibly followed by values.
 	// If the type and value are both missing, we carry down the type (and value,
 	// but the "go/types" package takes care of that).
 	for _, spec := range decl.Specs {
 		vspec := spec.(*ast.ValueSpec) // Guaranteed to succeed as this is CONST.
+		if len(vspec.Values) > 0 {
+			valueExprs = vspec.Values
+		}
 		if vspec.Type == nil && len(vspec.Values) > 0 {
 			// "X = 1". With no type but a value. If the constant is untyped,
 			// skip this vspec and reset the remembered type.
@@ -283,7 +386,7 @@ func (f *File) genDecl(node ast.Node) bool {
 		// We now have a list of names (from one line of source code) all being
 		// declared with the desired type.
 		// Grab their names and actual values and store them in f.values.
-		for _, name := range vspec.Names {
+		for i, name := range vspec.Names {
 			if name.Name == "_" {
 				continue
 			}
@@ -316,6 +419,12 @@ func (f *File) genDecl(node ast.Node) bool {
 				signed:       info&types.IsUnsigned == 0,
 				str:          value.String(),
 			}
+			if vspec.Doc != nil {
+				v.doc = strings.TrimSpace(vspec.Doc.Text())
+			}
+			if i < len(valueExprs) {
+				v.hex = isHexExpr(valueExprs[i])
+			}
 			if c := vspec.Comment; f.lineComment && c != nil && len(c.List) == 1 {
 				v.name = strings.TrimSpace(c.Text())
 			} else {
@@ -345,20 +454,40 @@ func usize(n int) int {
 }
 
 func (g *Generator) outputEnums(typeName string, values []Value) {
-	g.Printf("  %s:\n", snakeCase(typeName))
+	g.Printf("  %s:\n", naming.Snake(typeName))
 	w := tabwriter.NewWriter(&g.buf, 0, 3, 1, ' ', 0)
+	seen := make(map[uint64]string)
 	for _, value := range values {
-		var val string
-		if value.signed {
-			val = strconv.FormatInt(int64(value.value), 10)
-		} else {
-			val = strconv.FormatUint(value.value, 10)
+		if g.dedup {
+			if prev, ok := seen[value.value]; ok {
+				log.Printf("omitting %s; duplicate value of %s", value.originalName, prev)
+				continue
+			}
+			seen[value.value] = value.originalName
 		}
+		val := g.formatValue(value)
 		enumNameWithoutPrefix := strings.TrimPrefix(value.originalName, typeName)
-		enumName := snakeCase(typeName) + "_" + snakeCase(enumNameWithoutPrefix)
+		enumName := naming.Snake(typeName) + "_" + naming.Snake(enumNameWithoutPrefix)
+		comment := g.comment(value)
+		if g.doc && len(value.doc) > 0 {
+			// Use the verbose enum form to output doc strings.
+			fmt.Fprintf(w, "    %s:\n", val)
+			fmt.Fprintf(w, "      id: %s", enumName)
+			if len(comment) > 0 {
+				fmt.Fprintf(w, "\t# %s", comment)
+			}
+			fmt.Fprintf(w, "\n")
+			// Write the doc string directly, so that its tabs are not aligned
+			// by the tab writer.
+			if err := w.Flush(); err != nil {
+				log.Fatalf("unable to flush tab writer; %v", err)
+			}
+			writeDoc(&g.buf, "      ", value.doc)
+			continue
+		}
 		fmt.Fprintf(w, "    %s: %s", val, enumName)
-		if g.lineComment {
-			fmt.Fprintf(w, "\t# %s", value.name)
+		if len(comment) > 0 {
+			fmt.Fprintf(w, "\t# %s", comment)
 		}
 		fmt.Fprintf(w, "\n")
 	}
@@ -367,21 +496,127 @@ func (g *Generator) outputEnums(typeName string, values []Value) {
 	}
 }
 
-// snakeCase returns the snake_case version of the given string.
-func snakeCase(s string) string {
-	out := &strings.Builder{}
-	prevUpper := true
-	for _, r := range s {
-		if unicode.IsUpper(r) {
-			if !prevUpper {
-				out.WriteRune('_')
-			}
-			out.WriteRune(unicode.ToLower(r))
-			prevUpper = true
-		} else {
-			out.WriteRune(r)
-			prevUpper = false
+// formatValue returns the string representation of the given enum value, in
+// decimal or hexadecimal notation.
+func (g *Generator) formatValue(value Value) string {
+	base := 10
+	prefix := ""
+	if g.hex || g.flags || value.hex {
+		base = 16
+		prefix = "0x"
+	}
+	if value.signed && int64(value.value) < 0 {
+		return "-" + prefix + strconv.FormatUint(uint64(-int64(value.value)), base)
+	}
+	return prefix + strconv.FormatUint(value.value, base)
+}
+
+// comment returns the line comment of the given enum value; comprising the
+// line comment text of the constant (if -linecomment is set), the original Go
+// identifier of constants declared in hex or as bit flags, the bit positions of
+// the value (if -flags is set), and the build configurations defining the
+// constant if not defined in all configurations as well as conflicting values
+// in other configurations (if -tagsets is set).
+func (g *Generator) comment(value Value) string {
+	var parts []string
+	if g.lineComment {
+		parts = append(parts, value.name)
+	}
+	if value.hex || g.flags {
+		parts = append(parts, "-orig-id: "+value.originalName)
+	}
+	if g.flags {
+		parts = append(parts, bitPositions(value.value))
+	}
+	if len(value.configs) > 0 {
+		parts = append(parts, "only: "+strings.Join(value.configs, " | "))
+	}
+	if len(value.conflicts) > 0 {
+		var conflicts []string
+		for _, c := range value.conflicts {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", g.formatValue(c), strings.Join(c.configs, " | ")))
+		}
+		parts = append(parts, "conflicts: "+strings.Join(conflicts, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// bitPositions returns a description of the bit positions set in the given
+// value; e.g. "bit 4" or "bits 0, 1, 4".
+func bitPositions(x uint64) string {
+	var bits []string
+	for i := uint(0); i < 64; i++ {
+		if x&(1<<i) != 0 {
+			bits = append(bits, strconv.Itoa(int(i)))
+		}
+	}
+	switch len(bits) {
+	case 0:
+		return "no bits"
+	case 1:
+		return "bit " + bits[0]
+	default:
+		return "bits " + strings.Join(bits, ", ")
+	}
+}
+
+// isHexExpr reports whether the given constant value expression is a
+// hexadecimal literal or a bit flag (e.g. 1 << iota), possibly combined by
+// bitwise operators or wrapped in a type conversion.
+func isHexExpr(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		return expr.Kind == token.INT && (strings.HasPrefix(expr.Value, "0x") || strings.HasPrefix(expr.Value, "0X"))
+	case *ast.ParenExpr:
+		return isHexExpr(expr.X)
+	case *ast.CallExpr:
+		return len(expr.Args) == 1 && isHexExpr(expr.Args[0])
+	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.SHL:
+			return true
+		case token.OR, token.AND, token.AND_NOT, token.XOR:
+			return isHexExpr(expr.X) || isHexExpr(expr.Y)
 		}
 	}
-	return out.String()
+	return false
+}
+
+// writeDoc writes the given doc string to w, as a YAML doc key at the given
+// indentation. Doc strings other than plain scalars (see isPlainDoc) are
+// written in literal block style.
+func writeDoc(w io.Writer, indent, doc string) {
+	if isPlainDoc(doc) {
+		fmt.Fprintf(w, "%sdoc: %s\n", indent, doc)
+		return
+	}
+	fmt.Fprintf(w, "%sdoc: |\n", indent)
+	for _, line := range strings.Split(doc, "\n") {
+		if len(line) == 0 {
+			fmt.Fprintf(w, "\n")
+			continue
+		}
+		fmt.Fprintf(w, "%s  %s\n", indent, line)
+	}
+}
+
+// isPlainDoc reports whether the given doc string is valid as a plain YAML
+// string scalar; i.e. a single line without comment and mapping indicators or
+// quotes, not starting with an indicator character (e.g. [ or -), and not
+// resolved as another type (e.g. true or 42).
+func isPlainDoc(doc string) bool {
+	if len(doc) == 0 || strings.ContainsAny(doc, "\n\t:#'\"") || strings.TrimSpace(doc) != doc {
+		return false
+	}
+	if strings.ContainsRune("-?,[]{}&*!|>%@`", rune(doc[0])) {
+		return false
+	}
+	switch strings.ToLower(doc) {
+	case "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	if _, err := strconv.ParseFloat(doc, 64); err == nil {
+		return false
+	}
+	return true
 }
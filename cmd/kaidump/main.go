@@ -0,0 +1,227 @@
+// The kaidump tool prints an annotated hexdump of a binary file, based on the
+// binary layout of a Go type.
+//
+// The layout is computed by the same type analysis as used by type2kaitai to
+// generate Kaitai specs and Go parsers, so the annotated hexdump shows the
+// binary file as parsed by the generated code. Each field is listed with its
+// offset, raw bytes, field path and decoded value.
+//
+// Example output:
+//
+//	00000000  7f 45 4c 46              Header.Magic     "\x7fELF" (valid contents)
+//	00000004  01 00                    Header.Version   1 (0x1)
+//	00000006  10                       Header.Kind      16 (0x10) KindC
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/load"
+)
+
+var (
+	typeName  = flag.String("type", "", "type name; must be set")
+	buildTags = flag.String("tags", "", "comma-separated list of build tags to apply")
+	endian    = flag.String("endian", "le", "byte order of the binary format (le or be)")
+	arch      = flag.String("arch", "amd64", "target architecture; determines the size of int, uint and uintptr")
+	offset    = flag.Int64("offset", 0, "offset in bytes of the type within the binary file")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of kaidump:\n")
+	fmt.Fprintf(os.Stderr, "\tkaidump [flags] -type T FILE.bin [directory]\n")
+	fmt.Fprintf(os.Stderr, "\tkaidump [flags] -type T FILE.bin files... # Must be a single package\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("kaidump: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if len(*typeName) == 0 || flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *endian != "le" && *endian != "be" {
+		log.Fatalf("invalid endianness %q; valid options: le, be", *endian)
+	}
+	var tags []string
+	if len(*buildTags) > 0 {
+		tags = strings.Split(*buildTags, ",")
+	}
+	binPath := flag.Arg(0)
+	patterns := flag.Args()[1:]
+	if len(patterns) == 0 {
+		// Default: process whole package in current directory.
+		patterns = []string{"."}
+	}
+	sizes := types.SizesFor("gc", *arch)
+	if sizes == nil {
+		log.Fatalf("unsupported target architecture %q", *arch)
+	}
+
+	// Compute binary layout of Go type.
+	_, t, err := load.PackageType(patterns, tags, *arch, *typeName)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	l := &layout.Layout{Sizes: sizes, Endian: *endian}
+	fields, layoutErr := l.Fields(t, *typeName)
+
+	// Print annotated hexdump.
+	buf, err := ioutil.ReadFile(binPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if *offset < 0 || *offset > int64(len(buf)) {
+		log.Fatalf("offset %d out of bounds of %q (%d bytes)", *offset, binPath, len(buf))
+	}
+	data := buf[*offset:]
+	width := 0
+	for _, f := range fields {
+		if len(f.Path) > width {
+			width = len(f.Path)
+		}
+	}
+	end := int64(0)
+	for _, f := range fields {
+		if f.Offset+f.Size > int64(len(data)) {
+			fmt.Printf("%08x  %-*s  unexpected end of file; need %d bytes\n", *offset+f.Offset, 3*16, "", f.Size)
+			os.Exit(1)
+		}
+		raw := data[f.Offset : f.Offset+f.Size]
+		printField(*offset+f.Offset, raw, f, width)
+		end = f.Offset + f.Size
+	}
+	if layoutErr != nil {
+		log.Printf("unable to compute remaining layout of %s; %v", *typeName, layoutErr)
+	}
+	if rest := int64(len(data)) - end; rest > 0 {
+		fmt.Printf("%08x  (%d trailing bytes)\n", *offset+end, rest)
+	}
+}
+
+// printField prints the raw bytes of the given field, 16 bytes per line,
+// annotated with the field path and decoded value on the first line.
+func printField(offset int64, raw []byte, f *layout.Field, width int) {
+	for i := 0; i < len(raw) || i == 0; i += 16 {
+		j := i + 16
+		if j > len(raw) {
+			j = len(raw)
+		}
+		hex := &strings.Builder{}
+		for _, b := range raw[i:j] {
+			fmt.Fprintf(hex, "%02x ", b)
+		}
+		line := fmt.Sprintf("%08x  %s", offset+int64(i), hex)
+		if i == 0 {
+			line = fmt.Sprintf("%08x  %-48s  %-*s  %s", offset, hex, width, f.Path, decode(raw, f))
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+// decode returns the decoded value of the given field.
+func decode(raw []byte, f *layout.Field) string {
+	switch f.Kind {
+	case layout.Contents:
+		if string(raw) != string(f.Contents) {
+			return fmt.Sprintf("%q (invalid contents; expected %q)", raw, f.Contents)
+		}
+		return fmt.Sprintf("%q (valid contents)", raw)
+	case layout.Bool:
+		return fmt.Sprint(raw[0] != 0)
+	case layout.Int, layout.Uint:
+		x := readUint(raw, f.Endian)
+		var s string
+		if f.Kind == layout.Int {
+			shift := 64 - 8*uint(f.Size)
+			v := int64(x<<shift) >> shift
+			s = fmt.Sprintf("%d (0x%x)", v, x)
+		} else {
+			s = fmt.Sprintf("%d (0x%x)", x, x)
+		}
+		if named, ok := f.Type.(*types.Named); ok {
+			if name := enumName(named, raw, f); len(name) > 0 {
+				s += " " + name
+			}
+		}
+		return s
+	case layout.Float:
+		x := readUint(raw, f.Endian)
+		if f.Size == 4 {
+			return fmt.Sprint(math.Float32frombits(uint32(x)))
+		}
+		return fmt.Sprint(math.Float64frombits(x))
+	case layout.Bytes:
+		return ""
+	case layout.Str:
+		return fmt.Sprintf("%q", raw)
+	case layout.Strz:
+		if pos := strings.IndexByte(string(raw), 0); pos != -1 {
+			raw = raw[:pos]
+		}
+		return fmt.Sprintf("%q", raw)
+	case layout.Pointer:
+		return fmt.Sprintf("0x%x (pointer)", readUint(raw, f.Endian))
+	case layout.Blank:
+		return "(blank)"
+	}
+	return ""
+}
+
+// enumName returns the name of the constant of the given enum type with the
+// value of the given field, or an empty string if not present.
+func enumName(t *types.Named, raw []byte, f *layout.Field) string {
+	pkg := t.Obj().Pkg()
+	if pkg == nil {
+		return ""
+	}
+	x := readUint(raw, f.Endian)
+	var names []string
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), t) {
+			continue
+		}
+		v, ok := constant.Uint64Val(c.Val())
+		if !ok {
+			// Negative value.
+			i, _ := constant.Int64Val(c.Val())
+			v = uint64(i)
+		}
+		if f.Size < 8 {
+			v &= 1<<(8*uint(f.Size)) - 1
+		}
+		if v == x {
+			names = append(names, c.Name())
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// readUint returns the unsigned integer stored in raw, using the given byte
+// order.
+func readUint(raw []byte, endian string) uint64 {
+	var buf [8]byte
+	if endian == "be" {
+		copy(buf[8-len(raw):], raw)
+		return binary.BigEndian.Uint64(buf[:])
+	}
+	copy(buf[:], raw)
+	return binary.LittleEndian.Uint64(buf[:])
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// maxRefined is the maximum number of failing attributes for which fixes are
+// suggested, in order of failure count.
+const maxRefined = 5
+
+// sample is a sample file of a corpus.
+type sample struct {
+	name string
+	data []byte
+}
+
+// result is the result of parsing a sample.
+type result struct {
+	sample *sample
+	// Parse error, if any.
+	err error
+	// Number of trailing bytes not consumed by the spec.
+	trailing int
+}
+
+// failure is a failing attribute of a corpus.
+type failure struct {
+	// Type path and identifier of the attribute.
+	typePath, id string
+	// Name of the attribute; e.g. header::entry.data.
+	name string
+	// Failed samples.
+	results []*result
+	// Observed contents of invalid contents attributes, by hexadecimal bytes.
+	contents map[string]int
+}
+
+// fix is a suggested fix of a spec.
+type fix struct {
+	desc string
+	// apply applies the fix to the YAML root mapping of the spec, and reports
+	// whether the fix applies.
+	apply func(root *yaml.Node) bool
+	// Number of samples parsed with the fix.
+	parsed int
+}
+
+// loadCorpus loads the sample files of the given directory, sorted by name.
+func loadCorpus(dir string) ([]*sample, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var samples []*sample
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, &sample{name: info.Name(), data: data})
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no sample files in directory %q", dir)
+	}
+	return samples, nil
+}
+
+// parseCorpus parses the given samples as the type of the given path of the
+// given Kaitai spec.
+func parseCorpus(buf []byte, typePath string, samples []*sample) ([]*result, error) {
+	spec, err := kaitai.ParseSpec(buf)
+	if err != nil {
+		return nil, err
+	}
+	t := spec.Type(typePath)
+	if t == nil {
+		return nil, fmt.Errorf("unable to locate type %q", typePath)
+	}
+	var results []*result
+	for _, s := range samples {
+		v, err := spec.Parse(t, s.data)
+		results = append(results, &result{sample: s, err: err, trailing: len(s.data) - v.End})
+	}
+	return results, nil
+}
+
+// countParsed returns the number of parsed samples of the given results.
+func countParsed(results []*result) int {
+	n := 0
+	for _, r := range results {
+		if r.err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// refineCorpus parses the samples of the given corpus directory as the type of
+// the given path of the given Kaitai spec, and reports the attributes failing
+// most often, along with the fixes of the spec parsing more samples.
+func refineCorpus(buf []byte, typePath, dir string) error {
+	samples, err := loadCorpus(dir)
+	if err != nil {
+		return err
+	}
+	results, err := parseCorpus(buf, typePath, samples)
+	if err != nil {
+		return err
+	}
+	parsed := countParsed(results)
+	fmt.Printf("%d samples; %d parsed, %d failed\n", len(samples), parsed, len(samples)-parsed)
+
+	// Failing attributes.
+	failures := collectFailures(results)
+	if len(failures) > 0 {
+		fmt.Printf("\nfailing attributes:\n")
+	}
+	for _, f := range failures {
+		r := f.results[0]
+		fmt.Printf("%6d  %s (e.g. %s: %v)\n", len(f.results), f.name, r.sample.name, r.err)
+		for _, hex := range sortedKeys(f.contents) {
+			fmt.Printf("        observed contents %s in %d samples\n", hex, f.contents[hex])
+		}
+	}
+
+	// Trailing bytes.
+	min, max, n := 0, 0, 0
+	for _, r := range results {
+		if r.err != nil || r.trailing == 0 {
+			continue
+		}
+		if n == 0 || r.trailing < min {
+			min = r.trailing
+		}
+		if r.trailing > max {
+			max = r.trailing
+		}
+		n++
+	}
+	switch {
+	case n > 0 && min == max:
+		fmt.Printf("\n%d parsed samples have %d trailing bytes not covered by the spec\n", n, min)
+	case n > 0:
+		fmt.Printf("\n%d parsed samples have %d to %d trailing bytes not covered by the spec\n", n, min, max)
+	}
+
+	// Suggested fixes.
+	var fixes []*fix
+	for _, fix := range candidateFixes(buf, failures) {
+		fixed, err := applyFix(buf, fix)
+		if err != nil {
+			continue
+		}
+		results, err := parseCorpus(fixed, typePath, samples)
+		if err != nil {
+			continue
+		}
+		if fix.parsed = countParsed(results); fix.parsed > parsed {
+			fixes = append(fixes, fix)
+		}
+	}
+	sort.SliceStable(fixes, func(i, j int) bool {
+		return fixes[i].parsed > fixes[j].parsed
+	})
+	if len(fixes) > 0 {
+		fmt.Printf("\nsuggested fixes:\n")
+	}
+	for _, fix := range fixes {
+		fmt.Printf("  %s: %d of %d samples parse (%d with spec)\n", fix.desc, fix.parsed, len(samples), parsed)
+	}
+	return nil
+}
+
+// collectFailures returns the failing attributes of the given results, in
+// order of failure count.
+func collectFailures(results []*result) []*failure {
+	var failures []*failure
+	byName := make(map[string]*failure)
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		e, ok := r.err.(*kaitai.Error)
+		if !ok {
+			// Error of the parsed type itself; e.g. missing parameters.
+			e = &kaitai.Error{Err: r.err}
+		}
+		name := "(type)"
+		typePath := ""
+		if e.Type != nil {
+			name = e.Type.Name + "." + e.ID
+			typePath = e.Type.Path
+		}
+		f, ok := byName[name]
+		if !ok {
+			f = &failure{typePath: typePath, id: e.ID, name: name, contents: make(map[string]int)}
+			byName[name] = f
+			failures = append(failures, f)
+		}
+		f.results = append(f.results, r)
+		if e.Value != nil && strings.HasPrefix(e.Err.Error(), "invalid contents") {
+			if data, ok := e.Value.Val.([]byte); ok {
+				f.contents[fmt.Sprintf("%X", data)]++
+			}
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return len(failures[i].results) > len(failures[j].results)
+	})
+	return failures
+}
+
+var (
+	// reIdent matches identifiers of expressions.
+	reIdent = regexp.MustCompile(`[a-z_][a-z0-9_]*`)
+	// reMultiByte matches multi-byte integer and float types; e.g. u4be.
+	reMultiByte = regexp.MustCompile(`^([usf][248])(le|be)?$`)
+)
+
+// candidateFixes returns the candidate fixes of the given failing attributes
+// of the given Kaitai spec; flipped byte orders (of the spec, of the failing
+// attributes and of the attributes of their sizes and counts), sizes and
+// counts off by one, and counts replaced by repetition until the end of the
+// stream.
+func candidateFixes(buf []byte, failures []*failure) []*fix {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil || len(doc.Content) != 1 {
+		return nil
+	}
+	root := doc.Content[0]
+	spec, err := kaitai.ParseSpec(buf)
+	if err != nil {
+		return nil
+	}
+	var fixes []*fix
+	if endian := spec.Type("").Endian; len(endian) > 0 {
+		flipped := flipEndian(endian)
+		fixes = append(fixes, &fix{
+			desc: fmt.Sprintf("set endian of the spec to %s", flipped),
+			apply: func(root *yaml.Node) bool {
+				forTypes(root, "", func(t *yaml.Node, path string) {
+					if e := kaitai.Lookup(kaitai.Lookup(t, "meta"), "endian"); e != nil && e.Kind == yaml.ScalarNode {
+						e.Value = flipEndian(e.Value)
+					}
+				})
+				return true
+			},
+		})
+	}
+	if len(failures) > maxRefined {
+		failures = failures[:maxRefined]
+	}
+	for _, f := range failures {
+		t := spec.Type(f.typePath)
+		a := attrNode(typeNode(root, f.typePath), f.id)
+		if t == nil || a == nil {
+			continue
+		}
+		fixes = append(fixes, endianFix(t, f.id, kaitai.LookupValue(a, "type"))...)
+		for _, key := range []string{"size", "repeat-expr"} {
+			expr := kaitai.LookupValue(a, key)
+			if len(expr) == 0 {
+				continue
+			}
+			for _, delta := range []string{" - 1", " + 1"} {
+				fixes = append(fixes, setKeyFix(f, key, offByOne(expr, delta)))
+			}
+			// Byte order of the attributes of the size or count.
+			seen := make(map[string]bool)
+			for _, id := range reIdent.FindAllString(expr, -1) {
+				if ref := attrNode(typeNode(root, f.typePath), id); ref != nil && !seen[id] {
+					seen[id] = true
+					fixes = append(fixes, endianFix(t, id, kaitai.LookupValue(ref, "type"))...)
+				}
+			}
+		}
+		if kaitai.LookupValue(a, "repeat") == "expr" {
+			typePath, id := f.typePath, f.id
+			fixes = append(fixes, &fix{
+				desc: fmt.Sprintf("repeat of %s: eos", f.name),
+				apply: func(root *yaml.Node) bool {
+					a := attrNode(typeNode(root, typePath), id)
+					setKey(a, "repeat", "eos")
+					deleteKey(a, "repeat-expr")
+					return true
+				},
+			})
+		}
+	}
+	return fixes
+}
+
+// endianFix returns the fix flipping the byte order of the given attribute of
+// the given type, if of a multi-byte type.
+func endianFix(t *kaitai.Type, id, typ string) []*fix {
+	m := reMultiByte.FindStringSubmatch(typ)
+	if m == nil {
+		return nil
+	}
+	typePath, endian := t.Path, t.Endian
+	if len(m[2]) > 0 {
+		endian = m[2]
+	}
+	if len(endian) == 0 {
+		return nil
+	}
+	flipped := m[1] + flipEndian(endian)
+	return []*fix{{
+		desc: fmt.Sprintf("type of %s.%s: %s", t.Name, id, flipped),
+		apply: func(root *yaml.Node) bool {
+			setKey(attrNode(typeNode(root, typePath), id), "type", flipped)
+			return true
+		},
+	}}
+}
+
+// setKeyFix returns the fix setting the given key of the given failing
+// attribute to the given value.
+func setKeyFix(f *failure, key, value string) *fix {
+	typePath, id := f.typePath, f.id
+	return &fix{
+		desc: fmt.Sprintf("%s of %s: %s", key, f.name, value),
+		apply: func(root *yaml.Node) bool {
+			setKey(attrNode(typeNode(root, typePath), id), key, value)
+			return true
+		},
+	}
+}
+
+// applyFix returns the given Kaitai spec with the given fix applied.
+func applyFix(buf []byte, fix *fix) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) != 1 || !fix.apply(doc.Content[0]) {
+		return nil, fmt.Errorf("fix %q not applicable", fix.desc)
+	}
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// offByOne returns the given expression offset by the given delta (e.g.
+// " - 1").
+func offByOne(expr, delta string) string {
+	if reIdent.MatchString(expr) && reIdent.FindString(expr) == expr {
+		return expr + delta
+	}
+	return "(" + expr + ")" + delta
+}
+
+// flipEndian returns the opposite of the given byte order.
+func flipEndian(endian string) string {
+	if endian == "be" {
+		return "le"
+	}
+	return "be"
+}
+
+// typeNode returns the YAML mapping of the type of the given path, or nil if
+// not present.
+func typeNode(root *yaml.Node, path string) *yaml.Node {
+	t := root
+	if len(path) == 0 {
+		return t
+	}
+	for _, name := range strings.Split(path, "::") {
+		t = kaitai.Lookup(kaitai.Lookup(t, "types"), name)
+	}
+	return t
+}
+
+// attrNode returns the YAML mapping of the seq attribute or instance of the
+// given identifier of the given type mapping, or nil if not present.
+func attrNode(t *yaml.Node, id string) *yaml.Node {
+	if seq := kaitai.Lookup(t, "seq"); seq != nil {
+		for _, a := range seq.Content {
+			if kaitai.LookupValue(a, "id") == id {
+				return a
+			}
+		}
+	}
+	return kaitai.Lookup(kaitai.Lookup(t, "instances"), id)
+}
+
+// forTypes calls f for the given type mapping of the given path and its nested
+// types.
+func forTypes(t *yaml.Node, path string, f func(t *yaml.Node, path string)) {
+	f(t, path)
+	if types := kaitai.Lookup(t, "types"); types != nil && types.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(types.Content); i += 2 {
+			name := types.Content[i].Value
+			if len(path) > 0 {
+				name = path + "::" + name
+			}
+			forTypes(types.Content[i+1], name, f)
+		}
+	}
+}
+
+// setKey sets the given key of the given YAML mapping to the given scalar
+// value.
+func setKey(m *yaml.Node, key, value string) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	if v := kaitai.Lookup(m, key); v != nil {
+		v.Kind, v.Tag, v.Value, v.Content = yaml.ScalarNode, "", value, nil
+		return
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// deleteKey deletes the given key of the given YAML mapping, if present.
+func deleteKey(m *yaml.Node, key string) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// sortedKeys returns the keys of the given map, sorted.
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
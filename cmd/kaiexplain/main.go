@@ -0,0 +1,189 @@
+// The kaiexplain tool explains a binary file by parsing it according to a
+// Kaitai spec (.ksy file), hand-written or generated by type2kaitai, and
+// printing a tree of the decoded values with their byte ranges.
+//
+// The spec is interpreted at runtime (see package internal/kaitai), supporting
+// the commonly used subset of Kaitai; seq attributes, instances, parameters,
+// enums, contents, sizes, terminators, repetitions, conditions, switch types
+// and expressions. No Kaitai compiler or runtime is required. If parsing fails,
+// the values parsed up to the error are printed before the error is reported.
+//
+// With the -corpus flag, each file of a directory of sample files is parsed
+// instead, and the attributes failing most often are reported; e.g. to refine
+// a generated spec against real files. Fixes of the spec are suggested for the
+// failing attributes (e.g. flipped byte order, or sizes and counts off by one),
+// as tried against the samples.
+//
+// With the -sniff-endian flag, the byte order of the spec and of each
+// multi-byte integer attribute is sniffed from a directory of sample files
+// instead, by parsing the samples with both byte orders and scoring the
+// plausibility of the values (e.g. successful parses, lengths within the file
+// size, known enum values). With -w, the spec is annotated in place with the
+// byte orders sniffed with high confidence.
+//
+// Example output:
+//
+//	00000000-00000010  header: header
+//	00000000-00000004    magic = [7f 45 4c 46]
+//	00000004-00000006    version = 1 (0x1)
+//	00000006-00000007    kind = 16 (0x10) kind_c
+//	00000010-00000018  entries (2 elements)
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+var (
+	typeName = flag.String("type", "", "type name (e.g. header or header::entry); default the top-level type, or the first user type")
+	maxBytes = flag.Int("max-bytes", 16, "maximum number of bytes printed of byte array values")
+	corpus   = flag.Bool("corpus", false, "parse directory of sample files and report failing attributes")
+	sniff    = flag.Bool("sniff-endian", false, "sniff byte order of integer attributes from directory of sample files")
+	write    = flag.Bool("w", false, "annotate spec file in place with byte orders sniffed with high confidence (with -sniff-endian)")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of kaiexplain:\n")
+	fmt.Fprintf(os.Stderr, "\tkaiexplain [flags] SPEC.ksy FILE.bin\n")
+	fmt.Fprintf(os.Stderr, "\tkaiexplain -corpus [flags] SPEC.ksy DIR\n")
+	fmt.Fprintf(os.Stderr, "\tkaiexplain -sniff-endian [-w] [flags] SPEC.ksy DIR\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("kaiexplain: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ksyPath, binPath := flag.Arg(0), flag.Arg(1)
+	buf, err := ioutil.ReadFile(ksyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	spec, err := kaitai.ParseSpec(buf)
+	if err != nil {
+		log.Fatalf("%s: %v", ksyPath, err)
+	}
+	t := spec.DefaultType()
+	if len(*typeName) > 0 {
+		if t = spec.Type(*typeName); t == nil {
+			log.Fatalf("%s: unable to locate type %q", ksyPath, *typeName)
+		}
+	}
+	if *corpus {
+		if err := refineCorpus(buf, t.Path, binPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *sniff {
+		annotated, err := sniffEndian(buf, t.Path, binPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *write && !bytes.Equal(buf, annotated) {
+			if err := ioutil.WriteFile(ksyPath, annotated, 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+	data, err := ioutil.ReadFile(binPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	v, err := spec.Parse(t, data)
+	printValue(v, 0)
+	if err != nil {
+		log.Printf("%s: %v", binPath, err)
+		os.Exit(1)
+	}
+	if rest := len(data) - v.End; rest > 0 {
+		fmt.Printf("%08x  (%d trailing bytes)\n", v.End, rest)
+	}
+}
+
+// printValue prints the given value and its nested values, indented by the
+// given depth.
+func printValue(v *kaitai.Value, depth int) {
+	rng := strings.Repeat(" ", 17)
+	if v.Start >= 0 {
+		rng = fmt.Sprintf("%08x-%08x", v.Start, v.End)
+	}
+	indent := strings.Repeat("  ", depth)
+	switch {
+	case v.Array:
+		fmt.Printf("%s  %s%s (%d elements)\n", rng, indent, v.ID, len(v.Elems))
+		for _, elem := range v.Elems {
+			printValue(elem, depth+1)
+		}
+	case v.Type != nil:
+		fmt.Printf("%s  %s%s: %s\n", rng, indent, v.ID, v.Type.Name)
+		for _, f := range v.Fields {
+			printValue(f, depth+1)
+		}
+	default:
+		fmt.Printf("%s  %s%s = %s\n", rng, indent, v.ID, format(v))
+	}
+}
+
+// format returns the given scalar value in human-readable form; integers in
+// decimal and hexadecimal followed by the name of their enum value, if any,
+// and byte arrays in hexadecimal.
+func format(v *kaitai.Value) string {
+	switch x := v.Val.(type) {
+	case nil:
+		return "(absent)"
+	case uint64:
+		return fmt.Sprintf("%d (0x%x)%s", x, x, enumSuffix(v))
+	case int64:
+		return fmt.Sprintf("%d (%#x)%s", x, x, enumSuffix(v))
+	case string:
+		return fmt.Sprintf("%q", x)
+	case []byte:
+		hex := &strings.Builder{}
+		for i, b := range x {
+			if i == *maxBytes {
+				fmt.Fprintf(hex, " ... (%d bytes)", len(x))
+				break
+			}
+			if i > 0 {
+				hex.WriteString(" ")
+			}
+			fmt.Fprintf(hex, "%02x", b)
+		}
+		return "[" + hex.String() + "]"
+	case *kaitai.Value:
+		if x.Array {
+			return fmt.Sprintf("%s (%d elements)", x.ID, len(x.Elems))
+		}
+		return fmt.Sprintf("%s of type %s", x.ID, x.Type.Name)
+	}
+	return fmt.Sprint(v.Val)
+}
+
+// enumSuffix returns the name of the enum value of the given value, preceded
+// by a space, or an empty string if the value has no enum.
+func enumSuffix(v *kaitai.Value) string {
+	if len(v.Enum) == 0 {
+		return ""
+	}
+	if len(v.EnumName) == 0 {
+		return fmt.Sprintf(" (unknown value of enum %s)", v.Enum)
+	}
+	return " " + v.EnumName
+}
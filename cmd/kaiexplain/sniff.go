@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// minConfidence is the minimum fraction of deciding samples in favour of a
+// byte order for the spec to be annotated.
+const minConfidence = 0.9
+
+// intField is a multi-byte integer seq attribute of a Kaitai spec.
+type intField struct {
+	// Type path and identifier of the attribute.
+	typePath, id string
+	// Integer type without byte order; e.g. u4.
+	base string
+}
+
+// verdict is the byte order sniffed from the samples of a corpus.
+type verdict struct {
+	// Number of samples in favour of each byte order, and of undecided samples.
+	le, be, undecided int
+}
+
+// decide returns the byte order in favour of which the given numbers of
+// samples are, and whether the confidence is high.
+func (v *verdict) decide() (endian string, confident bool) {
+	votes := v.le + v.be
+	switch {
+	case v.le > v.be:
+		endian = "le"
+	case v.be > v.le:
+		endian = "be"
+	default:
+		return "", false
+	}
+	best := v.le
+	if endian == "be" {
+		best = v.be
+	}
+	return endian, float64(best) >= minConfidence*float64(votes)
+}
+
+// String returns the sniffed byte order with the numbers of samples in favour.
+func (v *verdict) String() string {
+	endian, confident := v.decide()
+	if len(endian) == 0 {
+		return fmt.Sprintf("undecided (%d le, %d be, %d undecided samples)", v.le, v.be, v.undecided)
+	}
+	conf := "low"
+	if confident {
+		conf = "high"
+	}
+	return fmt.Sprintf("%s, %s confidence (%d le, %d be, %d undecided samples)", endian, conf, v.le, v.be, v.undecided)
+}
+
+// score is the plausibility of the integer values of a sample parsed with a
+// byte order.
+type score struct {
+	// Sample parsed successfully.
+	ok bool
+	// Number of plausible and total values.
+	plausible, total int
+}
+
+// better reports whether the score is better than the given score; a
+// successful parse first, and a larger fraction of plausible values second.
+func (s score) better(t score) bool {
+	if s.ok != t.ok {
+		return s.ok
+	}
+	if s.total == 0 || t.total == 0 {
+		return false
+	}
+	return s.plausible*t.total > t.plausible*s.total
+}
+
+// sniffer sniffs the byte order of the integer attributes of a Kaitai spec
+// from the samples of a corpus.
+type sniffer struct {
+	typePath string
+	samples  []*sample
+	// Multi-byte integer attributes of the spec, in order of occurrence.
+	fields []*intField
+	// Attributes referenced by the sizes, counts and positions of attributes,
+	// by type path and identifier (e.g. header::entry.len).
+	lengths map[string]bool
+}
+
+// sniffEndian sniffs the byte order of the spec and of each multi-byte integer
+// attribute of the given Kaitai spec from the samples of the given corpus
+// directory, trying both byte orders and scoring the plausibility of the
+// parsed values (e.g. successful parses, lengths within the file size, known
+// enum values). The spec annotated with the byte orders of high confidence is
+// returned.
+func sniffEndian(buf []byte, typePath, dir string) ([]byte, error) {
+	samples, err := loadCorpus(dir)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil || len(doc.Content) != 1 {
+		return nil, fmt.Errorf("invalid Kaitai spec")
+	}
+	root := doc.Content[0]
+	sn := &sniffer{typePath: typePath, samples: samples, lengths: make(map[string]bool)}
+	forTypes(root, "", func(t *yaml.Node, path string) {
+		for _, a := range seqAttrs(t) {
+			if m := reMultiByte.FindStringSubmatch(kaitai.LookupValue(a, "type")); m != nil && m[1][0] != 'f' {
+				sn.fields = append(sn.fields, &intField{typePath: path, id: kaitai.LookupValue(a, "id"), base: m[1]})
+			}
+			for _, key := range []string{"size", "repeat-expr", "pos"} {
+				for _, id := range reIdent.FindAllString(kaitai.LookupValue(a, key), -1) {
+					sn.lengths[path+"."+id] = true
+				}
+			}
+		}
+	})
+	spec, err := kaitai.ParseSpec(buf)
+	if err != nil {
+		return nil, err
+	}
+	var edits []*edit
+
+	// Byte order of the spec.
+	base := buf
+	if endian := spec.Type("").Endian; len(endian) > 0 {
+		v, err := sn.sniff(buf, func(root *yaml.Node, endian string) {
+			forTypes(root, "", func(t *yaml.Node, path string) {
+				if e := kaitai.Lookup(kaitai.Lookup(t, "meta"), "endian"); e != nil && e.Kind == yaml.ScalarNode {
+					e.Value = endian
+				}
+			})
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("spec: %v\n", v)
+		if sniffed, confident := v.decide(); confident && sniffed != endian {
+			forTypes(root, "", func(t *yaml.Node, path string) {
+				if e := kaitai.Lookup(kaitai.Lookup(t, "meta"), "endian"); e != nil && e.Kind == yaml.ScalarNode && e.Value != sniffed {
+					edits = append(edits, &edit{node: e, value: sniffed})
+				}
+			})
+			if base, err = applyEdits(buf, edits); err != nil {
+				return nil, err
+			}
+			if spec, err = kaitai.ParseSpec(base); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Byte order of each integer attribute.
+	for _, f := range sn.fields {
+		t := spec.Type(f.typePath)
+		a := attrNode(typeNode(root, f.typePath), f.id)
+		if t == nil || a == nil {
+			continue
+		}
+		v, err := sn.sniff(base, func(root *yaml.Node, endian string) {
+			setKey(attrNode(typeNode(root, f.typePath), f.id), "type", f.base+endian)
+		}, func(t *kaitai.Type, id string) bool {
+			return t.Path == f.typePath && id == f.id
+		})
+		if err != nil {
+			return nil, err
+		}
+		note := ""
+		current := strings.TrimPrefix(kaitai.LookupValue(a, "type"), f.base)
+		if len(current) == 0 {
+			current = t.Endian
+		}
+		if sniffed, confident := v.decide(); confident && sniffed != current {
+			edits = append(edits, &edit{node: kaitai.Lookup(a, "type"), value: f.base + sniffed})
+			note = fmt.Sprintf("; annotated %s%s", f.base, sniffed)
+		}
+		if v.le+v.be == 0 {
+			// Not present in the samples, or of no consequence.
+			continue
+		}
+		fmt.Printf("%s.%s: %v%s\n", t.Name, f.id, v, note)
+	}
+	return applyEdits(buf, edits)
+}
+
+// sniff parses the samples with the given Kaitai spec set to each byte order
+// by the given function, and returns the byte order in favour of which the
+// samples are, scoring the integer values of the attributes selected by the
+// given function, or all if nil.
+func (sn *sniffer) sniff(buf []byte, set func(root *yaml.Node, endian string), sel func(t *kaitai.Type, id string) bool) (*verdict, error) {
+	var scores [2][]score
+	for i, endian := range []string{"le", "be"} {
+		endian := endian
+		fixed, err := applyFix(buf, &fix{apply: func(root *yaml.Node) bool {
+			set(root, endian)
+			return true
+		}})
+		if err != nil {
+			return nil, err
+		}
+		spec, err := kaitai.ParseSpec(fixed)
+		if err != nil {
+			return nil, err
+		}
+		t := spec.Type(sn.typePath)
+		if t == nil {
+			return nil, fmt.Errorf("unable to locate type %q", sn.typePath)
+		}
+		for _, s := range sn.samples {
+			v, err := spec.Parse(t, s.data)
+			sc := score{ok: err == nil}
+			walkInts(v, func(t *kaitai.Type, id string, x *kaitai.Value) {
+				if sel != nil && !sel(t, id) {
+					return
+				}
+				sc.total++
+				if sn.plausible(t, id, x, len(s.data)) {
+					sc.plausible++
+				}
+			})
+			scores[i] = append(scores[i], sc)
+		}
+	}
+	v := &verdict{}
+	for i := range sn.samples {
+		le, be := scores[0][i], scores[1][i]
+		switch {
+		case le.better(be):
+			v.le++
+		case be.better(le):
+			v.be++
+		default:
+			v.undecided++
+		}
+	}
+	return v, nil
+}
+
+// plausible reports whether the given integer value of the given attribute of
+// the given type is plausible in a sample of the given size; known enum values,
+// lengths within the file size, and otherwise values fitting in the lower half
+// of their bytes.
+func (sn *sniffer) plausible(t *kaitai.Type, id string, v *kaitai.Value, size int) bool {
+	if len(v.Enum) > 0 {
+		return len(v.EnumName) > 0
+	}
+	var x uint64
+	switch val := v.Val.(type) {
+	case uint64:
+		x = val
+	case int64:
+		if val < 0 {
+			val = -val
+		}
+		x = uint64(val)
+	default:
+		return false
+	}
+	if sn.lengths[t.Path+"."+id] {
+		return x <= uint64(size)
+	}
+	bits := uint(v.End-v.Start) * 8
+	return x < 1<<(bits/2)
+}
+
+// walkInts calls f for each integer value of the given parsed value and its
+// nested values, with the type and identifier of its attribute.
+func walkInts(v *kaitai.Value, f func(t *kaitai.Type, id string, x *kaitai.Value)) {
+	if v == nil || v.Type == nil {
+		return
+	}
+	for _, field := range v.Fields {
+		elems := []*kaitai.Value{field}
+		if field.Array {
+			elems = field.Elems
+		}
+		for _, elem := range elems {
+			switch {
+			case elem.Type != nil:
+				walkInts(elem, f)
+			case elem.Start < 0:
+				// Value instance.
+			default:
+				switch elem.Val.(type) {
+				case uint64, int64:
+					f(v.Type, field.ID, elem)
+				}
+			}
+		}
+	}
+}
+
+// seqAttrs returns the seq attributes of the given type mapping.
+func seqAttrs(t *yaml.Node) []*yaml.Node {
+	if seq := kaitai.Lookup(t, "seq"); seq != nil && seq.Kind == yaml.SequenceNode {
+		return seq.Content
+	}
+	return nil
+}
+
+// edit replaces the given scalar of a Kaitai spec by the given value.
+type edit struct {
+	node  *yaml.Node
+	value string
+}
+
+// applyEdits returns the given Kaitai spec with the given edits applied in
+// place, preserving formatting and comments.
+func applyEdits(buf []byte, edits []*edit) ([]byte, error) {
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	for _, e := range edits {
+		line, col := e.node.Line-1, e.node.Column-1
+		if line < 0 || line >= len(lines) || col < 0 || col > len(lines[line]) || !bytes.HasPrefix(lines[line][col:], []byte(e.node.Value)) {
+			return nil, fmt.Errorf("%d:%d: unable to locate scalar %q", e.node.Line, e.node.Column, e.node.Value)
+		}
+		l := lines[line]
+		lines[line] = append(append(append([]byte(nil), l[:col]...), e.value...), l[col+len(e.node.Value):]...)
+	}
+	return bytes.Join(lines, nil), nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// option is a kaitai option of the struct tag or comment directive of a field
+// or type declaration, located in the source file.
+type option struct {
+	// Key and value of the option.
+	key, value string
+	// Byte offsets of the start and end of the option in the source file.
+	start, end int
+	// Option given by comment directive.
+	directive bool
+}
+
+// problem is a problem of the kaitai options of a source file, located by byte
+// offsets.
+type problem struct {
+	start, end int
+	severity   int
+	msg        string
+}
+
+// checker checks the kaitai options of a Go source file.
+type checker struct {
+	fset *token.FileSet
+	file *token.File
+	// Declared top-level type and constant names of the package of the file.
+	typeNames, constNames map[string]bool
+	// Options of the file, in source order.
+	opts []*option
+	// Problems of the file.
+	problems []*problem
+}
+
+// reParam matches the arguments of the //kaitai:param directive.
+var reParam = regexp.MustCompile(`^([a-z][a-z0-9_]*)[ \t]+(\S+)$`)
+
+// checkFile checks the kaitai options of the struct tags and comment directives
+// of the given Go source file, as located in the directory of its package. The
+// top-level declarations of the other Go source files of the directory are
+// taken into account when resolving type and constant names.
+func checkFile(path string, src []byte) *checker {
+	c := &checker{
+		fset:       token.NewFileSet(),
+		typeNames:  make(map[string]bool),
+		constNames: make(map[string]bool),
+	}
+	// Parse errors are reported by the Go tooling; check the partial syntax
+	// tree.
+	file, _ := parser.ParseFile(c.fset, path, src, parser.ParseComments)
+	if file == nil {
+		return c
+	}
+	c.file = c.fset.File(file.Pos())
+	c.addDecls(file)
+	c.addPackageDecls(path, file.Name.Name)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.TypeSpec)
+			doc := spec.Doc
+			if doc == nil && len(decl.Specs) == 1 {
+				doc = decl.Doc
+			}
+			st, _ := spec.Type.(*ast.StructType)
+			params := c.checkTypeDirectives(spec.Name.Name, doc, st)
+			if st != nil {
+				c.checkStruct(spec.Name.Name, st, params)
+			}
+		}
+	}
+	sort.SliceStable(c.opts, func(i, j int) bool {
+		return c.opts[i].start < c.opts[j].start
+	})
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		return c.problems[i].start < c.problems[j].start
+	})
+	return c
+}
+
+// addDecls records the top-level type and constant names of the given file.
+func (c *checker) addDecls(file *ast.File) {
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				c.typeNames[spec.Name.Name] = true
+			case *ast.ValueSpec:
+				if decl.Tok == token.CONST {
+					for _, name := range spec.Names {
+						c.constNames[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// addPackageDecls records the top-level type and constant names of the other
+// Go source files of the given package in the directory of the named file.
+func (c *checker) addPackageDecls(path, pkgName string) {
+	names, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.go"))
+	for _, name := range names {
+		if filepath.Base(name) == filepath.Base(path) {
+			continue
+		}
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, src, 0)
+		if err != nil || file.Name.Name != pkgName {
+			continue
+		}
+		c.addDecls(file)
+	}
+}
+
+// checkTypeDirectives checks the comment directives of the doc comment of the
+// named type, and returns the identifiers of its parameters. st is the struct
+// type of the named type, or nil if not a struct type.
+func (c *checker) checkTypeDirectives(typeName string, doc *ast.CommentGroup, st *ast.StructType) map[string]bool {
+	params := make(map[string]bool)
+	for _, opt := range c.directives(doc) {
+		o, ok := structtag.Options[opt.key]
+		switch {
+		case !ok:
+			c.errorf(opt, "unknown directive %s%s", structtag.DirectivePrefix, opt.key)
+		case !o.Type:
+			c.errorf(opt, "type %s: directive %s%s not supported on types", typeName, structtag.DirectivePrefix, opt.key)
+		case opt.key == "param":
+			m := reParam.FindStringSubmatch(opt.value)
+			if m == nil {
+				c.errorf(opt, "type %s: invalid directive %s%s %s; expected %sparam <id> <type>", typeName, structtag.DirectivePrefix, opt.key, opt.value, structtag.DirectivePrefix)
+				continue
+			}
+			params[m[1]] = true
+		case opt.key == "endian":
+			_, _, err := structtag.Tag{"endian": opt.value}.Endian()
+			c.check(opt, err)
+		case opt.key == "bit-endian":
+			_, _, err := structtag.Tag{"bit-endian": opt.value}.BitEndian()
+			c.check(opt, err)
+		case opt.key == "webide-representation":
+			_, _, err := structtag.Tag{"webide-representation": opt.value}.WebIDERepresentation()
+			c.check(opt, err)
+		case opt.key == "order":
+			names, _, err := structtag.Tag{"order": opt.value}.Order()
+			c.check(opt, err)
+			if st == nil {
+				c.errorf(opt, "type %s: directive %s%s applies only to struct types", typeName, structtag.DirectivePrefix, opt.key)
+				continue
+			}
+			fieldNames := structFieldNames(st)
+			for _, name := range names {
+				if !fieldNames[name] {
+					c.errorf(opt, "order field %q is not a field of %s", name, typeName)
+				}
+			}
+		}
+	}
+	return params
+}
+
+// checkStruct checks the kaitai options of the fields of the given struct type,
+// and of the fields of nested unnamed struct types, as located in the named
+// type with the given parameters.
+func (c *checker) checkStruct(typeName string, st *ast.StructType, params map[string]bool) {
+	fieldNames := structFieldNames(st)
+	for _, field := range st.Fields.List {
+		opts := append(c.tagOptions(field.Tag), c.directives(field.Doc, field.Comment)...)
+		c.checkField(typeName, fieldNames, params, opts)
+		if nested := unnamedStruct(field.Type); nested != nil {
+			c.checkStruct(typeName, nested, params)
+		}
+	}
+}
+
+// structFieldNames returns the field names of the given struct type.
+func structFieldNames(st *ast.StructType) map[string]bool {
+	fieldNames := make(map[string]bool)
+	for _, field := range st.Fields.List {
+		for _, name := range field.Names {
+			fieldNames[name.Name] = true
+		}
+		if len(field.Names) == 0 {
+			// Embedded field.
+			if name := embeddedName(field.Type); len(name) > 0 {
+				fieldNames[name] = true
+			}
+		}
+	}
+	return fieldNames
+}
+
+// checkField checks the given kaitai options of a field of the named struct
+// type with the given field names and parameters.
+func (c *checker) checkField(typeName string, fieldNames, params map[string]bool, opts []*option) {
+	if len(opts) == 0 {
+		return
+	}
+	// Options of the struct tag take precedence over comment directives, as
+	// by type2kaitai.
+	tag := make(structtag.Tag)
+	byKey := make(map[string]*option)
+	for _, opt := range opts {
+		o, ok := structtag.Options[opt.key]
+		switch {
+		case !ok:
+			if opt.directive {
+				c.errorf(opt, "unknown directive %s%s", structtag.DirectivePrefix, opt.key)
+			} else {
+				c.errorf(opt, "unknown option %q", opt.key)
+			}
+			continue
+		case !o.Field:
+			c.errorf(opt, "%s option not supported on fields", opt.key)
+			continue
+		case o.DirectiveOnly && !opt.directive:
+			c.errorf(opt, "%s option only supported as %s%s directive", opt.key, structtag.DirectivePrefix, opt.key)
+			continue
+		}
+		if prev, ok := byKey[opt.key]; ok {
+			if prev.directive == opt.directive {
+				c.warnf(opt, "duplicate %s option", opt.key)
+			}
+			if opt.directive {
+				continue
+			}
+		}
+		byKey[opt.key] = opt
+		tag[opt.key] = opt.value
+	}
+	at := func(keys ...string) *option {
+		for _, key := range keys {
+			if opt, ok := byKey[key]; ok {
+				return opt
+			}
+		}
+		return opts[0]
+	}
+
+	// Option values.
+	_, _, err := tag.Contents()
+	c.check(at("contents"), err)
+	_, _, err = tag.Endian()
+	c.check(at("endian"), err)
+	_, _, err = tag.ID()
+	c.check(at("id"), err)
+	_, _, err = tag.Encoding()
+	c.check(at("encoding"), err)
+	size, _, err := tag.Size()
+	c.check(at("size"), err)
+	repeat, expr, _, err := tag.Repeat()
+	c.check(at("repeat", "expr"), err)
+	_, _, err = tag.Terminator()
+	c.check(at("terminator"), err)
+	_, _, err = tag.TermOptions()
+	c.check(at("include", "consume", "eos-error"), err)
+	_, _, _, err = tag.BigInt()
+	c.check(at("bigint", "bigint-sign"), err)
+	_, _, err = tag.Varint()
+	c.check(at("varint", "vlq"), err)
+	_, region, _, err := tag.Checksum()
+	c.check(at("checksum", "region"), err)
+	_, _, err = tag.Process()
+	c.check(at("process"), err)
+	on, cases, _, err := tag.SwitchOn()
+	c.check(at("switch-on", "cases"), err)
+	target, _, _, err := tag.OffsetTo()
+	c.check(at("offset-to", "whence"), err)
+	if tag.Has("str") && tag.Has("strz") {
+		c.warnf(at("strz"), "str and strz options are mutually exclusive; strz applies")
+	}
+
+	// Expressions.
+	if cond, ok := tag["if"]; ok {
+		if len(cond) == 0 {
+			c.errorf(at("if"), "missing expression of if option")
+		} else {
+			c.check(at("if"), checkExpr(cond))
+		}
+	}
+	if repeat == "until" {
+		c.check(at("expr"), checkExpr(expr))
+	}
+	if args, ok := tag["args"]; ok {
+		for _, arg := range strings.Split(args, ";") {
+			if err := checkExpr(strings.TrimSpace(arg)); err != nil {
+				c.errorf(at("args"), "invalid argument %q; %v", arg, err)
+			}
+		}
+	}
+
+	// Referenced fields, parameters, types and constants.
+	isRef := func(name string) bool {
+		return fieldNames[name] || params[name]
+	}
+	if len(size) > 0 && !isInteger(size) && !isRef(size) {
+		c.errorf(at("size"), "size %q is neither an integer, a field nor a parameter of %s", size, typeName)
+	}
+	if repeat == "expr" && len(expr) > 0 && !isInteger(expr) && !isRef(expr) {
+		c.errorf(at("expr"), "repeat count %q is neither an integer, a field nor a parameter of %s", expr, typeName)
+	}
+	if len(on) > 0 && !isRef(on) {
+		c.errorf(at("switch-on"), "switch-on %q is neither a field nor a parameter of %s", on, typeName)
+	}
+	for _, cs := range cases {
+		if cs.Value != "_" && !isInteger(cs.Value) && !strings.Contains(cs.Value, ".") && !c.constNames[cs.Value] {
+			c.errorf(at("cases"), "case value %q is neither an integer nor a constant of the package", cs.Value)
+		}
+		if !c.isType(cs.Type) {
+			c.errorf(at("cases"), "case type %q not defined in the package", cs.Type)
+		}
+	}
+	if len(region) > 0 {
+		for _, name := range strings.SplitN(region, "..", 2) {
+			if !fieldNames[name] {
+				c.errorf(at("region"), "region field %q is not a field of %s", name, typeName)
+			}
+		}
+	}
+	if len(target) > 0 && !fieldNames[target] && !c.typeNames[target] {
+		c.errorf(at("offset-to"), "offset-to target %q is neither a field of %s nor a type of the package", target, typeName)
+	}
+}
+
+// isType reports whether the given Go type name of a switch-on case is
+// defined; i.e. declared in the package, predeclared, or qualified by package
+// name (e.g. ast.Node), which is not resolved.
+func (c *checker) isType(name string) bool {
+	name = strings.TrimLeft(name, "*")
+	if strings.Contains(name, ".") || c.typeNames[name] {
+		return true
+	}
+	_, ok := types.Universe.Lookup(name).(*types.TypeName)
+	return ok
+}
+
+// tagOptions returns the kaitai options of the given struct tag literal, if
+// any. Options are located in raw string literals; the options of interpreted
+// string literals are located at the literal.
+func (c *checker) tagOptions(lit *ast.BasicLit) []*option {
+	if lit == nil {
+		return nil
+	}
+	tag, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil
+	}
+	litStart := c.file.Offset(lit.Pos())
+	litEnd := litStart + len(lit.Value)
+	s, ok := lookupTag(tag, "kaitai")
+	if !ok {
+		return nil
+	}
+	pos := strings.Index(lit.Value, s.raw)
+	raw := strings.HasPrefix(lit.Value, "`") && pos != -1
+	var opts []*option
+	offset := 0
+	for _, elem := range strings.Split(s.value, ",") {
+		elemOffset := offset
+		offset += len(elem) + len(",")
+		trimmed := strings.TrimSpace(elem)
+		if len(trimmed) == 0 {
+			continue
+		}
+		opt := &option{start: litStart, end: litEnd}
+		if raw {
+			opt.start = litStart + pos + elemOffset + strings.Index(elem, trimmed)
+			opt.end = opt.start + len(trimmed)
+		}
+		opt.key = trimmed
+		if i := strings.Index(trimmed, "="); i != -1 {
+			opt.key, opt.value = trimmed[:i], trimmed[i+1:]
+		}
+		opts = append(opts, opt)
+		c.opts = append(c.opts, opt)
+	}
+	return opts
+}
+
+// tagValue is the value of a key of a struct tag, and its raw form in the tag.
+type tagValue struct {
+	value, raw string
+}
+
+// lookupTag returns the value of the given key of the struct tag, as by
+// reflect.StructTag.Lookup, along with the quoted value as present in the tag.
+func lookupTag(tag, key string) (tagValue, bool) {
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := tag[:i+1]
+		tag = tag[i+1:]
+		if name == key {
+			value, err := strconv.Unquote(qvalue)
+			if err != nil {
+				break
+			}
+			return tagValue{value: value, raw: qvalue[1 : len(qvalue)-1]}, true
+		}
+	}
+	return tagValue{}, false
+}
+
+// directives returns the kaitai options of the comment directives of the given
+// comment groups, located at their comments. Groups may be nil.
+func (c *checker) directives(groups ...*ast.CommentGroup) []*option {
+	var opts []*option
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, comment := range group.List {
+			if !strings.HasPrefix(comment.Text, structtag.DirectivePrefix) {
+				continue
+			}
+			// Parsed as by structtag.ParseDirectives.
+			tag := structtag.ParseDirectives(&ast.CommentGroup{List: []*ast.Comment{comment}})
+			start := c.file.Offset(comment.Slash)
+			for key, value := range tag {
+				opt := &option{key: key, value: value, start: start, end: start + len(comment.Text), directive: true}
+				opts = append(opts, opt)
+				c.opts = append(c.opts, opt)
+			}
+		}
+	}
+	return opts
+}
+
+// errorf records the error of the given format and arguments at the given
+// option.
+func (c *checker) errorf(opt *option, format string, args ...interface{}) {
+	c.problems = append(c.problems, &problem{start: opt.start, end: opt.end, severity: severityError, msg: fmt.Sprintf(format, args...)})
+}
+
+// warnf records the warning of the given format and arguments at the given
+// option.
+func (c *checker) warnf(opt *option, format string, args ...interface{}) {
+	c.problems = append(c.problems, &problem{start: opt.start, end: opt.end, severity: severityWarning, msg: fmt.Sprintf(format, args...)})
+}
+
+// check records the given error, if any, at the given option.
+func (c *checker) check(opt *option, err error) {
+	if err != nil {
+		c.errorf(opt, "%v", err)
+	}
+}
+
+// isInteger reports whether the given string is an integer literal.
+func isInteger(s string) bool {
+	_, err := strconv.ParseUint(s, 0, 64)
+	return err == nil
+}
+
+// checkExpr checks the syntax of the given Kaitai expression; i.e. that it is
+// non-empty, with balanced parentheses and brackets, and terminated string
+// literals.
+func checkExpr(expr string) error {
+	if len(strings.TrimSpace(expr)) == 0 {
+		return fmt.Errorf("empty expression")
+	}
+	var stack []byte
+	closing := map[byte]byte{'(': ')', '[': ']'}
+	for i := 0; i < len(expr); i++ {
+		switch ch := expr[i]; ch {
+		case '"', '\'':
+			end := strings.IndexByte(expr[i+1:], ch)
+			if end == -1 {
+				return fmt.Errorf("unterminated string literal in expression %q", expr)
+			}
+			i += end + 1
+		case '(', '[':
+			stack = append(stack, closing[ch])
+		case ')', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != ch {
+				return fmt.Errorf("unbalanced %q in expression %q", ch, expr)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("missing %q in expression %q", stack[len(stack)-1], expr)
+	}
+	return nil
+}
+
+// embeddedName returns the field name of the given embedded field type; e.g.
+// Header of *pkg.Header.
+func embeddedName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(expr.X)
+	case *ast.SelectorExpr:
+		return expr.Sel.Name
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// unnamedStruct returns the unnamed struct type of the given field type, or of
+// its array, slice or pointer element types, if any.
+func unnamedStruct(expr ast.Expr) *ast.StructType {
+	for {
+		switch e := expr.(type) {
+		case *ast.StructType:
+			return e
+		case *ast.ArrayType:
+			expr = e.Elt
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		default:
+			return nil
+		}
+	}
+}
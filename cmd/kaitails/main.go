@@ -0,0 +1,342 @@
+// The kaitails tool is a language server offering diagnostics and hover
+// information for the kaitai options of Go source files, as used by
+// type2kaitai; i.e. the kaitai:"..." struct tags and //kaitai: comment
+// directives of struct types.
+//
+// Diagnostics report unknown options, invalid option values and expressions,
+// and references to fields, parameters, types and constants not defined, e.g.
+//
+//	Data []byte `kaitai:"size=Lenght"` // size "Lenght" is neither an integer, a field nor a parameter of Header
+//
+// Hovering over an option shows its documentation.
+//
+// The language server communicates over standard input and output, as
+// configured in the editor; e.g. for Neovim
+//
+//	vim.lsp.start({ name = "kaitails", cmd = { "kaitails" } })
+//
+// alongside gopls. If Go source files are given as arguments, kaitails checks
+// the files and prints the diagnostics instead.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of kaitails:\n")
+	fmt.Fprintf(os.Stderr, "\tkaitails # language server on standard input and output\n")
+	fmt.Fprintf(os.Stderr, "\tkaitails files... # print diagnostics of Go source files\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("kaitails: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if !checkFiles(flag.Args()) {
+			os.Exit(1)
+		}
+		return
+	}
+	s := &server{
+		conn: newConn(os.Stdin, os.Stdout),
+		docs: make(map[string][]byte),
+	}
+	if err := s.run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkFiles prints the diagnostics of the given Go source files, and reports
+// whether no errors were found.
+func checkFiles(paths []string) bool {
+	ok := true
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c := checkFile(path, src)
+		lines := newLineIndex(src)
+		for _, p := range c.problems {
+			line, col := lines.lineCol(p.start)
+			kind := "error"
+			if p.severity == severityWarning {
+				kind = "warning"
+			} else {
+				ok = false
+			}
+			fmt.Printf("%s:%d:%d: %s: %s\n", path, line+1, col+1, kind, p.msg)
+		}
+	}
+	return ok
+}
+
+// server is a language server of kaitai options.
+type server struct {
+	conn *conn
+	// docs maps from URI to the text of the open text documents.
+	docs map[string][]byte
+	// shutdown specifies whether the shutdown request was received.
+	shutdown bool
+}
+
+// run serves the requests of the language client until the exit notification
+// or the end of input.
+func (s *server) run() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*responseError); ok {
+			// Malformed message; the ID of the request is unknown.
+			if err := s.conn.reply(nil, nil, e); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				os.Exit(1)
+			}
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			// Notification.
+			if rerr != nil {
+				log.Printf("%s: %v", msg.Method, rerr)
+			}
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles the given request or notification, and returns its result.
+func (s *server) handle(msg *message) (interface{}, *responseError) {
+	invalid := func(err error) *responseError {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	switch msg.Method {
+	case "initialize":
+		result := &initializeResult{}
+		result.Capabilities.TextDocumentSync = syncFull
+		result.Capabilities.HoverProvider = true
+		result.ServerInfo.Name = "kaitails"
+		return result, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		s.docs[params.TextDocument.URI] = []byte(params.TextDocument.Text)
+		s.publish(params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		if n := len(params.Changes); n > 0 {
+			s.docs[params.TextDocument.URI] = []byte(params.Changes[n-1].Text)
+		}
+		s.publish(params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notifyDiagnostics(params.TextDocument.URI, []diagnostic{})
+		return nil, nil
+	case "textDocument/hover":
+		var params hoverParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalid(err)
+		}
+		if h := s.hover(params.TextDocument.URI, params.Position); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(msg.Method, "$/") {
+		// Optional notifications and requests of the protocol.
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)}
+}
+
+// publish publishes the diagnostics of the open text document of the given
+// URI.
+func (s *server) publish(uri string) {
+	src := s.docs[uri]
+	c := checkFile(uriPath(uri), src)
+	lines := newLineIndex(src)
+	diags := []diagnostic{}
+	for _, p := range c.problems {
+		diags = append(diags, diagnostic{
+			Range:    lines.textRange(p.start, p.end),
+			Severity: p.severity,
+			Source:   "kaitails",
+			Message:  p.msg,
+		})
+	}
+	s.notifyDiagnostics(uri, diags)
+}
+
+// notifyDiagnostics sends the given diagnostics of the text document of the
+// given URI to the client.
+func (s *server) notifyDiagnostics(uri string, diags []diagnostic) {
+	params := &publishDiagnosticsParams{URI: uri, Diagnostics: diags}
+	if err := s.conn.notify("textDocument/publishDiagnostics", params); err != nil {
+		log.Printf("publishing diagnostics: %v", err)
+	}
+}
+
+// hover returns the documentation of the kaitai option at the given position
+// of the open text document of the given URI, or nil if none.
+func (s *server) hover(uri string, pos position) *hover {
+	src, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+	c := checkFile(uriPath(uri), src)
+	lines := newLineIndex(src)
+	offset := lines.offset(pos)
+	for _, opt := range c.opts {
+		if offset < opt.start || offset >= opt.end {
+			continue
+		}
+		o, ok := structtag.Options[opt.key]
+		if !ok {
+			return nil
+		}
+		r := lines.textRange(opt.start, opt.end)
+		return &hover{
+			Contents: markupContent{Kind: "markdown", Value: optionDoc(o)},
+			Range:    &r,
+		}
+	}
+	return nil
+}
+
+// optionDoc returns the Markdown documentation of the given kaitai option.
+func optionDoc(o *structtag.Option) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "**kaitai option `%s`**\n\n%s\n\n", o.Key, o.Doc)
+	usage := o.Usage
+	if !strings.HasPrefix(usage, "//") {
+		usage = fmt.Sprintf("`kaitai:\"%s\"`", usage)
+	} else {
+		usage = fmt.Sprintf("`%s`", usage)
+	}
+	fmt.Fprintf(buf, "Usage: %s", usage)
+	var on []string
+	if o.Field {
+		on = append(on, "fields")
+	}
+	if o.Type {
+		on = append(on, "type declarations")
+	}
+	fmt.Fprintf(buf, " (on %s", strings.Join(on, " and "))
+	if o.DirectiveOnly {
+		fmt.Fprintf(buf, "; comment directive only")
+	}
+	fmt.Fprintf(buf, ")")
+	return buf.String()
+}
+
+// uriPath returns the file path of the given file URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// lineIndex maps between byte offsets and LSP positions of a text document;
+// zero-based lines and UTF-16 character offsets.
+type lineIndex struct {
+	src []byte
+	// Byte offsets of the start of each line.
+	starts []int
+}
+
+// newLineIndex returns the line index of the given text.
+func newLineIndex(src []byte) *lineIndex {
+	l := &lineIndex{src: src, starts: []int{0}}
+	for i, b := range src {
+		if b == '\n' {
+			l.starts = append(l.starts, i+1)
+		}
+	}
+	return l
+}
+
+// lineCol returns the zero-based line and UTF-16 character offset of the given
+// byte offset.
+func (l *lineIndex) lineCol(offset int) (line, col int) {
+	for line+1 < len(l.starts) && l.starts[line+1] <= offset {
+		line++
+	}
+	for _, r := range string(l.src[l.starts[line]:offset]) {
+		col += len(utf16.Encode([]rune{r}))
+	}
+	return line, col
+}
+
+// textRange returns the LSP range between the given byte offsets.
+func (l *lineIndex) textRange(start, end int) textRange {
+	startLine, startCol := l.lineCol(start)
+	endLine, endCol := l.lineCol(end)
+	return textRange{
+		Start: position{Line: startLine, Character: startCol},
+		End:   position{Line: endLine, Character: endCol},
+	}
+}
+
+// offset returns the byte offset of the given LSP position.
+func (l *lineIndex) offset(pos position) int {
+	if pos.Line >= len(l.starts) {
+		return len(l.src)
+	}
+	offset := l.starts[pos.Line]
+	for col := 0; col < pos.Character && offset < len(l.src) && l.src[offset] != '\n'; {
+		r, size := utf8.DecodeRune(l.src[offset:])
+		col += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
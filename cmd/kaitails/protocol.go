@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// message is a JSON-RPC 2.0 request, response or notification.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+// responseError is the error of a JSON-RPC response.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+)
+
+// conn is a JSON-RPC connection, framed by Content-Length headers as specified
+// by the Language Server Protocol.
+type conn struct {
+	r *bufio.Reader
+	// mu guards w.
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newConn returns a new JSON-RPC connection reading from r and writing to w.
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read reads the next message of the connection.
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header %q; %v", header.Get("Content-Length"), err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(buf, msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+// write writes the given message to the connection.
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(buf)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(buf)
+	return err
+}
+
+// reply writes the response of the given result or error to the request of the
+// given ID.
+func (c *conn) reply(id *json.RawMessage, result interface{}, err *responseError) error {
+	if result == nil && err == nil {
+		// The result of successful requests is required; null if empty.
+		result = json.RawMessage("null")
+	}
+	return c.write(&message{ID: id, Result: result, Error: err})
+}
+
+// notify writes the notification of the given method and parameters.
+func (c *conn) notify(method string, params interface{}) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: buf})
+}
+
+// Error implements the error interface.
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// Language Server Protocol types, as used by kaitails.
+
+// position is a zero-based line and UTF-16 character offset of a text
+// document.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// textRange is the range of a text document between start and end.
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+// Diagnostic severities.
+const (
+	severityError   = 1
+	severityWarning = 2
+)
+
+// diagnostic is a problem of a text document.
+type diagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+// publishDiagnosticsParams are the parameters of the
+// textDocument/publishDiagnostics notification.
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+// textDocumentItem is an opened text document.
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+// textDocumentIdentifier identifies a text document.
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// didOpenParams are the parameters of the textDocument/didOpen notification.
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+// didChangeParams are the parameters of the textDocument/didChange
+// notification. With full document sync, the last change holds the text of the
+// document.
+type didChangeParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Changes      []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// didCloseParams are the parameters of the textDocument/didClose notification.
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// hoverParams are the parameters of the textDocument/hover request.
+type hoverParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+// hover is the result of the textDocument/hover request.
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *textRange    `json:"range,omitempty"`
+}
+
+// markupContent is Markdown content.
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Text document sync kinds.
+const (
+	syncFull = 1
+)
+
+// initializeResult is the result of the initialize request.
+type initializeResult struct {
+	Capabilities struct {
+		TextDocumentSync int  `json:"textDocumentSync"`
+		HoverProvider    bool `json:"hoverProvider"`
+	} `json:"capabilities"`
+	ServerInfo struct {
+		Name string `json:"name"`
+	} `json:"serverInfo"`
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// check parses the given data as the named type of the Kaitai spec, and
+// returns the structural mismatches between the parsed value and the expected
+// value.
+func check(spec *kaitai.Spec, typeName string, want interface{}, data []byte) ([]string, error) {
+	t := spec.Type(typeName)
+	if t == nil {
+		// Top-level type of hand-written specs.
+		if root := spec.Types[0]; spec.DefaultType() == root {
+			t = root
+		} else {
+			return nil, fmt.Errorf("unable to locate type %q in Kaitai spec", typeName)
+		}
+	}
+	got, err := spec.Parse(t, data)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", typeName, err)}, nil
+	}
+	mismatches := compare(typeName, want, got)
+	if got.End < len(data) {
+		mismatches = append(mismatches, fmt.Sprintf("%s: %d trailing bytes not consumed by Kaitai spec", typeName, len(data)-got.End))
+	}
+	return mismatches, nil
+}
+
+// compare returns the structural mismatches between the expected value of a
+// Go type and the value parsed according to the Kaitai spec.
+func compare(path string, want interface{}, got *kaitai.Value) []string {
+	if want == nil {
+		return nil
+	}
+	switch want := want.(type) {
+	case *record:
+		if got.Type == nil {
+			return []string{fmt.Sprintf("%s: expected struct, got %s", path, describe(got))}
+		}
+		var mismatches []string
+		for _, f := range want.fields {
+			g := got.Field(f.id)
+			if g == nil {
+				mismatches = append(mismatches, fmt.Sprintf("%s.%s: field missing from Kaitai spec", path, f.id))
+				continue
+			}
+			mismatches = append(mismatches, compare(path+"."+f.id, f.val, g)...)
+		}
+		for _, g := range got.Fields {
+			// Value instances (e.g. of -const-instances) have no
+			// corresponding field.
+			if want.field(g.ID) == nil && g.Start >= 0 {
+				mismatches = append(mismatches, fmt.Sprintf("%s.%s: attribute missing from Go type", path, g.ID))
+			}
+		}
+		return mismatches
+	case []interface{}:
+		if !got.Array {
+			return []string{fmt.Sprintf("%s: expected array, got %s", path, describe(got))}
+		}
+		if len(got.Elems) != len(want) {
+			return []string{fmt.Sprintf("%s: expected %d elements, got %d", path, len(want), len(got.Elems))}
+		}
+		var mismatches []string
+		for i := range want {
+			mismatches = append(mismatches, compare(fmt.Sprintf("%s[%d]", path, i), want[i], got.Elems[i])...)
+		}
+		return mismatches
+	case []byte:
+		var data []byte
+		switch val := got.Val.(type) {
+		case []byte:
+			data = val
+		case string:
+			data = []byte(val)
+		default:
+			return []string{fmt.Sprintf("%s: expected bytes %X, got %s", path, want, describe(got))}
+		}
+		if !bytes.Equal(want, data) {
+			return []string{fmt.Sprintf("%s: expected bytes %X, got %X", path, want, data)}
+		}
+		return nil
+	}
+	// Integers may be parsed as bit fields; e.g. flag-style enums.
+	val := got.Val
+	if got.Type != nil {
+		if x, ok := bitsValue(got); ok {
+			val = x
+		}
+	}
+	if scalar(want) != scalar(val) {
+		return []string{fmt.Sprintf("%s: expected %v, got %s", path, want, describe(got))}
+	}
+	return nil
+}
+
+// field returns the field of the record with the given id, or nil if not
+// present.
+func (rec *record) field(id string) *field {
+	for _, f := range rec.fields {
+		if f.id == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// bitsValue returns the integer value of a user type value consisting only of
+// bit fields, and a boolean indicating whether the value consists only of bit
+// fields.
+func bitsValue(v *kaitai.Value) (uint64, bool) {
+	var x uint64
+	shift := uint(0)
+	for _, f := range v.Fields {
+		var bits uint64
+		switch val := f.Val.(type) {
+		case uint64:
+			bits = val
+		case bool:
+			if val {
+				bits = 1
+			}
+		}
+		if f.Bits == 0 {
+			return 0, false
+		}
+		if v.Type.BitEndian == "le" {
+			x |= bits << shift
+			shift += uint(f.Bits)
+		} else {
+			x = x<<uint(f.Bits) | bits
+		}
+	}
+	return x, len(v.Fields) > 0
+}
+
+// describe returns a description of the given parsed value for mismatch
+// reports.
+func describe(v *kaitai.Value) string {
+	switch {
+	case v.Type != nil:
+		return "struct " + v.Type.Name
+	case v.Array:
+		return fmt.Sprintf("array of %d elements", len(v.Elems))
+	}
+	return fmt.Sprint(v.Val)
+}
+
+// scalar returns the canonical string representation of the given scalar
+// value.
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%T(%v)", v, v)
+}
@@ -0,0 +1,159 @@
+// The kaitairt tool checks the round-trip consistency between Go types and
+// their generated Kaitai spec.
+//
+// For each iteration, a random instance of the Go type is synthesized and
+// serialized following the layout of the generated Go writers (see type2kaitai
+// -format go), as given by the struct tags and comment directives of the
+// package. Fields are identified as in the Kaitai spec generated with the same
+// -naming strategy, unless given by id options. The resulting bytes are then
+// parsed according to the semantics of the Kaitai spec, and structural
+// mismatches between the parsed values and the original instance are reported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mewrev/tools/internal/kaitai"
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/load"
+	"github.com/mewrev/tools/internal/naming"
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+var (
+	typeName  = flag.String("type", "", "type name; must be set")
+	ksyPath   = flag.String("ksy", "", "Kaitai spec file name; default srcdir/<type>_type.ksy")
+	n         = flag.Int("n", 100, "number of random instances to check")
+	seed      = flag.Int64("seed", 0, "random seed; default based on current time")
+	buildTags = flag.String("tags", "", "comma-separated list of build tags to apply")
+	arch      = flag.String("arch", "amd64", "target architecture; determines the size of int, uint and uintptr")
+	namingArg = flag.String("naming", "snake", "naming strategy of type and field identifiers of the Kaitai spec, as given to type2kaitai (snake, keep or camel)")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of kaitairt:\n")
+	fmt.Fprintf(os.Stderr, "\tkaitairt [flags] -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "\tkaitairt [flags] -type T files... # Must be a single package\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("kaitairt: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if len(*typeName) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var tags []string
+	if len(*buildTags) > 0 {
+		tags = strings.Split(*buildTags, ",")
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		// Default: process whole package in current directory.
+		args = []string{"."}
+	}
+	dir := args[0]
+	if len(args) != 1 || !isDirectory(args[0]) {
+		dir = filepath.Dir(args[0])
+	}
+	sizes := types.SizesFor("gc", *arch)
+	if sizes == nil {
+		log.Fatalf("unsupported target architecture %q", *arch)
+	}
+	switch *namingArg {
+	case naming.StrategySnake, naming.StrategyKeep, naming.StrategyCamel:
+		// valid naming strategy.
+	default:
+		log.Fatalf("unsupported naming strategy %q; valid options: snake, keep, camel", *namingArg)
+	}
+
+	// Parse Go type and Kaitai spec.
+	pkg, t, err := load.PackageType(args, tags, *arch, *typeName)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	specPath := *ksyPath
+	if len(specPath) == 0 {
+		specPath = filepath.Join(dir, strings.ToLower(*typeName+"_type.ksy"))
+	}
+	buf, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	spec, err := kaitai.ParseSpec(buf)
+	if err != nil {
+		log.Fatalf("unable to parse Kaitai spec %q; %v", specPath, err)
+	}
+
+	endian := spec.Types[0].Endian
+	if len(endian) == 0 {
+		endian = "le"
+	}
+
+	// Run round-trip checks.
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("using random seed %d", *seed)
+	// Fields are laid out as by type2kaitai, following the struct tags and
+	// comment directives of the package; e.g. the order and id directives.
+	dirs := structtag.PackageDirectives(pkg.Syntax, pkg.TypesInfo)
+	s := &synth{
+		rand: rand.New(rand.NewSource(*seed)),
+		layout: &layout.Layout{
+			Sizes:  sizes,
+			Endian: endian,
+			Exclude: func(field *types.Var) bool {
+				return dirs.Skipped[field]
+			},
+			Tag:   dirs.FieldTag,
+			Order: dirs.FieldOrder,
+		},
+		naming: *namingArg,
+	}
+	failed := 0
+	for i := 0; i < *n; i++ {
+		val, data, err := s.instance(t)
+		if err != nil {
+			log.Fatalf("unable to synthesize instance of %s; %v", *typeName, err)
+		}
+		mismatches, err := check(spec, naming.Ident(*namingArg, *typeName), val, data)
+		if err != nil {
+			log.Fatalf("unable to parse instance of %s; %v", *typeName, err)
+		}
+		for _, mismatch := range mismatches {
+			fmt.Printf("instance %d: %s\n", i, mismatch)
+		}
+		if len(mismatches) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d instances mismatched", failed, *n)
+		os.Exit(1)
+	}
+	log.Printf("%d instances of %s round-tripped", *n, *typeName)
+}
+
+// isDirectory reports whether the named file is a directory.
+func isDirectory(name string) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return info.IsDir()
+}
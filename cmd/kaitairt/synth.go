@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/naming"
+)
+
+// record is a structured value; the fields of a Go struct.
+type record struct {
+	// Fields in order of appearance.
+	fields []*field
+}
+
+// field is a named field of a record.
+type field struct {
+	// Field identifier in the Kaitai spec.
+	id string
+	// Field value; one of uint64, int64, float64, bool, []byte, []interface{}
+	// or *record. A nil value is not compared.
+	val interface{}
+}
+
+// synth synthesizes random instances of Go types, serialized as by the Go
+// writers generated by type2kaitai; i.e. following the binary layout of the
+// types (see package layout).
+type synth struct {
+	// Source of randomness.
+	rand *rand.Rand
+	// Binary layout of Go types.
+	layout *layout.Layout
+	// Naming strategy of field identifiers; see naming.Ident.
+	naming string
+}
+
+// instance returns a random instance of the given type and its serialized
+// bytes.
+func (s *synth) instance(t types.Type) (interface{}, []byte, error) {
+	fields, err := s.layout.Fields(t, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := &bytes.Buffer{}
+	var val interface{}
+	for _, f := range fields {
+		x := s.value(buf, f)
+		if val, err = s.insert(val, t, f.Path, x); err != nil {
+			return nil, nil, err
+		}
+	}
+	return val, buf.Bytes(), nil
+}
+
+// value writes a random value of the given field of the layout to buf, and
+// returns the value.
+func (s *synth) value(buf *bytes.Buffer, f *layout.Field) interface{} {
+	order := byteOrder(f.Endian)
+	switch f.Kind {
+	case layout.Contents:
+		buf.Write(f.Contents)
+		return f.Contents
+	case layout.Bool:
+		x := s.rand.Intn(2) == 1
+		if x {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		return x
+	case layout.Int, layout.Uint:
+		var c constant.Value
+		if named, ok := f.Type.(*types.Named); ok {
+			if consts := enumValues(named); len(consts) > 0 {
+				c = consts[s.rand.Intn(len(consts))].Val()
+			}
+		}
+		return s.integer(buf, f, c)
+	case layout.Float:
+		if f.Size == 4 {
+			x := float32(s.rand.NormFloat64() * 1e3)
+			writeUint(buf, order, 4, uint64(math.Float32bits(x)))
+			return float64(x)
+		}
+		x := s.rand.NormFloat64() * 1e3
+		writeUint(buf, order, 8, math.Float64bits(x))
+		return x
+	case layout.Bytes:
+		data := make([]byte, f.Size)
+		s.rand.Read(data)
+		buf.Write(data)
+		return data
+	case layout.Str, layout.Strz:
+		// ASCII letters, stored as code units of the size of the array
+		// elements (e.g. UTF-16 code units of uint16 arrays).
+		arr := f.Type.Underlying().(*types.Array)
+		unitSize := s.layout.Sizes.Sizeof(arr.Elem())
+		data := make([]byte, arr.Len())
+		n := len(data)
+		if f.Kind == layout.Strz && n > 0 {
+			// Null-terminated string; zero padded.
+			n = s.rand.Intn(len(data))
+		}
+		for i := 0; i < n; i++ {
+			data[i] = byte('a' + s.rand.Intn(26))
+		}
+		for _, b := range data {
+			writeUint(buf, order, unitSize, uint64(b))
+		}
+		return data[:n]
+	}
+	// Blank fields and addresses are written as zero bytes and not compared.
+	buf.Write(make([]byte, f.Size))
+	return nil
+}
+
+// insert returns the given structured value of type t (nil if not yet
+// present), with the given value of a scalar field of the layout stored at the
+// given field path relative to the value; e.g. Entries[1].Offset.
+func (s *synth) insert(dst interface{}, t types.Type, path string, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	if strings.HasPrefix(path, "[") {
+		// Array element.
+		end := strings.Index(path, "]")
+		arr, ok := t.Underlying().(*types.Array)
+		if end == -1 || !ok {
+			return nil, fmt.Errorf("invalid field path %q of type %s", path, types.TypeString(t, skipQualifier))
+		}
+		i, err := strconv.Atoi(path[1:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field path %q; %v", path, err)
+		}
+		elems, _ := dst.([]interface{})
+		if elems == nil {
+			elems = make([]interface{}, arr.Len())
+		}
+		if elems[i], err = s.insert(elems[i], arr.Elem(), path[end+1:], val); err != nil {
+			return nil, err
+		}
+		return elems, nil
+	}
+	path = strings.TrimPrefix(path, ".")
+	name, rest := path, ""
+	if pos := strings.IndexAny(path, ".["); pos != -1 {
+		name, rest = path[:pos], path[pos:]
+	}
+	rec, _ := dst.(*record)
+	if rec == nil {
+		rec = &record{}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		// Real and imaginary parts of complex numbers.
+		if u.Info()&types.IsComplex == 0 {
+			break
+		}
+		rec.fields = append(rec.fields, &field{id: name, val: val})
+		return rec, nil
+	case *types.Struct:
+		if name == "_" {
+			// Blank fields are not compared.
+			rec.fields = append(rec.fields, &field{id: s.fieldID(u, -1, name)})
+			return rec, nil
+		}
+		for i := 0; i < u.NumFields(); i++ {
+			if u.Field(i).Name() != name {
+				continue
+			}
+			id := s.fieldID(u, i, name)
+			f := rec.field(id)
+			if f == nil {
+				f = &field{id: id}
+				rec.fields = append(rec.fields, f)
+			}
+			var err error
+			if f.val, err = s.insert(f.val, u.Field(i).Type(), rest, val); err != nil {
+				return nil, err
+			}
+			return rec, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid field path %q of type %s", path, types.TypeString(t, skipQualifier))
+}
+
+// fieldID returns the identifier in the Kaitai spec of the i-th field of the
+// given struct type, of the given name; as given by the id option of the field
+// (see structtag.Tag.ID), or based on the naming strategy. i is -1 for blank
+// fields.
+func (s *synth) fieldID(st *types.Struct, i int, name string) string {
+	if i >= 0 && s.layout.Tag != nil {
+		if id, ok, err := s.layout.Tag(st, i).ID(); ok && err == nil {
+			return id
+		}
+	}
+	return naming.Ident(s.naming, name)
+}
+
+// integer writes an integer of the given field of the layout to buf, and
+// returns the value. The value is random if c is nil.
+func (s *synth) integer(buf *bytes.Buffer, f *layout.Field, c constant.Value) interface{} {
+	var x uint64
+	if c != nil {
+		if v, ok := constant.Int64Val(c); ok {
+			x = uint64(v)
+		} else {
+			x, _ = constant.Uint64Val(c)
+		}
+	} else {
+		x = s.rand.Uint64()
+	}
+	if f.Size < 8 {
+		x &= 1<<(8*uint(f.Size)) - 1
+	}
+	writeUint(buf, byteOrder(f.Endian), f.Size, x)
+	if f.Kind == layout.Uint {
+		return x
+	}
+	// Sign extend.
+	shift := 64 - 8*uint(f.Size)
+	return int64(x<<shift) >> shift
+}
+
+// writeUint writes the size least significant bytes of x to buf, using the
+// given byte order.
+func writeUint(buf *bytes.Buffer, order binary.ByteOrder, size int64, x uint64) {
+	var data [8]byte
+	order.PutUint64(data[:], x)
+	if order == binary.BigEndian {
+		buf.Write(data[8-size:])
+		return
+	}
+	buf.Write(data[:size])
+}
+
+// byteOrder returns the byte order of the given endianness.
+func byteOrder(endian string) binary.ByteOrder {
+	if endian == "be" {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// enumValues returns the constants of the given named integer type.
+func enumValues(t *types.Named) []*types.Const {
+	basic, ok := t.Underlying().(*types.Basic)
+	if !ok || basic.Info()&types.IsInteger == 0 {
+		return nil
+	}
+	pkg := t.Obj().Pkg()
+	if pkg == nil {
+		return nil
+	}
+	var consts []*types.Const
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), t) {
+			continue
+		}
+		consts = append(consts, c)
+	}
+	return consts
+}
+
+func skipQualifier(pkg *types.Package) string {
+	return ""
+}
@@ -0,0 +1,17 @@
+// The kaitaivet tool reports drift between Go struct types and the Kaitai
+// specs they reference by //kaitai:spec directive, as a vet tool; e.g.
+//
+//	go vet -vettool=$(which kaitaivet) ./...
+//
+// Struct fields missing from the seq of the Kaitai type, and seq attributes
+// missing from the struct type, are reported; see the kaitaidrift analyzer.
+package main
+
+import (
+	"github.com/mewrev/tools/internal/drift"
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() {
+	unitchecker.Main(drift.Analyzer)
+}
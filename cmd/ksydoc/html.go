@@ -0,0 +1,191 @@
+package main
+
+import (
+	"html/template"
+	"io"
+)
+
+// Dimensions of the layout diagrams of types, in pixels.
+const (
+	// Bytes per row of layout diagrams.
+	layoutCols = 16
+	// Maximum number of rows of layout diagrams; larger types are truncated.
+	layoutMaxRows = 32
+	// Width of the offset labels of rows.
+	layoutLabelWidth = 56
+	// Width and height of bytes.
+	layoutByteWidth  = 40
+	layoutByteHeight = 28
+)
+
+// layoutColors are the fill colors of the attributes of layout diagrams, in
+// turn.
+var layoutColors = []string{"#cfe2f3", "#d9ead3", "#fff2cc", "#f4cccc", "#d9d2e9", "#fce5cd", "#d0e0e3", "#ead1dc"}
+
+// layout is the layout diagram of the bytes of a type, as an SVG image.
+type layout struct {
+	Width, Height int
+	// Rectangles of the attributes, split at rows.
+	Rects []*layoutRect
+	// Offset labels of the rows.
+	Rows []*layoutText
+	// Truncated to layoutMaxRows.
+	Truncated bool
+}
+
+// layoutRect is a rectangle of the bytes of an attribute in a layout diagram.
+type layoutRect struct {
+	X, Y, Width, Height int
+	Fill                string
+	// Identifier of the attribute, shown if it fits the rectangle.
+	Label string
+	// Tooltip; the identifier, offset and size of the attribute.
+	Title string
+}
+
+// layoutText is a text label of a layout diagram.
+type layoutText struct {
+	X, Y int
+	Text string
+}
+
+// Layout returns the layout diagram of the seq attributes of fixed offset and
+// size of the type, or nil if none.
+func (t *typeDoc) Layout() *layout {
+	l := &layout{}
+	end := int64(0)
+	for i, a := range t.Seq {
+		if a.offset < 0 || a.size <= 0 {
+			break
+		}
+		start, stop := a.offset/8, (a.offset+a.size+7)/8
+		if start >= layoutCols*layoutMaxRows {
+			l.Truncated = true
+			break
+		}
+		if stop > layoutCols*layoutMaxRows {
+			stop = layoutCols * layoutMaxRows
+			l.Truncated = true
+		}
+		title := a.ID + " (offset " + a.Offset() + ", size " + a.Size() + ")"
+		for pos := start; pos < stop; {
+			row := pos / layoutCols
+			rowEnd := (row + 1) * layoutCols
+			if rowEnd > stop {
+				rowEnd = stop
+			}
+			r := &layoutRect{
+				X:      layoutLabelWidth + int(pos%layoutCols)*layoutByteWidth,
+				Y:      int(row) * layoutByteHeight,
+				Width:  int(rowEnd-pos) * layoutByteWidth,
+				Height: layoutByteHeight,
+				Fill:   layoutColors[i%len(layoutColors)],
+				Title:  title,
+			}
+			// Label the first rectangle of the attribute, if the label fits;
+			// approximately 7 pixels per character.
+			if pos == start && len(a.ID)*7+8 <= r.Width {
+				r.Label = a.ID
+			}
+			l.Rects = append(l.Rects, r)
+			pos = rowEnd
+		}
+		if stop > end {
+			end = stop
+		}
+	}
+	if len(l.Rects) == 0 {
+		return nil
+	}
+	rows := int((end + layoutCols - 1) / layoutCols)
+	for row := 0; row < rows; row++ {
+		l.Rows = append(l.Rows, &layoutText{X: 4, Y: row*layoutByteHeight + layoutByteHeight/2 + 4, Text: hexOffset(row * layoutCols)})
+	}
+	l.Width = layoutLabelWidth + layoutCols*layoutByteWidth + 1
+	l.Height = rows*layoutByteHeight + 1
+	return l
+}
+
+// hexOffset returns the given offset in hexadecimal; e.g. 0x0010.
+func hexOffset(offset int) string {
+	const digits = "0123456789abcdef"
+	buf := []byte("0x0000")
+	for i := len(buf) - 1; i >= 2 && offset > 0; i-- {
+		buf[i] = digits[offset%16]
+		offset /= 16
+	}
+	return string(buf)
+}
+
+// renderHTML writes the HTML documentation of the given spec to w.
+func renderHTML(w io.Writer, s *spec) error {
+	return docTmpl.Execute(w, s)
+}
+
+// docTmpl is the template of the HTML documentation of Kaitai specs.
+var docTmpl = template.Must(template.New("doc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}{{if .Title}}: {{.Title}}{{end}}</title>
+<style>
+body { font-family: sans-serif; max-width: 80em; margin: auto; padding: 0 1em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+td.num { text-align: right; font-family: monospace; }
+code, .mono { font-family: monospace; }
+.doc { white-space: pre-wrap; }
+.comment { color: #666; }
+nav li { display: inline; margin-right: 0.8em; }
+svg text { font-family: monospace; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}{{if .Title}}: {{.Title}}{{end}}</h1>
+{{if .Doc}}<p class="doc">{{.Doc}}</p>
+{{end}}{{if .Endian}}<p>Byte order: <code>{{.Endian}}</code></p>
+{{end}}<nav>
+<h2>Types</h2>
+<ul>{{range .Types}}<li><a href="#{{.Anchor}}">{{.Name}}</a></li>{{end}}</ul>
+{{if .Enums}}<h2>Enums</h2>
+<ul>{{range .Enums}}<li><a href="#{{.Anchor}}">{{.Path}}</a></li>{{end}}</ul>
+{{end}}</nav>
+{{range .Types}}<section id="{{.Anchor}}">
+<h2>Type {{.Name}}</h2>
+{{if .Doc}}<p class="doc">{{.Doc}}</p>
+{{end}}<p>{{with .Size}}Size in bytes: {{.}}. {{else}}Variable size. {{end}}{{if .Endian}}Byte order: <code>{{.Endian}}</code>. {{end}}{{if .UsedBy}}Used by: {{range $i, $t := .UsedBy}}{{if $i}}, {{end}}<a href="#{{$t.Anchor}}">{{$t.Name}}</a>{{end}}.{{end}}</p>
+{{if .Params}}<h3>Parameters</h3>
+<table>
+<tr><th>ID</th><th>Type</th><th>Description</th></tr>
+{{range .Params}}<tr><td><code>{{.ID}}</code></td><td>{{template "type" .}}</td><td>{{template "desc" .}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Seq}}<h3>Attributes</h3>
+<table>
+<tr><th title="offset in bytes; byte.bit of bit-sized integers">Offset</th><th title="size in bytes">Size</th><th>ID</th><th>Type</th><th>Description</th></tr>
+{{range .Seq}}<tr><td class="num">{{.Offset}}</td><td class="num">{{.Size}}</td><td><code>{{.ID}}</code></td><td>{{template "type" .}}</td><td>{{template "desc" .}}</td></tr>
+{{end}}</table>
+{{end}}{{with .Layout}}<h3>Layout</h3>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+{{range .Rows}}<text x="{{.X}}" y="{{.Y}}">{{.Text}}</text>
+{{end}}{{range .Rects}}<g><title>{{.Title}}</title><rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" fill="{{.Fill}}" stroke="#666"/>{{if .Label}}<text x="{{.X}}" y="{{.Y}}" dx="4" dy="18">{{.Label}}</text>{{end}}</g>
+{{end}}</svg>
+{{if .Truncated}}<p>Layout truncated to the first 512 bytes.</p>
+{{end}}{{end}}{{if .Instances}}<h3>Instances</h3>
+<table>
+<tr><th>ID</th><th>Type</th><th>Description</th></tr>
+{{range .Instances}}<tr><td><code>{{.ID}}</code></td><td>{{template "type" .}}</td><td>{{template "desc" .}}</td></tr>
+{{end}}</table>
+{{end}}</section>
+{{end}}{{range .Enums}}<section id="{{.Anchor}}">
+<h2>Enum {{.Path}}</h2>
+<table>
+<tr><th>Value</th><th>ID</th><th>Description</th></tr>
+{{range .Values}}<tr><td class="num">{{.Value}}</td><td><code>{{.ID}}</code></td><td class="doc">{{.Doc}}</td></tr>
+{{end}}</table>
+</section>
+{{end}}</body>
+</html>
+{{define "type"}}{{if .TypeRef}}<a href="#{{.TypeRef.Anchor}}">{{.Type}}</a>{{else}}<code>{{.Type}}</code>{{end}}{{if .EnumRef}} enum <a href="#{{.EnumRef.Anchor}}">{{.EnumRef.Path}}</a>{{end}}{{range .Cases}}<br><span class="mono">{{.Value}}</span>: {{if .TypeRef}}<a href="#{{.TypeRef.Anchor}}">{{.Type}}</a>{{else}}<code>{{.Type}}</code>{{end}}{{end}}{{end}}
+{{define "desc"}}{{if .Doc}}<div class="doc">{{.Doc}}</div>{{end}}{{range .Details}}<div class="mono">{{.}}</div>{{end}}{{if .Comment}}<div class="comment">{{.Comment}}</div>{{end}}{{end}}
+`))
@@ -0,0 +1,72 @@
+// The ksydoc tool renders Kaitai specs (.ksy files), hand-written or generated
+// by type2kaitai, as static HTML documentation.
+//
+// Each type is documented by a table of its attributes, with the offset and
+// size of the attributes of fixed size (up to the first attribute of variable
+// size), their types and documentation, a table of its instances, and a layout
+// diagram of its bytes. Each enum is documented by a table of its values.
+// References to user types and enums are cross-linked, and each type lists the
+// types referencing it.
+//
+// Example usage:
+//
+//	ksydoc header_type.ksy # writes header_type.html
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	output = flag.String("output", "", "output file name; default <spec>.html in the directory of the spec")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of ksydoc:\n")
+	fmt.Fprintf(os.Stderr, "\tksydoc [flags] files...\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ksydoc: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(*output) > 0 && flag.NArg() > 1 {
+		log.Fatal("-output option applies only to a single spec")
+	}
+	for _, path := range flag.Args() {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s, err := parseSpec(strings.TrimSuffix(filepath.Base(path), ".ksy"), buf)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		out := &bytes.Buffer{}
+		if err := renderHTML(out, s); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		outputName := *output
+		if outputName == "" {
+			outputName = strings.TrimSuffix(path, ".ksy") + ".html"
+		}
+		if err := ioutil.WriteFile(outputName, out.Bytes(), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// spec is the documentation of a Kaitai spec.
+type spec struct {
+	// Identifier of the spec (meta/id), or the file name if not set.
+	name string
+	// Title (meta/title) and documentation of the spec.
+	Title, Doc string
+	// Default byte order of the spec; le, be or empty.
+	Endian string
+	// Documented types, in declaration order; the root type first, if it has
+	// attributes.
+	Types []*typeDoc
+	// Documented enums, in declaration order.
+	Enums []*enumDoc
+	// typesByPath maps from the paths of the user types (e.g. header::entry)
+	// to their documentation.
+	typesByPath map[string]*typeDoc
+	// enumsByPath maps from the paths of the enums (e.g. header::kind) to their
+	// documentation.
+	enumsByPath map[string]*enumDoc
+}
+
+// Name returns the identifier of the spec.
+func (s *spec) Name() string {
+	return s.name
+}
+
+// typeDoc is the documentation of a type of a Kaitai spec; either the root type
+// or a user type.
+type typeDoc struct {
+	// Path of the type, relative to the root type (e.g. header::entry); empty
+	// for the root type.
+	path string
+	// Name of the type in the documentation; the path of the type, or the
+	// identifier of the spec for the root type.
+	Name string
+	// Documentation of the type.
+	Doc string
+	// Byte order of the type (meta/endian), if set.
+	Endian string
+	// Parameters, seq attributes and instances of the type.
+	Params, Seq, Instances []*attrDoc
+	// Types referencing the type, in declaration order.
+	UsedBy []*typeDoc
+	// Size of the type in bits; -1 if of variable size.
+	size int64
+	// State of the size computation; see sizeOf.
+	sizeState int
+}
+
+// Anchor returns the HTML anchor of the type.
+func (t *typeDoc) Anchor() string {
+	if len(t.path) == 0 {
+		return "root"
+	}
+	return "type-" + strings.Replace(t.path, "::", "-", -1)
+}
+
+// Size returns the size of the type in bytes (e.g. "16"), or the empty string
+// if of variable size.
+func (t *typeDoc) Size() string {
+	return sizeString(t.size)
+}
+
+// attrDoc is the documentation of a parameter, seq attribute or instance.
+type attrDoc struct {
+	// Identifier of the attribute.
+	ID string
+	// Type of the attribute as given by the spec; e.g. u4, str or header. For
+	// attributes without type; bytes, contents or value.
+	Type string
+	// User type and enum referenced by the attribute, if any.
+	TypeRef *typeDoc
+	EnumRef *enumDoc
+	// Cases of switch types.
+	Cases []*caseDoc
+	// Other keys of the attribute; e.g. "repeat: expr" or "if: len > 0".
+	Details []string
+	// Documentation of the attribute.
+	Doc string
+	// Comment of the attribute in the spec; e.g. the Go type of attributes of
+	// specs generated by type2kaitai.
+	Comment string
+	// Offset and size of the attribute in bits; -1 if not fixed.
+	offset, size int64
+	// YAML mapping of the attribute.
+	node *yaml.Node
+}
+
+// Offset returns the byte offset of the attribute (e.g. "4", or "4.3" for bit
+// 3 of byte 4), or the empty string if not fixed.
+func (a *attrDoc) Offset() string {
+	if a.offset < 0 {
+		return ""
+	}
+	if a.offset%8 != 0 {
+		return fmt.Sprintf("%d.%d", a.offset/8, a.offset%8)
+	}
+	return strconv.FormatInt(a.offset/8, 10)
+}
+
+// Size returns the size of the attribute in bytes (e.g. "4", or "3 bits"), or
+// the empty string if not fixed.
+func (a *attrDoc) Size() string {
+	return sizeString(a.size)
+}
+
+// sizeString returns the given size in bits as a string; in bytes if a multiple
+// of 8, or the empty string if negative (i.e. variable).
+func sizeString(size int64) string {
+	switch {
+	case size < 0:
+		return ""
+	case size%8 != 0:
+		return fmt.Sprintf("%d bits", size)
+	}
+	return strconv.FormatInt(size/8, 10)
+}
+
+// caseDoc is a case of a switch type.
+type caseDoc struct {
+	// Value of the case; e.g. 1 or _.
+	Value string
+	// Type of the case, and the user type it references, if any.
+	Type    string
+	TypeRef *typeDoc
+}
+
+// enumDoc is the documentation of an enum of a Kaitai spec.
+type enumDoc struct {
+	// Path of the enum (e.g. header::kind).
+	Path string
+	// Values of the enum, in declaration order.
+	Values []*enumValue
+}
+
+// Anchor returns the HTML anchor of the enum.
+func (e *enumDoc) Anchor() string {
+	return "enum-" + strings.Replace(e.Path, "::", "-", -1)
+}
+
+// enumValue is a value of an enum.
+type enumValue struct {
+	// Value (e.g. 0x10) and identifier of the value.
+	Value, ID string
+	// Documentation of the value.
+	Doc string
+}
+
+// parseSpec parses the given Kaitai spec into its documentation. The spec is
+// named by its meta/id, or by the given name if not set.
+func parseSpec(name string, buf []byte) (*spec, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("invalid Kaitai spec; %v", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid Kaitai spec; root is not a mapping")
+	}
+	root := doc.Content[0]
+	meta := kaitai.Lookup(root, "meta")
+	s := &spec{
+		name:        kaitai.LookupValue(meta, "id"),
+		Title:       kaitai.LookupValue(meta, "title"),
+		Doc:         kaitai.LookupValue(root, "doc"),
+		Endian:      kaitai.LookupValue(meta, "endian"),
+		typesByPath: make(map[string]*typeDoc),
+		enumsByPath: make(map[string]*enumDoc),
+	}
+	var nodes []*yaml.Node
+	s.collect(root, &nodes)
+	for i, t := range s.Types {
+		s.resolve(t, nodes[i])
+	}
+	for _, t := range s.Types {
+		s.sizeOf(t)
+	}
+	if len(s.name) == 0 {
+		s.name = name
+	}
+	s.Types[0].Name = s.name
+	// Omit the root type if it only holds the user types and enums (e.g. the
+	// specs generated by type2kaitai).
+	if r := s.Types[0]; len(r.path) == 0 && len(r.Seq) == 0 && len(r.Instances) == 0 && len(r.Params) == 0 {
+		s.Types = s.Types[1:]
+	}
+	return s, nil
+}
+
+// collect adds the given top-level type spec, and its nested user types and
+// enums, to the documented types and enums of the spec. The YAML mappings of
+// the types are appended to nodes.
+func (s *spec) collect(root *yaml.Node, nodes *[]*yaml.Node) {
+	kaitai.Walk(root, "", func(path string, node *yaml.Node) error {
+		t := &typeDoc{
+			path:   path,
+			Name:   path,
+			Doc:    kaitai.LookupValue(node, "doc"),
+			Endian: kaitai.LookupValue(kaitai.Lookup(node, "meta"), "endian"),
+		}
+		s.Types = append(s.Types, t)
+		s.typesByPath[path] = t
+		*nodes = append(*nodes, node)
+		return nil
+	}, func(path string, values *yaml.Node) {
+		e := &enumDoc{Path: path}
+		kaitai.ForPairs(values, func(value, id *yaml.Node) {
+			v := &enumValue{Value: value.Value, ID: id.Value}
+			if id.Kind == yaml.MappingNode {
+				v.ID = kaitai.LookupValue(id, "id")
+				v.Doc = kaitai.LookupValue(id, "doc")
+			}
+			e.Values = append(e.Values, v)
+		})
+		s.Enums = append(s.Enums, e)
+		s.enumsByPath[e.Path] = e
+	})
+}
+
+// resolve documents the parameters, seq attributes and instances of the given
+// type, resolving their references to user types and enums.
+func (s *spec) resolve(t *typeDoc, spec *yaml.Node) {
+	if params := kaitai.Lookup(spec, "params"); params != nil {
+		for _, param := range params.Content {
+			t.Params = append(t.Params, s.attr(t, param, kaitai.LookupValue(param, "id")))
+		}
+	}
+	if seq := kaitai.Lookup(spec, "seq"); seq != nil {
+		for _, attr := range seq.Content {
+			t.Seq = append(t.Seq, s.attr(t, attr, kaitai.LookupValue(attr, "id")))
+		}
+	}
+	kaitai.ForPairs(kaitai.Lookup(spec, "instances"), func(id, inst *yaml.Node) {
+		t.Instances = append(t.Instances, s.attr(t, inst, id.Value))
+	})
+}
+
+// attr returns the documentation of the given attribute of the given type.
+func (s *spec) attr(t *typeDoc, attr *yaml.Node, id string) *attrDoc {
+	a := &attrDoc{ID: id, offset: -1, size: -1, node: attr}
+	if attr.Kind != yaml.MappingNode {
+		return a
+	}
+	typ := kaitai.Lookup(attr, "type")
+	switch {
+	case typ != nil && typ.Kind == yaml.ScalarNode:
+		a.Type = typ.Value
+		a.TypeRef = s.lookupType(t, typ.Value)
+	case typ != nil && typ.Kind == yaml.MappingNode:
+		a.Type = "switch-on " + kaitai.LookupValue(typ, "switch-on")
+		kaitai.ForPairs(kaitai.Lookup(typ, "cases"), func(value, caseType *yaml.Node) {
+			c := &caseDoc{Value: value.Value, Type: caseType.Value, TypeRef: s.lookupType(t, caseType.Value)}
+			a.Cases = append(a.Cases, c)
+		})
+	case kaitai.Lookup(attr, "contents") != nil:
+		a.Type = "contents"
+	case kaitai.Lookup(attr, "value") != nil:
+		a.Type = "value"
+	default:
+		a.Type = "bytes"
+	}
+	if enum := kaitai.LookupValue(attr, "enum"); len(enum) > 0 {
+		a.EnumRef = s.lookupEnum(t, enum)
+	}
+	kaitai.ForPairs(attr, func(key, value *yaml.Node) {
+		if len(a.Comment) == 0 && len(value.LineComment) > 0 {
+			a.Comment = strings.TrimSpace(strings.TrimPrefix(value.LineComment, "#"))
+		}
+		switch {
+		case key.Value == "id" || key.Value == "type" || key.Value == "enum":
+		case key.Value == "doc":
+			a.Doc = value.Value
+		case strings.HasPrefix(key.Value, "-"):
+			// Custom keys.
+		default:
+			a.Details = append(a.Details, key.Value+": "+flow(value))
+		}
+	})
+	for _, c := range a.Cases {
+		s.addUse(c.TypeRef, t)
+	}
+	s.addUse(a.TypeRef, t)
+	return a
+}
+
+// addUse records the use of the given user type, if any, by the given type.
+func (s *spec) addUse(used, by *typeDoc) {
+	if used == nil {
+		return
+	}
+	for _, t := range used.UsedBy {
+		if t == by {
+			return
+		}
+	}
+	used.UsedBy = append(used.UsedBy, by)
+}
+
+// lookupType returns the user type referenced by the given type name (e.g.
+// entry, header::entry or entry(4)) from the scope of the given type, or nil
+// if not a user type of the spec; see kaitai.ResolvePath.
+func (s *spec) lookupType(t *typeDoc, name string) *typeDoc {
+	if pos := strings.Index(name, "("); pos != -1 {
+		name = name[:pos]
+	}
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return nil
+	}
+	path, ok := kaitai.ResolvePath(t.path, name, func(path string) bool {
+		_, ok := s.typesByPath[path]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+	return s.typesByPath[path]
+}
+
+// lookupEnum returns the enum referenced by the given enum name from the scope
+// of the given type, or nil if not an enum of the spec; see kaitai.ResolvePath.
+func (s *spec) lookupEnum(t *typeDoc, name string) *enumDoc {
+	path, ok := kaitai.ResolvePath(t.path, name, func(path string) bool {
+		_, ok := s.enumsByPath[path]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+	return s.enumsByPath[path]
+}
+
+// Size computation states of types.
+const (
+	sizeUnknown = iota
+	sizeComputing
+	sizeDone
+)
+
+// sizeOf returns the size in bits of the given type, or -1 if of variable
+// size, and lays out its seq attributes; the offsets of the attributes are set
+// up to the first attribute of variable size. Recursive types are of variable
+// size.
+func (s *spec) sizeOf(t *typeDoc) int64 {
+	switch t.sizeState {
+	case sizeComputing:
+		return -1
+	case sizeDone:
+		return t.size
+	}
+	t.sizeState = sizeComputing
+	off := int64(0)
+	for _, a := range t.Seq {
+		a.size = s.attrSize(a)
+		if off < 0 {
+			continue
+		}
+		if !reBitType.MatchString(a.Type) && off%8 != 0 {
+			// Attributes following bit fields are byte-aligned.
+			off += 8 - off%8
+		}
+		a.offset = off
+		if a.size < 0 {
+			off = -1
+			continue
+		}
+		off += a.size
+	}
+	if off > 0 && off%8 != 0 {
+		off += 8 - off%8
+	}
+	t.size = off
+	t.sizeState = sizeDone
+	return t.size
+}
+
+var (
+	// reIntType matches the built-in integer and float types; e.g. u4le or f8.
+	reIntType = regexp.MustCompile(`^[usf]([1248])(?:le|be)?$`)
+	// reBitType matches the built-in bit-sized integer types; e.g. b3 or b12le.
+	reBitType = regexp.MustCompile(`^b([0-9]+)(?:le|be)?$`)
+)
+
+// attrSize returns the size in bits of the given seq attribute, or -1 if of
+// variable size (e.g. conditional attributes, or sized by expressions).
+func (s *spec) attrSize(a *attrDoc) int64 {
+	attr := a.node
+	if attr.Kind != yaml.MappingNode || kaitai.Lookup(attr, "if") != nil {
+		return -1
+	}
+	size := int64(-1)
+	if contents := kaitai.Lookup(attr, "contents"); contents != nil {
+		size = contentsSize(contents) * 8
+	} else if sizeNode := kaitai.Lookup(attr, "size"); sizeNode != nil {
+		if n, ok := intLit(sizeNode); ok {
+			size = n * 8
+		}
+	} else if m := reIntType.FindStringSubmatch(a.Type); m != nil {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		size = n * 8
+	} else if m := reBitType.FindStringSubmatch(a.Type); m != nil {
+		size, _ = strconv.ParseInt(m[1], 10, 64)
+	} else if a.TypeRef != nil {
+		size = s.sizeOf(a.TypeRef)
+	}
+	if size < 0 {
+		return -1
+	}
+	switch kaitai.LookupValue(attr, "repeat") {
+	case "":
+		return size
+	case "expr":
+		if n, ok := intLit(kaitai.Lookup(attr, "repeat-expr")); ok {
+			return size * n
+		}
+	}
+	return -1
+}
+
+// contentsSize returns the size in bytes of the given contents key; a string,
+// or a sequence of bytes and strings.
+func contentsSize(contents *yaml.Node) int64 {
+	if contents.Kind == yaml.ScalarNode {
+		return int64(len(contents.Value))
+	}
+	size := int64(0)
+	for _, elem := range contents.Content {
+		if _, ok := intLit(elem); ok {
+			size++
+			continue
+		}
+		size += int64(len(elem.Value))
+	}
+	return size
+}
+
+// intLit returns the value of the given integer literal node (e.g. 16 or 0x10).
+func intLit(n *yaml.Node) (int64, bool) {
+	if n == nil || n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+		return 0, false
+	}
+	x, err := strconv.ParseInt(n.Value, 0, 64)
+	return x, err == nil
+}
+
+// flow returns the given YAML node in flow style; e.g. [0x7f, ELF].
+func flow(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		var elems []string
+		for _, elem := range n.Content {
+			elems = append(elems, flow(elem))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case yaml.MappingNode:
+		var pairs []string
+		kaitai.ForPairs(n, func(key, value *yaml.Node) {
+			pairs = append(pairs, key.Value+": "+flow(value))
+		})
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	return n.Value
+}
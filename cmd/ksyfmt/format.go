@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// Canonical key orders of the mappings of Kaitai specs, as output by
+// type2kaitai. Custom keys (starting with "-"; e.g. -webide-representation or
+// -orig-id) follow the doc-ref key, and unknown keys come last, in their
+// original order.
+var (
+	specOrder      = []string{"meta", "doc", "doc-ref", "-", "to-string", "params", "seq", "instances", "types", "enums"}
+	metaOrder      = []string{"id", "title", "application", "file-extension", "xref", "tags", "license", "ks-version", "ks-debug", "encoding", "endian", "bit-endian", "ks-opaque-types", "ks-zero-copy-substream", "imports"}
+	attrOrder      = []string{"id", "pos", "io", "contents", "type", "size", "size-eos", "terminator", "consume", "include", "eos-error", "pad-right", "encoding", "enum", "process", "repeat", "repeat-expr", "repeat-until", "if", "value", "valid", "parent", "doc", "doc-ref", "-"}
+	paramOrder     = []string{"id", "type", "enum", "doc", "doc-ref", "-"}
+	switchOrder    = []string{"switch-on", "cases"}
+	enumValueOrder = []string{"id", "doc", "doc-ref", "-"}
+)
+
+// format returns the given Kaitai spec in canonical formatting:
+//
+//	keys of types, meta sections, attributes, parameters and enum values in
+//	canonical order (see specOrder)
+//
+//	mappings in the block style, indented by two spaces, with the items of
+//	block sequences indented below their key
+//
+//	scalars unquoted unless quoting is required to preserve their value (e.g.
+//	'{name}: {value}' or '0x10' as a string), and multi-line scalars in the
+//	literal block style
+//
+// Comments, flow sequences (e.g. contents: [0x7f, ELF]) and the notation of
+// integers (e.g. 0x10) are preserved; thus the specs generated by type2kaitai
+// are formatted as is.
+func format(src []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML; %v", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid Kaitai spec; root is not a mapping")
+	}
+	root := doc.Content[0]
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("invalid Kaitai spec; empty spec")
+	}
+	// Keep the head comment of the spec (e.g. a license header) on top.
+	header := root.Content[0].HeadComment
+	root.Content[0].HeadComment = ""
+	formatSpec(root)
+	root.Content[0].HeadComment = strings.TrimPrefix(header+"\n"+root.Content[0].HeadComment, "\n")
+	formatStyles(&doc)
+	hoistFootComments(&doc, strings.Split(string(src), "\n"))
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("unable to encode Kaitai spec; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("unable to encode Kaitai spec; %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// formatSpec orders the keys of the given type spec and its nested mappings;
+// either the root of the spec or a user type.
+func formatSpec(spec *yaml.Node) {
+	sortKeys(spec, specOrder)
+	if meta := kaitai.Lookup(spec, "meta"); meta != nil {
+		sortKeys(meta, metaOrder)
+		sortKeys(kaitai.Lookup(meta, "endian"), switchOrder)
+	}
+	if params := kaitai.Lookup(spec, "params"); params != nil {
+		for _, param := range params.Content {
+			sortKeys(param, paramOrder)
+		}
+	}
+	if seq := kaitai.Lookup(spec, "seq"); seq != nil {
+		for _, attr := range seq.Content {
+			formatAttr(attr)
+		}
+	}
+	forValues(kaitai.Lookup(spec, "instances"), formatAttr)
+	forValues(kaitai.Lookup(spec, "types"), formatSpec)
+	forValues(kaitai.Lookup(spec, "enums"), func(values *yaml.Node) {
+		forValues(values, func(value *yaml.Node) {
+			sortKeys(value, enumValueOrder)
+		})
+	})
+}
+
+// formatAttr orders the keys of the given attribute of a seq or instance.
+func formatAttr(attr *yaml.Node) {
+	sortKeys(attr, attrOrder)
+	sortKeys(kaitai.Lookup(attr, "type"), switchOrder)
+}
+
+// forValues calls f for the values of the given mapping, if any.
+func forValues(m *yaml.Node, f func(value *yaml.Node)) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 1; i < len(m.Content); i += 2 {
+		f(m.Content[i])
+	}
+}
+
+// sortKeys sorts the keys of the given mapping, if any, in the given key order;
+// "-" in the order stands for custom keys. Keys of the same rank (e.g. two
+// custom keys) keep their relative order.
+func sortKeys(m *yaml.Node, order []string) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	rank := func(key string) int {
+		if strings.HasPrefix(key, "-") {
+			key = "-"
+		}
+		for i, k := range order {
+			if k == key {
+				return i
+			}
+		}
+		return len(order)
+	}
+	type pair struct {
+		key, value *yaml.Node
+	}
+	var pairs []pair
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		pairs = append(pairs, pair{key: m.Content[i], value: m.Content[i+1]})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return rank(pairs[i].key.Value) < rank(pairs[j].key.Value)
+	})
+	for i, p := range pairs {
+		m.Content[2*i] = p.key
+		m.Content[2*i+1] = p.value
+	}
+}
+
+// formatStyles normalizes the styles of the given node and its descendants;
+// mappings use the block style, multi-line scalars (e.g. doc keys) use the
+// literal block style, and other scalars are plain, quoted by the encoder only
+// as required.
+func formatStyles(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		n.Style = 0
+	case yaml.ScalarNode:
+		if strings.Contains(n.Value, "\n") {
+			n.Style = yaml.LiteralStyle
+		} else {
+			n.Style = 0
+		}
+	}
+	for _, child := range n.Content {
+		formatStyles(child)
+	}
+}
+
+// hoistFootComments moves the foot comments of block sequences of mappings
+// back to the keys of the sequences, as located in the given source lines. The
+// YAML parser attaches comments following a sequence, indented as its key (e.g.
+// the comments of the skipped fields of type2kaitai below a seq), to the last
+// key of the last item, which would indent them as the item on output.
+func hoistFootComments(n *yaml.Node, lines []string) {
+	for _, child := range n.Content {
+		hoistFootComments(child, lines)
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, seq := n.Content[i], n.Content[i+1]
+		if seq.Kind != yaml.SequenceNode || seq.Style == yaml.FlowStyle || len(seq.Content) == 0 {
+			continue
+		}
+		item := seq.Content[len(seq.Content)-1]
+		if item.Kind != yaml.MappingNode || len(item.Content) == 0 {
+			continue
+		}
+		last := item.Content[len(item.Content)-2]
+		if len(last.FootComment) == 0 || commentIndent(lines, last.Line, last.FootComment) >= item.Column-1 {
+			continue
+		}
+		key.FootComment = strings.TrimPrefix(key.FootComment+"\n"+last.FootComment, "\n")
+		last.FootComment = ""
+	}
+}
+
+// commentIndent returns the indentation of the first line of the given comment,
+// located in the given source lines after the given line (1-based), or -1 if
+// not found.
+func commentIndent(lines []string, line int, comment string) int {
+	first := strings.SplitN(comment, "\n", 2)[0]
+	for i := line; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == first {
+			return len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+		}
+	}
+	return -1
+}
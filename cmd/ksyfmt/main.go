@@ -0,0 +1,89 @@
+// The ksyfmt tool formats Kaitai specs (.ksy files) in the canonical style of
+// the specs generated by type2kaitai, so that generated and hand-written specs
+// share one style; see format.
+//
+// Without an explicit path, it formats standard input, writing the result to
+// standard output. Given files, it writes the formatted specs to standard
+// output by default, as does gofmt; with -w the files are overwritten, and
+// with -l the names of the files whose formatting differs are listed instead.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from ksyfmt's")
+	write = flag.Bool("w", false, "write result to (source) file instead of stdout")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of ksyfmt:\n")
+	fmt.Fprintf(os.Stderr, "\tksyfmt [flags] # format standard input\n")
+	fmt.Fprintf(os.Stderr, "\tksyfmt [flags] files...\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ksyfmt: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			log.Fatal("cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !processFile("<standard input>", src) {
+			os.Exit(1)
+		}
+		return
+	}
+	ok := true
+	for _, path := range flag.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ok = processFile(path, src) && ok
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// processFile formats the given Kaitai spec read from the named file, as
+// selected by -l and -w, and reports whether it was formatted successfully.
+func processFile(path string, src []byte) bool {
+	res, err := format(src)
+	if err != nil {
+		log.Printf("%s: %v", path, err)
+		return false
+	}
+	if *list || *write {
+		if bytes.Equal(src, res) {
+			return true
+		}
+		if *list {
+			fmt.Println(path)
+		}
+		if *write {
+			if err := ioutil.WriteFile(path, res, 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return true
+	}
+	os.Stdout.Write(res)
+	return true
+}
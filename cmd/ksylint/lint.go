@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/kaitai"
+)
+
+// problem is a problem of a Kaitai spec, located by line and column (1-based).
+type problem struct {
+	line, col int
+	// Warning rather than error.
+	warning bool
+	msg     string
+}
+
+// linter checks a Kaitai spec.
+type linter struct {
+	// Type names referenced by the types of attributes and parameters; the
+	// components of type paths (e.g. header and entry of header::entry).
+	refs map[string]bool
+	// Declared user types, in order.
+	decls []*typeDecl
+	// Problems of the spec.
+	problems []*problem
+}
+
+// typeDecl is the declaration of a user type.
+type typeDecl struct {
+	// Key node of the type in the types mapping.
+	name *yaml.Node
+	// Declared in the types of the root type.
+	top bool
+}
+
+// Valid keys of the mappings of Kaitai specs. Keys starting with "-" are
+// custom keys (e.g. -webide-representation or -orig-id), and always valid.
+var (
+	specKeys = keySet("meta", "doc", "doc-ref", "params", "seq", "instances", "types", "enums", "to-string")
+	metaKeys = keySet("id", "title", "application", "file-extension", "xref", "license", "ks-version", "ks-debug", "ks-opaque-types", "ks-zero-copy-substream", "imports", "encoding", "endian", "bit-endian", "tags")
+	attrKeys = keySet("id", "doc", "doc-ref", "contents", "type", "repeat", "repeat-expr", "repeat-until", "if", "size", "size-eos", "process", "enum", "encoding", "pad-right", "terminator", "consume", "include", "eos-error", "pos", "io", "value", "valid", "parent")
+	// Keys of the parameters of types.
+	paramKeys = keySet("id", "type", "doc", "doc-ref", "enum")
+	// Keys of switch types and switch endianness.
+	switchKeys = keySet("switch-on", "cases")
+	// Keys of the verbose form of enum values.
+	enumValueKeys = keySet("id", "doc", "doc-ref")
+)
+
+// keySet returns the set of the given keys.
+func keySet(keys ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+var (
+	// reID matches valid identifiers of Kaitai specs; lower snake_case.
+	reID = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// reEndianType matches the built-in types of unspecified endianness;
+	// multi-byte integers and floats.
+	reEndianType = regexp.MustCompile(`^(?:[us][248]|f[48])$`)
+)
+
+// lint returns the problems of the given Kaitai spec, in source order.
+func lint(buf []byte) []*problem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return []*problem{{line: 1, col: 1, msg: fmt.Sprintf("invalid YAML; %v", err)}}
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return []*problem{{line: 1, col: 1, msg: "invalid Kaitai spec; root is not a mapping"}}
+	}
+	root := doc.Content[0]
+	l := &linter{refs: make(map[string]bool)}
+	if id := kaitai.Lookup(kaitai.Lookup(root, "meta"), "id"); id != nil {
+		l.checkID(id, "spec")
+	}
+	l.checkSpec(root, true, false)
+	// Types of the root type are entry points of specs without a seq or
+	// instances of their own (e.g. the specs of type2kaitai, imported by
+	// other specs), and are not reported.
+	entry := kaitai.Lookup(root, "seq") != nil || kaitai.Lookup(root, "instances") != nil
+	for _, decl := range l.decls {
+		if l.refs[decl.name.Value] || decl.top && !entry {
+			continue
+		}
+		l.warnf(decl.name, "type %s is never referenced", decl.name.Value)
+	}
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		pi, pj := l.problems[i], l.problems[j]
+		if pi.line != pj.line {
+			return pi.line < pj.line
+		}
+		return pi.col < pj.col
+	})
+	return l.problems
+}
+
+// checkSpec checks the given type spec; either the root of the spec or a user
+// type. endian specifies whether the default endianness is set by the spec or
+// an enclosing type.
+func (l *linter) checkSpec(spec *yaml.Node, root, endian bool) {
+	if spec.Kind != yaml.MappingNode {
+		l.errorf(spec, "type spec is not a mapping")
+		return
+	}
+	l.checkKeys(spec, specKeys, "type spec")
+	if meta := kaitai.Lookup(spec, "meta"); meta != nil {
+		l.checkKeys(meta, metaKeys, "meta")
+		if endianNode := kaitai.Lookup(meta, "endian"); endianNode != nil {
+			if endianNode.Kind == yaml.MappingNode {
+				l.checkKeys(endianNode, switchKeys, "switch endianness")
+			}
+			endian = true
+		}
+	}
+	if params := kaitai.Lookup(spec, "params"); params != nil {
+		for _, param := range params.Content {
+			if param.Kind != yaml.MappingNode {
+				l.errorf(param, "parameter is not a mapping")
+				continue
+			}
+			l.checkKeys(param, paramKeys, "parameter")
+			if id := kaitai.Lookup(param, "id"); id != nil {
+				l.checkID(id, "parameter")
+			}
+			if typ := kaitai.Lookup(param, "type"); typ != nil && typ.Kind == yaml.ScalarNode {
+				// Parameters are not parsed; regardless of endianness.
+				l.checkType(typ, true)
+			}
+		}
+	}
+	if seq := kaitai.Lookup(spec, "seq"); seq != nil {
+		for _, attr := range seq.Content {
+			l.checkAttr(attr, endian)
+		}
+	}
+	if insts := kaitai.Lookup(spec, "instances"); insts != nil && insts.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(insts.Content); i += 2 {
+			l.checkID(insts.Content[i], "instance")
+			l.checkAttr(insts.Content[i+1], endian)
+		}
+	}
+	if types := kaitai.Lookup(spec, "types"); types != nil && types.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(types.Content); i += 2 {
+			name := types.Content[i]
+			l.checkID(name, "type")
+			l.decls = append(l.decls, &typeDecl{name: name, top: root})
+			l.checkSpec(types.Content[i+1], false, endian)
+		}
+	}
+	if enums := kaitai.Lookup(spec, "enums"); enums != nil && enums.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(enums.Content); i += 2 {
+			l.checkID(enums.Content[i], "enum")
+			l.checkEnum(enums.Content[i], enums.Content[i+1])
+		}
+	}
+}
+
+// checkAttr checks the given attribute of a seq or instance. endian specifies
+// whether the default endianness is set.
+func (l *linter) checkAttr(attr *yaml.Node, endian bool) {
+	if attr.Kind != yaml.MappingNode {
+		l.errorf(attr, "attribute is not a mapping")
+		return
+	}
+	l.checkKeys(attr, attrKeys, "attribute")
+	if id := kaitai.Lookup(attr, "id"); id != nil {
+		l.checkID(id, "attribute")
+	}
+	typ := kaitai.Lookup(attr, "type")
+	switch {
+	case typ == nil:
+		// Byte array or value instance.
+	case typ.Kind == yaml.ScalarNode:
+		l.checkType(typ, endian)
+	case typ.Kind == yaml.MappingNode:
+		l.checkKeys(typ, switchKeys, "switch type")
+		if cases := kaitai.Lookup(typ, "cases"); cases != nil && cases.Kind == yaml.MappingNode {
+			for i := 1; i < len(cases.Content); i += 2 {
+				if cases.Content[i].Kind == yaml.ScalarNode {
+					l.checkType(cases.Content[i], endian)
+				}
+			}
+		}
+	}
+}
+
+// checkType checks the given type reference of an attribute or parameter, and
+// records the referenced user types. endian specifies whether the default
+// endianness is set.
+func (l *linter) checkType(typ *yaml.Node, endian bool) {
+	name := typ.Value
+	if pos := strings.Index(name, "("); pos != -1 {
+		// Arguments of parametric types; e.g. entry(4).
+		name = name[:pos]
+	}
+	name = strings.TrimSpace(name)
+	if reEndianType.MatchString(name) && !endian {
+		l.errorf(typ, "type %s of unspecified endianness; set meta/endian or use %sle or %sbe", name, name, name)
+	}
+	for _, part := range strings.Split(name, "::") {
+		l.refs[part] = true
+	}
+}
+
+// checkEnum checks the values of the given enum.
+func (l *linter) checkEnum(name, values *yaml.Node) {
+	if values.Kind != yaml.MappingNode {
+		l.errorf(values, "enum %s is not a mapping", name.Value)
+		return
+	}
+	var vals []int64
+	integers := true
+	for i := 0; i+1 < len(values.Content); i += 2 {
+		key, value := values.Content[i], values.Content[i+1]
+		x, err := strconv.ParseInt(key.Value, 0, 64)
+		if err != nil {
+			if _, err := strconv.ParseUint(key.Value, 0, 64); err != nil {
+				l.errorf(key, "value %q of enum %s is not an integer", key.Value, name.Value)
+			}
+			integers = false
+		}
+		vals = append(vals, x)
+		switch value.Kind {
+		case yaml.ScalarNode:
+			l.checkID(value, "enum value")
+		case yaml.MappingNode:
+			l.checkKeys(value, enumValueKeys, "enum value")
+			if id := kaitai.Lookup(value, "id"); id != nil {
+				l.checkID(id, "enum value")
+			} else {
+				l.errorf(value, "missing id of enum value %s of enum %s", key.Value, name.Value)
+			}
+		}
+	}
+	if integers {
+		if missing := enumGaps(vals); len(missing) > 0 {
+			l.warnf(name, "enum %s has gaps; missing values %s", name.Value, missing)
+		}
+	}
+}
+
+// enumGaps returns the missing values of the given enum values, as a list of
+// values and ranges (e.g. "3, 5-7"), or the empty string if none are missing.
+// Only gaps of mostly contiguous enums are reported, with fewer missing values
+// than values; flag enums of powers of two and sparse enums (e.g. of magic
+// numbers) are not.
+func enumGaps(vals []int64) string {
+	if len(vals) < 2 {
+		return ""
+	}
+	flags := true
+	for _, x := range vals {
+		if x < 0 || x&(x-1) != 0 {
+			flags = false
+		}
+	}
+	if flags {
+		return ""
+	}
+	sorted := append([]int64(nil), vals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	if hi-lo < 0 || hi-lo >= int64(2*len(sorted)) {
+		// Sparse enum (or overflow).
+		return ""
+	}
+	var gaps []string
+	for i := 1; i < len(sorted); i++ {
+		prev, x := sorted[i-1], sorted[i]
+		switch {
+		case x-prev == 2:
+			gaps = append(gaps, strconv.FormatInt(prev+1, 10))
+		case x-prev > 2:
+			gaps = append(gaps, fmt.Sprintf("%d-%d", prev+1, x-1))
+		}
+	}
+	return strings.Join(gaps, ", ")
+}
+
+// checkKeys checks that the keys of the given mapping are valid keys of the
+// given kind of mapping (e.g. "attribute").
+func (l *linter) checkKeys(m *yaml.Node, keys map[string]bool, kind string) {
+	if m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key := m.Content[i]
+		if strings.HasPrefix(key.Value, "-") || keys[key.Value] {
+			continue
+		}
+		l.errorf(key, "unknown key %q of %s", key.Value, kind)
+	}
+}
+
+// checkID checks that the given identifier of the given kind (e.g. "type") is
+// lower snake_case, as required by the Kaitai Struct compiler.
+func (l *linter) checkID(id *yaml.Node, kind string) {
+	if id.Kind != yaml.ScalarNode || reID.MatchString(id.Value) {
+		return
+	}
+	l.errorf(id, "%s identifier %q is not snake_case", kind, id.Value)
+}
+
+// errorf reports an error located at the given node.
+func (l *linter) errorf(node *yaml.Node, format string, args ...interface{}) {
+	l.problems = append(l.problems, &problem{line: node.Line, col: node.Column, msg: fmt.Sprintf(format, args...)})
+}
+
+// warnf reports a warning located at the given node.
+func (l *linter) warnf(node *yaml.Node, format string, args ...interface{}) {
+	l.problems = append(l.problems, &problem{line: node.Line, col: node.Column, warning: true, msg: fmt.Sprintf(format, args...)})
+}
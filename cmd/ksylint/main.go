@@ -0,0 +1,77 @@
+// The ksylint tool checks Kaitai specs (.ksy files), hand-written or generated
+// by type2kaitai, for problems the Kaitai Struct compiler would reject or which
+// likely indicate mistakes, without running the compiler.
+//
+// Errors are reported for
+//
+//	unknown keys                 e.g. "sise: 4" rather than "size: 4"
+//	non-snake_case identifiers   of types, enums, enum values, attributes and parameters
+//	missing endianness           of multi-byte integer and float types (e.g. u4)
+//
+// and warnings for
+//
+//	unreferenced types           types never referenced by the type of an attribute
+//	enum gaps                    missing values of mostly contiguous enums
+//
+// Example output:
+//
+//	header.ksy:12:9: error: unknown key "sise" of attribute
+//	header.ksy:20:3: warning: type unused_type is never referenced
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+var (
+	strict = flag.Bool("strict", false, "exit with a non-zero status if any warnings are found")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of ksylint:\n")
+	fmt.Fprintf(os.Stderr, "\tksylint [flags] files...\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ksylint: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if !lintFiles(flag.Args()) {
+		os.Exit(1)
+	}
+}
+
+// lintFiles prints the problems of the given Kaitai specs, and reports whether
+// no errors were found (nor warnings, with -strict).
+func lintFiles(paths []string) bool {
+	ok := true
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range lint(buf) {
+			kind := "error"
+			if p.warning {
+				kind = "warning"
+			}
+			if !p.warning || *strict {
+				ok = false
+			}
+			fmt.Printf("%s:%d:%d: %s: %s\n", path, p.line, p.col, kind, p.msg)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// analyze returns the IR of the given types and their dependencies, as
+// consumed by the backends. Identifiers are resolved by the -rename map and
+// -naming strategy, and the options of the kaitai struct tags of fields are
+// parsed; problems with the tags are recorded once, here, rather than by each
+// backend.
+func (g *Generator) analyze(typeNames []string) *ir.Module {
+	a := &analyzer{
+		g:          g,
+		named:      make(map[*types.Named]*ir.Type),
+		lengths:    g.lengthFields(),
+		magics:     g.magicFields(),
+		fieldExprs: g.fieldTypeExprs(),
+		anonIDs:    make(map[string]string),
+	}
+	m := &ir.Module{
+		Package:   g.pkg.name,
+		Path:      g.pkg.path,
+		Endian:    g.endian,
+		BitEndian: g.bitEndian,
+		Arch:      g.arch,
+	}
+	for _, typeName := range typeNames {
+		if t := g.lookupType(typeName); t != nil {
+			m.Roots = append(m.Roots, a.typ(t))
+		}
+	}
+	structs, enums := g.typeGraph(typeNames)
+	for _, t := range structs {
+		m.Structs = append(m.Structs, a.structDef(t))
+	}
+	for _, t := range enums {
+		m.Enums = append(m.Enums, a.enumDef(t))
+	}
+	return m
+}
+
+// analyzer converts Go types to IR types.
+type analyzer struct {
+	g *Generator
+	// named maps from named Go types to IR types, so that every reference to a
+	// named type shares the same IR type. The IR type is nil while the
+	// underlying type is being converted.
+	named map[*types.Named]*ir.Type
+	// lengths maps from slice fields to the integer fields controlling their
+	// length; see lengthFields.
+	lengths map[*types.Var]*types.Var
+	// magics maps from byte array fields to their magic signatures; see
+	// magicFields.
+	magics map[*types.Var][]byte
+	// fieldExprs maps from struct fields to their type expressions; see
+	// fieldTypeExprs.
+	fieldExprs map[*types.Var]ast.Expr
+	// anonIDs maps from the structural hashes of unnamed struct types to their
+	// identifiers; see anonStruct.
+	anonIDs map[string]string
+}
+
+// typ returns the IR type of the given Go type. Aliases are resolved, the
+// integer types of cgo (e.g. C.uint32_t) are converted to the basic types of
+// the same size, and types substituted by -substitute are converted to their
+// substitute.
+func (a *analyzer) typ(t types.Type) *ir.Type {
+	goType := cgoString(types.TypeString(t, skipQualifier))
+	if sub := a.g.substitute(t); sub != t {
+		typ := *a.typ(sub)
+		typ.Subst = goType
+		return &typ
+	}
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		return a.basic(t)
+	case *types.Named:
+		if underlying, ok := t.Underlying().(*types.Basic); ok && isCgo(t) {
+			typ := a.basic(underlying)
+			typ.Go = goType
+			return typ
+		}
+		if typ, ok := a.named[t]; ok {
+			if typ == nil {
+				// Named type defined in terms of itself; e.g. type List []List.
+				return &ir.Type{Kind: ir.Invalid, Go: goType}
+			}
+			return typ
+		}
+		a.named[t] = nil
+		name := t.Obj().Name()
+		typ := &ir.Type{
+			Kind: ir.Named,
+			Go:   goType,
+			Name: cgoName(name),
+			ID:   a.g.typeID(name),
+			Pos:  a.g.posString(t.Obj().Pos()),
+		}
+		if pkg := t.Obj().Pkg(); pkg != nil {
+			typ.Package = pkg.Path()
+		}
+		if _, ok := t.Underlying().(*types.Struct); ok {
+			// The fields of named struct types are defined by the Struct of
+			// the module.
+			typ.Underlying = &ir.Type{Kind: ir.Struct, Go: cgoString(types.TypeString(t.Underlying(), skipQualifier))}
+		} else {
+			typ.Underlying = a.typ(t.Underlying())
+		}
+		a.named[t] = typ
+		return typ
+	case *types.Array:
+		return &ir.Type{Kind: ir.Array, Go: goType, Len: t.Len(), Elem: a.typ(t.Elem())}
+	case *types.Slice:
+		return &ir.Type{Kind: ir.Slice, Go: goType, Elem: a.typ(t.Elem())}
+	case *types.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		return &ir.Type{Kind: ir.Pointer, Go: goType, Size: a.g.sizeof(types.Uintptr), Elem: a.typ(t.Elem())}
+	case *types.Struct:
+		typ := &ir.Type{Kind: ir.Struct, Go: goType}
+		for i := 0; i < t.NumFields(); i++ {
+			field := t.Field(i)
+			if a.g.excluded[field] {
+				continue
+			}
+			typ.Fields = append(typ.Fields, &ir.Field{
+				Name:  field.Name(),
+				ID:    a.g.ident(field.Name()),
+				Index: i,
+				Pos:   a.g.posString(field.Pos()),
+				Type:  a.typ(field.Type()),
+			})
+		}
+		return typ
+	case *types.Signature, *types.Interface, *types.Chan:
+		return &ir.Type{Kind: ir.Unsupported, Go: goType}
+	default:
+		return &ir.Type{Kind: ir.Invalid, Go: goType}
+	}
+}
+
+// basic returns the IR type of the given basic Go type, sized by the target
+// architecture.
+func (a *analyzer) basic(t *types.Basic) *ir.Type {
+	typ := &ir.Type{Go: t.Name()}
+	info := t.Info()
+	switch {
+	case info&types.IsUntyped != 0:
+		typ.Kind = ir.Invalid
+	case info&types.IsBoolean != 0:
+		typ.Kind = ir.Bool
+	case info&types.IsUnsigned != 0:
+		typ.Kind = ir.Uint
+	case info&types.IsInteger != 0:
+		typ.Kind = ir.Int
+	case info&types.IsFloat != 0:
+		typ.Kind = ir.Float
+	case info&types.IsComplex != 0:
+		typ.Kind = ir.Complex
+	case info&types.IsString != 0:
+		typ.Kind = ir.String
+	default:
+		// unsafe.Pointer; stored as an address of the target architecture,
+		// without element type.
+		typ.Kind = ir.Pointer
+		typ.Go = t.String()
+	}
+	switch typ.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.Pointer:
+		typ.Size = a.g.sizeof(t.Kind())
+	}
+	return typ
+}
+
+// structDef returns the IR struct of the given named struct type.
+func (a *analyzer) structDef(t *types.Named) *ir.StructDef {
+	g := a.g
+	typeName := t.Obj().Name()
+	st := t.Underlying().(*types.Struct)
+	s := &ir.StructDef{
+		Name:      cgoName(typeName),
+		ID:        g.typeID(typeName),
+		Pos:       g.posString(t.Obj().Pos()),
+		Recursive: g.cycles[t],
+		Params:    g.parseParams(typeName),
+		Instances: g.typeInstances(typeName),
+		Methods:   typeMethods(t),
+	}
+	if pkg := t.Obj().Pkg(); pkg != nil {
+		s.Package = pkg.Path()
+	}
+	g.checkTypeDirectives(t)
+	targets := structtag.OffsetTargetsFunc(st, func(i int) structtag.Tag {
+		return g.fieldTag(st, i)
+	})
+	order := g.fieldOrders[st]
+	// Omitted fields preceding the next field.
+	var omitted []*ir.OmittedField
+	for pos := 0; pos < st.NumFields(); pos++ {
+		i := pos
+		if order != nil {
+			i = order[pos]
+		}
+		field := st.Field(i)
+		if g.excluded[field] {
+			if g.unexported == unexportedComment && g.omitUnexported(field) {
+				omitted = append(omitted, &ir.OmittedField{
+					Name: field.Name(),
+					ID:   g.fieldID(typeName, field.Name()),
+					Pos:  g.posString(field.Pos()),
+					Go:   types.TypeString(field.Type(), skipQualifier),
+				})
+			}
+			continue
+		}
+		if isCgo(t) && field.Name() == "_" {
+			// Padding of the C struct layout; see cgoPadded.
+			continue
+		}
+		f := &ir.Field{
+			Name:        field.Name(),
+			ID:          g.fieldID(typeName, field.Name()),
+			Index:       i,
+			Pos:         g.posString(field.Pos()),
+			Type:        a.typ(field.Type()),
+			OffsetField: targets[field],
+		}
+		a.tagOptions(f, typeName, st, g.fieldTag(st, i))
+		a.archDependent(typeName, field)
+		a.inferLength(f, st, s.Fields)
+		a.inferContents(f, st)
+		a.lenConsts(f.Type, field)
+		a.anonStruct(f.Type, field.Type(), s.ID+"__"+f.ID, typeName+"."+field.Name())
+		s.Fields = append(s.Fields, f)
+		for _, o := range omitted {
+			o.Before = f.Name
+		}
+		s.Omitted = append(s.Omitted, omitted...)
+		omitted = nil
+	}
+	s.Omitted = append(s.Omitted, omitted...)
+	s.Representation = g.webIDERepresentation(t, s)
+	if isCgo(t) && g.cgoPadding && order == nil {
+		// The C struct layout applies to the fields in declaration order.
+		s.Fields = g.cgoPadded(st, s.Fields)
+	}
+	return s
+}
+
+// anonStruct assigns the given identifier to the unnamed struct type of a field
+// (e.g. header__info of Header.Info), looking through arrays, slices and
+// pointers, and records the options of the kaitai struct tags of its fields.
+// The unnamed struct types of its fields are handled in turn; e.g.
+// header__info__inner of Header.Info.Inner.
+//
+// Structurally identical unnamed struct types (see structKey) share the
+// identifier of the first one, so that one Kaitai type is output for all.
+func (a *analyzer) anonStruct(typ *ir.Type, t types.Type, id, name string) {
+	for typ.Kind == ir.Array || typ.Kind == ir.Slice || typ.Kind == ir.Pointer {
+		if typ.Elem == nil {
+			return
+		}
+		typ = typ.Elem
+		switch u := types.Unalias(t).(type) {
+		case *types.Array:
+			t = u.Elem()
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Pointer:
+			t = u.Elem()
+		}
+	}
+	st, ok := types.Unalias(t).(*types.Struct)
+	if typ.Kind != ir.Struct || !ok {
+		return
+	}
+	var fields []*ir.Field
+	for _, f := range typ.Fields {
+		a.tagOptions(f, name, st, a.g.fieldTag(st, f.Index))
+		a.inferLength(f, st, fields)
+		a.inferContents(f, st)
+		a.lenConsts(f.Type, st.Field(f.Index))
+		a.anonStruct(f.Type, st.Field(f.Index).Type(), id+"__"+f.ID, name+"."+f.Name)
+		fields = append(fields, f)
+	}
+	key := structKey(typ)
+	if prev, ok := a.anonIDs[key]; ok {
+		typ.ID = prev
+		return
+	}
+	a.anonIDs[key] = id
+	typ.ID = id
+}
+
+// structKey returns the structural hash of the given unnamed struct type, as
+// analyzed; i.e. of its fields, field types and tag options, not including
+// source positions. The unnamed struct types of its fields are identified by
+// their (deduplicated) identifiers.
+func structKey(typ *ir.Type) string {
+	buf, err := json.Marshal(typ.Fields)
+	if err != nil {
+		panic(fmt.Errorf("unable to marshal fields of %s; %v", typ.Go, err))
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		panic(fmt.Errorf("unable to unmarshal fields of %s; %v", typ.Go, err))
+	}
+	var strip func(v interface{})
+	strip = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			delete(v, "pos")
+			if _, ok := v["id"]; ok && v["kind"] == float64(ir.Struct) {
+				// Unnamed struct type; identified by its identifier.
+				delete(v, "fields")
+			}
+			for _, elem := range v {
+				strip(elem)
+			}
+		case []interface{}:
+			for _, elem := range v {
+				strip(elem)
+			}
+		}
+	}
+	strip(v)
+	buf, err = json.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("unable to marshal fields of %s; %v", typ.Go, err))
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// tagOptions records the options of the given kaitai struct tag in the field
+// of the named struct type. Identifiers of switch-on cases and offset-to
+// targets are resolved. The first problem with the options, if any, is
+// reported and recorded as TagErr of the field.
+func (a *analyzer) tagOptions(f *ir.Field, typeName string, st *types.Struct, tag structtag.Tag) {
+	g := a.g
+	fail := func(err error) {
+		if err == nil || len(f.TagErr) > 0 {
+			return
+		}
+		f.TagErr = err.Error()
+		g.errorAt(f.Pos, "field %s.%s: %v", typeName, f.Name, err)
+	}
+	if on, ok := tag["switch-on"]; ok {
+		f.SwitchOn = on
+		_, cases, _, err := tag.SwitchOn()
+		fail(err)
+		for _, c := range cases {
+			value, comment, err := g.caseValue(c.Value)
+			if err != nil {
+				fail(err)
+				break
+			}
+			t, err := g.caseType(c.Type)
+			if err != nil {
+				fail(err)
+				break
+			}
+			f.Cases = append(f.Cases, &ir.Case{Value: value, Const: comment, Type: a.typ(t)})
+		}
+	}
+	size, _, err := tag.Size()
+	f.Size = size
+	fail(err)
+	repeat, expr, _, err := tag.Repeat()
+	f.Repeat, f.RepeatExpr = repeat, expr
+	fail(err)
+	if repeat == "until" && err == nil {
+		// Method of the element type; e.g. expr=IsLast().
+		elemName := ""
+		if elem := f.Type.Under().Elem; elem != nil && elem.Kind == ir.Named {
+			elemName = elem.Name
+		}
+		f.RepeatExpr, err = g.optionExpr(expr, elemName, "_.")
+		fail(err)
+	}
+	term, ok, err := tag.Terminator()
+	if ok && err == nil {
+		f.Terminator = &term
+	}
+	fail(err)
+	termOpts, _, err := tag.TermOptions()
+	f.Include, f.NoConsume, f.NoEOSError = termOpts.Include, !termOpts.Consume, !termOpts.EOSError
+	fail(err)
+	contents, ok, err := tag.Contents()
+	if ok && err == nil {
+		f.Contents = contents
+	}
+	fail(err)
+	switch {
+	case tag.Has("strz"):
+		f.Str = "strz"
+	case tag.Has("str"):
+		f.Str = "str"
+	}
+	encoding, ok, err := tag.Encoding()
+	f.Encoding = encoding
+	fail(err)
+	if u := f.Type.Under(); ok && u.Kind != ir.String && (u.Kind != ir.Array || len(f.Str) == 0) {
+		fail(fmt.Errorf("encoding option only valid for strings and arrays tagged with str or strz; got %s", f.Type.Go))
+	}
+	endian, _, err := tag.Endian()
+	f.Endian = endian
+	fail(err)
+	_, _, err = tag.ID()
+	fail(err)
+	bigSize, bigSign, ok, err := tag.BigInt()
+	f.BigInt, f.BigIntSign = bigSize, bigSign
+	fail(err)
+	switch {
+	case ok && !isBigInt(f.Type):
+		fail(fmt.Errorf("bigint option only valid for big.Int and *big.Int; got %s", f.Type.Go))
+	case !ok && isBigInt(f.Type):
+		fail(fmt.Errorf("missing bigint option of arbitrary-precision integer; e.g. bigint=32"))
+	}
+	varint, ok, err := tag.Varint()
+	f.Varint = varint
+	fail(err)
+	if ok && !f.Type.IsInteger() {
+		fail(fmt.Errorf("varint option only valid for integers; got %s", f.Type.Go))
+	}
+	algo, region, ok, err := tag.Checksum()
+	f.Checksum = algo
+	fail(err)
+	if ok && err == nil {
+		size := structtag.ChecksumSize(algo)
+		if u := f.Type.Under(); u.Kind != ir.Uint || u.Size != size {
+			fail(fmt.Errorf("checksum=%s only valid for uint%d; got %s", algo, 8*size, f.Type.Go))
+		}
+		fields, err := structtag.ChecksumFields(st, g.fieldOrders[st], f.Index, region)
+		fail(err)
+		for _, i := range fields {
+			if field := st.Field(i); !g.excluded[field] {
+				f.Region = append(f.Region, field.Name())
+			}
+		}
+	}
+	if args, ok := tag["args"]; ok {
+		for _, arg := range strings.Split(args, ";") {
+			f.Args = append(f.Args, strings.TrimSpace(arg))
+		}
+	}
+	process, _, err := tag.Process()
+	f.Process = process
+	fail(err)
+	if cond, ok := tag["if"]; ok {
+		f.If = cond
+		if len(cond) == 0 {
+			fail(fmt.Errorf("missing expression of if option"))
+		}
+		// Method of the struct type; e.g. if=HasBody().
+		f.If, err = g.optionExpr(cond, typeName, "")
+		fail(err)
+	}
+
+	// Problems with the offset-to option concern the field storing the offset
+	// rather than the field itself, which is thus still recorded.
+	target, whence, ok, err := tag.OffsetTo()
+	if !ok {
+		return
+	}
+	if err != nil {
+		g.errorAt(f.Pos, "field %s.%s: %v", typeName, f.Name, err)
+		return
+	}
+	f.OffsetTo, f.Whence = target, whence
+	for i := 0; i < st.NumFields(); i++ {
+		if field := st.Field(i); field.Name() == target && !g.excluded[field] {
+			return
+		}
+	}
+	if g.defines(target) {
+		if t := g.lookupType(target); t != nil {
+			f.OffsetType = a.typ(t)
+			f.OffsetID = g.fieldID(typeName, target)
+		}
+	}
+}
+
+// enumDef returns the IR enum of the given enum type.
+func (a *analyzer) enumDef(t *types.Named) *ir.EnumDef {
+	g := a.g
+	typeName := t.Obj().Name()
+	e := &ir.EnumDef{
+		Name:       typeName,
+		ID:         g.typeID(typeName),
+		Pos:        g.posString(t.Obj().Pos()),
+		Underlying: a.typ(t.Underlying()),
+		Flags:      isFlagEnum(t),
+	}
+	if pkg := t.Obj().Pkg(); pkg != nil {
+		e.Package = pkg.Path()
+	}
+	e.Values = g.enumValueDefs(enumValues(t))
+	bitEndian, ok, err := g.typeDirectives[typeName].BitEndian()
+	switch {
+	case err != nil:
+		g.errorf(t.Obj().Pos(), "type %s: %v", typeName, err)
+	case ok && !e.Flags:
+		g.errorf(t.Obj().Pos(), "type %s: directive %sbit-endian applies only to flag-style enum types", typeName, structtag.DirectivePrefix)
+	}
+	e.BitEndian = bitEndian
+	return e
+}
+
+// enumValueDefs returns the IR enum values of the given constants.
+func (g *Generator) enumValueDefs(consts []*types.Const) []*ir.EnumValue {
+	var values []*ir.EnumValue
+	for _, c := range consts {
+		values = append(values, &ir.EnumValue{
+			Name:  c.Name(),
+			ID:    g.ident(c.Name()),
+			Value: c.Val().ExactString(),
+			Doc:   g.constDoc(c),
+		})
+	}
+	return values
+}
+
+// constDoc returns the doc comment of the given constant of the package, or an
+// empty string if not present. The doc comment of a const declaration applies
+// to its constants if it declares only one.
+func (g *Generator) constDoc(c *types.Const) string {
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.ValueSpec)
+				for _, name := range spec.Names {
+					if g.pkg.info.Defs[name] != c {
+						continue
+					}
+					doc := spec.Doc
+					if doc == nil && len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					return strings.TrimSpace(doc.Text())
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// byteArray returns the IR type of byte arrays of the given length.
+func byteArray(n int64) *ir.Type {
+	return &ir.Type{
+		Kind: ir.Array,
+		Go:   fmt.Sprintf("[%d]byte", n),
+		Len:  n,
+		Elem: &ir.Type{Kind: ir.Uint, Go: "byte", Size: 1},
+	}
+}
+
+// posString returns the source position of the given position, or an empty
+// string if not valid.
+func (g *Generator) posString(pos token.Pos) string {
+	if !pos.IsValid() {
+		return ""
+	}
+	return g.position(pos).String()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strconv"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// isBigInt reports whether the given type is an arbitrary-precision integer;
+// big.Int or *big.Int.
+func isBigInt(t *ir.Type) bool {
+	if t.Kind == ir.Pointer && t.Elem != nil {
+		t = t.Elem
+	}
+	return t.Kind == ir.Named && t.Package == "math/big" && t.Name == "Int"
+}
+
+// isBigIntType reports whether the given Go type is big.Int. The fields of
+// big.Int are internal to math/big; arbitrary-precision integers are stored as
+// byte arrays of the size of the bigint option instead.
+func isBigIntType(t *types.Named) bool {
+	obj := t.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "math/big" && obj.Name() == "Int"
+}
+
+// bigInt returns the Kaitai type of an arbitrary-precision integer field
+// tagged with the bigint option, and a boolean indicating whether the field is
+// tagged as such. Kaitai has no integer types wider than 8 bytes, so the
+// integer is output as a byte array of the given size, with its byte order
+// and sign convention noted in the doc key of the attribute.
+//
+// Example:
+//
+//	type Key struct {
+//	    Modulus *big.Int `kaitai:"bigint=256"`
+//	}
+//
+// is output as
+//
+//	id: modulus
+//	size: 256 # *Int
+//	doc: 256-byte big-endian unsigned integer (arbitrary-precision).
+func (g *Generator) bigInt(f *ir.Field) (*yaml.Node, bool, error) {
+	if f.BigInt == 0 {
+		return nil, false, nil
+	}
+	order := "big-endian"
+	if f.Endian == "le" {
+		order = "little-endian"
+	}
+	sign := f.BigIntSign
+	switch sign {
+	case "twos-complement":
+		sign = "two's complement signed"
+	case "sign-magnitude":
+		sign = "sign-magnitude signed (sign in the most significant bit)"
+	}
+	spec := ksySpec("size", strconv.FormatInt(f.BigInt, 10), f.Type.Go)
+	ksyAdd(spec, "doc", ksyValue(fmt.Sprintf("%d-byte %s %s integer (arbitrary-precision).", f.BigInt, order, sign), ""))
+	return spec, true, nil
+}
+
+// decodeBigInt outputs the statements decoding the arbitrary-precision integer
+// field f into lhs, from a byte array of the size of the bigint option of the
+// field.
+func (w *goWriter) decodeBigInt(lhs string, f *ir.Field) {
+	w.imports["math/big"] = true
+	n := f.BigInt
+	w.Printf("{\n")
+	w.Printf("buf := make([]byte, %d)\n", n)
+	w.Printf("d.read(buf)\n")
+	if f.Endian == "le" {
+		w.reverseBytes("buf")
+	}
+	if f.BigIntSign == "sign-magnitude" {
+		w.Printf("neg := buf[0]&0x80 != 0\n")
+		w.Printf("buf[0] &^= 0x80\n")
+	}
+	w.Printf("x := new(big.Int).SetBytes(buf)\n")
+	switch f.BigIntSign {
+	case "twos-complement":
+		w.Printf("if buf[0]&0x80 != 0 {\n")
+		w.Printf("x.Sub(x, new(big.Int).Lsh(big.NewInt(1), %d))\n", 8*n)
+		w.Printf("}\n")
+	case "sign-magnitude":
+		w.Printf("if neg {\n")
+		w.Printf("x.Neg(x)\n")
+		w.Printf("}\n")
+	}
+	switch {
+	case lhs == "_":
+		w.Printf("_ = x\n")
+	case f.Type.Kind == ir.Pointer:
+		w.Printf("%s = x\n", lhs)
+	default:
+		w.Printf("%s.Set(x)\n", lhs)
+	}
+	w.Printf("}\n")
+}
+
+// encodeBigInt outputs the statements encoding the arbitrary-precision integer
+// field rhs as a byte array of the size of the bigint option of the field f.
+// Values out of range of the size and sign convention of the field are
+// reported as errors.
+func (w *goWriter) encodeBigInt(rhs string, f *ir.Field) {
+	w.imports["math/big"] = true
+	n := f.BigInt
+	w.Printf("{\n")
+	if f.Type.Kind == ir.Pointer {
+		w.Printf("x := %s\n", rhs)
+		w.Printf("if x == nil {\n")
+		w.Printf("x = new(big.Int)\n")
+		w.Printf("}\n")
+	} else {
+		w.Printf("x := &%s\n", rhs)
+	}
+	// Magnitude of the value, and number of bits available to it.
+	bits := 8*n - 1
+	switch f.BigIntSign {
+	case "twos-complement":
+		// The bit length of negative values in two's complement is that of
+		// -x-1.
+		w.Printf("abs := x\n")
+		w.Printf("if x.Sign() < 0 {\n")
+		w.Printf("abs = new(big.Int).Not(x)\n")
+		w.Printf("}\n")
+		w.Printf("if abs.BitLen() > %d && e.err == nil {\n", bits)
+	case "sign-magnitude":
+		w.Printf("if x.BitLen() > %d && e.err == nil {\n", bits)
+	default:
+		bits = 8 * n
+		w.Printf("if (x.Sign() < 0 || x.BitLen() > %d) && e.err == nil {\n", bits)
+	}
+	w.Printf("e.err = fmt.Errorf(\"%s out of range of %d-byte %s integer\")\n", f.Name, n, f.BigIntSign)
+	w.Printf("}\n")
+	w.Printf("buf := make([]byte, %d)\n", n)
+	switch f.BigIntSign {
+	case "twos-complement":
+		w.Printf("if x.Sign() < 0 {\n")
+		w.Printf("x = new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), %d))\n", 8*n)
+		w.Printf("}\n")
+	}
+	w.Printf("if b := x.Bytes(); len(b) <= len(buf) {\n")
+	w.Printf("copy(buf[len(buf)-len(b):], b)\n")
+	w.Printf("}\n")
+	if f.BigIntSign == "sign-magnitude" {
+		w.Printf("if x.Sign() < 0 {\n")
+		w.Printf("buf[0] |= 0x80\n")
+		w.Printf("}\n")
+	}
+	if f.Endian == "le" {
+		w.reverseBytes("buf")
+	}
+	w.Printf("e.write(buf)\n")
+	w.Printf("}\n")
+}
+
+// reverseBytes outputs the statements reversing the bytes of the given byte
+// slice, converting between big-endian and little-endian byte order.
+func (w *goWriter) reverseBytes(buf string) {
+	w.Printf("for i, j := 0, len(%s)-1; i < j; i, j = i+1, j-1 {\n", buf)
+	w.Printf("%s[i], %s[j] = %s[j], %s[i]\n", buf, buf, buf, buf)
+	w.Printf("}\n")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/naming"
+)
+
+// generateC outputs a C header of the selected types and their dependencies.
+// Structs are packed, and a static_assert on the size of each fixed-size
+// struct is emitted, as computed from the Go layout of its fields.
+func (g *Generator) generateC() {
+	guard := strings.ToUpper(g.mod.Package) + "_H"
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	g.Printf("#ifndef %s\n", guard)
+	g.Printf("#define %s\n", guard)
+	g.Printf("\n")
+	g.Printf("#include <assert.h>\n")
+	g.Printf("#include <stdint.h>\n")
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.cEnum(e)
+	}
+	g.Printf("\n")
+	g.Printf("#pragma pack(push, 1)\n")
+	// Output dependencies before the types referring to them.
+	structs := g.mod.Structs
+	for i := len(structs) - 1; i >= 0; i-- {
+		g.Printf("\n")
+		g.cStruct(structs[i])
+	}
+	g.Printf("\n")
+	g.Printf("#pragma pack(pop)\n")
+	g.Printf("\n")
+	g.Printf("#endif // %s\n", guard)
+}
+
+// cStruct outputs the struct definition of the given struct type, followed by
+// a static assertion of its size if the struct is fixed-size.
+func (g *Generator) cStruct(s *ir.StructDef) {
+	name := s.Name
+	g.Printf("typedef struct %s {\n", name)
+	for _, f := range s.Fields {
+		decl, err := g.cDecl(f.Type, f.ID)
+		if err != nil {
+			g.Printf("\t// TODO: add field %s; %v\n", f.Name, err)
+			continue
+		}
+		g.Printf("\t%s; // %s\n", decl, f.Type.Go)
+	}
+	g.Printf("} %s;\n", name)
+	if size, ok := g.fieldsSize(s.Fields); ok {
+		g.Printf("\n")
+		g.Printf("static_assert(sizeof(%s) == %d, \"size of %s\");\n", name, size, name)
+	}
+}
+
+// cEnum outputs the enum definition of the given enum type. As the size of C
+// enums is implementation-defined, fields of enum type are declared using the
+// underlying integer type.
+func (g *Generator) cEnum(e *ir.EnumDef) {
+	prefix := strings.ToUpper(naming.Snake(e.Name))
+	g.Printf("enum %s {\n", e.Name)
+	for _, v := range e.Values {
+		name := strings.ToUpper(naming.Snake(v.Name))
+		if !strings.HasPrefix(name, prefix) {
+			name = prefix + "_" + name
+		}
+		g.Printf("\t%s = %s,\n", name, v.Value)
+	}
+	g.Printf("};\n")
+}
+
+// cDecl returns the C declaration of a variable with the given name and type.
+func (g *Generator) cDecl(t *ir.Type, name string) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		typ, err := g.cBasicType(t)
+		if err != nil {
+			return "", err
+		}
+		return typ + " " + name, nil
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct {
+			return t.Name + " " + name, nil
+		}
+		return g.cDecl(t.Underlying, name)
+	case ir.Array:
+		return g.cDecl(t.Elem, fmt.Sprintf("%s[%d]", name, t.Len))
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		return g.cDecl(addrType(t), name)
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// cBasicType returns the C type corresponding to the given basic type.
+func (g *Generator) cBasicType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return "uint8_t", nil
+	case ir.Int:
+		return fmt.Sprintf("int%d_t", 8*t.Size), nil
+	case ir.Uint:
+		return fmt.Sprintf("uint%d_t", 8*t.Size), nil
+	case ir.Float:
+		if t.Size == 4 {
+			return "float", nil
+		}
+		return "double", nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
+
+// addrType returns the unsigned integer type of the addresses stored for the
+// given pointer type.
+func addrType(ptr *ir.Type) *ir.Type {
+	return &ir.Type{Kind: ir.Uint, Go: "uintptr", Size: ptr.Size}
+}
+
+// packedSize returns the size in bytes of the given type when laid out
+// without padding, and a boolean indicating whether the type is fixed-size.
+// Pointers are sized as addresses of the target architecture.
+func (g *Generator) packedSize(t *ir.Type) (int64, bool) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Pointer:
+		return t.Size, true
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct {
+			s := g.mod.Struct(t)
+			if s == nil {
+				return 0, false
+			}
+			return g.fieldsSize(s.Fields)
+		}
+		return g.packedSize(t.Underlying)
+	case ir.Array:
+		size, ok := g.packedSize(t.Elem)
+		return t.Len * size, ok
+	case ir.Struct:
+		return g.fieldsSize(t.Fields)
+	default:
+		return 0, false
+	}
+}
+
+// fieldsSize returns the total packed size in bytes of the given fields, and
+// a boolean indicating whether all fields are fixed-size.
+func (g *Generator) fieldsSize(fields []*ir.Field) (int64, bool) {
+	total := int64(0)
+	for _, f := range fields {
+		size, ok := g.packedSize(f.Type)
+		if !ok {
+			return 0, false
+		}
+		total += size
+	}
+	return total, true
+}
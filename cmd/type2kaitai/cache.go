@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mewrev/tools/internal/load"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
+)
+
+// cacheVersion is recorded in each package hash, and is to be incremented
+// whenever the cache format changes.
+const cacheVersion = "type2kaitai-cache-v1"
+
+// typeCache is an on-disk cache of the export data of type-checked
+// dependencies, keyed by package hash (similar to the go build cache). The
+// package hash covers the package path, the target architecture, the contents
+// of the source files, and the hashes of the imported packages, so unchanged
+// dependencies are read from the cache rather than parsed and type-checked
+// again on each invocation.
+type typeCache struct {
+	// Cache directory.
+	dir string
+	// Target architecture.
+	arch  string
+	sizes types.Sizes
+	fset  *token.FileSet
+	// roots tracks the packages to generate, which are always type-checked
+	// from source to record their syntax and type information.
+	roots map[*packages.Package]bool
+	// Type-checked packages, keyed by package path.
+	imports map[string]*types.Package
+	// Package hashes, keyed by package path.
+	hashes map[string]string
+}
+
+// defaultCacheDir returns the default directory of the type-check cache, or
+// "off" if no user cache directory is present.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "off"
+	}
+	return filepath.Join(dir, "type2kaitai")
+}
+
+// loadCachedPackages loads the packages constructed from the patterns, as
+// configured. The dependencies of the packages are read from the type-check
+// cache of the given directory when unchanged. Failures to read and write the
+// cache are reported as warnings, and the dependencies type-checked from
+// source. loadCachedPackages exits if there is an error.
+func loadCachedPackages(dir string, patterns []string, loadCfg *load.Config) []*packages.Package {
+	fset := token.NewFileSet()
+	cfg := loadCfg.PackagesConfig(packages.LoadImports | packages.NeedDeps)
+	cfg.Fset = fset
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		fatal(err)
+	}
+	if len(pkgs) == 0 {
+		fatalf("error: no packages found")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		warnf("cache", logAttrs{"dir": dir}, "unable to create type-check cache; %v; type-checking dependencies from source", err)
+		return loadPackages(patterns, loadCfg)
+	}
+	c := &typeCache{
+		dir:     dir,
+		arch:    loadCfg.Arch,
+		sizes:   archSizes(loadCfg.Arch),
+		fset:    fset,
+		roots:   make(map[*packages.Package]bool),
+		imports: make(map[string]*types.Package),
+		hashes:  make(map[string]string),
+	}
+	for _, pkg := range pkgs {
+		c.roots[pkg] = true
+	}
+	for _, pkg := range pkgs {
+		if err := c.load(pkg); err != nil {
+			fatalf("type-checking package %s: %v", pkg.PkgPath, err)
+		}
+	}
+	return pkgs
+}
+
+// load type-checks the given package and its dependencies, reading unchanged
+// dependencies from the cache and writing the others to the cache.
+func (c *typeCache) load(pkg *packages.Package) error {
+	if _, ok := c.hashes[pkg.PkgPath]; ok {
+		// Already loaded.
+		return nil
+	}
+	if pkg.PkgPath == "unsafe" {
+		c.hashes[pkg.PkgPath] = pkg.PkgPath
+		c.imports[pkg.PkgPath] = types.Unsafe
+		return nil
+	}
+	// Load dependencies first, so that the export data of the package is read
+	// into complete imported packages.
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", cacheVersion, c.arch, pkg.PkgPath)
+	var paths []string
+	for path := range pkg.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		dep := pkg.Imports[path]
+		if err := c.load(dep); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "import %s %s\n", dep.PkgPath, c.hashes[dep.PkgPath])
+	}
+	for _, file := range pkg.CompiledGoFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "file %s %x\n", filepath.Base(file), sha256.Sum256(data))
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	c.hashes[pkg.PkgPath] = hash
+
+	// Read dependency from cache.
+	root := c.roots[pkg]
+	if !root {
+		tpkg, err := c.read(pkg.PkgPath, hash)
+		if err == nil {
+			c.imports[pkg.PkgPath] = tpkg
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			// Cache miss; e.g. an entry of an incompatible export data format.
+			warnf("cache", logAttrs{"package": pkg.PkgPath}, "unable to read type-check cache entry of package %s; %v; type-checking from source", pkg.PkgPath, err)
+		}
+	}
+
+	// Type-check package from source.
+	mode := parser.Mode(0)
+	if root {
+		mode = parser.ParseComments
+	}
+	var files []*ast.File
+	for _, file := range pkg.CompiledGoFiles {
+		f, err := parser.ParseFile(c.fset, file, nil, mode)
+		if err != nil {
+			pkg.Errors = append(pkg.Errors, packages.Error{Msg: err.Error(), Kind: packages.ParseError})
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	if !root {
+		// Only the exported API of dependencies is of interest.
+		info = nil
+	}
+	conf := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if path == "unsafe" {
+				return types.Unsafe, nil
+			}
+			dep, ok := pkg.Imports[path]
+			if !ok || c.imports[dep.PkgPath] == nil {
+				return nil, fmt.Errorf("unable to import package %q", path)
+			}
+			return c.imports[dep.PkgPath], nil
+		}),
+		Sizes: c.sizes,
+		Error: func(err error) {
+			pkg.Errors = append(pkg.Errors, packages.Error{Msg: err.Error(), Kind: packages.TypeError})
+		},
+		IgnoreFuncBodies: !root,
+	}
+	tpkg, _ := conf.Check(pkg.PkgPath, c.fset, files, info)
+	c.imports[pkg.PkgPath] = tpkg
+	if root {
+		pkg.Fset = c.fset
+		pkg.Syntax = files
+		pkg.Types = tpkg
+		pkg.TypesInfo = info
+		return nil
+	}
+	if len(pkg.Errors) > 0 {
+		// Only cache packages without errors.
+		return nil
+	}
+	if err := c.write(hash, tpkg); err != nil {
+		// The package is type-checked from source again by the next run.
+		warnf("cache", logAttrs{"package": pkg.PkgPath}, "unable to write type-check cache entry of package %s; %v", pkg.PkgPath, err)
+	}
+	return nil
+}
+
+// read reads the type-checked package of the given package path and hash from
+// the cache.
+func (c *typeCache) read(path, hash string) (*types.Package, error) {
+	f, err := os.Open(c.cachePath(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return gcexportdata.Read(bufio.NewReader(f), c.fset, c.imports, path)
+}
+
+// write writes the given type-checked package of the given hash to the cache.
+// The cache entry is written atomically, so that concurrent invocations never
+// read partial entries.
+func (c *typeCache) write(hash string, pkg *types.Package) error {
+	buf := &bytes.Buffer{}
+	if err := gcexportdata.Write(buf, c.fset, pkg); err != nil {
+		return err
+	}
+	name := c.cachePath(hash)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// cachePath returns the path of the cache entry of the given hash.
+func (c *typeCache) cachePath(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash+"-x")
+}
+
+// importerFunc implements types.Importer by a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/naming"
+)
+
+// generateCapnp outputs a Cap'n Proto schema of the selected types and their
+// dependencies. Field ordinals follow the source order of the fields, and the
+// file ID is derived from the import path of the package, so that
+// regenerating the schema is deterministic.
+func (g *Generator) generateCapnp() {
+	g.Printf("%s", g.headerComment("# "))
+	g.Printf("\n")
+	g.Printf("@0x%016x;\n", capnpFileID(g.mod.Path))
+	for _, s := range g.mod.Structs {
+		g.Printf("\n")
+		g.capnpStruct(s)
+	}
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.capnpEnum(e)
+	}
+}
+
+// capnpFileID returns the Cap'n Proto file ID of the given import path. File
+// IDs are required to have the most significant bit set.
+func capnpFileID(path string) uint64 {
+	sum := sha256.Sum256([]byte(path))
+	return binary.BigEndian.Uint64(sum[:8]) | 1<<63
+}
+
+// capnpStruct outputs the struct definition of the given struct type.
+func (g *Generator) capnpStruct(s *ir.StructDef) {
+	g.Printf("struct %s {\n", s.Name)
+	ordinal := 0
+	for _, f := range s.Fields {
+		typ, err := g.capnpType(f.Type)
+		if err != nil {
+			g.Printf("  # TODO: add field %s; %v\n", f.Name, err)
+			continue
+		}
+		// Derived from the field ID, which is disambiguated from the IDs of the
+		// other fields; e.g. id_2 of Id next to ID.
+		g.Printf("  %s @%d :%s;\n", naming.LowerCamel(f.ID), ordinal, typ)
+		ordinal++
+	}
+	g.Printf("}\n")
+}
+
+// capnpEnum outputs the enum definition of the given enum type. Cap'n Proto
+// enumerants are identified by ordinal, so enums with values other than
+// 0, 1, ..., n-1 are instead emitted as constants of the underlying integer
+// type, prefixed by the enum name as constants are declared at file scope.
+func (g *Generator) capnpEnum(e *ir.EnumDef) {
+	if !isOrdinalEnum(e.Values) {
+		underlying, _ := g.capnpType(e.Underlying)
+		for _, v := range e.Values {
+			g.Printf("const %s :%s = %s;\n", naming.LowerCamel(e.Name+"_"+enumerantName(e, v)), underlying, v.Value)
+		}
+		return
+	}
+	g.Printf("enum %s {\n", e.Name)
+	for i, v := range e.Values {
+		g.Printf("  %s @%d;\n", enumerantName(e, v), i)
+	}
+	g.Printf("}\n")
+}
+
+// isOrdinalEnum reports whether the given enum values are 0, 1, ..., n-1 in
+// order.
+func isOrdinalEnum(values []*ir.EnumValue) bool {
+	for i, v := range values {
+		x, exact := v.Int64()
+		if !exact || x != int64(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// enumerantName returns the Cap'n Proto enumerant name of the given value of
+// the enum type e.
+func enumerantName(e *ir.EnumDef, v *ir.EnumValue) string {
+	name := strings.TrimPrefix(v.Name, e.Name)
+	if len(name) == 0 {
+		name = v.Name
+	}
+	return naming.LowerCamel(name)
+}
+
+// capnpType returns the Cap'n Proto type corresponding to the given type.
+func (g *Generator) capnpType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return g.capnpBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct {
+			return t.Name, nil
+		}
+		if e := g.mod.Enum(t); e != nil && isOrdinalEnum(e.Values) {
+			return t.Name, nil
+		}
+		return g.capnpType(t.Underlying)
+	case ir.Array, ir.Slice:
+		return g.capnpListType(t.Elem)
+	case ir.Pointer:
+		if t.Elem == nil {
+			// unsafe.Pointer, stored as an address of the target architecture.
+			return g.capnpBasicType(addrType(t))
+		}
+		return g.capnpType(t.Elem)
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// capnpListType returns the Cap'n Proto type of an array or slice with the
+// given element type. Byte arrays and slices are mapped to Data.
+func (g *Generator) capnpListType(elem *ir.Type) (string, error) {
+	if elem.IsByte() {
+		return "Data", nil
+	}
+	typ, err := g.capnpType(elem)
+	if err != nil {
+		return "", err
+	}
+	return "List(" + typ + ")", nil
+}
+
+// capnpBasicType returns the Cap'n Proto type corresponding to the given basic
+// type.
+func (g *Generator) capnpBasicType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return "Bool", nil
+	case ir.Int:
+		return fmt.Sprintf("Int%d", 8*t.Size), nil
+	case ir.Uint:
+		return fmt.Sprintf("UInt%d", 8*t.Size), nil
+	case ir.Float:
+		return fmt.Sprintf("Float%d", 8*t.Size), nil
+	case ir.String:
+		return "Text", nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
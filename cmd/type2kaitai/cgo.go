@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// cgoPrefix is the prefix of the Go type names generated by cgo for C types;
+// e.g. C.struct_point is defined as _Ctype_struct_point, and C.uint32_t as an
+// alias of _Ctype_uint.
+const cgoPrefix = "_Ctype_"
+
+// isCgo reports whether the given named type is generated by cgo for a C
+// type.
+func isCgo(t *types.Named) bool {
+	return strings.HasPrefix(t.Obj().Name(), cgoPrefix)
+}
+
+// cgoName returns the C name of the given type name if generated by cgo (e.g.
+// struct_point), and the type name itself otherwise.
+func cgoName(typeName string) string {
+	return strings.TrimPrefix(typeName, cgoPrefix)
+}
+
+// cgoString returns the given Go type string with the type names generated by
+// cgo replaced by their C names; e.g. [8]_Ctype_char becomes [8]C.char.
+func cgoString(s string) string {
+	return strings.ReplaceAll(s, cgoPrefix, "C.")
+}
+
+// cgoStructs returns the names of the cgo struct types (e.g. C.struct_point)
+// reachable from the given type names, and not among them. As cgo types cannot
+// be selected by their C names, they are generated along with the types
+// referring to them.
+func (g *Generator) cgoStructs(typeNames []string) []string {
+	selected := make(map[string]bool)
+	for _, typeName := range typeNames {
+		selected[typeName] = true
+	}
+	var names []string
+	structs, _ := g.typeGraph(typeNames)
+	for _, t := range structs {
+		if name := t.Obj().Name(); isCgo(t) && !selected[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// cgoPadded returns the given fields of the cgo struct type with padding
+// fields inserted as needed to match the field offsets and size of the
+// original C struct layout. cgo mirrors the layout of the C compiler in the
+// generated struct type, adding blank fields where the alignment of Go differs
+// from C; these are omitted from the fields, and accounted for by the padding.
+func (g *Generator) cgoPadded(st *types.Struct, fields []*ir.Field) []*ir.Field {
+	vars := make([]*types.Var, st.NumFields())
+	for i := range vars {
+		vars[i] = st.Field(i)
+	}
+	offsets := g.sizes.Offsetsof(vars)
+	var padded []*ir.Field
+	end := int64(0)
+	pad := func(offset int64, pos string) {
+		if offset <= end {
+			return
+		}
+		padded = append(padded, &ir.Field{
+			Name:  "_",
+			ID:    g.ident(fmt.Sprintf("pad_%d", end)),
+			Index: -1,
+			Pos:   pos,
+			Type:  byteArray(offset - end),
+		})
+	}
+	for _, f := range fields {
+		offset := offsets[f.Index]
+		pad(offset, f.Pos)
+		padded = append(padded, f)
+		end = offset + g.sizes.Sizeof(vars[f.Index].Type())
+	}
+	pad(g.sizes.Sizeof(st), "")
+	return padded
+}
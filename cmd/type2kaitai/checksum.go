@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"hash/crc64"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// checksumNames maps from checksum algorithms to their names in doc comments.
+var checksumNames = map[string]string{
+	"crc32":   "CRC-32 (IEEE)",
+	"crc32c":  "CRC-32C (Castagnoli)",
+	"adler32": "Adler-32",
+	"crc64":   "CRC-64 (ECMA)",
+}
+
+// checksum returns the Kaitai type of a field tagged with the checksum option,
+// and a boolean indicating whether the field is tagged as such. Kaitai does not
+// compute checksums, so the field is output as an integer with the algorithm
+// and region of the checksum noted in the doc key of the attribute.
+//
+// Example:
+//
+//	type Header struct {
+//	    Magic [4]byte
+//	    Size  uint32
+//	    CRC   uint32 `kaitai:"checksum=crc32"`
+//	}
+//
+// is output as
+//
+//	id: crc
+//	type: u4 # uint32
+//	doc: CRC-32 (IEEE) checksum of magic to size.
+func (g *Generator) checksum(s *ir.StructDef, f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.Checksum) == 0 {
+		return nil, false, nil
+	}
+	spec, err := g.fieldSpec(f)
+	if err != nil {
+		return nil, true, err
+	}
+	ksyAdd(spec, "doc", ksyValue(checksumDoc(s, f), ""))
+	return spec, true, nil
+}
+
+// checksumDoc returns the description of the checksum stored in the given field
+// of the struct type; e.g. "CRC-32 (IEEE) checksum of magic to size.".
+func checksumDoc(s *ir.StructDef, f *ir.Field) string {
+	var ids []string
+	for _, name := range f.Region {
+		if field := s.Field(name); field != nil {
+			ids = append(ids, field.ID)
+		}
+	}
+	region := strings.Join(ids, " and ")
+	if len(ids) > 2 {
+		region = ids[0] + " to " + ids[len(ids)-1]
+	}
+	return fmt.Sprintf("%s checksum of %s.", checksumNames[f.Checksum], region)
+}
+
+// checksumFuncName returns the name of the generated Go function computing the
+// checksum of the given field of the struct type; e.g. checksumHeaderCRC.
+func checksumFuncName(s *ir.StructDef, f *ir.Field) string {
+	return "checksum" + s.Name + f.Name
+}
+
+// checksumFuncs outputs the functions computing the checksums of the fields of
+// the given struct type tagged with the checksum option. The bytes of the
+// region of a checksum are those written by the encoder; checksums within the
+// region are written as stored rather than computed.
+func (w *goWriter) checksumFuncs(s *ir.StructDef) {
+	for _, f := range s.Fields {
+		if len(f.Checksum) == 0 || len(f.TagErr) > 0 {
+			continue
+		}
+		name := checksumFuncName(s, f)
+		w.Printf("\n")
+		w.Printf("// %s returns the %s checksum of the region of\n", name, checksumNames[f.Checksum])
+		w.Printf("// field %s of %s.\n", f.Name, s.Name)
+		w.Printf("func %s(v %s, order binary.ByteOrder) uint64 {\n", name, w.goType(s))
+		w.Printf("buf := &bytes.Buffer{}\n")
+		w.Printf("e := &kaitaiEncoder{w: buf}\n")
+		for _, region := range f.Region {
+			if field := s.Field(region); field != nil {
+				w.encodeField(s, field, false)
+			}
+		}
+		switch f.Checksum {
+		case "crc32":
+			w.imports["hash/crc32"] = true
+			w.Printf("return uint64(crc32.ChecksumIEEE(buf.Bytes()))\n")
+		case "crc32c":
+			w.imports["hash/crc32"] = true
+			w.Printf("return uint64(crc32.Checksum(buf.Bytes(), crc32.MakeTable(crc32.Castagnoli)))\n")
+		case "adler32":
+			w.imports["hash/adler32"] = true
+			w.Printf("return uint64(adler32.Checksum(buf.Bytes()))\n")
+		case "crc64":
+			w.imports["hash/crc64"] = true
+			w.Printf("return crc64.Checksum(buf.Bytes(), crc64.MakeTable(crc64.ECMA))\n")
+		}
+		w.Printf("}\n")
+	}
+}
+
+// verifyChecksums outputs the statements verifying the checksums of the fields
+// of the given struct type tagged with the checksum option, once all fields
+// are parsed.
+func (w *goWriter) verifyChecksums(s *ir.StructDef) {
+	for _, f := range s.Fields {
+		if len(f.Checksum) == 0 || len(f.TagErr) > 0 {
+			continue
+		}
+		w.Printf("if sum := %s(v, order); sum != uint64(v.%s) && d.err == nil {\n", checksumFuncName(s, f), f.Name)
+		w.Printf("d.err = fmt.Errorf(\"invalid checksum %s.%s; expected 0x%%X, got 0x%%X\", sum, v.%s)\n", s.Name, f.Name, f.Name)
+		w.Printf("}\n")
+	}
+}
+
+// checksumOf returns the checksum of the given data, using the given algorithm
+// of the checksum option.
+func checksumOf(algo string, data []byte) uint64 {
+	switch algo {
+	case "crc32":
+		return uint64(crc32.ChecksumIEEE(data))
+	case "crc32c":
+		return uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	case "adler32":
+		return uint64(adler32.Checksum(data))
+	case "crc64":
+		return crc64.Checksum(data, crc64.MakeTable(crc64.ECMA))
+	}
+	panic(fmt.Errorf("support for checksum %q not yet implemented", algo))
+}
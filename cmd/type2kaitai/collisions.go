@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+)
+
+// checkCollisions detects identifiers of the generated output shared by
+// distinct Go types, or by distinct fields of the same struct type; e.g. the
+// fields Id and ID, both of which are named id in snake_case.
+//
+// Colliding identifiers of types of the package and of struct fields are
+// disambiguated by a numeric suffix (e.g. id_2), which is recorded in the
+// rename map so that every reference uses the same identifier. Each collision
+// is recorded as a problem, suggesting a -rename to resolve it; types of other
+// packages cannot be renamed by name, and are only reported.
+func (g *Generator) checkCollisions(typeNames []string) {
+	if g.renames == nil {
+		g.renames = make(map[string]string)
+	}
+	structs, enums := g.typeGraph(typeNames)
+	named := append(structs, enums...)
+
+	// Type identifiers.
+	typeIDs := make(map[string]*types.Named)
+	for _, t := range named {
+		name := t.Obj().Name()
+		id := g.typeID(name)
+		prev, ok := typeIDs[id]
+		if !ok {
+			typeIDs[id] = t
+			continue
+		}
+		if prev.Obj().Name() == name && prev.Obj().Pkg() != t.Obj().Pkg() {
+			g.errorf(t.Obj().Pos(), "type id %q of %s collides with %s; types of other packages cannot be renamed", id, g.qualifiedName(t), g.qualifiedName(prev))
+			continue
+		}
+		unique := uniqueID(id, func(id string) bool { return typeIDs[id] != nil })
+		g.errorf(t.Obj().Pos(), "type id %q of %s collides with %s; using %q (use -rename %s=<id> to rename)", id, g.qualifiedName(t), g.qualifiedName(prev), unique, name)
+		g.renames[name] = unique
+		typeIDs[unique] = t
+	}
+
+	// Field identifiers.
+	for _, t := range structs {
+		typeName := t.Obj().Name()
+		st := t.Underlying().(*types.Struct)
+		fieldIDs := make(map[string]*types.Var)
+		for i := 0; i < st.NumFields(); i++ {
+			field := st.Field(i)
+			if g.excluded[field] || field.Name() == "_" {
+				continue
+			}
+			id := g.fieldID(typeName, field.Name())
+			prev, ok := fieldIDs[id]
+			if !ok {
+				fieldIDs[id] = field
+				continue
+			}
+			unique := uniqueID(id, func(id string) bool { return fieldIDs[id] != nil })
+			g.errorf(field.Pos(), "field id %q of %s.%s collides with %s.%s; using %q (use -rename %s.%s=<id> to rename)", id, typeName, field.Name(), typeName, prev.Name(), unique, typeName, field.Name())
+			g.renames[typeName+"."+field.Name()] = unique
+			fieldIDs[unique] = field
+		}
+	}
+}
+
+// uniqueID returns the given identifier with the first numeric suffix (_2, _3,
+// ...) not already in use.
+func uniqueID(id string, used func(id string) bool) string {
+	for i := 2; ; i++ {
+		unique := fmt.Sprintf("%s_%d", id, i)
+		if !used(unique) {
+			return unique
+		}
+	}
+}
+
+// qualifiedName returns the name of the given named type, qualified by package
+// name if declared outside of the package.
+func (g *Generator) qualifiedName(t *types.Named) string {
+	return types.TypeString(t, func(pkg *types.Package) string {
+		if pkg.Path() == g.pkg.path {
+			return ""
+		}
+		return pkg.Name()
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"go/types"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mewrev/tools/internal/structtag"
+	"gopkg.in/yaml.v3"
+)
+
+// config holds the choices of a -interactive session, as stored in the -config
+// file for future non-interactive runs; e.g.
+//
+//	types:
+//	  - Header
+//	  - Entry
+//	endian: be
+//	fields:
+//	  Entry.Name:
+//	    strz: ""
+//	  Header.Size:
+//	    endian: le
+//	substitute:
+//	  Handle: uint32
+//
+// The types and byte order of the configuration apply unless given by -type,
+// -all-exported or -endian, and type substitutions unless given by
+// -substitute. The kaitai options of fields apply as comment directives (see
+// parseDirectives) not present in the struct tag or comment directives of the
+// field.
+type config struct {
+	// Names of the selected types.
+	Types []string `yaml:"types,omitempty"`
+	// Byte order of the binary format; le or be, or empty to infer.
+	Endian string `yaml:"endian,omitempty"`
+	// Kaitai options of fields, keyed by qualified field name (e.g.
+	// Header.Size).
+	Fields map[string]structtag.Tag `yaml:"fields,omitempty"`
+	// Type substitutions, mapping from Go type names to the Go types of their
+	// substitutes (e.g. Handle: uint32); see -substitute.
+	Substitute map[string]string `yaml:"substitute,omitempty"`
+}
+
+// configHeader is the comment prepended to -config files written by
+// -interactive.
+const configHeader = "# Choices of type2kaitai -interactive; reuse with -config.\n"
+
+// readConfig reads the -config file of the given name. readConfig exits if the
+// file is invalid.
+func readConfig(name string) *config {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		fatal(err)
+	}
+	cfg := &config{}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		fatalf("%s: invalid config; %v", name, err)
+	}
+	if len(cfg.Endian) > 0 && cfg.Endian != "le" && cfg.Endian != "be" {
+		fatalf("%s: unsupported endianness %q; valid options: le, be", name, cfg.Endian)
+	}
+	return cfg
+}
+
+// writeConfig writes the given configuration to the named -config file.
+func writeConfig(name string, cfg *config) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(configHeader)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		fatalf("unable to encode config; %v", err)
+	}
+	enc.Close()
+	logf(levelInfo, "config", logAttrs{"file": name}, "writing config: %q", name)
+	if err := writeOutput(name, buf.Bytes(), false); err != nil {
+		fatalf("writing config: %s", err)
+	}
+}
+
+// parseConfigOptions records the kaitai options of the fields of the -config
+// file, keyed by the struct fields of the package. Fields not defined in the
+// package are reported, except in batch mode, where each package defines a
+// subset of the types.
+func (g *Generator) parseConfigOptions() {
+	g.configOptions = make(map[*types.Var]structtag.Tag)
+	if g.config == nil || len(g.config.Fields) == 0 {
+		return
+	}
+	found := make(map[string]bool)
+	for _, ident := range g.structTypes() {
+		st := g.pkg.defs[ident].Type().Underlying().(*types.Struct)
+		for i := 0; i < st.NumFields(); i++ {
+			name := ident.Name + "." + st.Field(i).Name()
+			if tag, ok := g.config.Fields[name]; ok {
+				g.configOptions[st.Field(i)] = tag
+				found[name] = true
+			}
+		}
+	}
+	if g.batch {
+		return
+	}
+	var missing []string
+	for name := range g.config.Fields {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		warnf("config_field", logAttrs{"field": name}, "field %s of config not defined in package %s", name, g.pkg.path)
+	}
+}
+
+// setFieldOption sets the kaitai option of the given key and value of the
+// qualified field name in the configuration, or removes it if remove is set.
+func (cfg *config) setFieldOption(name, key, value string, remove bool) {
+	tag := cfg.Fields[name]
+	if remove {
+		delete(tag, key)
+		if len(tag) == 0 {
+			delete(cfg.Fields, name)
+		}
+		return
+	}
+	if tag == nil {
+		if cfg.Fields == nil {
+			cfg.Fields = make(map[string]structtag.Tag)
+		}
+		tag = make(structtag.Tag)
+		cfg.Fields[name] = tag
+	}
+	tag[key] = value
+}
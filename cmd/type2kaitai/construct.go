@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// generateConstruct outputs Python construct declarations of the selected
+// types and their dependencies.
+func (g *Generator) generateConstruct() {
+	g.Printf("%s", g.headerComment("# "))
+	g.Printf("\n")
+	g.Printf("from construct import *\n")
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.constructEnum(e)
+	}
+	// Output dependencies before the types referring to them.
+	structs := g.mod.Structs
+	for i := len(structs) - 1; i >= 0; i-- {
+		g.Printf("\n")
+		g.constructStruct(structs[i])
+	}
+}
+
+// constructStruct outputs the Struct declaration of the given struct type.
+func (g *Generator) constructStruct(s *ir.StructDef) {
+	g.Printf("%s = Struct(\n", s.Name)
+	for _, f := range s.Fields {
+		typ, err := g.constructType(f.Type)
+		if err != nil {
+			g.Printf("    # TODO: add field %s; %v\n", f.Name, err)
+			continue
+		}
+		if f.Type.Under().Kind == ir.Slice && len(g.slicePrefix) == 0 {
+			typ = strings.Replace(typ, "this.todo_add_slice_len", "this."+sliceLen(s, f), 1)
+		}
+		g.Printf("    %q / %s,  # %s\n", f.ID, typ, f.Type.Go)
+	}
+	g.Printf(")\n")
+}
+
+// constructEnum outputs the Enum declaration of the given enum type.
+func (g *Generator) constructEnum(e *ir.EnumDef) {
+	underlying, _ := g.constructType(e.Underlying)
+	g.Printf("%s = Enum(\n", e.Name)
+	g.Printf("    %s,\n", underlying)
+	for _, v := range e.Values {
+		g.Printf("    %s=%s,\n", v.Name, v.Value)
+	}
+	g.Printf(")\n")
+}
+
+// constructType returns the construct declaration corresponding to the given
+// type.
+func (g *Generator) constructType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return g.constructBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct || t.IsEnum() {
+			return t.Name, nil
+		}
+		return g.constructType(t.Underlying)
+	case ir.Array:
+		if t.Elem.IsByte() {
+			return fmt.Sprintf("Bytes(%d)", t.Len), nil
+		}
+		elem, err := g.constructType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Array(%d, %s)", t.Len, elem), nil
+	case ir.Slice:
+		elem, err := g.constructType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		if len(g.slicePrefix) > 0 {
+			prefix, err := g.constructBasicType(g.slicePrefixType())
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("PrefixedArray(%s, %s)", prefix, elem), nil
+		}
+		return fmt.Sprintf("Array(this.todo_add_slice_len, %s)", elem), nil
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		return g.constructBasicType(addrType(t))
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// constructBasicType returns the construct declaration corresponding to the
+// given basic type.
+func (g *Generator) constructBasicType(t *ir.Type) (string, error) {
+	suffix := "l"
+	if g.endian == "be" {
+		suffix = "b"
+	}
+	switch t.Kind {
+	case ir.Bool:
+		return "Flag", nil
+	case ir.Int:
+		return fmt.Sprintf("Int%ds%s", 8*t.Size, suffix), nil
+	case ir.Uint:
+		return fmt.Sprintf("Int%du%s", 8*t.Size, suffix), nil
+	case ir.Float:
+		return fmt.Sprintf("Float%d%s", 8*t.Size, suffix), nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
+
+// slicePrefixType returns the basic type of the length prefix of slices, as
+// specified by the -slice-prefix flag. slicePrefixType exits if the type is
+// not an unsigned integer type.
+func (g *Generator) slicePrefixType() *ir.Type {
+	for _, size := range []int64{1, 2, 4, 8} {
+		if name := fmt.Sprintf("uint%d", 8*size); name == g.slicePrefix {
+			return &ir.Type{Kind: ir.Uint, Go: name, Size: size}
+		}
+	}
+	usagef("invalid slice length prefix type %q; valid options: uint8, uint16, uint32, uint64", g.slicePrefix)
+	panic("unreachable")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// fieldTypeExprs returns the type expressions of the struct fields declared in
+// the package, including the fields of unnamed struct types; e.g.
+// [HeaderSize]byte of
+//
+//	Magic [HeaderSize]byte
+func (g *Generator) fieldTypeExprs() map[*types.Var]ast.Expr {
+	exprs := make(map[*types.Var]ast.Expr)
+	for _, file := range g.pkg.files {
+		ast.Inspect(file.file, func(n ast.Node) bool {
+			field, ok := n.(*ast.Field)
+			if !ok {
+				return true
+			}
+			for _, name := range field.Names {
+				if v, ok := g.pkg.info.Defs[name].(*types.Var); ok && v.IsField() {
+					exprs[v] = field.Type
+				}
+			}
+			return true
+		})
+	}
+	return exprs
+}
+
+// lenConsts records the names of the constants defining the lengths of the
+// array types of the given field, as declared by its type expression; e.g.
+// HeaderSize of [HeaderSize]byte. Arrays of arrays, slices and pointers are
+// handled in turn.
+func (a *analyzer) lenConsts(typ *ir.Type, field *types.Var) {
+	expr, ok := a.fieldExprs[field]
+	for ok && typ != nil {
+		switch e := expr.(type) {
+		case *ast.ParenExpr:
+			expr = e.X
+			continue
+		case *ast.ArrayType:
+			if typ.Kind != ir.Array && typ.Kind != ir.Slice {
+				return
+			}
+			if typ.Kind == ir.Array {
+				typ.LenConst = a.constName(e.Len)
+			}
+			expr = e.Elt
+		case *ast.StarExpr:
+			if typ.Kind != ir.Pointer {
+				return
+			}
+			expr = e.X
+		default:
+			return
+		}
+		typ = typ.Elem
+	}
+}
+
+// constName returns the name of the constant referred to by the given
+// expression, qualified by package name for constants of other packages, or an
+// empty string if the expression is not a constant identifier (e.g. an integer
+// literal or a constant expression).
+func (a *analyzer) constName(expr ast.Expr) string {
+	var ident *ast.Ident
+	switch e := expr.(type) {
+	case *ast.Ident:
+		ident = e
+	case *ast.SelectorExpr:
+		ident = e.Sel
+	default:
+		return ""
+	}
+	c, ok := a.g.pkg.info.Uses[ident].(*types.Const)
+	if !ok {
+		return ""
+	}
+	if c.Pkg() != nil && c.Pkg().Path() != a.g.pkg.path {
+		return c.Pkg().Name() + "." + c.Name()
+	}
+	return c.Name()
+}
+
+// constID returns the identifier of the value instance of the given length
+// constant with -const-instances; e.g. header_size of HeaderSize, or
+// max_varint_len64 of binary.MaxVarintLen64.
+func (g *Generator) constID(name string) string {
+	return g.ident(name[strings.LastIndex(name, ".")+1:])
+}
+
+// arrayLen returns the Kaitai expression of the length of the given array
+// type; the value instance of its length constant with -const-instances (see
+// constInstances), and the integer length otherwise.
+func (g *Generator) arrayLen(t *ir.Type) string {
+	if g.constInsts && len(t.LenConst) > 0 {
+		return g.constID(t.LenConst)
+	}
+	return strconv.FormatInt(t.Len, 10)
+}
+
+// arrayComment returns the comment of the Kaitai type of the given array type;
+// the Go type, followed by the name of its length constant, if any; e.g.
+// "[16]byte (HeaderSize)".
+func arrayComment(t *ir.Type) string {
+	if len(t.LenConst) > 0 {
+		return t.Go + " (" + t.LenConst + ")"
+	}
+	return t.Go
+}
+
+// constInstances adds the value instances of the constants defining the
+// lengths of the array fields of the given struct type to the given mapping
+// with -const-instances, so that the spec preserves the link between the
+// arrays and the constants of the Go source; e.g.
+//
+//	instances:
+//	  header_size:
+//	    value: 16 # HeaderSize
+func (g *Generator) constInstances(instances *yaml.Node, s *ir.StructDef) {
+	if !g.constInsts {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, f := range s.Fields {
+		for t := f.Type; t != nil; t = t.Elem {
+			if t.Kind != ir.Array && t.Kind != ir.Slice && t.Kind != ir.Pointer {
+				break
+			}
+			if len(t.LenConst) == 0 {
+				continue
+			}
+			id := g.constID(t.LenConst)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if hasFieldID(s, id) || s.Param(id) != nil {
+				g.errorAt(f.Pos, "field %s.%s: instance %s of constant %s collides with field or parameter of the same identifier", s.Name, f.Name, id, t.LenConst)
+			}
+			instance := ksyMap()
+			ksyAdd(instance, "value", ksyValue(strconv.FormatInt(t.Len, 10), t.LenConst))
+			ksyAdd(instances, id, instance)
+		}
+	}
+}
+
+// hasFieldID reports whether the given struct type has a field of the given
+// identifier.
+func hasFieldID(s *ir.StructDef, id string) bool {
+	for _, f := range s.Fields {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
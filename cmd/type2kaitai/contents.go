@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// magicFields returns the magic signatures of byte array fields, as found in
+// the constants and the encoding and decoding functions of the package. The
+// following patterns relate a byte array field F of struct type T and a magic
+// signature:
+//
+//	const F = "\x7fELF"      // e.g. Magic of T.Magic
+//	const TF = "\x7fELF"     // e.g. HeaderMagic of T.Magic
+//	const TMagic = "\x7fELF" // F is the first field of T
+//	const Magic = "\x7fELF"  // F is named Magic, Signature or Sig, or e.g. FileMagic
+//
+// and, in the encoding and decoding functions, for the first field of T or a
+// field named as above:
+//
+//	string(v.F[:]) != "\x7fELF"
+//	!bytes.Equal(v.F[:], []byte(Magic))
+//	v.F = [4]byte{0x7f, 'E', 'L', 'F'}
+//	copy(v.F[:], Magic)
+//
+// The signature must be of the length of the field. Fields related to more
+// than one magic signature are ambiguous, and omitted.
+func (g *Generator) magicFields() map[*types.Var][]byte {
+	magics := make(map[*types.Var][]byte)
+	ambiguous := make(map[*types.Var]bool)
+	add := func(field *types.Var, magic []byte) {
+		if n, ok := byteArrayLen(field.Type()); !ok || n != int64(len(magic)) {
+			return
+		}
+		if prev, ok := magics[field]; ok && !bytes.Equal(prev, magic) {
+			ambiguous[field] = true
+		}
+		magics[field] = magic
+	}
+
+	// Fields of the struct types of the package, by struct type name.
+	scope := g.pkg.types.Scope()
+	fields := make(map[string][]*types.Var)
+	first := make(map[*types.Var]bool)
+	for _, name := range scope.Names() {
+		obj, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || obj.IsAlias() {
+			continue
+		}
+		st, ok := obj.Type().Underlying().(*types.Struct)
+		if !ok || st.NumFields() == 0 {
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			fields[name] = append(fields[name], st.Field(i))
+		}
+		first[st.Field(0)] = true
+	}
+
+	// Magic constants.
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || c.Val().Kind() != constant.String || !isMagicName(name) {
+			continue
+		}
+		magic := []byte(constant.StringVal(c.Val()))
+		for typeName, fs := range fields {
+			for _, f := range fs {
+				switch {
+				case strings.EqualFold(name, f.Name()), strings.EqualFold(name, typeName+f.Name()):
+				case first[f] && (strings.EqualFold(name, typeName+"Magic") || strings.EqualFold(name, typeName+"Signature")):
+				case isMagicName(f.Name()) && (strings.EqualFold(name, "Magic") || strings.EqualFold(name, "Signature")):
+				default:
+					continue
+				}
+				add(f, magic)
+			}
+		}
+	}
+
+	// Magic signatures of the encoding and decoding functions.
+	check := func(field, value ast.Expr) {
+		f, ok := g.byteArrayField(field)
+		if !ok || !(first[f] || isMagicName(f.Name())) {
+			return
+		}
+		if magic, ok := g.constBytes(value); ok {
+			add(f, magic)
+		}
+	}
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || !isLengthFunc(fn.Name.Name) {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.BinaryExpr:
+					// string(v.F[:]) != "\x7fELF"
+					if n.Op == token.EQL || n.Op == token.NEQ {
+						check(n.X, n.Y)
+						check(n.Y, n.X)
+					}
+				case *ast.CallExpr:
+					// !bytes.Equal(v.F[:], []byte(Magic))
+					if g.isPkgFunc(n.Fun, "bytes", "Equal") && len(n.Args) == 2 {
+						check(n.Args[0], n.Args[1])
+						check(n.Args[1], n.Args[0])
+					}
+					// copy(v.F[:], Magic)
+					if dst, ok := g.builtinArg(n, "copy", 0); ok && len(n.Args) == 2 {
+						check(dst, n.Args[1])
+					}
+				case *ast.AssignStmt:
+					// v.F = [4]byte{0x7f, 'E', 'L', 'F'}
+					if len(n.Lhs) == 1 && len(n.Rhs) == 1 {
+						check(n.Lhs[0], n.Rhs[0])
+					}
+				}
+				return true
+			})
+		}
+	}
+	for f := range ambiguous {
+		delete(magics, f)
+	}
+	return magics
+}
+
+// inferContents records the magic contents of the given byte array field of a
+// struct, as found by magicFields, unless specified by its kaitai struct tag.
+func (a *analyzer) inferContents(f *ir.Field, st *types.Struct) {
+	if f.Contents != nil || f.Index < 0 || len(f.TagErr) > 0 {
+		return
+	}
+	magic, ok := a.magics[st.Field(f.Index)]
+	if !ok {
+		return
+	}
+	if u := f.Type.Under(); u.Kind == ir.Array && u.Elem.IsByte() && u.Len == int64(len(magic)) {
+		f.Contents = magic
+	}
+}
+
+// isMagicName reports whether the given identifier names a magic signature;
+// i.e. Magic, Signature or Sig, or ending with Magic or Signature (e.g.
+// ELFMagic), in any case.
+func isMagicName(name string) bool {
+	name = strings.ToLower(name)
+	return name == "sig" || strings.HasSuffix(name, "magic") || strings.HasSuffix(name, "signature")
+}
+
+// byteArrayLen returns the length of the given byte array type, and a boolean
+// indicating whether the type is a byte array.
+func byteArrayLen(t types.Type) (int64, bool) {
+	arr, ok := t.Underlying().(*types.Array)
+	if !ok {
+		return 0, false
+	}
+	elem, ok := arr.Elem().Underlying().(*types.Basic)
+	if !ok || elem.Kind() != types.Uint8 {
+		return 0, false
+	}
+	return arr.Len(), true
+}
+
+// byteArrayField returns the byte array field selected by the given
+// expression, looking through type conversions and full slices; e.g. v.F of
+// string(v.F[:]).
+func (g *Generator) byteArrayField(expr ast.Expr) (*types.Var, bool) {
+	expr = g.unconvert(expr)
+	if s, ok := expr.(*ast.SliceExpr); ok && s.Low == nil && s.High == nil {
+		expr = s.X
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return nil, false
+	}
+	f, ok := g.fieldVar(sel)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := byteArrayLen(f.Type()); !ok {
+		return nil, false
+	}
+	return f, true
+}
+
+// constBytes returns the bytes of the given constant expression, looking
+// through type conversions; a string constant (e.g. "\x7fELF" or []byte(Magic))
+// or a composite literal of constant bytes (e.g. [4]byte{0x7f, 'E', 'L', 'F'}).
+func (g *Generator) constBytes(expr ast.Expr) ([]byte, bool) {
+	expr = g.unconvert(expr)
+	if tv, ok := g.pkg.info.Types[expr]; ok && tv.Value != nil && tv.Value.Kind() == constant.String {
+		return []byte(constant.StringVal(tv.Value)), true
+	}
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok || len(lit.Elts) == 0 {
+		return nil, false
+	}
+	if n, ok := byteArrayLen(g.pkg.info.TypeOf(lit)); !ok || n != int64(len(lit.Elts)) {
+		return nil, false
+	}
+	var buf []byte
+	for _, elt := range lit.Elts {
+		tv, ok := g.pkg.info.Types[elt]
+		if !ok || tv.Value == nil {
+			return nil, false
+		}
+		x, ok := constant.Uint64Val(constant.ToInt(tv.Value))
+		if !ok || x > 0xFF {
+			return nil, false
+		}
+		buf = append(buf, byte(x))
+	}
+	return buf, true
+}
+
+// isPkgFunc reports whether the given expression refers to the given function
+// of the package of the given import path; e.g. bytes.Equal.
+func (g *Generator) isPkgFunc(expr ast.Expr, path, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	fn, ok := g.pkg.info.Uses[sel.Sel].(*types.Func)
+	return ok && fn.Pkg() != nil && fn.Pkg().Path() == path
+}
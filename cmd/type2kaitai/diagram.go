@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+)
+
+// generateDot outputs a Graphviz diagram of the dependency graph of the
+// selected types, with one record node per struct and enum, and one edge per
+// field referring to another type.
+func (g *Generator) generateDot() {
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	g.Printf("digraph %s {\n", g.mod.Package)
+	g.Printf("\tnode [shape=record];\n")
+	for _, s := range g.mod.Structs {
+		var rows []string
+		for _, f := range s.Fields {
+			rows = append(rows, "<"+f.Name+"> "+dotEscape(f.Name+" "+f.Type.Go)+`\l`)
+		}
+		g.Printf("\t%s [label=\"{%s|%s}\"];\n", s.Name, s.Name, strings.Join(rows, "|"))
+	}
+	for _, e := range g.mod.Enums {
+		var rows []string
+		for _, v := range e.Values {
+			rows = append(rows, dotEscape(v.Name+" = "+v.Value)+`\l`)
+		}
+		g.Printf("\t%s [label=\"{%s (enum)|%s}\"];\n", e.Name, e.Name, strings.Join(rows, "|"))
+	}
+	for _, s := range g.mod.Structs {
+		for _, f := range s.Fields {
+			for _, dep := range f.Type.Deps() {
+				g.Printf("\t%s:%s -> %s;\n", s.Name, f.Name, dep.Name)
+			}
+		}
+	}
+	g.Printf("}\n")
+}
+
+// dotEscape escapes the special characters of Graphviz record labels in the
+// given string.
+func dotEscape(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`{`, `\{`,
+		`}`, `\}`,
+		`|`, `\|`,
+		`<`, `\<`,
+		`>`, `\>`,
+	)
+	return r.Replace(s)
+}
+
+// generateMermaid outputs a Mermaid class diagram of the dependency graph of
+// the selected types, with one class per struct and enum, and one relation per
+// field referring to another type.
+func (g *Generator) generateMermaid() {
+	g.Printf("%s", g.headerComment("%% "))
+	g.Printf("\n")
+	g.Printf("classDiagram\n")
+	for _, s := range g.mod.Structs {
+		g.Printf("    class %s {\n", s.Name)
+		for _, f := range s.Fields {
+			g.Printf("        %s %s\n", f.Type.Go, f.Name)
+		}
+		g.Printf("    }\n")
+	}
+	for _, e := range g.mod.Enums {
+		g.Printf("    class %s {\n", e.Name)
+		g.Printf("        <<enumeration>>\n")
+		for _, v := range e.Values {
+			g.Printf("        %s = %s\n", v.Name, v.Value)
+		}
+		g.Printf("    }\n")
+	}
+	for _, s := range g.mod.Structs {
+		for _, f := range s.Fields {
+			for _, dep := range f.Type.Deps() {
+				g.Printf("    %s --> %s : %s\n", s.Name, dep.Name, f.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// diffUpToDate displays the problems found during generation, and the
+// differences between the Kaitai spec in the named output file and the
+// generated spec (-diff); i.e. added, removed and retyped types, fields and
+// enums. Differences not affecting the structure of the spec are reported as
+// changed comments or formatting. diffUpToDate reports whether the output file
+// is up to date (and no problems were found with -strict).
+func (g *Generator) diffUpToDate(outputName string) bool {
+	failed := g.reportErrors() && *strict || g.failed
+	buf, err := ioutil.ReadFile(outputName)
+	if err != nil {
+		logf(levelWarn, "stale", logAttrs{"file": outputName}, "%s is out of date; %v", outputName, err)
+		return false
+	}
+	if bytes.Equal(buf, g.buf.Bytes()) {
+		return !failed
+	}
+	old, err := ksyOutlineOf(buf)
+	if err != nil {
+		logf(levelWarn, "stale", logAttrs{"file": outputName}, "%s is out of date; invalid Kaitai spec; %v", outputName, err)
+		return false
+	}
+	gen, err := ksyOutlineOf(g.buf.Bytes())
+	if err != nil {
+		g.errorf(token.NoPos, "invalid generated Kaitai spec; %v", err)
+		return false
+	}
+	changes := diffSpecs(old, gen)
+	if len(changes) == 0 {
+		// Same structure; e.g. changed comments or command line.
+		changes = append(changes, "~ comments or formatting")
+	}
+	fmt.Printf("%s:\n", outputName)
+	for _, change := range changes {
+		fmt.Printf("\t%s\n", change)
+	}
+	logf(levelWarn, "stale", logAttrs{"file": outputName, "changes": changes}, "%s is out of date; %d differences", outputName, len(changes))
+	return false
+}
+
+// ksyOutline is the outline of a Kaitai spec compared by -diff.
+type ksyOutline struct {
+	// meta maps from meta keys to values.
+	meta map[string]string
+	// types maps from type identifiers to seq attributes, in order.
+	types map[string][]ksyAttr
+	// typeOrder specifies the type identifiers in order of appearance.
+	typeOrder []string
+	// enums maps from enum identifiers to values, mapping from enum values to
+	// identifiers.
+	enums map[string]map[string]string
+	// enumOrder specifies the enum identifiers in order of appearance.
+	enumOrder []string
+}
+
+// ksyAttr is a seq attribute of a Kaitai type.
+type ksyAttr struct {
+	// Attribute identifier.
+	id string
+	// Keys of the attribute specifying its type (e.g. "type: u4, repeat:
+	// eos"); i.e. all keys other than id and documentation.
+	spec string
+}
+
+// ksyOutlineOf parses the outline of the given Kaitai spec.
+func ksyOutlineOf(buf []byte) (*ksyOutline, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("root is not a mapping")
+	}
+	root := doc.Content[0]
+	spec := &ksyOutline{
+		meta:  make(map[string]string),
+		types: make(map[string][]ksyAttr),
+		enums: make(map[string]map[string]string),
+	}
+	if meta := ksyLookup(root, "meta"); meta != nil {
+		for i := 0; i+1 < len(meta.Content); i += 2 {
+			spec.meta[meta.Content[i].Value] = ksyFlow(meta.Content[i+1])
+		}
+	}
+	if types := ksyLookup(root, "types"); types != nil {
+		for i := 0; i+1 < len(types.Content); i += 2 {
+			id := types.Content[i].Value
+			spec.typeOrder = append(spec.typeOrder, id)
+			spec.types[id] = nil
+			seq := ksyLookup(types.Content[i+1], "seq")
+			if seq == nil {
+				continue
+			}
+			for _, attr := range seq.Content {
+				spec.types[id] = append(spec.types[id], ksyAttrOf(attr))
+			}
+		}
+	}
+	if enums := ksyLookup(root, "enums"); enums != nil {
+		for i := 0; i+1 < len(enums.Content); i += 2 {
+			id := enums.Content[i].Value
+			spec.enumOrder = append(spec.enumOrder, id)
+			values := make(map[string]string)
+			m := enums.Content[i+1]
+			for j := 0; j+1 < len(m.Content); j += 2 {
+				values[m.Content[j].Value] = ksyEnumID(m.Content[j+1])
+			}
+			spec.enums[id] = values
+		}
+	}
+	return spec, nil
+}
+
+// ksyAttrOf returns the seq attribute of the given mapping node.
+func ksyAttrOf(n *yaml.Node) ksyAttr {
+	var attr ksyAttr
+	var keys []string
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i].Value, n.Content[i+1]
+		switch key {
+		case "id":
+			attr.id = value.Value
+		case "doc", "doc-ref", "-orig-id":
+			// Documentation; not part of the structure.
+		default:
+			keys = append(keys, key+": "+ksyFlow(value))
+		}
+	}
+	attr.spec = strings.Join(keys, ", ")
+	return attr
+}
+
+// ksyEnumID returns the identifier of the given enum value; either a plain
+// identifier or the id key of the verbose enum form.
+func ksyEnumID(n *yaml.Node) string {
+	if id := ksyLookup(n, "id"); id != nil {
+		return id.Value
+	}
+	return ksyFlow(n)
+}
+
+// ksyFlow returns the given node in YAML flow notation, without comments.
+func ksyFlow(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		var pairs []string
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			pairs = append(pairs, ksyFlow(n.Content[i])+": "+ksyFlow(n.Content[i+1]))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case yaml.SequenceNode:
+		var elems []string
+		for _, elem := range n.Content {
+			elems = append(elems, ksyFlow(elem))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return n.Value
+}
+
+// diffSpecs returns the differences between the old and the generated Kaitai
+// spec, one per line; prefixed by + for additions, - for removals and ~ for
+// changes.
+func diffSpecs(old, gen *ksyOutline) []string {
+	var changes []string
+	for _, key := range sortedKeys(old.meta, gen.meta) {
+		oldValue, inOld := old.meta[key]
+		newValue, inNew := gen.meta[key]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("+ meta %s: %s", key, newValue))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("- meta %s: %s", key, oldValue))
+		case oldValue != newValue:
+			changes = append(changes, fmt.Sprintf("~ meta %s: %s -> %s", key, oldValue, newValue))
+		}
+	}
+	for _, id := range old.typeOrder {
+		if _, ok := gen.types[id]; !ok {
+			changes = append(changes, fmt.Sprintf("- type %s", id))
+		}
+	}
+	for _, id := range gen.typeOrder {
+		oldAttrs, ok := old.types[id]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ type %s", id))
+			continue
+		}
+		changes = append(changes, diffAttrs(id, oldAttrs, gen.types[id])...)
+	}
+	for _, id := range old.enumOrder {
+		if _, ok := gen.enums[id]; !ok {
+			changes = append(changes, fmt.Sprintf("- enum %s", id))
+		}
+	}
+	for _, id := range gen.enumOrder {
+		oldValues, ok := old.enums[id]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ enum %s", id))
+			continue
+		}
+		newValues := gen.enums[id]
+		for _, value := range sortedKeys(oldValues, newValues) {
+			oldID, inOld := oldValues[value]
+			newID, inNew := newValues[value]
+			switch {
+			case !inOld:
+				changes = append(changes, fmt.Sprintf("+ enum %s: %s: %s", id, value, newID))
+			case !inNew:
+				changes = append(changes, fmt.Sprintf("- enum %s: %s: %s", id, value, oldID))
+			case oldID != newID:
+				changes = append(changes, fmt.Sprintf("~ enum %s: %s: %s -> %s", id, value, oldID, newID))
+			}
+		}
+	}
+	return changes
+}
+
+// diffAttrs returns the differences between the old and the generated seq
+// attributes of the given type; added, removed, retyped and moved fields.
+func diffAttrs(typeID string, old, gen []ksyAttr) []string {
+	var changes []string
+	oldIndex := make(map[string]int)
+	for i, attr := range old {
+		oldIndex[attr.id] = i
+	}
+	newIndex := make(map[string]int)
+	for i, attr := range gen {
+		newIndex[attr.id] = i
+	}
+	for _, attr := range old {
+		if _, ok := newIndex[attr.id]; !ok {
+			changes = append(changes, fmt.Sprintf("- field %s.%s (%s)", typeID, attr.id, attr.spec))
+		}
+	}
+	// Fields present in both specs, in the old order, to detect moved fields.
+	var common []string
+	for _, attr := range old {
+		if _, ok := newIndex[attr.id]; ok {
+			common = append(common, attr.id)
+		}
+	}
+	pos := 0
+	for _, attr := range gen {
+		i, ok := oldIndex[attr.id]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ field %s.%s (%s)", typeID, attr.id, attr.spec))
+			continue
+		}
+		if old[i].spec != attr.spec {
+			changes = append(changes, fmt.Sprintf("~ field %s.%s: %s -> %s", typeID, attr.id, old[i].spec, attr.spec))
+		}
+		if common[pos] != attr.id {
+			changes = append(changes, fmt.Sprintf("~ field %s.%s: moved", typeID, attr.id))
+		}
+		pos++
+	}
+	return changes
+}
+
+// sortedKeys returns the keys of the given maps, in sorted order.
+func sortedKeys(a, b map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]string{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// parseDirectives records the //kaitai: comment directives of the struct types
+// of the package and their fields (see structtag.ParseDirectives). Directives
+// are given in the doc comment of type declarations, and in the doc or line
+// comment of fields; e.g.
+//
+//	//kaitai:endian be
+//	type Header struct {
+//	    Len  uint16
+//	    //kaitai:size Len
+//	    Data []byte `json:"data"`
+//	    Cache map[string]int //kaitai:skip
+//	}
+//
+// The endian directive of a type applies to the fields of the type without an
+// endian option of their own, and the order directive of a type gives the
+// order of its fields in the binary format; e.g.
+//
+//	//kaitai:order Kind,Len
+//	type Entry struct {
+//	    Len  uint32
+//	    Kind uint8
+//	}
+//
+// is output with kind preceding len. The id directive of a field gives its
+// identifier in the generated output; e.g.
+//
+//	//kaitai:id legacy_name
+//	Name [16]byte
+func (g *Generator) parseDirectives() {
+	g.typeDirectives = make(map[string]structtag.Tag)
+	g.fieldDirectives = make(map[*types.Var]structtag.Tag)
+	g.fieldIDs = make(map[string]string)
+	g.fieldOrders = make(map[*types.Struct][]int)
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				doc := spec.Doc
+				if doc == nil && len(decl.Specs) == 1 {
+					doc = decl.Doc
+				}
+				typeTag := structtag.ParseDirectives(doc)
+				if len(typeTag) > 0 {
+					g.typeDirectives[spec.Name.Name] = typeTag
+				}
+				g.parseFieldDirectives(spec, typeTag)
+			}
+		}
+	}
+	g.parseConfigOptions()
+}
+
+// parseFieldDirectives records the comment directives of the fields of the
+// given struct type declaration, with the endian directive of the type
+// applied to fields without one, and the field order of the order directive
+// of the type, if valid; invalid orders are reported by structDef.
+func (g *Generator) parseFieldDirectives(spec *ast.TypeSpec, typeTag structtag.Tag) {
+	structType, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return
+	}
+	def, ok := g.pkg.defs[spec.Name]
+	if !ok {
+		return
+	}
+	st, ok := def.Type().Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+	if names, ok, err := typeTag.Order(); ok && err == nil {
+		if order, err := structtag.FieldOrder(st, names); err == nil {
+			g.fieldOrders[st] = order
+		}
+	}
+	// The fields of the type checker are in order of the field names of the
+	// declaration; embedded fields have no names.
+	i := 0
+	for _, field := range structType.Fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		tag := structtag.ParseDirectives(field.Doc, field.Comment)
+		if endian, ok := typeTag["endian"]; ok && !tag.Has("endian") {
+			tag["endian"] = endian
+		}
+		for j := 0; j < n && i < st.NumFields(); j++ {
+			if len(tag) > 0 {
+				g.fieldDirectives[st.Field(i)] = tag
+			}
+			if id, ok, err := tag.ID(); ok && err == nil {
+				g.fieldIDs[spec.Name.Name+"."+st.Field(i).Name()] = id
+			}
+			i++
+		}
+	}
+}
+
+// checkTypeDirectives reports the comment directives of the given type
+// declaration not supported on types (see structtag.Options); i.e. other than
+//
+//	//kaitai:endian be    byte order of the fields of the type
+//	//kaitai:skip         omit the type, and fields of the type
+//	//kaitai:param ...    parameter of the type; see parseParams
+//	//kaitai:order ...    order of the fields in the binary format
+//
+// The bit-endian directive applies only to flag-style enum types; see
+// generateFlagTypes.
+func (g *Generator) checkTypeDirectives(t *types.Named) {
+	typeName := t.Obj().Name()
+	var keys []string
+	for key := range g.typeDirectives[typeName] {
+		if opt, ok := structtag.Options[key]; !ok || !opt.Type || key == "bit-endian" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g.errorf(t.Obj().Pos(), "type %s: directive %s%s not supported on types", typeName, structtag.DirectivePrefix, key)
+	}
+	names, ok, err := g.typeDirectives[typeName].Order()
+	if ok && err == nil {
+		_, err = structtag.FieldOrder(t.Underlying().(*types.Struct), names)
+	}
+	if err != nil {
+		g.errorf(t.Obj().Pos(), "type %s: %v", typeName, err)
+	}
+}
+
+// fieldTag returns the kaitai options of the i-th field of the given struct
+// type; the options of the struct tag of the field, the options of its
+// comment directives not present in the struct tag, and the options of the
+// -config file not present in either.
+func (g *Generator) fieldTag(st *types.Struct, i int) structtag.Tag {
+	return structtag.Parse(st.Tag(i)).Merge(g.fieldDirectives[st.Field(i)]).Merge(g.configOptions[st.Field(i)])
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// byteOrderFuncs specifies the functions of encoding/binary taking the byte
+// order as second argument and the data as third argument.
+var byteOrderFuncs = map[string]bool{
+	"Read":   true,
+	"Write":  true,
+	"Decode": true,
+	"Encode": true,
+	"Append": true,
+}
+
+// inferEndian returns the byte order of the binary format, as inferred from
+// the calls of the package to encoding/binary (e.g. binary.Read) with data of
+// the given types or their dependencies, and the calls decoding or encoding
+// their fields, directly or by decode helpers returning multiple results (see
+// decodeHelpers); e.g.
+//
+//	binary.Read(r, binary.BigEndian, &hdr)
+//	hdr.Len = binary.BigEndian.Uint16(b[4:])
+//	binary.BigEndian.PutUint16(b[4:], hdr.Len)
+//	hdr.Len, off, err = readUint16(b, off)
+//
+// The byte order used by most calls is returned, and a warning reported if
+// both byte orders are used. inferEndian returns le if no calls are found.
+func (g *Generator) inferEndian(typeNames []string) string {
+	structs, _ := g.typeGraph(typeNames)
+	targets := make(map[*types.Named]bool)
+	fields := make(map[*types.Var]bool)
+	for _, t := range structs {
+		targets[t] = true
+		st := t.Underlying().(*types.Struct)
+		for i := 0; i < st.NumFields(); i++ {
+			fields[st.Field(i)] = true
+		}
+	}
+	isField := func(expr ast.Expr) bool {
+		sel, ok := g.unconvert(expr).(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		field, ok := g.fieldVar(sel)
+		return ok && fields[field]
+	}
+	helpers := g.decodeHelpers()
+	count := make(map[string]int)
+	pos := make(map[string]string)
+	record := func(endian string, call *ast.CallExpr) {
+		count[endian]++
+		if _, ok := pos[endian]; !ok {
+			pos[endian] = g.posString(call.Pos())
+		}
+	}
+	for _, file := range g.pkg.files {
+		ast.Inspect(file.file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if len(n.Args) == 3 && g.isBinaryFunc(n.Fun) {
+					// binary.Read(r, binary.BigEndian, &hdr)
+					if endian, ok := g.byteOrder(n.Args[1]); ok && targets[dataType(g.pkg.info.TypeOf(n.Args[2]))] {
+						record(endian, n)
+					}
+					break
+				}
+				// binary.BigEndian.PutUint16(b[4:], hdr.Len)
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if !ok || len(n.Args) != 2 || !strings.HasPrefix(sel.Sel.Name, "PutUint") && !strings.HasPrefix(sel.Sel.Name, "AppendUint") {
+					break
+				}
+				if endian, ok := g.callByteOrder(n); ok && isField(n.Args[1]) {
+					record(endian, n)
+				}
+			case *ast.AssignStmt:
+				// hdr.Len = binary.BigEndian.Uint16(b[4:])
+				// hdr.Len, off, err = readUint16(b, off)
+				if len(n.Rhs) != 1 || !isField(n.Lhs[0]) {
+					break
+				}
+				call, h, ok := g.helperCall(helpers, n.Rhs[0])
+				if ok && len(h.endian) > 0 {
+					record(h.endian, call)
+					break
+				}
+				call, ok = g.unconvert(n.Rhs[0]).(*ast.CallExpr)
+				if !ok {
+					break
+				}
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok && strings.HasPrefix(sel.Sel.Name, "Uint") {
+					if endian, ok := g.callByteOrder(call); ok {
+						record(endian, call)
+					}
+				}
+			}
+			return true
+		})
+	}
+	switch {
+	case count["le"] > 0 && count["be"] > 0:
+		endian := "le"
+		if count["be"] > count["le"] {
+			endian = "be"
+		}
+		warnf("endian", logAttrs{"endian": endian, "le": count["le"], "be": count["be"]}, "encoding/binary calls use both little-endian (e.g. %s) and big-endian (e.g. %s) byte order; using %s of %d out of %d calls", pos["le"], pos["be"], endian, count[endian], count["le"]+count["be"])
+		return endian
+	case count["be"] > 0:
+		return "be"
+	}
+	return "le"
+}
+
+// isBinaryFunc reports whether the given function expression refers to one of
+// the byteOrderFuncs of encoding/binary.
+func (g *Generator) isBinaryFunc(fun ast.Expr) bool {
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	fn, ok := g.pkg.info.Uses[sel.Sel].(*types.Func)
+	return ok && fn.Pkg() != nil && fn.Pkg().Path() == "encoding/binary" && byteOrderFuncs[fn.Name()]
+}
+
+// byteOrder returns the endianness (le or be) of the given byte order
+// expression, and a boolean indicating whether the expression refers to
+// binary.LittleEndian or binary.BigEndian.
+func (g *Generator) byteOrder(expr ast.Expr) (string, bool) {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+	v, ok := g.pkg.info.Uses[sel.Sel].(*types.Var)
+	if !ok || v.Pkg() == nil || v.Pkg().Path() != "encoding/binary" {
+		return "", false
+	}
+	switch v.Name() {
+	case "LittleEndian":
+		return "le", true
+	case "BigEndian":
+		return "be", true
+	}
+	return "", false
+}
+
+// dataType returns the named type of the data passed to encoding/binary;
+// pointers, slices and arrays of the named type are resolved.
+func dataType(t types.Type) *types.Named {
+	for t != nil {
+		switch u := types.Unalias(t).(type) {
+		case *types.Named:
+			return u
+		case *types.Pointer:
+			t = u.Elem()
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
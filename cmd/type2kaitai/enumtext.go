@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// writeEnumTexts writes the MarshalText and UnmarshalText methods of the enum
+// types of the package in dir (-gen enumtext), one file srcdir/<type>_text.go
+// per enum type. The text of enum values is the identifier of the value in
+// the Kaitai enum of the type, so that the names of JSON APIs and Kaitai specs
+// are consistent; values without a constant are represented by their decimal
+// integer value. With -gen enumjson, the MarshalJSON and UnmarshalJSON methods
+// are written as well, representing values without a constant by JSON
+// numbers rather than strings. Enum types which already have one of the
+// methods declared elsewhere are skipped.
+func (g *Generator) writeEnumTexts(dir string, withJSON bool) {
+	methods := []string{"MarshalText", "UnmarshalText"}
+	if withJSON {
+		methods = append(methods, "MarshalJSON", "UnmarshalJSON")
+	}
+	for _, e := range g.mod.Enums {
+		if e.Package != g.pkg.path {
+			// Methods may only be declared in the package of the type.
+			continue
+		}
+		outputName := filepath.Join(dir, strings.ToLower(e.Name)+"_text.go")
+		if pos, ok := g.declaredMethod(e.Name, outputName, methods...); ok {
+			warnf("enumtext", logAttrs{"name": e.Name, "pos": pos}, "skipping text methods of type %s; already declared at %s", e.Name, pos)
+			continue
+		}
+		src := &bytes.Buffer{}
+		src.WriteString(g.headerComment("// "))
+		fmt.Fprintf(src, "\n")
+		fmt.Fprintf(src, "package %s\n", g.mod.Package)
+		fmt.Fprintf(src, "\n")
+		fmt.Fprintf(src, "import (\n")
+		if withJSON {
+			fmt.Fprintf(src, "\t\"encoding/json\"\n")
+		}
+		fmt.Fprintf(src, "\t\"fmt\"\n")
+		fmt.Fprintf(src, "\t\"strconv\"\n")
+		fmt.Fprintf(src, ")\n")
+		enumTextFuncs(src, e, withJSON)
+		out, err := format.Source(src.Bytes())
+		if err != nil {
+			warnf("internal_error", nil, "internal error: invalid Go text methods generated: %s", err)
+			out = src.Bytes()
+		}
+		logf(levelInfo, "enumtext", logAttrs{"file": outputName}, "writing text methods: %q", outputName)
+		if err := writeOutput(outputName, out, *writeIfChanged); err != nil {
+			fatalf("writing text methods: %s", err)
+		}
+	}
+}
+
+// enumTextFuncs writes the constant tables and the text methods of the given
+// enum type to w; and the JSON methods if withJSON is set.
+func enumTextFuncs(w *bytes.Buffer, e *ir.EnumDef, withJSON bool) {
+	typeName := e.Name
+	// Format and parse functions of integer values.
+	formatFunc, parseFunc, conv := "FormatUint", "ParseUint", "uint64"
+	if e.Underlying != nil && e.Underlying.Kind == ir.Int {
+		formatFunc, parseFunc, conv = "FormatInt", "ParseInt", "int64"
+	}
+	bitSize := int64(64)
+	if e.Underlying != nil && e.Underlying.Size > 0 {
+		bitSize = e.Underlying.Size * 8
+	}
+
+	// Identifiers of values, of the first constant of each value as in the
+	// Kaitai enum.
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// _%s_text maps from values of %s to their identifiers in the Kaitai enum\n", typeName, typeName)
+	fmt.Fprintf(w, "// %s.\n", e.ID)
+	fmt.Fprintf(w, "var _%s_text = map[%s]string{\n", typeName, typeName)
+	seen := make(map[string]bool)
+	for _, v := range e.Values {
+		if v.Name == "_" || seen[v.Value] {
+			continue
+		}
+		seen[v.Value] = true
+		fmt.Fprintf(w, "\t%s: %q,\n", v.Name, v.ID)
+	}
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// _%s_values maps from identifiers in the Kaitai enum %s to values of\n", typeName, e.ID)
+	fmt.Fprintf(w, "// %s.\n", typeName)
+	fmt.Fprintf(w, "var _%s_values = map[string]%s{\n", typeName, typeName)
+	for _, v := range e.Values {
+		if v.Name == "_" {
+			continue
+		}
+		fmt.Fprintf(w, "\t%q: %s,\n", v.ID, v.Name)
+	}
+	fmt.Fprintf(w, "}\n")
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// MarshalText implements encoding.TextMarshaler. The text of values without a\n")
+	fmt.Fprintf(w, "// constant is their decimal integer value.\n")
+	fmt.Fprintf(w, "func (i %s) MarshalText() ([]byte, error) {\n", typeName)
+	fmt.Fprintf(w, "\tif text, ok := _%s_text[i]; ok {\n", typeName)
+	fmt.Fprintf(w, "\t\treturn []byte(text), nil\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn []byte(strconv.%s(%s(i), 10)), nil\n", formatFunc, conv)
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// UnmarshalText implements encoding.TextUnmarshaler, accepting the identifiers\n")
+	fmt.Fprintf(w, "// of the Kaitai enum %s and decimal integer values.\n", e.ID)
+	fmt.Fprintf(w, "func (i *%s) UnmarshalText(text []byte) error {\n", typeName)
+	fmt.Fprintf(w, "\tif v, ok := _%s_values[string(text)]; ok {\n", typeName)
+	fmt.Fprintf(w, "\t\t*i = v\n")
+	fmt.Fprintf(w, "\t\treturn nil\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\tx, err := strconv.%s(string(text), 10, %d)\n", parseFunc, bitSize)
+	fmt.Fprintf(w, "\tif err != nil {\n")
+	fmt.Fprintf(w, "\t\treturn fmt.Errorf(\"invalid %s %%q\", text)\n", typeName)
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\t*i = %s(x)\n", typeName)
+	fmt.Fprintf(w, "\treturn nil\n")
+	fmt.Fprintf(w, "}\n")
+	if !withJSON {
+		return
+	}
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// MarshalJSON implements json.Marshaler. Values without a constant are\n")
+	fmt.Fprintf(w, "// represented by JSON numbers.\n")
+	fmt.Fprintf(w, "func (i %s) MarshalJSON() ([]byte, error) {\n", typeName)
+	fmt.Fprintf(w, "\tif text, ok := _%s_text[i]; ok {\n", typeName)
+	fmt.Fprintf(w, "\t\treturn json.Marshal(text)\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn []byte(strconv.%s(%s(i), 10)), nil\n", formatFunc, conv)
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// UnmarshalJSON implements json.Unmarshaler, accepting JSON strings of the\n")
+	fmt.Fprintf(w, "// identifiers of the Kaitai enum %s and JSON numbers.\n", e.ID)
+	fmt.Fprintf(w, "func (i *%s) UnmarshalJSON(data []byte) error {\n", typeName)
+	fmt.Fprintf(w, "\tif len(data) > 0 && data[0] == '\"' {\n")
+	fmt.Fprintf(w, "\t\tvar text string\n")
+	fmt.Fprintf(w, "\t\tif err := json.Unmarshal(data, &text); err != nil {\n")
+	fmt.Fprintf(w, "\t\t\treturn err\n")
+	fmt.Fprintf(w, "\t\t}\n")
+	fmt.Fprintf(w, "\t\treturn i.UnmarshalText([]byte(text))\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn i.UnmarshalText(data)\n")
+	fmt.Fprintf(w, "}\n")
+}
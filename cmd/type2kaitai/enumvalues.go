@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// writeEnumValues writes the Values function and IsValid method of the enum
+// types of the package in dir (-gen enumvalues), one file
+// srcdir/<type>_values.go per enum type; e.g. KindValues() []Kind and
+// Kind.IsValid() bool. The values are the constants of the Kaitai enum of the
+// type, so that validation is consistent with the Kaitai spec. Values of
+// flag-style enum types are valid if each set bit is one of the flags. Enum
+// types of which the function or method is already declared elsewhere are
+// skipped.
+func (g *Generator) writeEnumValues(dir string) {
+	for _, e := range g.mod.Enums {
+		if e.Package != g.pkg.path {
+			// Methods may only be declared in the package of the type.
+			continue
+		}
+		outputName := filepath.Join(dir, strings.ToLower(e.Name)+"_values.go")
+		pos, ok := g.declaredMethod(e.Name, outputName, "IsValid")
+		if obj := g.lookupObject(e.Name + "Values"); !ok && obj != nil {
+			if p := g.pkg.fset.Position(obj.Pos()); filepath.Base(p.Filename) != filepath.Base(outputName) {
+				pos, ok = p.String(), true
+			}
+		}
+		if ok {
+			warnf("enumvalues", logAttrs{"name": e.Name, "pos": pos}, "skipping values of type %s; already declared at %s", e.Name, pos)
+			continue
+		}
+		src := &bytes.Buffer{}
+		src.WriteString(g.headerComment("// "))
+		fmt.Fprintf(src, "\n")
+		fmt.Fprintf(src, "package %s\n", g.mod.Package)
+		enumValuesFuncs(src, e)
+		out, err := format.Source(src.Bytes())
+		if err != nil {
+			warnf("internal_error", nil, "internal error: invalid Go values generated: %s", err)
+			out = src.Bytes()
+		}
+		logf(levelInfo, "enumvalues", logAttrs{"file": outputName}, "writing values: %q", outputName)
+		if err := writeOutput(outputName, out, *writeIfChanged); err != nil {
+			fatalf("writing values: %s", err)
+		}
+	}
+}
+
+// enumValuesFuncs writes the Values function and IsValid method of the given
+// enum type to w.
+func enumValuesFuncs(w *bytes.Buffer, e *ir.EnumDef) {
+	typeName := e.Name
+	// Constants of distinct values, of the first constant of each value as in
+	// the Kaitai enum.
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range e.Values {
+		if v.Name == "_" || seen[v.Value] {
+			continue
+		}
+		seen[v.Value] = true
+		names = append(names, v.Name)
+	}
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "// %sValues returns the values of %s, as defined by the Kaitai enum %s.\n", typeName, typeName, e.ID)
+	fmt.Fprintf(w, "func %sValues() []%s {\n", typeName, typeName)
+	fmt.Fprintf(w, "\treturn []%s{\n", typeName)
+	for _, name := range names {
+		fmt.Fprintf(w, "\t\t%s,\n", name)
+	}
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "\n")
+	if e.Flags {
+		fmt.Fprintf(w, "// IsValid reports whether each bit set in i is a flag of the Kaitai enum %s.\n", e.ID)
+		fmt.Fprintf(w, "func (i %s) IsValid() bool {\n", typeName)
+		fmt.Fprintf(w, "\treturn i&^(%s) == 0\n", strings.Join(names, " | "))
+		fmt.Fprintf(w, "}\n")
+		return
+	}
+	fmt.Fprintf(w, "// IsValid reports whether i is a value of the Kaitai enum %s.\n", e.ID)
+	fmt.Fprintf(w, "func (i %s) IsValid() bool {\n", typeName)
+	fmt.Fprintf(w, "\tswitch i {\n")
+	fmt.Fprintf(w, "\tcase %s:\n", strings.Join(names, ", "))
+	fmt.Fprintf(w, "\t\treturn true\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn false\n")
+	fmt.Fprintf(w, "}\n")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// errorf records a problem encountered during generation at the given source
+// position, which may be token.NoPos. Generation continues past recorded
+// problems, which are reported by reportErrors once generation is complete.
+func (g *Generator) errorf(pos token.Pos, format string, args ...interface{}) {
+	g.errorAt(g.posString(pos), format, args...)
+}
+
+// errorAt records a problem encountered during generation at the given source
+// position of the IR, which may be empty.
+func (g *Generator) errorAt(pos string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if len(pos) > 0 {
+		msg = fmt.Sprintf("%s: %s", pos, msg)
+	}
+	g.errs = append(g.errs, msg)
+}
+
+// reportErrors outputs the problems recorded during generation, and reports
+// whether any were found.
+func (g *Generator) reportErrors() bool {
+	if len(g.errs) == 0 {
+		return false
+	}
+	for _, msg := range g.errs {
+		logf(levelError, "problem", nil, "error: %s", msg)
+	}
+	logf(levelError, "problems", logAttrs{"count": len(g.errs)}, "%d problems found; output may be incomplete", len(g.errs))
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// execPrefix is the prefix of -format values selecting an external backend,
+// followed by the command line of the backend; e.g. -format exec:./mybackend.
+//
+// External backends add output formats without modifying type2kaitai. The
+// backend is run once per generated output, with the IR of the selected types
+// as JSON (as written by -emit-ir; see package ir) on standard input, and
+// writes the generated output to standard output. Diagnostics written to
+// standard error are displayed as is, and a non-zero exit status aborts
+// generation. The type2kaitai command line, for use in "Code generated"
+// headers, is passed in the TYPE2KAITAI_CMDLINE environment variable, and the
+// lines of the header of generated files (see headerLines) in the
+// TYPE2KAITAI_HEADER environment variable.
+const execPrefix = "exec:"
+
+// execArgs returns the command line of the external backend of the given
+// -format value.
+func execArgs(format string) []string {
+	args := strings.Fields(strings.TrimPrefix(format, execPrefix))
+	if len(args) == 0 {
+		usagef("missing command of output format %q; expected %s<command>", format, execPrefix)
+	}
+	return args
+}
+
+// execExt returns the output file extension of the external backend of the
+// given -format value; i.e. the name of the backend command.
+func execExt(format string) string {
+	name := filepath.Base(execArgs(format)[0])
+	return "." + strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// generateExec outputs the output of the external backend selected by -format,
+// run on the IR of the selected types.
+func (g *Generator) generateExec() {
+	in := &bytes.Buffer{}
+	if err := g.mod.Encode(in); err != nil {
+		fatalf("unable to encode IR; %v", err)
+	}
+	args := execArgs(*outputFormat)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = in
+	cmd.Stdout = &g.buf
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "TYPE2KAITAI_CMDLINE="+cmdline(), "TYPE2KAITAI_HEADER="+strings.Join(g.headerLines(), "\n"))
+	if err := cmd.Run(); err != nil {
+		fatalf("backend %s failed; %v", args[0], err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// generateFlatBuffers outputs a FlatBuffers schema of the selected types and
+// their dependencies. Struct types are emitted as tables, or as FlatBuffers
+// structs if -fbs-structs is set and all fields of the type are fixed-size.
+func (g *Generator) generateFlatBuffers() {
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	g.Printf("namespace %s;\n", g.mod.Package)
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.fbsEnum(e)
+	}
+	// Output dependencies before the types referring to them.
+	structs := g.mod.Structs
+	for i := len(structs) - 1; i >= 0; i-- {
+		g.Printf("\n")
+		g.fbsType(structs[i])
+	}
+	if root := g.mod.Roots[0]; !g.fbsIsStruct(root) {
+		g.Printf("\n")
+		g.Printf("root_type %s;\n", root.Name)
+	}
+}
+
+// fbsType outputs the table or struct definition of the given struct type.
+func (g *Generator) fbsType(s *ir.StructDef) {
+	isStruct := g.fbsStructs && g.fieldsFixedSize(s.Fields)
+	kind := "table"
+	if isStruct {
+		kind = "struct"
+	}
+	g.Printf("%s %s {\n", kind, s.Name)
+	for _, f := range s.Fields {
+		typ, err := g.fbsFieldType(f.Type, isStruct)
+		if err != nil {
+			g.Printf("  // TODO: add field %s; %v\n", f.Name, err)
+			continue
+		}
+		g.Printf("  %s:%s;\n", f.ID, typ)
+	}
+	g.Printf("}\n")
+}
+
+// fbsEnum outputs the enum definition of the given enum type.
+func (g *Generator) fbsEnum(e *ir.EnumDef) {
+	underlying, _ := g.fbsFieldType(e.Underlying, false)
+	g.Printf("enum %s : %s {\n", e.Name, underlying)
+	for _, v := range e.Values {
+		g.Printf("  %s = %s,\n", v.Name, v.Value)
+	}
+	g.Printf("}\n")
+}
+
+// fbsIsStruct reports whether the given named struct type is emitted as a
+// FlatBuffers struct rather than as a table.
+func (g *Generator) fbsIsStruct(t *ir.Type) bool {
+	return g.fbsStructs && g.isFixedSize(t)
+}
+
+// isFixedSize reports whether the given type has a fixed size; i.e. whether it
+// is composed only of numeric types, enums, arrays and structs thereof.
+func (g *Generator) isFixedSize(t *ir.Type) bool {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float:
+		return true
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct {
+			s := g.mod.Struct(t)
+			return s != nil && g.fieldsFixedSize(s.Fields)
+		}
+		return g.isFixedSize(t.Underlying)
+	case ir.Array:
+		return g.isFixedSize(t.Elem)
+	case ir.Struct:
+		return g.fieldsFixedSize(t.Fields)
+	default:
+		return false
+	}
+}
+
+// fieldsFixedSize reports whether all of the given fields have a fixed size.
+func (g *Generator) fieldsFixedSize(fields []*ir.Field) bool {
+	for _, f := range fields {
+		if !g.isFixedSize(f.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// fbsFieldType returns the FlatBuffers field type corresponding to the given
+// type. inStruct specifies whether the field is part of a FlatBuffers struct,
+// in which case arrays are emitted as fixed-length arrays rather than vectors.
+func (g *Generator) fbsFieldType(t *ir.Type, inStruct bool) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return g.fbsBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct {
+			if inStruct && !g.fbsIsStruct(t) {
+				return "", fmt.Errorf("table %s not allowed in struct", t.Name)
+			}
+			return t.Name, nil
+		}
+		if t.IsEnum() {
+			return t.Name, nil
+		}
+		return g.fbsFieldType(t.Underlying, inStruct)
+	case ir.Array:
+		elem, err := g.fbsFieldType(t.Elem, inStruct)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(elem, "[") {
+			return "", fmt.Errorf("nested vector of element type %s not supported", t.Elem.Go)
+		}
+		if inStruct {
+			return fmt.Sprintf("[%s:%d]", elem, t.Len), nil
+		}
+		return "[" + elem + "]", nil
+	case ir.Slice:
+		if inStruct {
+			return "", fmt.Errorf("vector not allowed in struct")
+		}
+		elem, err := g.fbsFieldType(t.Elem, false)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(elem, "[") {
+			return "", fmt.Errorf("nested vector of element type %s not supported", t.Elem.Go)
+		}
+		return "[" + elem + "]", nil
+	case ir.Pointer:
+		if t.Elem == nil {
+			// unsafe.Pointer, stored as an address of the target architecture.
+			return g.fbsBasicType(addrType(t))
+		}
+		if inStruct {
+			return "", fmt.Errorf("reference not allowed in struct")
+		}
+		return g.fbsFieldType(t.Elem, false)
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// fbsIntTypes maps from sizes in bytes to FlatBuffers signed integer types.
+var fbsIntTypes = map[int64]string{1: "byte", 2: "short", 4: "int", 8: "long"}
+
+// fbsBasicType returns the FlatBuffers scalar type corresponding to the given
+// basic type.
+func (g *Generator) fbsBasicType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return "bool", nil
+	case ir.Int:
+		return fbsIntTypes[t.Size], nil
+	case ir.Uint:
+		return "u" + fbsIntTypes[t.Size], nil
+	case ir.Float:
+		if t.Size == 4 {
+			return "float", nil
+		}
+		return "double", nil
+	case ir.String:
+		return "string", nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
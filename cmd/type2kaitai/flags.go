@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+	"go/types"
+	"math/bits"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// isFlagEnum reports whether the given enum type is a flag-style enum; i.e.
+// whether it has at least two single-bit constants, the bits of every other
+// constant are covered by the single-bit constants, and the values are not
+// simply sequential (e.g. 0, 1, 2).
+func isFlagEnum(t *types.Named) bool {
+	return isEnum(t) && isFlagConsts(enumValues(t))
+}
+
+// isFlagConsts reports whether the given enum constants are flag-style; see
+// isFlagEnum.
+func isFlagConsts(consts []*types.Const) bool {
+	var mask uint64
+	values := make(map[uint64]bool)
+	for _, c := range consts {
+		x, ok := constant.Uint64Val(c.Val())
+		if !ok {
+			return false
+		}
+		if bits.OnesCount64(x) == 1 {
+			mask |= x
+		}
+		values[x] = true
+	}
+	if bits.OnesCount64(mask) < 2 {
+		return false
+	}
+	for x := range values {
+		if x&^mask != 0 {
+			return false
+		}
+	}
+	return !isSequential(values)
+}
+
+// isSequential reports whether the given set of values is 0, 1, ..., n-1 or
+// 1, 2, ..., n.
+func isSequential(values map[uint64]bool) bool {
+	n := uint64(len(values))
+	first := uint64(0)
+	if !values[0] {
+		first = 1
+	}
+	for x := first; x < first+n; x++ {
+		if !values[x] {
+			return false
+		}
+	}
+	return true
+}
+
+// flagTypeName returns the name of the bit field sub-type of the given
+// flag-style enum type.
+func (g *Generator) flagTypeName(e *ir.EnumDef) string {
+	return e.ID + "_flags"
+}
+
+// addFlagType registers the given flag-style enum type to be output as a bit
+// field sub-type.
+func (g *Generator) addFlagType(e *ir.EnumDef) {
+	for _, prev := range g.flagTypes {
+		if prev == e {
+			return
+		}
+	}
+	g.flagTypes = append(g.flagTypes, e)
+}
+
+// generateFlagTypes adds the bit field sub-types of the flag-style enum types
+// referenced by the generated types to the given mapping of Kaitai types, with
+// one b1 field per flag bit, named after the constant of the flag. Bits not
+// covered by any flag are output as unused bit fields.
+//
+// The bit endianness of each sub-type is given by the bit-endian directive of
+// the enum type, or -bit-endian, and follows the byte order of the binary
+// format otherwise, so that bit fields are read in the order of the bits of the
+// underlying integer; from the least significant bit for little-endian and
+// from the most significant bit for big-endian. Bytes are read in the byte
+// order of the binary format, and the bits of each byte in the bit order of
+// the sub-type; e.g. the bits of a little-endian uint16 with bit-endian be are
+// read in the order 7..0, 15..8.
+func (g *Generator) generateFlagTypes(kaiTypes *yaml.Node) {
+	for _, e := range g.flagTypes {
+		names := make(map[int]string)
+		for _, v := range e.Values {
+			x, _ := v.Uint64()
+			if bits.OnesCount64(x) != 1 {
+				continue
+			}
+			bit := bits.TrailingZeros64(x)
+			if _, ok := names[bit]; !ok {
+				names[bit] = v.ID
+			}
+		}
+		width := int(8 * e.Underlying.Size)
+		bitEndian := g.endian
+		switch {
+		case len(e.BitEndian) > 0:
+			bitEndian = e.BitEndian
+		case len(g.bitEndian) > 0:
+			bitEndian = g.bitEndian
+		}
+		meta := ksyMap()
+		ksyAdd(meta, "bit-endian", ksyValue(bitEndian, ""))
+		seq := ksySeq()
+		var order []int
+		for i := 0; i < width; i++ {
+			// Byte of the bit in stream order, and bit within the byte.
+			byteIndex, bit := i/8, i%8
+			if g.endian == "be" {
+				byteIndex = width/8 - 1 - byteIndex
+			}
+			if bitEndian == "be" {
+				bit = 7 - bit
+			}
+			order = append(order, 8*byteIndex+bit)
+		}
+		for i := 0; i < len(order); {
+			bit := order[i]
+			if name, ok := names[bit]; ok {
+				attr := ksyMap()
+				ksyAdd(attr, "id", ksyValue(name, ""))
+				ksyAdd(attr, "type", ksyValue("b1", fmt.Sprintf("bit %d", bit)))
+				seq.Content = append(seq.Content, attr)
+				i++
+				continue
+			}
+			// Group consecutive unused bits.
+			n := 0
+			for i+n < len(order) {
+				if _, ok := names[order[i+n]]; ok {
+					break
+				}
+				n++
+			}
+			attr := ksyMap()
+			ksyAdd(attr, "id", ksyValue(fmt.Sprintf("unused_bit_%d", bit), ""))
+			ksyAdd(attr, "type", ksyValue(fmt.Sprintf("b%d", n), ""))
+			seq.Content = append(seq.Content, attr)
+			i += n
+		}
+		spec := ksyMap()
+		ksyAdd(spec, "meta", meta)
+		ksyAdd(spec, "seq", seq)
+		ksyAdd(kaiTypes, g.flagTypeName(e), spec)
+	}
+}
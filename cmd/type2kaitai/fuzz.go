@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// writeFuzzTests writes a Go test file of native fuzz targets next to the
+// generated Go output of the given file name (-gen-fuzz); e.g.
+// header_type_fuzz_test.go of header_type.go. For each of the given types, the
+// FuzzParseT target parses arbitrary input using the generated ParseT
+// function, and checks that values parsed successfully are encoded stably by
+// the generated WriteT function; i.e. that parsing and writing the written
+// bytes reproduces them. The corpus is seeded with the sample binary file of
+// the type in dir (see writeSamples).
+func (g *Generator) writeFuzzTests(dir, outputName string, typeNames []string) {
+	buf := &bytes.Buffer{}
+	local := g.localStructs()
+	for _, typeName := range typeNames {
+		if !local[typeName] {
+			// Parse functions are generated for the types of the package.
+			continue
+		}
+		sampleName, err := samplePath(dir, outputName, typeName)
+		if err != nil {
+			g.errorf(token.NoPos, "unable to generate fuzz target of type %s; %v", typeName, err)
+			g.failed = true
+			continue
+		}
+		fuzzFunc(buf, typeName, sampleName)
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	testName := strings.TrimSuffix(outputName, filepath.Ext(outputName)) + "_fuzz_test.go"
+	// Native fuzzing requires Go 1.18.
+	g.writeTestFile(testName, "//go:build go1.18\n// +build go1.18", buf.Bytes())
+}
+
+// fuzzFunc outputs the FuzzParseT function of the given type, seeded with the
+// given sample binary file; the fuzz target fails if the sample is missing.
+func fuzzFunc(buf *bytes.Buffer, name, sampleName string) {
+	fmt.Fprintf(buf, "\n")
+	fmt.Fprintf(buf, "func FuzzParse%s(f *testing.F) {\n", name)
+	fmt.Fprintf(buf, "seed, err := ioutil.ReadFile(%q)\n", sampleName)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "f.Fatalf(\"unable to read seed corpus; %%v\", err)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "f.Add(seed)\n")
+	fmt.Fprintf(buf, "f.Fuzz(func(t *testing.T, data []byte) {\n")
+	fmt.Fprintf(buf, "v, err := Parse%s(bytes.NewReader(data))\n", name)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "return\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "out := &bytes.Buffer{}\n")
+	fmt.Fprintf(buf, "if err := Write%s(out, v); err != nil {\n", name)
+	fmt.Fprintf(buf, "t.Fatalf(\"unable to write parsed value; %%v\", err)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "v2, err := Parse%s(bytes.NewReader(out.Bytes()))\n", name)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "t.Fatalf(\"unable to parse written value; %%v\", err)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "out2 := &bytes.Buffer{}\n")
+	fmt.Fprintf(buf, "if err := Write%s(out2, v2); err != nil {\n", name)
+	fmt.Fprintf(buf, "t.Fatalf(\"unable to write reparsed value; %%v\", err)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "if !bytes.Equal(out2.Bytes(), out.Bytes()) {\n")
+	fmt.Fprintf(buf, "t.Errorf(\"unstable encoding; got %%x, want %%x\", out2.Bytes(), out.Bytes())\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "})\n")
+	fmt.Fprintf(buf, "}\n")
+}
@@ -0,0 +1,886 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// generateGo outputs Go code for parsing and writing the selected types and
+// their dependencies, following the layout of their Kaitai specs. For each
+// struct type T, a ParseT function decoding a T value from an io.Reader and a
+// WriteT function encoding a T value to an io.Writer are generated. No
+// reflection is used. Unnamed struct types of fields are decoded and encoded by
+// parse and write methods of their own; e.g. parseHeader_Info of Header.Info.
+// Sizes and counts read from the input are checked before use, so that corrupt
+// input is reported as an error by ParseT.
+//
+// Fields not supported by the Go runtime (e.g. fields tagged with the process,
+// if or switch-on options, or repeated until a condition) are reported as
+// errors, and fail generation. Fields of non-serializable types are handled by
+// the -on-unsupported policy, as in Kaitai specs.
+func (g *Generator) generateGo() {
+	w := &goWriter{g: g, imports: make(map[string]bool), anon: make(map[*ir.Type]*ir.StructDef), anonTypes: make(map[*ir.StructDef]string)}
+	var structs []*ir.StructDef
+	for _, s := range g.mod.Structs {
+		if !w.isLocal(s.Package) {
+			logf(levelInfo, "skipped_type", logAttrs{"package": s.Package, "name": s.Name}, "skipping type %s.%s; defined in other package", s.Package, s.Name)
+			continue
+		}
+		structs = append(structs, s)
+		structs = append(structs, w.anonStructs(s)...)
+	}
+	for _, s := range structs {
+		w.parseFunc(s)
+		w.writeFunc(s)
+		w.checksumFuncs(s)
+	}
+	w.imports["bytes"] = true
+	w.imports["encoding/binary"] = true
+	w.imports["fmt"] = true
+	w.imports["io"] = true
+
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	g.Printf("package %s\n", g.mod.Package)
+	g.Printf("\n")
+	var imports []string
+	for path := range w.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	g.Printf("import (\n")
+	for _, path := range imports {
+		g.Printf("\t%q\n", path)
+	}
+	g.Printf(")\n")
+	g.buf.Write(w.buf.Bytes())
+	g.Printf("%s", goSupport)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		warnf("internal_error", nil, "internal error: invalid Go generated: %s", err)
+		warnf("internal_error", nil, "compile the package to analyze the error")
+		return
+	}
+	g.buf.Reset()
+	g.buf.Write(src)
+}
+
+// goWriter outputs the parse and write functions of Go types.
+type goWriter struct {
+	g       *Generator
+	buf     bytes.Buffer    // Accumulated output.
+	imports map[string]bool // Import paths used by the generated code.
+	// anon maps from the unnamed struct types of fields to their synthetic
+	// struct types; see anonStructs.
+	anon map[*ir.Type]*ir.StructDef
+	// anonTypes maps from synthetic struct types to the Go type expressions of
+	// their unnamed struct types.
+	anonTypes map[*ir.StructDef]string
+}
+
+func (w *goWriter) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&w.buf, format, args...)
+}
+
+// isLocal reports whether the package of the given import path is the package
+// being generated.
+func (w *goWriter) isLocal(pkg string) bool {
+	return pkg == w.g.mod.Path
+}
+
+// anonStructs returns the synthetic struct types of the unnamed struct types of
+// the fields of the given struct type, and of their fields in turn, looking
+// through arrays, slices and pointers (see analyzer.anonStruct). Synthetic
+// struct types are named after the field; e.g. Header_Info of Header.Info.
+func (w *goWriter) anonStructs(s *ir.StructDef) []*ir.StructDef {
+	var anons []*ir.StructDef
+	for _, f := range s.Fields {
+		t := f.Type
+		for (t.Kind == ir.Array || t.Kind == ir.Slice || t.Kind == ir.Pointer) && t.Elem != nil {
+			t = t.Elem
+		}
+		if t.Kind != ir.Struct || len(t.ID) == 0 {
+			continue
+		}
+		anon := &ir.StructDef{
+			Name:    s.Name + "_" + f.Name,
+			Package: s.Package,
+			ID:      t.ID,
+			Pos:     f.Pos,
+			Fields:  t.Fields,
+		}
+		w.anon[t] = anon
+		w.anonTypes[anon] = t.Go
+		anons = append(anons, anon)
+		anons = append(anons, w.anonStructs(anon)...)
+	}
+	return anons
+}
+
+// structName returns the name of the struct type of the parse and write
+// methods of the given named struct type of the package or unnamed struct type
+// of a field, and a boolean indicating whether t is such a type.
+func (w *goWriter) structName(t *ir.Type) (string, bool) {
+	if t.Kind == ir.Named && w.isLocal(t.Package) && t.Underlying.Kind == ir.Struct {
+		return t.Name, true
+	}
+	if anon, ok := w.anon[t]; ok {
+		return anon.Name, true
+	}
+	return "", false
+}
+
+// goType returns the Go type expression of the given struct type; the struct
+// type literal of synthetic struct types.
+func (w *goWriter) goType(s *ir.StructDef) string {
+	if t, ok := w.anonTypes[s]; ok {
+		return t
+	}
+	return s.Name
+}
+
+// unsupported reports that the given field of the struct type cannot be
+// decoded or encoded by the Go runtime, which fails generation.
+func (w *goWriter) unsupported(s *ir.StructDef, f *ir.Field, format string, args ...interface{}) {
+	w.g.errorAt(f.Pos, "field %s.%s: unable to generate Go code; %s", s.Name, f.Name, fmt.Sprintf(format, args...))
+	w.g.failed = true
+}
+
+// order returns the byte order expression of the given struct field; the byte
+// order of the endian option of the field, or the byte order of the struct
+// value (the order parameter of the parse and write methods), which is the
+// byte order of the binary format unless given by the endian option of the
+// field of the struct value in turn.
+func (w *goWriter) order(f *ir.Field) string {
+	switch f.Endian {
+	case "be":
+		return "binary.BigEndian"
+	case "le":
+		return "binary.LittleEndian"
+	}
+	return "order"
+}
+
+// formatOrder returns the byte order expression of the binary format.
+func (w *goWriter) formatOrder() string {
+	if w.g.endian == "be" {
+		return "binary.BigEndian"
+	}
+	return "binary.LittleEndian"
+}
+
+// parseFunc outputs the ParseT function and parseT decoder method of the given
+// struct type; only the decoder method of synthetic struct types.
+func (w *goWriter) parseFunc(s *ir.StructDef) {
+	name := s.Name
+	if _, ok := w.anonTypes[s]; !ok {
+		w.Printf("\n")
+		w.Printf("// Parse%s parses a %s from r.\n", name, name)
+		w.Printf("func Parse%s(r io.Reader) (%s, error) {\n", name, name)
+		w.Printf("d := &kaitaiDecoder{r: r}\n")
+		w.Printf("v := d.parse%s(%s)\n", name, w.formatOrder())
+		w.Printf("return v, d.err\n")
+		w.Printf("}\n")
+	}
+	w.Printf("\n")
+	w.Printf("func (d *kaitaiDecoder) parse%s(order binary.ByteOrder) (v %s) {\n", name, w.goType(s))
+	for _, f := range s.Fields {
+		if w.isUnsupported(f) {
+			if comment := w.g.unsupportedField(s, f); len(comment) > 0 {
+				w.Printf("// skipped field %s; unsupported type %s\n", f.Name, f.Type.Go)
+			}
+			continue
+		}
+		if len(f.TagErr) > 0 {
+			// Reported during analysis.
+			w.g.failed = true
+			continue
+		}
+		if err := checkGoField(f); err != nil {
+			w.unsupported(s, f, "%v", err)
+			continue
+		}
+		lhs := "v." + f.Name
+		if f.Name == "_" {
+			lhs = "_"
+		}
+		if len(f.Size) > 0 {
+			n, err := w.lengthExpr(s, f, f.Size)
+			if err == nil {
+				err = w.decodeSubstream(lhs, f.Type, n, w.order(f))
+			}
+			if err != nil {
+				w.unsupported(s, f, "%v", err)
+			}
+			continue
+		}
+		if len(f.Repeat) > 0 {
+			if err := w.decodeRepeat(lhs, s, f, w.order(f)); err != nil {
+				w.unsupported(s, f, "%v", err)
+			}
+			continue
+		}
+		if f.Terminator != nil && len(f.Str) == 0 {
+			if err := w.decodeTerminated(lhs, f); err != nil {
+				w.unsupported(s, f, "%v", err)
+			}
+			continue
+		}
+		if f.BigInt > 0 {
+			w.decodeBigInt(lhs, f)
+			continue
+		}
+		if len(f.Varint) > 0 {
+			w.decodeVarint(lhs, f)
+			continue
+		}
+		if f.Contents != nil {
+			if arr := f.Type.Under(); arr.Kind != ir.Array || !arr.Elem.IsByte() || arr.Len != int64(len(f.Contents)) {
+				w.unsupported(s, f, "contents only valid for byte arrays of matching length")
+				continue
+			}
+			w.Printf("d.contents(%q, %s[:], %s)\n", name+"."+f.Name, lhs, goBytes(f.Contents))
+			continue
+		}
+		if err := w.decodeStmt(lhs, f.Type, w.order(f), 0); err != nil {
+			w.unsupported(s, f, "%v", err)
+		}
+	}
+	w.verifyChecksums(s)
+	w.Printf("return v\n")
+	w.Printf("}\n")
+}
+
+// writeFunc outputs the WriteT function and writeT encoder method of the given
+// struct type; only the encoder method of synthetic struct types.
+func (w *goWriter) writeFunc(s *ir.StructDef) {
+	name := s.Name
+	if _, ok := w.anonTypes[s]; !ok {
+		w.Printf("\n")
+		w.Printf("// Write%s writes v to w.\n", name)
+		w.Printf("func Write%s(w io.Writer, v %s) error {\n", name, name)
+		w.Printf("e := &kaitaiEncoder{w: w}\n")
+		w.Printf("e.write%s(%s, v)\n", name, w.formatOrder())
+		w.Printf("return e.err\n")
+		w.Printf("}\n")
+	}
+	w.Printf("\n")
+	w.Printf("func (e *kaitaiEncoder) write%s(order binary.ByteOrder, v %s) {\n", name, w.goType(s))
+	for _, f := range s.Fields {
+		w.encodeField(s, f, true)
+	}
+	w.Printf("}\n")
+}
+
+// encodeField outputs the statements encoding the given field of the struct
+// type, from the struct value v. If checksums is set, the checksum of fields
+// tagged with the checksum option is computed rather than written as stored.
+// Unsupported fields are reported by parseFunc.
+func (w *goWriter) encodeField(s *ir.StructDef, f *ir.Field, checksums bool) {
+	if w.isUnsupported(f) || len(f.TagErr) > 0 || checkGoField(f) != nil {
+		return
+	}
+	rhs := "v." + f.Name
+	if checksums && len(f.Checksum) > 0 {
+		rhs = fmt.Sprintf("%s(%s(v, order))", f.Type.Go, checksumFuncName(s, f))
+	}
+	if len(f.Size) > 0 {
+		if n, err := w.sizeExpr(s, f.Size); err == nil {
+			w.encodeSubstream(rhs, f.Type, n, w.order(f))
+		}
+		return
+	}
+	if len(f.Repeat) > 0 {
+		w.encodeRepeat(rhs, f.Type, w.order(f))
+		return
+	}
+	if f.Terminator != nil && len(f.Str) == 0 {
+		w.encodeTerminated(rhs, f)
+		return
+	}
+	if f.BigInt > 0 {
+		w.encodeBigInt(rhs, f)
+		return
+	}
+	if len(f.Varint) > 0 {
+		w.encodeVarint(rhs, f)
+		return
+	}
+	if f.Contents != nil {
+		w.Printf("e.write(%s)\n", goBytes(f.Contents))
+		return
+	}
+	if f.Name == "_" {
+		size, ok := w.g.packedSize(f.Type)
+		if !ok {
+			if checksums {
+				// Reported once, rather than by each checksum function.
+				w.unsupported(s, f, "size of blank field of type %s not known", f.Type.Go)
+			}
+			return
+		}
+		w.Printf("e.skip(%d)\n", size)
+		return
+	}
+	w.encodeStmt(rhs, f.Type, w.order(f), 0)
+}
+
+// isUnsupported reports whether the given field is of a non-serializable type,
+// and is thus handled by the -on-unsupported policy rather than decoded and
+// encoded.
+func (w *goWriter) isUnsupported(f *ir.Field) bool {
+	return f.Type.IsUnsupported() && len(f.SwitchOn) == 0
+}
+
+// checkGoField returns an error if the given field is tagged with options not
+// supported by the Go runtime; i.e. options evaluating Kaitai expressions (if
+// and switch-on), options requiring a seekable stream (offset-to) and the
+// process option.
+func checkGoField(f *ir.Field) error {
+	switch {
+	case len(f.OffsetField) > 0:
+		return fmt.Errorf("field located at offset v.%s not supported", f.OffsetField)
+	case len(f.If) > 0:
+		return fmt.Errorf("if=%s not supported", f.If)
+	case len(f.Process) > 0:
+		return fmt.Errorf("process=%s not supported", f.Process)
+	case len(f.SwitchOn) > 0:
+		return fmt.Errorf("switch-on=%s not supported", f.SwitchOn)
+	}
+	return nil
+}
+
+// sizeExpr returns the Go expression of the given size option; either the name
+// of a field of the given struct type or an integer literal. Parameters of
+// Kaitai types are not supported.
+func (w *goWriter) sizeExpr(s *ir.StructDef, size string) (string, error) {
+	if _, err := strconv.ParseUint(size, 0, 64); err == nil {
+		return size, nil
+	}
+	if s.Field(size) == nil {
+		return "", fmt.Errorf("%s is not a field", size)
+	}
+	return fmt.Sprintf("int(v.%s)", size), nil
+}
+
+// lengthExpr returns the Go expression decoding the length of the given sized
+// or repeated field of the struct type, given by the size or repeat-expr option
+// expr. Lengths stored in fields are read from untrusted input, and are checked
+// by the decoder to be neither negative nor above kaitaiMaxLen.
+func (w *goWriter) lengthExpr(s *ir.StructDef, f *ir.Field, expr string) (string, error) {
+	n, err := w.sizeExpr(s, expr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(expr, 0, 64); err == nil {
+		return n, nil
+	}
+	return fmt.Sprintf("d.length(%q, int64(v.%s))", s.Name+"."+f.Name, expr), nil
+}
+
+// decodeSubstream outputs the statements decoding a value of the given type
+// into lhs, from a substream of n bytes, using the given byte order.
+func (w *goWriter) decodeSubstream(lhs string, t *ir.Type, n, order string) error {
+	switch u := t.Under(); u.Kind {
+	case ir.Slice:
+		if u.Elem.IsByte() {
+			if t.Go == "[]byte" {
+				w.Printf("%s = d.next(%s)\n", lhs, n)
+			} else {
+				w.Printf("%s = %s(d.next(%s))\n", lhs, t.Go, n)
+			}
+			return nil
+		}
+	case ir.String:
+		w.Printf("%s = %s(d.next(%s))\n", lhs, t.Go, n)
+		return nil
+	case ir.Struct:
+		name, ok := w.structName(t)
+		if !ok {
+			return fmt.Errorf("support for type %s not yet implemented", t.Go)
+		}
+		w.Printf("{\n")
+		w.Printf("sub := d.substream(%s)\n", n)
+		w.Printf("%s = sub.parse%s(%s)\n", lhs, name, order)
+		w.Printf("if d.err == nil {\n")
+		w.Printf("d.err = sub.err\n")
+		w.Printf("}\n")
+		w.Printf("}\n")
+		return nil
+	}
+	return fmt.Errorf("size option only valid for byte slices, strings and struct types")
+}
+
+// encodeSubstream outputs the statements encoding the value rhs of the given
+// type, as a substream of n bytes, using the given byte order.
+func (w *goWriter) encodeSubstream(rhs string, t *ir.Type, n, order string) error {
+	switch u := t.Under(); u.Kind {
+	case ir.Slice:
+		if u.Elem.IsByte() {
+			w.Printf("e.substream(%s, func(e *kaitaiEncoder) { e.write(%s) })\n", n, rhs)
+			return nil
+		}
+	case ir.String:
+		w.Printf("e.substream(%s, func(e *kaitaiEncoder) { e.write([]byte(%s)) })\n", n, rhs)
+		return nil
+	case ir.Struct:
+		w.Printf("e.substream(%s, func(e *kaitaiEncoder) {\n", n)
+		if err := w.encodeStmt(rhs, t, order, 0); err != nil {
+			return err
+		}
+		w.Printf("})\n")
+		return nil
+	}
+	return fmt.Errorf("size option only valid for byte slices, strings and struct types")
+}
+
+// decodeRepeat outputs the statements decoding the slice field f of the given
+// struct type into lhs, based on the repeat option of the field. Only
+// repeat=expr is supported, as the expressions of repeat=until are Kaitai
+// expressions, and repeat=eos requires peeking at the stream. Elements are
+// appended as they are decoded, rather than preallocated, as the count is read
+// from the input.
+func (w *goWriter) decodeRepeat(lhs string, s *ir.StructDef, f *ir.Field, order string) error {
+	slice := f.Type.Under()
+	if slice.Kind != ir.Slice || f.Repeat != "expr" {
+		return fmt.Errorf("repeat=%s not supported", f.Repeat)
+	}
+	n, err := w.lengthExpr(s, f, f.RepeatExpr)
+	if err != nil {
+		return err
+	}
+	w.Printf("for n := %s; n > 0 && d.err == nil; n-- {\n", n)
+	w.Printf("var elem %s\n", slice.Elem.Go)
+	if err := w.decodeStmt("elem", slice.Elem, order, 1); err != nil {
+		return err
+	}
+	w.Printf("%s = append(%s, elem)\n", lhs, lhs)
+	w.Printf("}\n")
+	return nil
+}
+
+// encodeRepeat outputs the statements encoding the elements of the slice rhs
+// of the given type, tagged with the repeat option.
+func (w *goWriter) encodeRepeat(rhs string, t *ir.Type, order string) error {
+	slice := t.Under()
+	if slice.Kind != ir.Slice {
+		return fmt.Errorf("repeat option only valid for slices")
+	}
+	i := loopVars[0]
+	w.Printf("for %s := range %s {\n", i, rhs)
+	if err := w.encodeStmt(fmt.Sprintf("%s[%s]", rhs, i), slice.Elem, order, 1); err != nil {
+		return err
+	}
+	w.Printf("}\n")
+	return nil
+}
+
+// decodeTerminated outputs the statements decoding the byte slice or string
+// field f into lhs, terminated by the terminator byte of the field. The
+// terminator is included in the value with the include option.
+func (w *goWriter) decodeTerminated(lhs string, f *ir.Field) error {
+	if err := checkTerminated(f); err != nil {
+		return err
+	}
+	term := *f.Terminator
+	if f.Include {
+		w.Printf("%s = %s(append(d.until(0x%02x), 0x%02x))\n", lhs, f.Type.Go, term, term)
+		return nil
+	}
+	w.Printf("%s = %s(d.until(0x%02x))\n", lhs, f.Type.Go, term)
+	return nil
+}
+
+// encodeTerminated outputs the statements encoding the byte slice or string
+// field rhs, terminated by the terminator byte of the field f. With the
+// include option, the terminator is part of the value.
+func (w *goWriter) encodeTerminated(rhs string, f *ir.Field) error {
+	if err := checkTerminated(f); err != nil {
+		return err
+	}
+	w.Printf("e.write([]byte(%s))\n", rhs)
+	if !f.Include {
+		w.Printf("e.u1(0x%02x)\n", *f.Terminator)
+	}
+	return nil
+}
+
+// checkTerminated reports an error if the given terminated field is not
+// supported by the Go runtime; i.e. terminated fields other than byte slices
+// and strings, and the consume and eos-error options, which would require
+// peeking at the stream.
+func checkTerminated(f *ir.Field) error {
+	if !isByteSliceOrString(f.Type) {
+		return fmt.Errorf("terminator option only valid for byte slices and strings")
+	}
+	if f.NoConsume || f.NoEOSError {
+		return fmt.Errorf("consume and eos-error options not supported")
+	}
+	return nil
+}
+
+// isByteSliceOrString reports whether the given type is a byte slice or a
+// string.
+func isByteSliceOrString(t *ir.Type) bool {
+	switch u := t.Under(); u.Kind {
+	case ir.Slice:
+		return u.Elem.IsByte()
+	case ir.String:
+		return true
+	}
+	return false
+}
+
+// loopVars holds the index variable names of nested loops.
+var loopVars = []string{"i", "j", "k", "l", "m", "n"}
+
+// checkSubst returns an error if the given type, or the element type of arrays,
+// slices and pointers thereof, is substituted (see -substitute); values of the
+// substitute are not assignable to the Go type.
+func checkSubst(t *ir.Type) error {
+	for ; t != nil; t = t.Elem {
+		if len(t.Subst) > 0 {
+			return fmt.Errorf("substituted type %s not supported", t.Subst)
+		}
+	}
+	return nil
+}
+
+// decodeStmt outputs the statements decoding a value of the given type into
+// lhs.
+func (w *goWriter) decodeStmt(lhs string, t *ir.Type, order string, depth int) error {
+	if err := checkSubst(t); err != nil {
+		return err
+	}
+	switch u := t.Under(); u.Kind {
+	case ir.Array:
+		if u.Elem.IsByte() {
+			w.Printf("d.read(%s[:])\n", lhs)
+			return nil
+		}
+		if depth >= len(loopVars) {
+			return fmt.Errorf("array nesting too deep")
+		}
+		i := loopVars[depth]
+		w.Printf("for %s := range %s {\n", i, lhs)
+		if err := w.decodeStmt(fmt.Sprintf("%s[%s]", lhs, i), u.Elem, order, depth+1); err != nil {
+			return err
+		}
+		w.Printf("}\n")
+		return nil
+	case ir.Slice:
+		return fmt.Errorf("slice length not known")
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		w.Printf("d.skip(%d) // %s\n", u.Size, t.Go)
+		return nil
+	}
+	expr, err := w.decodeExpr(t, order)
+	if err != nil {
+		return err
+	}
+	w.Printf("%s = %s\n", lhs, expr)
+	return nil
+}
+
+// decodeExpr returns the expression decoding a value of the given scalar or
+// struct type, using the given byte order.
+func (w *goWriter) decodeExpr(t *ir.Type, order string) (string, error) {
+	if t.Kind == ir.Named && !w.isLocal(t.Package) {
+		return "", fmt.Errorf("type %s.%s defined in other package", t.Package, t.Name)
+	}
+	if name, ok := w.structName(t); ok {
+		return fmt.Sprintf("d.parse%s(%s)", name, order), nil
+	}
+	// expr is the decoding expression, of type typ.
+	var expr, typ string
+	switch u := t.Under(); u.Kind {
+	case ir.Bool:
+		expr, typ = "d.u1() != 0", "bool"
+	case ir.Int, ir.Uint:
+		expr, typ = fmt.Sprintf("d.u%d(%s)", u.Size, order), fmt.Sprintf("uint%d", 8*u.Size)
+		if u.Size == 1 {
+			expr = "d.u1()"
+		}
+		if u.Kind == ir.Int {
+			typ = fmt.Sprintf("int%d", 8*u.Size)
+			expr = fmt.Sprintf("%s(%s)", typ, expr)
+		}
+	case ir.Float:
+		w.imports["math"] = true
+		expr, typ = fmt.Sprintf("math.Float%dfrombits(d.u%d(%s))", 8*u.Size, u.Size, order), fmt.Sprintf("float%d", 8*u.Size)
+	case ir.Complex:
+		w.imports["math"] = true
+		part := fmt.Sprintf("math.Float%dfrombits(d.u%d(%s))", 4*u.Size, u.Size/2, order)
+		expr, typ = fmt.Sprintf("complex(%s, %s)", part, part), fmt.Sprintf("complex%d", 8*u.Size)
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+	if t.Go == typ {
+		return expr, nil
+	}
+	return fmt.Sprintf("%s(%s)", t.Go, expr), nil
+}
+
+// encodeStmt outputs the statements encoding the value rhs of the given type.
+func (w *goWriter) encodeStmt(rhs string, t *ir.Type, order string, depth int) error {
+	if err := checkSubst(t); err != nil {
+		return err
+	}
+	if t.Kind == ir.Named && !w.isLocal(t.Package) {
+		return fmt.Errorf("type %s.%s defined in other package", t.Package, t.Name)
+	}
+	if name, ok := w.structName(t); ok {
+		w.Printf("e.write%s(%s, %s)\n", name, order, rhs)
+		return nil
+	}
+	switch u := t.Under(); u.Kind {
+	case ir.Array:
+		if u.Elem.IsByte() {
+			w.Printf("e.write(%s[:])\n", rhs)
+			return nil
+		}
+		if depth >= len(loopVars) {
+			return fmt.Errorf("array nesting too deep")
+		}
+		i := loopVars[depth]
+		w.Printf("for %s := range %s {\n", i, rhs)
+		if err := w.encodeStmt(fmt.Sprintf("%s[%s]", rhs, i), u.Elem, order, depth+1); err != nil {
+			return err
+		}
+		w.Printf("}\n")
+		return nil
+	case ir.Slice:
+		return fmt.Errorf("slice length not known")
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		w.Printf("e.skip(%d) // %s\n", u.Size, t.Go)
+		return nil
+	case ir.Bool:
+		w.Printf("e.bool(%s)\n", w.conv(t, "bool", rhs))
+	case ir.Int, ir.Uint:
+		x := w.conv(t, fmt.Sprintf("uint%d", 8*u.Size), rhs)
+		if u.Size == 1 {
+			w.Printf("e.u1(%s)\n", x)
+		} else {
+			w.Printf("e.u%d(%s, %s)\n", u.Size, order, x)
+		}
+	case ir.Float:
+		w.imports["math"] = true
+		w.Printf("e.u%d(%s, math.Float%dbits(%s))\n", u.Size, order, 8*u.Size, w.conv(t, fmt.Sprintf("float%d", 8*u.Size), rhs))
+	case ir.Complex:
+		w.imports["math"] = true
+		w.Printf("e.u%d(%s, math.Float%dbits(real(%s)))\n", u.Size/2, order, 4*u.Size, rhs)
+		w.Printf("e.u%d(%s, math.Float%dbits(imag(%s)))\n", u.Size/2, order, 4*u.Size, rhs)
+	default:
+		return fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+	return nil
+}
+
+// conv returns the conversion of the expression x of type t to the named
+// type, omitting the conversion if redundant.
+func (w *goWriter) conv(t *ir.Type, typ, x string) string {
+	if t.Go == typ {
+		return x
+	}
+	return fmt.Sprintf("%s(%s)", typ, x)
+}
+
+// goBytes returns the Go byte slice literal of the given bytes.
+func goBytes(buf []byte) string {
+	var elems []string
+	for _, b := range buf {
+		elems = append(elems, fmt.Sprintf("0x%02X", b))
+	}
+	return "[]byte{" + strings.Join(elems, ", ") + "}"
+}
+
+// goSupport is the decoder and encoder support code of the generated Go code.
+const goSupport = `
+// kaitaiDecoder decodes binary data from an io.Reader. The first error
+// encountered is recorded, after which subsequent reads are no-ops.
+type kaitaiDecoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *kaitaiDecoder) read(buf []byte) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = io.ReadFull(d.r, buf)
+}
+
+func (d *kaitaiDecoder) skip(n int) {
+	d.next(n)
+}
+
+// kaitaiMaxLen is the maximum length of sized and repeated values, guarding
+// against lengths of corrupt or malicious input.
+const kaitaiMaxLen = 1 << 30
+
+// length returns the length n of a sized or repeated value read from the input,
+// recording an error if n is negative or above kaitaiMaxLen.
+func (d *kaitaiDecoder) length(name string, n int64) int {
+	if d.err != nil {
+		return 0
+	}
+	if n < 0 || n > kaitaiMaxLen {
+		d.err = fmt.Errorf("invalid length %d of %s", n, name)
+		return 0
+	}
+	return int(n)
+}
+
+// next returns the next n bytes. The buffer grows as bytes are read, rather
+// than being allocated up front.
+func (d *kaitaiDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 {
+		d.err = fmt.Errorf("invalid length %d", n)
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if _, err := io.CopyN(buf, d.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+	return buf.Bytes()
+}
+
+// until returns the bytes preceding the next occurrence of the terminator
+// byte, consuming the terminator.
+func (d *kaitaiDecoder) until(term byte) []byte {
+	var buf []byte
+	for d.err == nil {
+		b := d.u1()
+		if d.err != nil || b == term {
+			break
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+// substream returns a decoder of the next n bytes.
+func (d *kaitaiDecoder) substream(n int) *kaitaiDecoder {
+	buf := d.next(n)
+	return &kaitaiDecoder{r: bytes.NewReader(buf), err: d.err}
+}
+
+func (d *kaitaiDecoder) contents(name string, buf, want []byte) {
+	d.read(buf)
+	if d.err == nil && !bytes.Equal(buf, want) {
+		d.err = fmt.Errorf("invalid contents of %s; expected %X, got %X", name, want, buf)
+	}
+}
+
+func (d *kaitaiDecoder) u1() uint8 {
+	var buf [1]byte
+	d.read(buf[:])
+	return buf[0]
+}
+
+func (d *kaitaiDecoder) u2(order binary.ByteOrder) uint16 {
+	var buf [2]byte
+	d.read(buf[:])
+	return order.Uint16(buf[:])
+}
+
+func (d *kaitaiDecoder) u4(order binary.ByteOrder) uint32 {
+	var buf [4]byte
+	d.read(buf[:])
+	return order.Uint32(buf[:])
+}
+
+func (d *kaitaiDecoder) u8(order binary.ByteOrder) uint64 {
+	var buf [8]byte
+	d.read(buf[:])
+	return order.Uint64(buf[:])
+}
+
+// kaitaiEncoder encodes binary data to an io.Writer. The first error
+// encountered is recorded, after which subsequent writes are no-ops.
+type kaitaiEncoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *kaitaiEncoder) write(buf []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(buf)
+}
+
+func (e *kaitaiEncoder) skip(n int) {
+	e.write(make([]byte, n))
+}
+
+// substream writes the output of encode as a substream of n bytes, padded with
+// zero bytes.
+func (e *kaitaiEncoder) substream(n int, encode func(e *kaitaiEncoder)) {
+	if e.err != nil {
+		return
+	}
+	if n < 0 || n > kaitaiMaxLen {
+		e.err = fmt.Errorf("invalid substream size %d", n)
+		return
+	}
+	buf := &bytes.Buffer{}
+	sub := &kaitaiEncoder{w: buf, err: e.err}
+	encode(sub)
+	if sub.err != nil {
+		e.err = sub.err
+		return
+	}
+	if buf.Len() > n {
+		e.err = fmt.Errorf("substream of %d bytes exceeds size %d", buf.Len(), n)
+		return
+	}
+	e.write(buf.Bytes())
+	e.skip(n - buf.Len())
+}
+
+func (e *kaitaiEncoder) bool(x bool) {
+	if x {
+		e.u1(1)
+	} else {
+		e.u1(0)
+	}
+}
+
+func (e *kaitaiEncoder) u1(x uint8) {
+	e.write([]byte{x})
+}
+
+func (e *kaitaiEncoder) u2(order binary.ByteOrder, x uint16) {
+	var buf [2]byte
+	order.PutUint16(buf[:], x)
+	e.write(buf[:])
+}
+
+func (e *kaitaiEncoder) u4(order binary.ByteOrder, x uint32) {
+	var buf [4]byte
+	order.PutUint32(buf[:], x)
+	e.write(buf[:])
+}
+
+func (e *kaitaiEncoder) u8(order binary.ByteOrder, x uint64) {
+	var buf [8]byte
+	order.PutUint64(buf[:], x)
+	e.write(buf[:])
+}
+`
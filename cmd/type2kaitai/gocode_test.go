@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mewrev/tools/internal/load"
+)
+
+// TestGoRoundTrip checks that the Go code generated for the struct types of the
+// testdata package writes and parses values losslessly, and reports corrupt
+// input as errors. The generated code is compiled and run by the tests of the
+// testdata package (roundtrip_test.go), in a module of its own.
+func TestGoRoundTrip(t *testing.T) {
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	pkgs := loadPackages([]string{"./testdata/roundtrip"}, &load.Config{Arch: "amd64"})
+	g := newGenerator(nil, nil)
+	g.addPackage(pkgs[0])
+	if defined, _ := g.analyzePackage([]string{"*"}); len(defined) == 0 {
+		t.Fatalf("no types of package %s analyzed", pkgs[0].PkgPath)
+	}
+	g.generateFormat("go")
+	if g.failed {
+		t.Fatalf("unable to generate Go code; got\n%s", g.buf.String())
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module roundtrip\n\ngo 1.22\n",
+		"gen.go": g.buf.String(),
+	}
+	for _, name := range []string{"types.go", "roundtrip_test.go"} {
+		buf, err := ioutil.ReadFile(filepath.Join("testdata", "roundtrip", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(buf)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gocmd, "test", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off", "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("tests of generated Go code failed; %v\n%s\ngenerated code:\n%s", err, out, files["gen.go"])
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lookupType returns the named type with the given type name, as defined at
+// the top-level of the package, or nil if the type is not defined.
+func (g *Generator) lookupType(typeName string) *types.Named {
+	for ident, def := range g.pkg.defs {
+		if ident.Name != typeName {
+			continue
+		}
+		if named, ok := def.Type().(*types.Named); ok {
+			return named
+		}
+		g.errorf(ident.Pos(), "type %s is not a type definition", typeName)
+		return nil
+	}
+	g.errorf(token.NoPos, "%s: unable to locate type definition of type name %q in package %s", g.pkgDir(), typeName, g.pkg.path)
+	return nil
+}
+
+// defines reports whether the package defines a type of the given name.
+func (g *Generator) defines(typeName string) bool {
+	for ident := range g.pkg.defs {
+		if ident.Name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// pkgDir returns the source directory of the package.
+func (g *Generator) pkgDir() string {
+	for _, file := range g.pkg.files {
+		return filepath.Dir(g.position(file.file.Package).Filename)
+	}
+	return "."
+}
+
+// typeGraph returns the named struct types and enum types reachable from the
+// given type names, in order of discovery. The case types of switch-on fields
+// are reachable from the struct type of the field.
+func (g *Generator) typeGraph(typeNames []string) (structs, enums []*types.Named) {
+	seen := make(map[*types.Named]bool)
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		switch t := types.Unalias(g.substitute(t)).(type) {
+		case *types.Named:
+			if seen[t] || isBigIntType(t) {
+				return
+			}
+			seen[t] = true
+			switch underlying := t.Underlying().(type) {
+			case *types.Struct:
+				structs = append(structs, t)
+				for i := 0; i < underlying.NumFields(); i++ {
+					if field := underlying.Field(i); !g.excluded[field] {
+						visit(field.Type())
+						for _, c := range g.switchCases(g.fieldTag(underlying, i)) {
+							visit(c)
+						}
+					}
+				}
+			case *types.Basic:
+				if isEnum(t) {
+					enums = append(enums, t)
+				}
+			default:
+				visit(underlying)
+			}
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Pointer:
+			visit(t.Elem())
+		}
+	}
+	for _, typeName := range typeNames {
+		visit(g.lookupType(typeName))
+	}
+	return structs, enums
+}
+
+// isEnum reports whether the given named type is an enum; i.e. a named integer
+// type, other than the integer types of cgo.
+func isEnum(t *types.Named) bool {
+	underlying, ok := t.Underlying().(*types.Basic)
+	return ok && underlying.Info()&types.IsInteger != 0 && !isCgo(t)
+}
+
+// enumValues returns the constants of the given enum type, in source order.
+func enumValues(t *types.Named) []*types.Const {
+	pkg := t.Obj().Pkg()
+	if pkg == nil {
+		return nil
+	}
+	var consts []*types.Const
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), t) {
+			continue
+		}
+		consts = append(consts, c)
+	}
+	sort.Slice(consts, func(i, j int) bool {
+		return consts[i].Pos() < consts[j].Pos()
+	})
+	return consts
+}
+
+// namedDeps returns the named struct and enum types directly referenced by
+// the given type, looking through arrays, slices and pointers.
+func namedDeps(t types.Type) []*types.Named {
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		if isBigIntType(t) {
+			return nil
+		}
+		switch t.Underlying().(type) {
+		case *types.Struct:
+			return []*types.Named{t}
+		case *types.Basic:
+			if isEnum(t) {
+				return []*types.Named{t}
+			}
+			return nil
+		default:
+			return namedDeps(t.Underlying())
+		}
+	case *types.Array:
+		return namedDeps(t.Elem())
+	case *types.Slice:
+		return namedDeps(t.Elem())
+	case *types.Pointer:
+		return namedDeps(t.Elem())
+	default:
+		return nil
+	}
+}
+
+// typeCycles returns the recursive struct types reachable from the given type
+// names (e.g. linked list nodes referring to the next node through a pointer
+// field), mapped to a description of the cycle through the fields of the types;
+// e.g. "Node.Tree -> Tree.Root -> Node".
+//
+// Recursive types are valid in the generated output, as types are referenced
+// by name, but their binary representation must be terminated; e.g. by a nil
+// pointer or an empty slice.
+func (g *Generator) typeCycles(typeNames []string) map[*types.Named]string {
+	cycles := make(map[*types.Named]string)
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*types.Named]int)
+	// Stack of types being visited, and the fields through which the next type
+	// on the stack was reached.
+	var stack []*types.Named
+	var fields []string
+	var visit func(t *types.Named)
+	visit = func(t *types.Named) {
+		state[t] = visiting
+		stack = append(stack, t)
+		if st, ok := t.Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				field := st.Field(i)
+				if g.excluded[field] {
+					continue
+				}
+				for _, dep := range namedDeps(field.Type()) {
+					fields = append(fields, t.Obj().Name()+"."+field.Name())
+					switch state[dep] {
+					case 0:
+						visit(dep)
+					case visiting:
+						// Record the cycle of each type on the stack from dep,
+						// starting at the type itself.
+						start := 0
+						for stack[start] != dep {
+							start++
+						}
+						n := len(stack) - start
+						for j := 0; j < n; j++ {
+							member := stack[start+j]
+							if _, ok := cycles[member]; ok {
+								continue
+							}
+							var path []string
+							for k := 0; k < n; k++ {
+								path = append(path, fields[start+(j+k)%n])
+							}
+							cycles[member] = strings.Join(path, " -> ") + " -> " + member.Obj().Name()
+						}
+					}
+					fields = fields[:len(fields)-1]
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[t] = visited
+	}
+	for _, typeName := range typeNames {
+		if t := g.lookupType(typeName); t != nil && state[t] == 0 {
+			visit(t)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"go/ast"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// instanceDirective is the comment directive which marks a method as the
+// source of a Kaitai instance. An optional instance name may follow the
+// directive; by default the snake_case method name is used.
+//
+// Example:
+//
+//	//kaitai:instance
+//	func (hdr *Header) DataEnd() uint32 {
+//	    return hdr.DataOffset + hdr.DataSize
+//	}
+const instanceDirective = "//kaitai:instance"
+
+// typeInstances returns the instances of the named type, based on the methods
+// of the type annotated with the //kaitai:instance directive.
+func (g *Generator) typeInstances(typeName string) []*ir.Instance {
+	var instances []*ir.Instance
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || recvTypeName(fn) != typeName {
+				continue
+			}
+			name, ok := g.instanceName(fn)
+			if !ok {
+				continue
+			}
+			instance := &ir.Instance{
+				ID:     name,
+				Method: fn.Name.Name,
+				Pos:    g.posString(fn.Pos()),
+			}
+			expr, err := g.methodExpr(fn, "")
+			if err != nil {
+				instance.Err = err.Error()
+			}
+			instance.Value = expr
+			instances = append(instances, instance)
+		}
+	}
+	return instances
+}
+
+// generateInstances adds the instances of the given struct type to the given
+// mapping. Methods which could not be translated are output as placeholders.
+func (g *Generator) generateInstances(instances *yaml.Node, s *ir.StructDef) {
+	for _, inst := range s.Instances {
+		instance := ksyMap()
+		ksyAdd(instances, inst.ID, instance)
+		comment := s.Name + "." + inst.Method
+		if len(inst.Err) > 0 {
+			logf(levelInfo, "untranslated_method", logAttrs{"pos": inst.Pos, "type": s.Name, "method": inst.Method}, "%s: unable to translate method %s.%s; %s", inst.Pos, s.Name, inst.Method, inst.Err)
+			ksyAdd(instance, "value", ksyValue("todo_translate_method", comment))
+			continue
+		}
+		ksyAdd(instance, "value", ksyValue(inst.Value, comment))
+	}
+}
+
+// recvTypeName returns the name of the receiver type of the given method.
+func recvTypeName(fn *ast.FuncDecl) string {
+	typ := fn.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// instanceName returns the Kaitai instance name of the given method, and a
+// boolean indicating whether the method is annotated with the
+// //kaitai:instance directive.
+func (g *Generator) instanceName(fn *ast.FuncDecl) (string, bool) {
+	if fn.Doc == nil {
+		return "", false
+	}
+	for _, c := range fn.Doc.List {
+		if !strings.HasPrefix(c.Text, instanceDirective) {
+			continue
+		}
+		arg := strings.TrimPrefix(c.Text, instanceDirective)
+		if len(arg) > 0 && arg[0] != ' ' && arg[0] != '\t' {
+			// Different directive sharing the same prefix.
+			continue
+		}
+		if name := strings.TrimSpace(arg); len(name) > 0 {
+			return name, true
+		}
+		return g.fieldID(recvTypeName(fn), fn.Name.Name), true
+	}
+	return "", false
+}
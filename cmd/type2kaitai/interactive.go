@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// prompter reads the answers of the user to the prompts of a -interactive
+// session.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask outputs the given question with the default answer, and returns the
+// answer of the user; the default answer on an empty line or at the end of
+// input.
+func (p *prompter) ask(question, def string) string {
+	if len(def) > 0 {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	if !p.in.Scan() {
+		fmt.Fprintln(p.out)
+		return def
+	}
+	if answer := strings.TrimSpace(p.in.Text()); len(answer) > 0 {
+		return answer
+	}
+	return def
+}
+
+// choose asks the given question until the answer is one of the given
+// options, and returns the answer.
+func (p *prompter) choose(question, def string, options ...string) string {
+	for {
+		answer := p.ask(fmt.Sprintf("%s (%s)", question, strings.Join(options, ", ")), def)
+		for _, option := range options {
+			if answer == option {
+				return answer
+			}
+		}
+		fmt.Fprintf(p.out, "invalid answer %q; valid options: %s\n", answer, strings.Join(options, ", "))
+	}
+}
+
+// interactive lists the struct types of the package on the given terminal
+// output, and prompts the user to select the types to generate, the byte order
+// of the binary format, and the byte order and string mode of the fields of the
+// selected types. The choices are recorded in the given configuration, which
+// holds the choices of earlier sessions (if any) as defaults. The types
+// selected by the given -type patterns are selected by default.
+//
+// Options given by the struct tags or comment directives of fields take
+// precedence over the configuration, and are thus not prompted for.
+func (g *Generator) interactive(in io.Reader, out io.Writer, cfg *config, patterns []string) {
+	p := &prompter{in: bufio.NewScanner(in), out: out}
+	g.parseDirectives()
+	g.excludeFields(splitList(*excludeType), splitList(*excludeField))
+	structs := g.structTypes()
+	if len(structs) == 0 {
+		fatalf("no struct types found in package %s", g.pkg.path)
+	}
+
+	// Select types.
+	if len(patterns) == 0 {
+		patterns = cfg.Types
+	}
+	selected := make(map[string]bool)
+	typeNames, _ := selectIndices(structs, strings.Join(patterns, ","))
+	for _, typeName := range typeNames {
+		selected[typeName] = true
+	}
+	for _, ident := range structs {
+		if *allExported && ast.IsExported(ident.Name) {
+			selected[ident.Name] = true
+		}
+	}
+	fmt.Fprintf(out, "Struct types of package %s:\n", g.pkg.path)
+	var def []string
+	for i, ident := range structs {
+		mark := " "
+		if selected[ident.Name] {
+			mark = "x"
+			def = append(def, strconv.Itoa(i+1))
+		}
+		st := g.pkg.defs[ident].Type().Underlying().(*types.Struct)
+		fmt.Fprintf(out, "  [%s] %2d. %s (%d fields)\n", mark, i+1, ident.Name, st.NumFields())
+	}
+	for {
+		answer := p.ask("Types to generate (e.g. 1,3-4 or Header*)", strings.Join(def, ","))
+		typeNames, err := selectIndices(structs, answer)
+		if err == nil && len(typeNames) == 0 {
+			err = fmt.Errorf("no types selected")
+		}
+		if err != nil {
+			fmt.Fprintf(out, "invalid selection %q; %v\n", answer, err)
+			continue
+		}
+		cfg.Types = typeNames
+		break
+	}
+
+	// Byte order.
+	order := cfg.Endian
+	if len(*endian) > 0 {
+		order = *endian
+	}
+	if order == "" {
+		order = "-"
+	}
+	order = p.choose("Byte order of the binary format; - to infer", order, "le", "be", "-")
+	cfg.Endian = strings.TrimPrefix(order, "-")
+	if len(cfg.Endian) > 0 {
+		g.endian = cfg.Endian
+	}
+
+	// Field options.
+	defs := make(map[string]types.Object)
+	for _, ident := range structs {
+		defs[ident.Name] = g.pkg.defs[ident]
+	}
+	for _, typeName := range cfg.Types {
+		st := defs[typeName].Type().Underlying().(*types.Struct)
+		fmt.Fprintf(out, "Fields of %s:\n", typeName)
+		for i := 0; i < st.NumFields(); i++ {
+			g.promptField(p, cfg, typeName, st, i)
+		}
+	}
+}
+
+// promptField prompts the user for the byte order of multi-byte integer and
+// float fields, the string mode (str or strz) of byte array fields, and the
+// terminator of byte slice and string fields; the i-th field of the given
+// struct type.
+func (g *Generator) promptField(p *prompter, cfg *config, typeName string, st *types.Struct, i int) {
+	field := st.Field(i)
+	if g.excluded[field] {
+		return
+	}
+	name := typeName + "." + field.Name()
+	src := structtag.Parse(st.Tag(i)).Merge(g.fieldDirectives[field])
+	opts := cfg.Fields[name]
+	// option prompts for the option of the given key, unless given by the
+	// struct tag or comment directives of the field; "-" removes the option.
+	option := func(key, question string, options ...string) string {
+		if value, ok := src[key]; ok {
+			fmt.Fprintf(p.out, "  %s: %s=%s set by struct tag or directive\n", name, key, value)
+			return ""
+		}
+		def := "-"
+		if value, ok := opts[key]; ok {
+			def = value
+		}
+		q := fmt.Sprintf("  %s (%s) %s", name, types.TypeString(field.Type(), skipQualifier), question)
+		if len(options) > 0 {
+			return p.choose(q, def, append(options, "-")...)
+		}
+		return p.ask(q, def)
+	}
+	switch t := field.Type().Underlying().(type) {
+	case *types.Basic:
+		if t.Info()&(types.IsInteger|types.IsFloat) == 0 || g.sizes.Sizeof(t) < 2 {
+			return
+		}
+		answer := option("endian", "byte order; - for default", "le", "be")
+		if len(answer) > 0 {
+			cfg.setFieldOption(name, "endian", answer, answer == "-")
+		}
+	case *types.Array:
+		if !isByte(t.Elem()) || src.Has("contents") {
+			return
+		}
+		def := "-"
+		switch {
+		case src.Has("str"), src.Has("strz"):
+			fmt.Fprintf(p.out, "  %s: string mode set by struct tag or directive\n", name)
+			return
+		case opts.Has("strz"):
+			def = "strz"
+		case opts.Has("str"):
+			def = "str"
+		}
+		q := fmt.Sprintf("  %s (%s) string mode; - for bytes", name, types.TypeString(field.Type(), skipQualifier))
+		answer := p.choose(q, def, "str", "strz", "-")
+		cfg.setFieldOption(name, "str", "", answer != "str")
+		cfg.setFieldOption(name, "strz", "", answer != "strz")
+	}
+	if isTerminable(field.Type()) {
+		for {
+			answer := option("terminator", "terminator byte (e.g. 0x00); - for none")
+			if len(answer) == 0 {
+				return
+			}
+			if answer != "-" {
+				if _, err := strconv.ParseUint(answer, 0, 8); err != nil {
+					fmt.Fprintf(p.out, "invalid terminator %q; expected byte value\n", answer)
+					continue
+				}
+			}
+			cfg.setFieldOption(name, "terminator", answer, answer == "-")
+			return
+		}
+	}
+}
+
+// isByte reports whether the given type is byte (or uint8).
+func isByte(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// isTerminable reports whether the given type is a byte slice or string type,
+// which may be terminated by a sentinel byte.
+func isTerminable(t types.Type) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Slice:
+		return isByte(t.Elem())
+	case *types.Basic:
+		return t.Kind() == types.String
+	}
+	return false
+}
+
+// selectIndices returns the names of the given struct types selected by the
+// given comma-separated list of one-based indices, index ranges (e.g. 3-4),
+// type names and patterns (see selectTypes), in order of the struct types.
+func selectIndices(structs []*ast.Ident, list string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, elem := range splitList(list) {
+		elem = strings.TrimSpace(elem)
+		if len(elem) == 0 {
+			continue
+		}
+		lo, hi := elem, elem
+		if pos := strings.Index(elem, "-"); pos != -1 {
+			lo, hi = elem[:pos], elem[pos+1:]
+		}
+		start, err1 := strconv.Atoi(lo)
+		end, err2 := strconv.Atoi(hi)
+		if err1 == nil && err2 == nil {
+			if start < 1 || end > len(structs) || start > end {
+				return nil, fmt.Errorf("index %s out of range [1, %d]", elem, len(structs))
+			}
+			for i := start; i <= end; i++ {
+				selected[structs[i-1].Name] = true
+			}
+			continue
+		}
+		match, err := typeMatcher(elem)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			typeName := elem
+			match = func(s string) bool { return s == typeName }
+		}
+		n := 0
+		for _, ident := range structs {
+			if match(ident.Name) {
+				selected[ident.Name] = true
+				n++
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("no struct types match %q", elem)
+		}
+	}
+	var typeNames []string
+	for _, ident := range structs {
+		if selected[ident.Name] {
+			typeNames = append(typeNames, ident.Name)
+		}
+	}
+	return typeNames, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// generateIR outputs the IR of the selected types as JSON, to be post-processed
+// or hand-tuned before generating output with -from-ir.
+func (g *Generator) generateIR() {
+	if err := g.mod.Encode(&g.buf); err != nil {
+		g.errorAt("", "unable to encode IR; %v", err)
+	}
+}
+
+// runIR generates the output of the types of the IR in the given JSON file,
+// and writes it to the directory of the file (or -output). runIR reports
+// whether generation succeeded (i.e. the output is up to date with -check, and
+// no problems were found with -strict).
+func (g *Generator) runIR(path, ext string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal(err)
+	}
+	m, err := ir.Decode(f)
+	f.Close()
+	if err != nil {
+		fatalf("%s: invalid IR; %v", path, err)
+	}
+	g.mod = m
+	g.endian = m.Endian
+	g.bitEndian = m.BitEndian
+	g.arch = m.Arch
+	g.sizes = archSizes(m.Arch)
+	outputName := *output
+	if outputName == "" {
+		baseName := fmt.Sprintf("%s_type%s", m.Roots[0].Name, ext)
+		outputName = filepath.Join(filepath.Dir(path), strings.ToLower(baseName))
+	}
+	if *merge {
+		g.merge = outputName
+	}
+	g.generateOutput()
+
+	// Write to file.
+	switch {
+	case *check:
+		return g.checkUpToDate(outputName)
+	case *diffOutput:
+		return g.diffUpToDate(outputName)
+	}
+	if err := writeOutput(outputName, g.buf.Bytes(), *writeIfChanged); err != nil {
+		fatalf("writing output: %s", err)
+	}
+
+	// Write stub implementations of opaque types.
+	g.writeOpaqueStubs(filepath.Dir(path))
+
+	// Display problems.
+	failed := g.reportErrors() && *strict || g.failed
+	return !failed
+}
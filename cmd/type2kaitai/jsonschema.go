@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// jsonSchema is a JSON Schema describing the decoded representation of a
+// type.
+type jsonSchema struct {
+	Schema      string          `json:"$schema,omitempty"`
+	Comment     string          `json:"$comment,omitempty"`
+	Ref         string          `json:"$ref,omitempty"`
+	Type        string          `json:"type,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Properties  *jsonProperties `json:"properties,omitempty"`
+	Required    []string        `json:"required,omitempty"`
+	Items       *jsonSchema     `json:"items,omitempty"`
+	MinItems    *int64          `json:"minItems,omitempty"`
+	MaxItems    *int64          `json:"maxItems,omitempty"`
+	Minimum     json.Number     `json:"minimum,omitempty"`
+	Maximum     json.Number     `json:"maximum,omitempty"`
+	Enum        []json.Number   `json:"enum,omitempty"`
+	Defs        *jsonProperties `json:"$defs,omitempty"`
+}
+
+// jsonProperties is an ordered map from name to JSON Schema, used to output
+// properties in field order.
+type jsonProperties struct {
+	names   []string
+	schemas map[string]*jsonSchema
+}
+
+// add adds the named schema to the properties.
+func (p *jsonProperties) add(name string, schema *jsonSchema) {
+	if p.schemas == nil {
+		p.schemas = make(map[string]*jsonSchema)
+	}
+	p.names = append(p.names, name)
+	p.schemas[name] = schema
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p *jsonProperties) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	for i, name := range p.names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(p.schemas[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(val)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// generateJSONSchema outputs a JSON Schema describing the decoded
+// representation of the selected types and their dependencies, as produced by
+// the JSON dumps of Kaitai-generated parsers.
+func (g *Generator) generateJSONSchema() {
+	root := &jsonSchema{
+		Schema:  "https://json-schema.org/draft/2020-12/schema",
+		Comment: strings.Join(g.headerLines(), "\n"),
+		Ref:     "#/$defs/" + g.mod.Roots[0].ID,
+		Defs:    &jsonProperties{},
+	}
+	for _, s := range g.mod.Structs {
+		root.Defs.add(s.ID, g.jsonStruct(s))
+	}
+	for _, e := range g.mod.Enums {
+		root.Defs.add(e.ID, g.jsonEnum(e))
+	}
+	for _, size := range []int64{8, 16} {
+		if g.complexTypes[size] {
+			root.Defs.add(basicToKai(&ir.Type{Kind: ir.Complex, Size: size}), jsonComplex())
+		}
+	}
+	buf, err := json.MarshalIndent(root, "", "\t")
+	if err != nil {
+		fatalf("unable to encode JSON Schema; %v", err)
+	}
+	g.buf.Write(buf)
+	g.Printf("\n")
+}
+
+// jsonStruct returns the JSON Schema of the given struct type.
+func (g *Generator) jsonStruct(s *ir.StructDef) *jsonSchema {
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: &jsonProperties{},
+	}
+	for _, f := range s.Fields {
+		fieldSchema, err := g.jsonType(f.Type)
+		if err != nil {
+			logf(levelInfo, "skipped_field", logAttrs{"pos": f.Pos, "type": s.Name, "field": f.Name}, "%s: skipping field %s.%s; %v", f.Pos, s.Name, f.Name, err)
+			continue
+		}
+		schema.Properties.add(f.ID, fieldSchema)
+		schema.Required = append(schema.Required, f.ID)
+	}
+	return schema
+}
+
+// jsonEnum returns the JSON Schema of the given enum type, allowing only the
+// values of the constants of the enum.
+func (g *Generator) jsonEnum(e *ir.EnumDef) *jsonSchema {
+	schema := &jsonSchema{Type: "integer"}
+	var names []string
+	for _, v := range e.Values {
+		schema.Enum = append(schema.Enum, json.Number(v.Value))
+		names = append(names, fmt.Sprintf("%s (%s)", v.Name, v.Value))
+	}
+	schema.Description = strings.Join(names, ", ")
+	return schema
+}
+
+// jsonComplex returns the JSON Schema of a complex number sub-type.
+func jsonComplex() *jsonSchema {
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: &jsonProperties{},
+		Required:   []string{"real", "imag"},
+	}
+	schema.Properties.add("real", &jsonSchema{Type: "number"})
+	schema.Properties.add("imag", &jsonSchema{Type: "number"})
+	return schema
+}
+
+// jsonType returns the JSON Schema corresponding to the given type.
+func (g *Generator) jsonType(t *ir.Type) (*jsonSchema, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return g.jsonBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct || t.IsEnum() {
+			return &jsonSchema{Ref: "#/$defs/" + t.ID}, nil
+		}
+		return g.jsonType(t.Underlying)
+	case ir.Array:
+		items, err := g.jsonType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		n := t.Len
+		return &jsonSchema{Type: "array", Items: items, MinItems: &n, MaxItems: &n}, nil
+	case ir.Slice:
+		items, err := g.jsonType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		return g.jsonBasicType(addrType(t))
+	default:
+		return nil, fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// jsonBasicType returns the JSON Schema corresponding to the given basic type.
+// The range of integer types is derived from their width.
+func (g *Generator) jsonBasicType(t *ir.Type) (*jsonSchema, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case ir.Int:
+		bits := uint(8 * t.Size)
+		max := new(big.Int).Lsh(big.NewInt(1), bits-1)
+		min := new(big.Int).Neg(max)
+		max.Sub(max, big.NewInt(1))
+		return &jsonSchema{Type: "integer", Minimum: json.Number(min.String()), Maximum: json.Number(max.String())}, nil
+	case ir.Uint:
+		bits := uint(8 * t.Size)
+		max := new(big.Int).Lsh(big.NewInt(1), bits)
+		max.Sub(max, big.NewInt(1))
+		return &jsonSchema{Type: "integer", Minimum: "0", Maximum: json.Number(max.String())}, nil
+	case ir.Float:
+		return &jsonSchema{Type: "number"}, nil
+	case ir.Complex:
+		if g.complexTypes == nil {
+			g.complexTypes = make(map[int64]bool)
+		}
+		g.complexTypes[t.Size] = true
+		return &jsonSchema{Ref: "#/$defs/" + basicToKai(t)}, nil
+	case ir.String:
+		return &jsonSchema{Type: "string"}, nil
+	default:
+		return nil, fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// exprTranslator translates the Go expressions of the methods of struct types
+// into Kaitai expressions. Supported are literals, constants, fields of the
+// receiver (and their fields and elements), conversions, len of slices and
+// arrays, arithmetic, bitwise, comparison and logical operators, and calls of
+// the methods of the receiver; instance methods are referenced by instance
+// name, other methods are inlined. Enum fields compared with enum constants
+// are translated to Kaitai enum comparisons (e.g. kind == kind::kind_a), and
+// converted to integers (e.g. kind.to_i) elsewhere. Conversions of integers to
+// floats, and division and remainder of signed integers, are not supported, as
+// their Kaitai semantics differ.
+type exprTranslator struct {
+	g *Generator
+	// Name of the receiver of the method being translated.
+	recv string
+	// Kaitai expression prefix of the fields of the receiver; e.g. "_." of the
+	// elements of repeat-until expressions.
+	self string
+	// Methods being translated, to detect recursion.
+	active map[*ast.FuncDecl]bool
+}
+
+// methodExpr translates the body of the given method into a Kaitai expression,
+// where the fields of the receiver are prefixed by self. Only methods without
+// parameters consisting of a single return statement are supported; see
+// exprTranslator. Errors locate the unsupported construct.
+func (g *Generator) methodExpr(fn *ast.FuncDecl, self string) (string, error) {
+	t := &exprTranslator{g: g, self: self, active: make(map[*ast.FuncDecl]bool)}
+	return t.method(fn)
+}
+
+// method translates the body of the given method.
+func (t *exprTranslator) method(fn *ast.FuncDecl) (string, error) {
+	if fn.Body == nil || len(fn.Body.List) != 1 {
+		return "", fmt.Errorf("method body is not a single return statement")
+	}
+	ret, ok := fn.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return "", fmt.Errorf("method body is not a single return statement")
+	}
+	if fn.Type.Params.NumFields() > 0 {
+		return "", fmt.Errorf("method %s has parameters", fn.Name.Name)
+	}
+	recv := ""
+	if names := fn.Recv.List[0].Names; len(names) > 0 {
+		recv = names[0].Name
+	}
+	outer := t.recv
+	t.recv = recv
+	t.active[fn] = true
+	defer func() {
+		t.recv = outer
+		delete(t.active, fn)
+	}()
+	return t.expr(ret.Results[0], false)
+}
+
+// unsupported returns an error of the given unsupported construct, prefixed by
+// the position of the given node.
+func (t *exprTranslator) unsupported(node ast.Node, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if pos := t.g.posString(node.Pos()); len(pos) > 0 {
+		return fmt.Errorf("%s: %s", pos, msg)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// expr translates the given Go expression. If enum is set, enum constants are
+// translated to Kaitai enum values and enum fields are left as is, rather than
+// converted to integers.
+func (t *exprTranslator) expr(expr ast.Expr, enum bool) (string, error) {
+	info := t.g.pkg.info
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		return t.literal(expr, enum)
+	case *ast.Ident:
+		if c, ok := info.Uses[expr].(*types.Const); ok {
+			return t.constant(expr, c, enum)
+		}
+		if expr.Name == t.recv {
+			return "", t.unsupported(expr, "unsupported use of receiver %s as value", expr.Name)
+		}
+		return "", t.unsupported(expr, "unsupported identifier %s", expr.Name)
+	case *ast.SelectorExpr:
+		// Constants of other packages; e.g. binary.MaxVarintLen64.
+		if c, ok := info.Uses[expr.Sel].(*types.Const); ok {
+			return t.constant(expr, c, enum)
+		}
+		return t.field(expr, enum)
+	case *ast.IndexExpr:
+		x, err := t.expr(expr.X, false)
+		if err != nil {
+			return "", err
+		}
+		index, err := t.expr(expr.Index, false)
+		if err != nil {
+			return "", err
+		}
+		ref := fmt.Sprintf("%s[%s]", x, index)
+		if !enum && t.g.kaiEnum(info.TypeOf(expr)) != nil {
+			ref += ".to_i"
+		}
+		return ref, nil
+	case *ast.ParenExpr:
+		x, err := t.expr(expr.X, enum)
+		if err != nil {
+			return "", err
+		}
+		return "(" + x + ")", nil
+	case *ast.CallExpr:
+		return t.call(expr, enum)
+	case *ast.UnaryExpr:
+		x, err := t.expr(expr.X, false)
+		if err != nil {
+			return "", err
+		}
+		switch expr.Op {
+		case token.SUB:
+			return "-" + x, nil
+		case token.ADD:
+			return x, nil
+		case token.NOT:
+			return "not " + x, nil
+		case token.XOR:
+			return "~" + x, nil
+		}
+		return "", t.unsupported(expr, "unsupported unary operator %s", expr.Op)
+	case *ast.BinaryExpr:
+		return t.binary(expr)
+	default:
+		return "", t.unsupported(expr, "unsupported expression %s", types.ExprString(expr))
+	}
+}
+
+// literal translates the given literal.
+func (t *exprTranslator) literal(lit *ast.BasicLit, enum bool) (string, error) {
+	tv := t.g.pkg.info.Types[lit]
+	if enum {
+		if named := t.g.kaiEnum(tv.Type); named != nil && tv.Value != nil {
+			if id, ok := t.g.enumLiteral(named, tv.Value); ok {
+				return id, nil
+			}
+			return "", t.unsupported(lit, "unsupported comparison of enum %s with value %s not defined by a constant", named.Obj().Name(), lit.Value)
+		}
+	}
+	switch lit.Kind {
+	case token.INT:
+		if isKaiInt(lit.Value) {
+			return lit.Value, nil
+		}
+		// Octal and binary literals, and literals with digit separators.
+		if tv.Value != nil {
+			return tv.Value.ExactString(), nil
+		}
+	case token.FLOAT:
+		return lit.Value, nil
+	case token.CHAR:
+		if tv.Value != nil {
+			if v := constant.ToInt(tv.Value); v.Kind() == constant.Int {
+				return v.ExactString(), nil
+			}
+		}
+	case token.STRING:
+		s, err := strconv.Unquote(lit.Value)
+		if err == nil {
+			return strconv.Quote(s), nil
+		}
+	}
+	return "", t.unsupported(lit, "unsupported literal %s", lit.Value)
+}
+
+// reKaiInt matches Go integer literals of identical value in Kaitai
+// expressions; i.e. decimal and hexadecimal literals.
+var reKaiInt = regexp.MustCompile(`^(0|[1-9][0-9]*|0[xX][0-9a-fA-F]+)$`)
+
+// isKaiInt reports whether the given Go integer literal has the same value in
+// Kaitai expressions.
+func isKaiInt(lit string) bool {
+	return reKaiInt.MatchString(lit)
+}
+
+// constant translates the given constant, as referenced by the given
+// expression.
+func (t *exprTranslator) constant(expr ast.Expr, c *types.Const, enum bool) (string, error) {
+	if enum {
+		if named := t.g.kaiEnum(c.Type()); named != nil {
+			if id, ok := t.g.enumLiteral(named, c.Val()); ok {
+				return id, nil
+			}
+		}
+	}
+	val := c.Val()
+	switch val.Kind() {
+	case constant.Bool, constant.Int:
+		return val.ExactString(), nil
+	case constant.Float:
+		f, _ := constant.Float64Val(val)
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case constant.String:
+		return strconv.Quote(constant.StringVal(val)), nil
+	}
+	return "", t.unsupported(expr, "unsupported constant %s", c.Name())
+}
+
+// field translates the given field selector; e.g. hdr.Size of the receiver hdr
+// or hdr.Info.Size of a field of the receiver.
+func (t *exprTranslator) field(expr *ast.SelectorExpr, enum bool) (string, error) {
+	info := t.g.pkg.info
+	sel, ok := info.Selections[expr]
+	if !ok || sel.Kind() != types.FieldVal {
+		return "", t.unsupported(expr, "unsupported selector %s", types.ExprString(expr))
+	}
+	if len(sel.Index()) > 1 {
+		return "", t.unsupported(expr, "unsupported promoted field %s", types.ExprString(expr))
+	}
+	prefix := t.self
+	if x, ok := expr.X.(*ast.Ident); !ok || x.Name != t.recv || len(t.recv) == 0 {
+		x, err := t.expr(expr.X, false)
+		if err != nil {
+			return "", err
+		}
+		prefix = x + "."
+	}
+	// Name of the struct type of the field, for renamed fields.
+	recvType := sel.Recv()
+	if ptr, ok := recvType.(*types.Pointer); ok {
+		recvType = ptr.Elem()
+	}
+	ref := prefix + t.g.fieldID(types.TypeString(recvType, skipQualifier), expr.Sel.Name)
+	if st, ok := recvType.Underlying().(*types.Struct); ok {
+		if field, ok := sel.Obj().(*types.Var); ok {
+			ref += t.g.varintTypeValue(st, field)
+		}
+	}
+	if !enum && t.g.kaiEnum(info.TypeOf(expr)) != nil {
+		ref += ".to_i"
+	}
+	return ref, nil
+}
+
+// call translates the given conversion, len call or method call.
+func (t *exprTranslator) call(expr *ast.CallExpr, enum bool) (string, error) {
+	info := t.g.pkg.info
+	// Type conversions, e.g. int64(hdr.Size).
+	if tv, ok := info.Types[expr.Fun]; ok && tv.IsType() && len(expr.Args) == 1 {
+		// Kaitai has no conversion of integers to floats; e.g. the division of
+		// float64(hdr.Size) / 2 would be an integer division.
+		if basicInfo(tv.Type)&types.IsFloat != 0 && basicInfo(info.TypeOf(expr.Args[0]))&types.IsInteger != 0 {
+			return "", t.unsupported(expr, "unsupported conversion %s of integer to float", types.ExprString(expr))
+		}
+		return t.expr(expr.Args[0], false)
+	}
+	switch fun := expr.Fun.(type) {
+	case *ast.Ident:
+		if b, ok := info.Uses[fun].(*types.Builtin); ok && b.Name() == "len" && len(expr.Args) == 1 {
+			switch info.TypeOf(expr.Args[0]).Underlying().(type) {
+			case *types.Slice, *types.Array:
+				x, err := t.expr(expr.Args[0], false)
+				if err != nil {
+					return "", err
+				}
+				return x + ".size", nil
+			}
+			return "", t.unsupported(expr, "unsupported len of %s; not a slice or array", types.ExprString(expr.Args[0]))
+		}
+	case *ast.SelectorExpr:
+		// Methods of the receiver; e.g. hdr.DataEnd().
+		x, ok := fun.X.(*ast.Ident)
+		sel, found := info.Selections[fun]
+		if !ok || x.Name != t.recv || len(t.recv) == 0 || !found || sel.Kind() != types.MethodVal {
+			break
+		}
+		if len(expr.Args) > 0 {
+			return "", t.unsupported(expr, "unsupported call of method %s with arguments", fun.Sel.Name)
+		}
+		recvType := sel.Recv()
+		if ptr, ok := recvType.(*types.Pointer); ok {
+			recvType = ptr.Elem()
+		}
+		fn := t.g.methodDecl(types.TypeString(recvType, skipQualifier), fun.Sel.Name)
+		if fn == nil {
+			break
+		}
+		if name, ok := t.g.instanceName(fn); ok {
+			ref := t.self + name
+			if !enum && t.g.kaiEnum(info.TypeOf(expr)) != nil {
+				ref += ".to_i"
+			}
+			return ref, nil
+		}
+		if t.active[fn] {
+			return "", t.unsupported(expr, "unsupported recursive call of method %s", fun.Sel.Name)
+		}
+		body, err := t.method(fn)
+		if err != nil {
+			return "", fmt.Errorf("method %s called at %s: %v", fun.Sel.Name, t.g.posString(expr.Pos()), err)
+		}
+		return "(" + body + ")", nil
+	}
+	return "", t.unsupported(expr, "unsupported call %s", types.ExprString(expr))
+}
+
+// Kaitai expression operators of Go binary operators.
+var kaiOps = map[token.Token]string{
+	token.ADD:     "+",
+	token.SUB:     "-",
+	token.MUL:     "*",
+	token.QUO:     "/",
+	token.REM:     "%",
+	token.SHL:     "<<",
+	token.SHR:     ">>",
+	token.AND:     "&",
+	token.OR:      "|",
+	token.XOR:     "^",
+	token.AND_NOT: "&",
+	token.EQL:     "==",
+	token.NEQ:     "!=",
+	token.LSS:     "<",
+	token.LEQ:     "<=",
+	token.GTR:     ">",
+	token.GEQ:     ">=",
+	token.LAND:    "and",
+	token.LOR:     "or",
+}
+
+// kaiPrec returns the precedence of the given binary operator in Kaitai
+// expressions, which follows Python rather than Go; e.g. shifts bind less
+// tightly than addition, and bitwise operators more tightly than comparisons.
+func kaiPrec(op token.Token) int {
+	switch op {
+	case token.LOR:
+		return 1
+	case token.LAND:
+		return 2
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return 3
+	case token.OR:
+		return 4
+	case token.XOR:
+		return 5
+	case token.AND, token.AND_NOT:
+		return 6
+	case token.SHL, token.SHR:
+		return 7
+	case token.ADD, token.SUB:
+		return 8
+	default:
+		return 9
+	}
+}
+
+// binary translates the given binary expression, parenthesizing operands as
+// required by the precedence of Kaitai operators.
+func (t *exprTranslator) binary(expr *ast.BinaryExpr) (string, error) {
+	op, ok := kaiOps[expr.Op]
+	if !ok {
+		return "", t.unsupported(expr, "unsupported binary operator %s", expr.Op)
+	}
+	info := t.g.pkg.info
+	if expr.Op == token.QUO || expr.Op == token.REM {
+		// Integer division and remainder truncate in Go, but are floored in
+		// Kaitai; i.e. they only agree on unsigned operands.
+		tv := info.Types[expr]
+		if tv.Value != nil && tv.Value.Kind() == constant.Int {
+			return tv.Value.ExactString(), nil
+		}
+		if b := basicInfo(tv.Type); b&types.IsInteger != 0 && b&types.IsUnsigned == 0 {
+			return "", t.unsupported(expr, "unsupported operator %s of signed operands %s; truncated in Go, floored in Kaitai", expr.Op, types.ExprString(expr))
+		}
+	}
+	enum := false
+	if expr.Op == token.EQL || expr.Op == token.NEQ {
+		enum = t.g.kaiEnum(info.TypeOf(expr.X)) != nil && t.g.kaiEnum(info.TypeOf(expr.Y)) != nil
+	}
+	prec := kaiPrec(expr.Op)
+	x, err := t.operand(expr.X, prec, true, enum)
+	if err != nil {
+		return "", err
+	}
+	if expr.Op == token.AND_NOT {
+		// x &^ y is x & ~y.
+		y, err := t.expr(expr.Y, false)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := expr.Y.(*ast.BinaryExpr); ok {
+			y = "(" + y + ")"
+		}
+		return fmt.Sprintf("%s & ~%s", x, y), nil
+	}
+	y, err := t.operand(expr.Y, prec, false, enum)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", x, op, y), nil
+}
+
+// operand translates the given operand of a binary operator of the given
+// Kaitai precedence, parenthesized if needed; left specifies whether the
+// operand is the left operand.
+func (t *exprTranslator) operand(expr ast.Expr, prec int, left, enum bool) (string, error) {
+	x, err := t.expr(expr, enum)
+	if err != nil {
+		return "", err
+	}
+	if b, ok := expr.(*ast.BinaryExpr); ok {
+		p := kaiPrec(b.Op)
+		// Comparisons are not chained, as in Python.
+		if p < prec || p == prec && (!left || p == kaiPrec(token.EQL)) {
+			x = "(" + x + ")"
+		}
+	}
+	return x, nil
+}
+
+// basicInfo returns the properties of the underlying basic type of the given
+// type, or 0 if not a basic type.
+func basicInfo(t types.Type) types.BasicInfo {
+	if b, ok := t.Underlying().(*types.Basic); ok {
+		return b.Info()
+	}
+	return 0
+}
+
+// kaiEnum returns the named enum type of the given type, or nil if the type is
+// not output as a Kaitai enum; e.g. flag-style enums with -flags-as-bits.
+func (g *Generator) kaiEnum(t types.Type) *types.Named {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || !isEnum(named) || g.flagsAsBits && isFlagEnum(named) {
+		return nil
+	}
+	return named
+}
+
+// enumLiteral returns the Kaitai enum value of the given value of the enum
+// type (e.g. kind::kind_a), and a boolean indicating whether the value is
+// defined by a constant. The first constant of each value applies, as in the
+// Kaitai enum.
+func (g *Generator) enumLiteral(t *types.Named, val constant.Value) (string, bool) {
+	for _, c := range enumValues(t) {
+		if constant.Compare(c.Val(), token.EQL, val) {
+			return g.typeID(t.Obj().Name()) + "::" + g.ident(c.Name()), true
+		}
+	}
+	return "", false
+}
+
+// methodDecl returns the declaration of the given method of the named type of
+// the package, or nil if not declared.
+func (g *Generator) methodDecl(typeName, methodName string) *ast.FuncDecl {
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv != nil && fn.Name.Name == methodName && recvTypeName(fn) == typeName {
+				return fn
+			}
+		}
+	}
+	return nil
+}
+
+// reMethodRef matches the method references of the expressions of kaitai
+// options; e.g. HasBody() of if=HasBody().
+var reMethodRef = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\(\)$`)
+
+// optionExpr returns the Kaitai expression of the given expression of a kaitai
+// option (if or the expr of repeat=until). Method references (e.g.
+// if=HasBody()) are translated from the body of the method of the named type;
+// the struct type of the field for if, and the element type for repeat=until,
+// with fields of the receiver prefixed by self. Other expressions are Kaitai
+// expressions, returned as is.
+func (g *Generator) optionExpr(expr, typeName, self string) (string, error) {
+	m := reMethodRef.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return expr, nil
+	}
+	fn := g.methodDecl(typeName, m[1])
+	if fn == nil {
+		return "", fmt.Errorf("method %s of %s referenced by expression %q not found", m[1], typeName, expr)
+	}
+	x, err := g.methodExpr(fn, self)
+	if err != nil {
+		return "", fmt.Errorf("unable to translate method %s.%s; %v", typeName, m[1], err)
+	}
+	return x, nil
+}
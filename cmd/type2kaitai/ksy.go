@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"math/big"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kaitai specs are built as a YAML document model, which is serialized by the
+// YAML encoder; thus values containing special characters (e.g. expressions
+// containing ": " or starting with "&") are quoted as needed, and the output is
+// always syntactically valid YAML. With -compat-raw, the document is instead
+// written unquoted in the format of earlier versions, byte for byte.
+
+// generateKaitai outputs the Kaitai spec of the selected types.
+func (g *Generator) generateKaitai() {
+	meta := ksyMap()
+	ksyAdd(meta, "endian", ksyValue(g.endian, ""))
+	if len(g.bitEndian) > 0 {
+		ksyAdd(meta, "bit-endian", ksyValue(g.bitEndian, ""))
+	}
+	types := ksyMap()
+	for _, t := range g.mod.Roots {
+		g.generate(types, t)
+	}
+	g.generateEndianVariants(types)
+	g.generateComplexTypes(types)
+	g.generateFlagTypes(types)
+	if len(g.opaqueTypes) > 0 {
+		ksyAdd(meta, "ks-opaque-types", ksyValue("true", ""))
+	}
+	if len(g.imports) > 0 {
+		var imports []string
+		for spec := range g.imports {
+			imports = append(imports, spec)
+		}
+		sort.Strings(imports)
+		ksyAdd(meta, "imports", ksyValue("["+strings.Join(imports, ", ")+"]", ""))
+	}
+	root := ksyMap()
+	ksyAdd(root, "meta", meta)
+	ksyAdd(root, "types", types)
+	if len(g.mod.Enums) > 0 && !g.compatRaw {
+		ksyAdd(root, "enums", g.kaiEnums())
+	}
+	if len(g.merge) > 0 {
+		g.mergeKsy(root)
+	}
+	header := strings.Join(g.headerLines(), "\n")
+	if len(g.merge) > 0 && len(g.header) == 0 {
+		header = fmt.Sprintf("Generated by \"type2kaitai %s\"; hand-edits are kept on regeneration.", cmdline())
+	}
+	doc := &yaml.Node{
+		Kind:        yaml.DocumentNode,
+		HeadComment: ksyComment(header),
+		Content:     []*yaml.Node{root},
+	}
+	if g.compatRaw {
+		g.printRawKsy(doc)
+		return
+	}
+	enc := yaml.NewEncoder(&g.buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		g.errorf(token.NoPos, "unable to encode Kaitai spec; %v", err)
+	}
+	enc.Close()
+}
+
+// kaiEnums returns the Kaitai enums of the enum types of the module, mapping
+// from the values of the constants to their identifiers. As Kaitai enums map
+// each value to one identifier, constants sharing the value of a preceding
+// constant are recorded as a comment. Values of flag-style enums and values
+// above 255 are output in hexadecimal. With -enum-docs, values are output in
+// the verbose form, with the doc comment and Go identifier of the constant;
+// e.g.
+//
+//	0x7f:
+//	  id: elf_mag0
+//	  doc: Magic number.
+//	  -orig-id: ELFMAG0
+func (g *Generator) kaiEnums() *yaml.Node {
+	enums := ksyMap()
+	for _, e := range g.mod.Enums {
+		values := ksyMap()
+		ids := make(map[string]*yaml.Node)
+		aliases := make(map[string][]string)
+		for _, v := range e.Values {
+			if _, ok := ids[v.Value]; ok {
+				aliases[v.Value] = append(aliases[v.Value], v.ID)
+				continue
+			}
+			ids[v.Value] = ksyValue(v.ID, "")
+			if !g.enumDocs {
+				ksyAdd(values, kaiEnumValue(v.Value, e.Flags), ids[v.Value])
+				continue
+			}
+			verbose := ksyMap()
+			ksyAdd(verbose, "id", ids[v.Value])
+			if len(v.Doc) > 0 {
+				ksyAdd(verbose, "doc", ksyDoc(v.Doc))
+			}
+			ksyAdd(verbose, "-orig-id", ksyValue(v.Name, ""))
+			ksyAdd(values, kaiEnumValue(v.Value, e.Flags), verbose)
+		}
+		for value, also := range aliases {
+			ids[value].LineComment = ksyComment("also " + strings.Join(also, ", "))
+		}
+		key := ksyAdd(enums, e.ID, values)
+		key.LineComment = ksyComment(e.Name)
+	}
+	return enums
+}
+
+// ksyDoc returns the node of the given doc string; multi-line doc strings are
+// output as literal blocks.
+func ksyDoc(doc string) *yaml.Node {
+	n := ksyValue(doc, "")
+	if strings.Contains(doc, "\n") {
+		n = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: doc + "\n"}
+	}
+	return n
+}
+
+// kaiEnumValue returns the Kaitai enum key of the given decimal enum value, in
+// hexadecimal if hex is set or the value is above 255.
+func kaiEnumValue(value string, hex bool) string {
+	x, ok := new(big.Int).SetString(value, 10)
+	if !ok || x.Sign() < 0 || !hex && x.Cmp(big.NewInt(255)) <= 0 {
+		return value
+	}
+	return fmt.Sprintf("0x%x", x)
+}
+
+// ksyMap returns a new mapping node.
+func ksyMap() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode}
+}
+
+// ksySeq returns a new block sequence node.
+func ksySeq() *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode}
+}
+
+// ksyAdd adds the given key and value to the mapping node, and returns the key
+// node.
+func ksyAdd(m *yaml.Node, key string, value *yaml.Node) *yaml.Node {
+	k := ksyValue(key, "")
+	m.Content = append(m.Content, k, value)
+	return k
+}
+
+// ksyValue returns the node of the given value, with an optional line comment.
+// Values which are plain YAML scalars (e.g. u4 or 42) or flow sequences of
+// plain scalars (e.g. [0x7f, 0x45]) retain their type; other values are output
+// as strings, and quoted as needed.
+func ksyValue(value, comment string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err == nil && len(doc.Content) == 1 && isPlain(doc.Content[0], value) {
+		n = doc.Content[0]
+	}
+	n.LineComment = ksyComment(comment)
+	return n
+}
+
+// isPlain reports whether the given parsed node is a plain scalar or a flow
+// sequence of plain scalars, which is output unchanged as the given value.
+func isPlain(n *yaml.Node, value string) bool {
+	if len(n.Anchor) > 0 || len(n.HeadComment) > 0 || len(n.LineComment) > 0 || len(n.FootComment) > 0 {
+		return false
+	}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Style == 0 && n.Value == value && strings.HasPrefix(n.Tag, "!!")
+	case yaml.SequenceNode:
+		if n.Style != yaml.FlowStyle {
+			return false
+		}
+		var elems []string
+		for _, elem := range n.Content {
+			if !isPlain(elem, elem.Value) {
+				return false
+			}
+			elems = append(elems, elem.Value)
+		}
+		return "["+strings.Join(elems, ", ")+"]" == value
+	}
+	return false
+}
+
+// ksyComment returns the YAML comment of the given text, or an empty string if
+// text is empty. Multi-line text is output as one comment per line.
+func ksyComment(text string) string {
+	if len(text) == 0 {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(line) == 0 {
+			lines[i] = "#"
+			continue
+		}
+		lines[i] = "# " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ksyComments returns the YAML comments of the given lines of text.
+func ksyComments(lines []string) string {
+	return ksyComment(strings.Join(lines, "\n"))
+}
+
+// ksySpec returns a new attribute specification of the given key and value,
+// with an optional line comment; e.g. "type: u4 # uint32". Further keys of the
+// specification are added by ksyAdd.
+func ksySpec(key, value, comment string) *yaml.Node {
+	m := ksyMap()
+	ksyAdd(m, key, ksyValue(value, comment))
+	return m
+}
+
+// ksyNote sets the line comment of the last value of the given attribute
+// specification; e.g. the Go type of the field following the last key.
+func ksyNote(m *yaml.Node, comment string) {
+	m.Content[len(m.Content)-1].LineComment = ksyComment(comment)
+}
+
+// printRawKsy outputs the given Kaitai spec document in the unquoted format of
+// earlier versions (-compat-raw). Sequences are expected to consist of
+// mappings, and comments following the last element of a sequence are
+// expected as foot comment of the key of the sequence.
+func (g *Generator) printRawKsy(doc *yaml.Node) {
+	g.printRawComment(doc.HeadComment, 0)
+	g.Printf("\n")
+	g.printRawMapping(doc.Content[0], 0, false)
+}
+
+// printRawMapping outputs the given mapping node at the given indentation. If
+// item is set, the mapping is an element of a sequence, and its first key is
+// preceded by "- ".
+func (g *Generator) printRawMapping(m *yaml.Node, indent int, item bool) {
+	for i := 0; i < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		g.printRawComment(key.HeadComment, indent)
+		prefix := strings.Repeat(" ", indent)
+		if item && i == 0 {
+			prefix = strings.Repeat(" ", indent-2) + "- "
+		}
+		switch {
+		case value.Kind == yaml.MappingNode:
+			g.Printf("%s%s:%s\n", prefix, key.Value, rawLineComment(key))
+			g.printRawMapping(value, indent+2, false)
+		case value.Kind == yaml.SequenceNode && value.Style != yaml.FlowStyle:
+			g.Printf("%s%s:%s\n", prefix, key.Value, rawLineComment(key))
+			for _, elem := range value.Content {
+				g.printRawComment(elem.HeadComment, indent+2)
+				g.printRawMapping(elem, indent+4, true)
+			}
+			g.printRawComment(key.FootComment, indent+2)
+		default:
+			g.Printf("%s%s: %s%s\n", prefix, key.Value, rawValue(value), rawLineComment(value))
+		}
+	}
+}
+
+// printRawComment outputs the lines of the given comment at the given
+// indentation.
+func (g *Generator) printRawComment(comment string, indent int) {
+	if len(comment) == 0 {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		g.Printf("%s%s\n", strings.Repeat(" ", indent), line)
+	}
+}
+
+// rawValue returns the unquoted value of the given scalar or flow sequence
+// node.
+func rawValue(n *yaml.Node) string {
+	if n.Kind != yaml.SequenceNode {
+		return n.Value
+	}
+	var elems []string
+	for _, elem := range n.Content {
+		elems = append(elems, elem.Value)
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// rawLineComment returns the line comment of the given node, preceded by a
+// space, or an empty string if not present.
+func rawLineComment(n *yaml.Node) string {
+	if len(n.LineComment) == 0 {
+		return ""
+	}
+	return " " + n.LineComment
+}
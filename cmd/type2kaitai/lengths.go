@@ -0,0 +1,252 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// lengthFuncPrefixes specifies the prefixes of the names of the functions and
+// methods inspected for the length fields of slices; i.e. the functions
+// encoding and decoding the types of the package.
+var lengthFuncPrefixes = []string{"Read", "Unmarshal", "Decode", "Parse", "Write", "Marshal", "Encode"}
+
+// lengthFields returns the integer fields controlling the length of slice
+// fields, as found in the encoding and decoding functions of the package. The
+// following patterns relate a slice field S and an integer field N of the same
+// struct value v:
+//
+//	v.S = make([]T, v.N)
+//	v.N = uint32(len(v.S))
+//	for i := 0; i < int(v.N); i++ { v.S = append(v.S, elem) }
+//	for range v.N { v.S = append(v.S, elem) }
+//	v.S, off, err = readEntries(b, off, v.N)
+//
+// where readEntries is a decode helper with a length parameter (see
+// decodeHelpers). The integer field may also be given by a local variable
+// holding its value (see fieldAliases); e.g. n of
+//
+//	n, off, err := readUint32(b, off)
+//	v.N = n
+//	v.S = make([]T, n)
+//
+// Slices related to more than one integer field are ambiguous, and omitted.
+func (g *Generator) lengthFields() map[*types.Var]*types.Var {
+	lengths := make(map[*types.Var]*types.Var)
+	ambiguous := make(map[*types.Var]bool)
+	add := func(slice, n *ast.SelectorExpr) {
+		s, ok := g.fieldVar(slice)
+		if !ok || types.ExprString(slice.X) != types.ExprString(n.X) {
+			return
+		}
+		if _, ok := s.Type().Underlying().(*types.Slice); !ok {
+			return
+		}
+		count, ok := g.fieldVar(n)
+		if !ok || !isIntegerType(count.Type()) {
+			return
+		}
+		if prev, ok := lengths[s]; ok && prev != count {
+			ambiguous[s] = true
+		}
+		lengths[s] = count
+	}
+	helpers := g.decodeHelpers()
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || !isLengthFunc(fn.Name.Name) {
+				continue
+			}
+			aliases := g.fieldAliases(fn.Body)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					if len(n.Rhs) != 1 {
+						break
+					}
+					lhs, ok := n.Lhs[0].(*ast.SelectorExpr)
+					if !ok {
+						break
+					}
+					if call, h, ok := g.helperCall(helpers, n.Rhs[0]); ok && h.lenParam >= 0 && h.lenParam < len(call.Args) {
+						// v.S, off, err = readEntries(b, off, v.N)
+						if count, ok := g.fieldExpr(call.Args[h.lenParam], aliases); ok {
+							add(lhs, count)
+						}
+					}
+					if len(n.Lhs) != 1 {
+						break
+					}
+					if arg, ok := g.builtinArg(n.Rhs[0], "make", 1); ok {
+						// v.S = make([]T, v.N)
+						if count, ok := g.fieldExpr(arg, aliases); ok {
+							add(lhs, count)
+						}
+					}
+					if arg, ok := g.builtinArg(n.Rhs[0], "len", 0); ok {
+						// v.N = uint32(len(v.S))
+						if slice, ok := g.unconvert(arg).(*ast.SelectorExpr); ok {
+							add(slice, lhs)
+						}
+					}
+				case *ast.ForStmt:
+					// for i := 0; i < int(v.N); i++ { v.S = append(v.S, elem) }
+					cond, ok := n.Cond.(*ast.BinaryExpr)
+					if !ok || cond.Op != token.LSS {
+						break
+					}
+					if count, ok := g.fieldExpr(cond.Y, aliases); ok {
+						for _, slice := range g.appended(n.Body) {
+							add(slice, count)
+						}
+					}
+				case *ast.RangeStmt:
+					// for range v.N { v.S = append(v.S, elem) }
+					if count, ok := g.fieldExpr(n.X, aliases); ok {
+						for _, slice := range g.appended(n.Body) {
+							add(slice, count)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	for s := range ambiguous {
+		delete(lengths, s)
+	}
+	return lengths
+}
+
+// inferLength records the length field of the given slice field of a struct,
+// as found by lengthFields, unless the length of the field is specified by its
+// kaitai struct tag. The length field must precede the slice field, so that it
+// is parsed first. Byte slices are sized by the length field, and other slices
+// repeated.
+func (a *analyzer) inferLength(f *ir.Field, st *types.Struct, fields []*ir.Field) {
+	if len(f.Repeat) > 0 || len(f.Size) > 0 || f.Terminator != nil || len(f.SwitchOn) > 0 || len(f.TagErr) > 0 {
+		return
+	}
+	slice := f.Type.Under()
+	if slice.Kind != ir.Slice {
+		return
+	}
+	count, ok := a.lengths[st.Field(f.Index)]
+	if !ok {
+		return
+	}
+	for _, prev := range fields {
+		if prev.Index < 0 || st.Field(prev.Index) != count {
+			continue
+		}
+		if slice.Elem.IsByte() {
+			f.Size = prev.Name
+		} else {
+			f.Repeat, f.RepeatExpr = "expr", prev.Name
+		}
+		return
+	}
+}
+
+// isLengthFunc reports whether the given function name is the name of an
+// encoding or decoding function (e.g. ReadHeader or UnmarshalBinary).
+func isLengthFunc(name string) bool {
+	for _, prefix := range lengthFuncPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldVar returns the struct field selected by the given selector expression.
+func (g *Generator) fieldVar(sel *ast.SelectorExpr) (*types.Var, bool) {
+	selection, ok := g.pkg.info.Selections[sel]
+	if !ok || selection.Kind() != types.FieldVal {
+		return nil, false
+	}
+	field, ok := selection.Obj().(*types.Var)
+	return field, ok
+}
+
+// builtinArg returns the argument at the given index of a call to the given
+// built-in function, and a boolean indicating whether expr is such a call.
+func (g *Generator) builtinArg(expr ast.Expr, name string, index int) (ast.Expr, bool) {
+	call, ok := g.unconvert(expr).(*ast.CallExpr)
+	if !ok || index >= len(call.Args) {
+		return nil, false
+	}
+	ident, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := g.pkg.info.Uses[ident].(*types.Builtin); !ok || ident.Name != name {
+		return nil, false
+	}
+	return call.Args[index], true
+}
+
+// unconvert returns the given expression without enclosing parentheses and
+// type conversions; e.g. v.N of int(v.N).
+func (g *Generator) unconvert(expr ast.Expr) ast.Expr {
+	for {
+		switch e := expr.(type) {
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.CallExpr:
+			if len(e.Args) != 1 || !g.pkg.info.Types[e.Fun].IsType() {
+				return expr
+			}
+			expr = e.Args[0]
+		default:
+			return expr
+		}
+	}
+}
+
+// appended returns the slice fields appended to in the given loop body; i.e.
+// v.S of v.S = append(v.S, elem).
+func (g *Generator) appended(body *ast.BlockStmt) []*ast.SelectorExpr {
+	var slices []*ast.SelectorExpr
+	for _, stmt := range body.List {
+		assign, ok := stmt.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			continue
+		}
+		lhs, ok := assign.Lhs[0].(*ast.SelectorExpr)
+		if !ok {
+			continue
+		}
+		arg, ok := g.builtinArg(assign.Rhs[0], "append", 0)
+		if !ok || types.ExprString(arg) != types.ExprString(lhs) {
+			continue
+		}
+		slices = append(slices, lhs)
+	}
+	return slices
+}
+
+// isIntegerType reports whether the underlying type of the given type is an
+// integer type.
+func isIntegerType(t types.Type) bool {
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Info()&types.IsInteger != 0
+}
+
+// sliceLen returns the identifier of the field storing the length of the given
+// slice field of the struct type, as given by the repeat-expr or size option
+// of the field or inferred by inferLength, or todo_add_slice_len if unknown.
+func sliceLen(s *ir.StructDef, f *ir.Field) string {
+	n := f.Size
+	if f.Repeat == "expr" {
+		n = f.RepeatExpr
+	}
+	if field := s.Field(n); len(n) > 0 && field != nil {
+		return field.ID
+	}
+	return "todo_add_slice_len"
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Log records of the generation (generated types, dependencies, skipped
+// fields, problems, timing, ...) are leveled, and written to standard error;
+// as text lines prefixed by "type2kaitai: " by default, or with -log json as
+// one JSON object per line, holding the level, event kind and message of the
+// record and its attributes, so that other tooling may consume the generation
+// report; e.g.
+//
+//	{"level":"info","event":"type","msg":"generating type: \"header\"","id":"header","name":"Header"}
+//
+// With -q, only warnings and errors are output. With -v, debug records are
+// output as well; i.e. the dependency edges between the generated types and
+// the timing of the phases of the generation. Fatal errors are output as text.
+
+// logLevel is the level of a log record.
+type logLevel uint8
+
+// Log levels.
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevelNames maps from log levels to their names.
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// logAttrs holds the attributes of a log record, keyed by name.
+type logAttrs map[string]interface{}
+
+var (
+	// logMinLevel is the minimum level of output log records.
+	logMinLevel = levelInfo
+	// logJSON specifies whether log records are output as JSON.
+	logJSON bool
+	// jsonLogger outputs JSON log records.
+	jsonLogger = log.New(os.Stderr, "", 0)
+)
+
+// setLogOptions configures the log output of the given -log format and the
+// verbosity of -v and -q.
+func setLogOptions(format string, verbose, quiet bool) {
+	switch format {
+	case "text":
+		logJSON = false
+	case "json":
+		logJSON = true
+	default:
+		usagef("unsupported log format %q; valid options: text, json", format)
+	}
+	switch {
+	case verbose && quiet:
+		usagef("-v and -q options are mutually exclusive")
+	case verbose:
+		logMinLevel = levelDebug
+	case quiet:
+		logMinLevel = levelWarn
+	}
+}
+
+// logf outputs a log record of the given level and event kind, with the message
+// of the given format and arguments, and the given attributes. The message is
+// output as is in text mode; in JSON mode, the "warning: " and "error: "
+// prefixes of messages are implied by the level, and thus omitted.
+func logf(level logLevel, event string, attrs logAttrs, format string, args ...interface{}) {
+	if level == levelWarn {
+		// Warnings are recorded in the -report summary.
+		recordWarning(strings.TrimPrefix(strings.TrimSpace(fmt.Sprintf(format, args...)), "warning: "))
+	}
+	if level < logMinLevel {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !logJSON {
+		log.Print(msg)
+		return
+	}
+	msg = strings.TrimSpace(msg)
+	for _, prefix := range []string{"warning: ", "error: "} {
+		msg = strings.TrimPrefix(msg, prefix)
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	writeJSONField(buf, "level", logLevelNames[level])
+	buf.WriteString(",")
+	writeJSONField(buf, "event", event)
+	buf.WriteString(",")
+	writeJSONField(buf, "msg", msg)
+	var keys []string
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(",")
+		writeJSONField(buf, key, attrs[key])
+	}
+	buf.WriteString("}")
+	jsonLogger.Print(buf.String())
+}
+
+// writeJSONField writes the given key and value as a field of a JSON object.
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	if d, ok := value.(time.Duration); ok {
+		// Durations in milliseconds.
+		value = float64(d) / float64(time.Millisecond)
+	}
+	k, _ := json.Marshal(key)
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(k)
+	buf.WriteString(":")
+	buf.Write(v)
+}
+
+// warnf outputs a warning of the given event kind; the message of the given
+// format and arguments is prefixed by "warning: ".
+func warnf(event string, attrs logAttrs, format string, args ...interface{}) {
+	logf(levelWarn, event, attrs, "warning: "+format, args...)
+}
+
+// logEdges outputs the dependency edges between the types of the module, from
+// the fields of struct types to the named struct and enum types of the fields
+// and their switch-on cases, as debug records.
+func (g *Generator) logEdges() {
+	if logMinLevel > levelDebug {
+		return
+	}
+	for _, s := range g.mod.Structs {
+		for _, f := range s.Fields {
+			deps := f.Type.Deps()
+			for _, c := range f.Cases {
+				deps = append(deps, c.Type.Deps()...)
+			}
+			for _, dep := range deps {
+				logf(levelDebug, "edge", logAttrs{"from": s.Name, "field": f.Name, "to": dep.Name}, "dependency edge: %s.%s -> %s", s.Name, f.Name, dep.Name)
+			}
+		}
+	}
+}
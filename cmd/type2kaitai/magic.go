@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// generateMagic outputs magic(5) rules identifying files of the selected types,
+// based on the fields tagged with magic contents. The rules may be used both
+// by libmagic and as binwalk signatures.
+func (g *Generator) generateMagic() {
+	g.Printf("%s", g.headerComment("# "))
+	for _, s := range g.mod.Structs {
+		offset := int64(0)
+		fixed := true
+		for _, f := range s.Fields {
+			if len(f.OffsetField) > 0 {
+				// Located at offset, rather than inline.
+				continue
+			}
+			if f.Contents != nil {
+				g.Printf("\n")
+				g.Printf("# %s.%s\n", s.Name, f.Name)
+				if !fixed {
+					g.Printf("# TODO: add rule; offset of field %s not fixed\n", f.Name)
+				} else {
+					g.Printf("%d\tstring\t%s\t%s %s\n", offset, magicEscape(f.Contents), g.mod.Package, s.Name)
+				}
+			}
+			size, ok := g.packedSize(f.Type)
+			if !ok {
+				fixed = false
+			}
+			offset += size
+		}
+	}
+}
+
+// magicEscape returns the magic(5) string notation of the given contents,
+// escaping whitespace, backslashes and non-printable characters.
+func magicEscape(buf []byte) string {
+	sb := &strings.Builder{}
+	for _, b := range buf {
+		switch {
+		case b == '\\':
+			sb.WriteString(`\\`)
+		case b > ' ' && b < 0x7f:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(sb, `\x%02x`, b)
+		}
+	}
+	return sb.String()
+}
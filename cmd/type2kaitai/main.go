@@ -5,79 +5,596 @@ import (
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/token"
 	"go/types"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"unicode"
+	"sync"
+	"time"
 
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/load"
+	"github.com/mewrev/tools/internal/structtag"
 	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
-	output    = flag.String("output", "", "output file name; default srcdir/<type>_string.go")
-	buildTags = flag.String("tags", "", "comma-separated list of build tags to apply")
+	typeNames      = flag.String("type", "", "comma-separated list of type names, glob patterns (e.g. Header*) or /regexp/; must be set unless -all-exported")
+	excludeType    = flag.String("exclude-type", "", "comma-separated list of type names or patterns to omit, both as generated types and as field types (e.g. sync.Mutex)")
+	excludeField   = flag.String("exclude-field", "", "comma-separated list of field names or patterns to omit (e.g. Header.cache or *Cache)")
+	allExported    = flag.Bool("all-exported", false, "generate every exported struct type of the package")
+	output         = flag.String("output", "", "output file name; default srcdir/<type>_type.ksy")
+	headerFile     = flag.String("header-file", "", "prepend the contents of the given file (e.g. a license or provenance notice) as comments to the generated files of every format, rather than the \"Code generated\" line; {cmdline} is replaced by the type2kaitai command line")
+	buildTags      = flag.String("tags", "", "comma-separated list of build tags to apply")
+	outputFormat   = flag.String("format", "kaitai", "output format (kaitai, go, proto3, fbs, capnp, rust, c, construct, jsonschema, dot, mermaid or magic), or exec:<command> to run an external backend on the IR of the types")
+	endian         = flag.String("endian", "", "byte order of the binary format (le or be); inferred from the encoding/binary calls of the package if not set, le by default")
+	fbsStructs     = flag.Bool("fbs-structs", false, "emit fixed-size types as FlatBuffers structs rather than tables")
+	flagsAsBits    = flag.Bool("flags-as-bits", false, "emit fields of flag-style enum types as sub-types with one bit field per flag")
+	bitEndian      = flag.String("bit-endian", "", "bit order of the bit fields of -flags-as-bits (le or be); the byte order of the binary format if not set")
+	rustDerive     = flag.String("rust-derive", "binrw", "derive attributes of Rust structs (binrw or deku)")
+	slicePrefix    = flag.String("slice-prefix", "", "unsigned integer type of the length prefix of slices, if any (construct only)")
+	onUnsupported  = flag.String("on-unsupported", "comment", "policy for fields of non-serializable types such as funcs, interfaces and channels (skip, comment or fail)")
+	unexported     = flag.String("unexported", "include", "policy for unexported fields (include, skip or comment)")
+	runtimeFields  = flag.String("runtime-fields", "keep", "policy for runtime-only fields, not set by the Read, Unmarshal, Decode and Parse functions of the package (keep, suggest skipping them, or skip)")
+	writeIfChanged = flag.Bool("write-if-changed", false, "skip writing the output file if its contents are unchanged, preserving its modification time")
+	check          = flag.Bool("check", false, "check that the output file is up to date without writing it; exit with a non-zero status if stale")
+	diffOutput     = flag.Bool("diff", false, "print the added, removed and retyped types, fields and enums of the generated Kaitai spec compared to the output file, without writing it; exit with a non-zero status on differences")
+	strict         = flag.Bool("strict", false, "exit with a non-zero status if any problems are found during generation")
+	genSample      = flag.Bool("gen-sample", false, "also write a sample binary file srcdir/<type>_sample.bin for each type")
+	genTests       = flag.Bool("gen-tests", false, "also write a Go test file <output>_test.go parsing the sample binary files with the generated functions and checking the decoded field values (go only); implies -gen-sample")
+	genFuzz        = flag.Bool("gen-fuzz", false, "also write a Go test file <output>_fuzz_test.go of native fuzz targets of the generated functions, seeded with the sample binary files (go only); implies -gen-sample")
+	gen            = flag.String("gen", "", "comma-separated list of additional Go code to generate for the types of the package; stringer writes the String methods of enum types to srcdir/<type>_string.go, as by x/tools stringer; enumtext writes the MarshalText and UnmarshalText methods of enum types to srcdir/<type>_text.go, using the identifiers of the Kaitai enums; enumjson also writes their MarshalJSON and UnmarshalJSON methods; enumvalues writes the <type>Values function and IsValid method of enum types to srcdir/<type>_values.go")
+	cacheDir       = flag.String("cache-dir", defaultCacheDir(), "directory of the type-check cache of dependencies; off disables the cache")
+	namingFlag     = flag.String("naming", "snake", "naming strategy of type and field identifiers (snake, keep or camel)")
+	rename         = flag.String("rename", "", "comma-separated list of identifier renames of the form Type=id or Type.Field=id")
+	substitute     = flag.String("substitute", "", "comma-separated list of type substitutions of the form OldType=NewType (e.g. Handle=uint32), emitting fields of OldType as if of NewType")
+	compatRaw      = flag.Bool("compat-raw", false, "write Kaitai specs in the unquoted format of earlier versions, byte for byte; may produce invalid YAML")
+	arch           = flag.String("arch", "amd64", "target architecture (amd64, 386, arm64, arm or wasm); determines the size of int, uint and uintptr")
+	matrix         = flag.Bool("matrix", false, "generate the output of each target architecture, with the architecture as suffix of the output file name (e.g. header_type_386.ksy), if the sizes of the types differ by architecture (e.g. of int and uintptr fields); one output otherwise")
+	cgoPaddingFlag = flag.Bool("cgo-padding", false, "pad cgo struct types (C.struct_*) to the field offsets and size of the original C struct layout, as aligned by the C compiler; packed otherwise")
+	opaque         = flag.Bool("opaque", false, "reference types not representable in Kaitai as opaque external types (meta/ks-opaque-types), and write stub implementations srcdir/<id>.{go,py}.tmpl and <Id>.java.tmpl")
+	emitIR         = flag.Bool("emit-ir", false, "output the intermediate representation of the types as JSON rather than the -format output; default output srcdir/<type>_type.ir.json")
+	profileName    = flag.String("profile", "", "generate the Kaitai spec of the header types of a standard library package from a built-in profile (elf, pe or macho), with the enums of its constants and magic signatures; default output ./<profile>.ksy")
+	merge          = flag.Bool("merge", false, "merge the generated Kaitai spec into the existing output file, keeping hand-edits (e.g. doc keys, instances and expressions replacing placeholders) and updating the structure derived from the Go types")
+	docMethods     = flag.Bool("doc-methods", false, "list the exported Go methods of the generated types (e.g. String or Validate) in the doc key of their Kaitai types")
+	constInstances = flag.Bool("const-instances", false, "output the named constants defining array lengths (e.g. [HeaderSize]byte) as value instances of the Kaitai types, referenced by the array fields")
+	enumDocs       = flag.Bool("enum-docs", false, "output the doc comments and original Go identifiers of enum constants as doc and -orig-id keys, using the verbose enum form")
+	logFormat      = flag.String("log", "text", "format of the log output (text or json); json writes one object per line with the level, event kind, message and attributes of each record")
+	verbose        = flag.Bool("v", false, "verbose output; also log the dependency edges between the generated types and the timing of the generation")
+	quiet          = flag.Bool("q", false, "quiet output; only log warnings and errors")
+	pkgFilter      = flag.String("pkg", "", "comma-separated list of import paths, glob patterns (e.g. */format) or /regexp/ selecting the packages to generate among those of the package arguments (e.g. ./...)")
+	loadDir        = flag.String("dir", "", "directory in which to run the go command to load the packages, and to which relative package patterns are resolved (e.g. the root of a module in a monorepo); default current directory")
+	modFlag        = flag.String("mod", "", "module download mode of the go command (readonly, vendor or mod); default vendor if the main module has a vendor directory. The GOFLAGS of the environment apply")
+	gopath         = flag.String("gopath", "", "GOPATH of the go command; default $GOPATH")
+	workfile       = flag.String("workfile", "", "module workspace file of the go command (GOWORK), or off to disable workspaces; default the go.work file of the -dir directory or its parents")
+	interactive    = flag.Bool("interactive", false, "list the struct types of the package on the terminal, prompting for the types to generate, the byte order and the byte order and string mode of their fields; the choices are written to the -config file (default srcdir/type2kaitai.yaml)")
+	configFile     = flag.String("config", "", "read the types, byte order and field options of the given YAML file, as written by -interactive; -type, -all-exported, -endian and the options of struct tags and comment directives take precedence")
+	serveAddr      = flag.String("serve", "", "serve the generated output of each format over HTTP on the given address (e.g. :8080) at the default output file names (e.g. /header_type.ksy), with an HTML view at /; the output is regenerated when the Go source files of the package change")
+	fromIR         = flag.String("from-ir", "", "generate the -format output from the intermediate representation in the given JSON file (as written by -emit-ir) rather than from Go source; the byte order and architecture of the file apply")
+	reportFile     = flag.String("report", "", "write a JSON summary of the generation (exit status, generated types, fields of unsupported types, problems, warnings and written files) to the given file on exit")
 )
 
 // Usage is a replacement usage function for the flags package.
 func Usage() {
-	fmt.Fprintf(os.Stderr, "Usage of enum2kaitai:\n")
-	fmt.Fprintf(os.Stderr, "\tenum2kaitai [flags] -type T [directory]\n")
-	fmt.Fprintf(os.Stderr, "\tenum2kaitai [flags] -type T files... # Must be a single package\n")
+	fmt.Fprintf(os.Stderr, "Usage of type2kaitai:\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -type T packages... # e.g. ./...; one output file per package\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -type T importpath # e.g. github.com/foo/bar/format; output to the package directory\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -type T files... # Must be a single package\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -interactive [directory] # choices written to -config\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -config type2kaitai.yaml [directory]\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -serve :8080 -type T [directory] # e.g. import http://localhost:8080/t_type.ksy into the Kaitai Web IDE\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -from-ir file.ir.json\n")
+	fmt.Fprintf(os.Stderr, "\ttype2kaitai [flags] -profile elf|pe|macho\n")
 	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Exit status:\n")
+	fmt.Fprintf(os.Stderr, "\t0 output generated\n")
+	fmt.Fprintf(os.Stderr, "\t1 errors; e.g. no matching types, stale output with -check or -diff, fields not supported by -format go, or problems with -strict\n")
+	fmt.Fprintf(os.Stderr, "\t2 invalid command line\n")
+	fmt.Fprintf(os.Stderr, "\t3 output generated with problems; may be incomplete\n")
 }
 
 func main() {
 	log.SetFlags(0)
-	log.SetPrefix("enum2kaitai: ")
+	log.SetPrefix("type2kaitai: ")
 	flag.Usage = Usage
 	flag.Parse()
-	if len(*typeNames) == 0 {
+	setLogOptions(*logFormat, *verbose, *quiet)
+	if len(*typeNames) == 0 && !*allExported && len(*fromIR) == 0 && len(*profileName) == 0 && !*interactive && len(*configFile) == 0 {
 		flag.Usage()
-		os.Exit(2)
+		exit(exitUsage)
+	}
+	patterns := splitList(*typeNames)
+	ext, ok := formatExts[*outputFormat]
+	if strings.HasPrefix(*outputFormat, execPrefix) {
+		ext, ok = execExt(*outputFormat), true
+	}
+	if !ok {
+		usagef("unsupported output format %q", *outputFormat)
+	}
+	if *emitIR {
+		ext = ".ir.json"
+	}
+	if *genTests || *genFuzz {
+		if *outputFormat != "go" || *emitIR {
+			usagef("-gen-tests and -gen-fuzz options apply only to Go output, without -emit-ir")
+		}
+		*genSample = true
+	}
+	for _, name := range splitList(*gen) {
+		if !validGen(name) {
+			usagef("unsupported generator %q of -gen option; valid options: %s", name, strings.Join(genNames, ", "))
+		}
+	}
+	if *diffOutput && (*outputFormat != "kaitai" || *emitIR) {
+		usagef("-diff option applies only to Kaitai specs, without -emit-ir")
+	}
+	if *docMethods && (*outputFormat != "kaitai" || *emitIR || *compatRaw) {
+		usagef("-doc-methods option applies only to Kaitai specs, without -emit-ir or -compat-raw")
+	}
+	if *constInstances && (*outputFormat != "kaitai" || *emitIR) {
+		usagef("-const-instances option applies only to Kaitai specs, without -emit-ir")
+	}
+	if *merge && (*outputFormat != "kaitai" || *emitIR || *compatRaw) {
+		usagef("-merge option applies only to Kaitai specs, without -emit-ir or -compat-raw")
+	}
+	if len(*endian) > 0 && *endian != "le" && *endian != "be" {
+		usagef("unsupported endianness %q; valid options: le, be", *endian)
+	}
+	if len(*bitEndian) > 0 && *bitEndian != "le" && *bitEndian != "be" {
+		usagef("unsupported bit endianness %q; valid options: le, be", *bitEndian)
+	}
+	switch *modFlag {
+	case "", "readonly", "vendor", "mod":
+		// valid module download mode.
+	default:
+		usagef("unsupported module download mode %q; valid options: readonly, vendor, mod", *modFlag)
+	}
+	switch *onUnsupported {
+	case unsupportedSkip, unsupportedComment, unsupportedFail:
+		// valid policy.
+	default:
+		usagef("unsupported policy %q; valid options: skip, comment, fail", *onUnsupported)
+	}
+	switch *unexported {
+	case unexportedInclude, unexportedSkip, unexportedComment:
+		// valid policy.
+	default:
+		usagef("unsupported unexported field policy %q; valid options: include, skip, comment", *unexported)
+	}
+	switch *runtimeFields {
+	case runtimeKeep, runtimeSuggest, runtimeSkip:
+		// valid policy.
+	default:
+		usagef("unsupported runtime-only field policy %q; valid options: keep, suggest, skip", *runtimeFields)
+	}
+	switch *namingFlag {
+	case namingSnake, namingKeep, namingCamel:
+		// valid naming strategy.
+	default:
+		usagef("unsupported naming strategy %q; valid options: snake, keep, camel", *namingFlag)
+	}
+	if *namingFlag != namingSnake && *outputFormat == "kaitai" {
+		warnf("naming", nil, "Kaitai identifiers must be snake_case; -naming %s may produce an invalid spec", *namingFlag)
+	}
+	renames, err := parseRenames(*rename)
+	if err != nil {
+		usagef("%v", err)
+	}
+	if _, err := parseSubstitutes(*substitute); err != nil {
+		usagef("%v", err)
+	}
+	if *interactive && (len(*fromIR) > 0 || len(*profileName) > 0 || *check || *diffOutput) {
+		usagef("-interactive option cannot be combined with -from-ir, -profile, -check or -diff")
+	}
+	if len(*serveAddr) > 0 && (len(*fromIR) > 0 || *interactive || *check || *diffOutput || *merge || *genSample || len(*gen) > 0) {
+		usagef("-serve option cannot be combined with -from-ir, -interactive, -check, -diff, -merge, -gen, -gen-sample, -gen-tests or -gen-fuzz")
+	}
+	if *matrix && (len(*fromIR) > 0 || len(*profileName) > 0 || *interactive || len(*serveAddr) > 0 || *merge || *genSample || *genTests || *genFuzz || len(*gen) > 0) {
+		usagef("-matrix option cannot be combined with -from-ir, -profile, -interactive, -serve, -merge, -gen, -gen-sample, -gen-tests or -gen-fuzz")
+	}
+	if *matrix && isFlagSet("arch") {
+		usagef("-matrix option cannot be combined with -arch")
+	}
+	var cfg *config
+	switch {
+	case *interactive:
+		cfg = &config{}
+		if len(*configFile) > 0 {
+			if _, err := os.Stat(*configFile); err == nil {
+				// Choices of an earlier session as defaults.
+				cfg = readConfig(*configFile)
+			}
+		}
+	case len(*configFile) > 0:
+		cfg = readConfig(*configFile)
+		if len(*typeNames) == 0 && !*allExported {
+			patterns = cfg.Types
+		}
+	}
+	if len(*fromIR) > 0 {
+		if len(*typeNames) > 0 || *allExported || flag.NArg() > 0 {
+			usagef("-from-ir option cannot be combined with -type, -all-exported or package arguments")
+		}
+		if cfg != nil {
+			usagef("-config option requires Go source; not supported with -from-ir")
+		}
+		if *genSample || *genTests || *genFuzz || len(*gen) > 0 {
+			usagef("-gen, -gen-sample, -gen-tests and -gen-fuzz options require Go source; not supported with -from-ir")
+		}
+		g := newGenerator(renames, cfg)
+		ok := g.runIR(*fromIR, ext)
+		g.recordPackage()
+		exit(exitStatus(true, ok, g))
 	}
-	types := strings.Split(*typeNames, ",")
 	var tags []string
 	if len(*buildTags) > 0 {
 		tags = strings.Split(*buildTags, ",")
 	}
 
-	// We accept either one directory or a list of files. Which do we have?
+	// We accept either package patterns (e.g. one directory or ./...) or a list
+	// of files. Which do we have?
 	args := flag.Args()
 	if len(args) == 0 {
 		// Default: process whole package in current directory.
 		args = []string{"."}
 	}
+	if len(*profileName) > 0 {
+		if len(*typeNames) > 0 || *allExported || flag.NArg() > 0 {
+			usagef("-profile option cannot be combined with -type, -all-exported or package arguments")
+		}
+		p := lookupProfile(*profileName)
+		args = []string{p.pkg}
+		patterns = p.types
+		*endian = p.endian
+		if len(*output) == 0 {
+			*output = *profileName + ext
+		}
+	}
+	if len(tags) != 0 && strings.HasSuffix(args[0], ".go") {
+		usagef("-tags option applies only to package patterns, not when files are specified")
+	}
 
-	// Parse the package once.
-	var dir string
-	g := Generator{namedTypeDeps: make(map[string]bool)}
-	// TODO(suzmue): accept other patterns for packages (directories, list of files, import paths, etc).
-	if len(args) == 1 && isDirectory(args[0]) {
-		dir = args[0]
+	// Parse the packages once.
+	loadCfg := &load.Config{
+		Tags:   tags,
+		Arch:   *arch,
+		Dir:    *loadDir,
+		Mod:    *modFlag,
+		GOPATH: *gopath,
+		GOWork: *workfile,
+	}
+	start := time.Now()
+	var pkgs []*packages.Package
+	if *cacheDir == "off" {
+		pkgs = loadPackages(args, loadCfg)
 	} else {
-		if len(tags) != 0 {
-			log.Fatal("-tags option applies only to directories, not when files are specified")
+		pkgs = loadCachedPackages(*cacheDir, args, loadCfg)
+	}
+	elapsed := time.Since(start)
+	logf(levelDebug, "timing", logAttrs{"phase": "load", "packages": len(pkgs), "elapsed": elapsed}, "loaded %d packages in %v", len(pkgs), elapsed)
+	pkgs = filterPackages(pkgs, splitList(*pkgFilter))
+	if len(*serveAddr) > 0 {
+		if len(pkgs) != 1 {
+			usagef("-serve option applies only to a single package")
+		}
+		serve(*serveAddr, pkgs[0], args, loadCfg, patterns, renames, cfg)
+	}
+	if *matrix {
+		if len(pkgs) != 1 {
+			usagef("-matrix option applies only to a single package")
+		}
+		generated, ok, gens := runMatrix(args, loadCfg, patterns, renames, cfg, ext)
+		if generated {
+			for _, g := range gens {
+				g.recordPackage()
+			}
+		}
+		exit(exitStatus(generated, ok, gens...))
+	}
+	if len(pkgs) == 1 {
+		g := newGenerator(renames, cfg)
+		g.addPackage(pkgs[0])
+		dir := g.pkgDir()
+		switch {
+		case g.profile != nil:
+			// Write to the current directory rather than the standard
+			// library.
+			dir = "."
+		case len(args) == 1 && isDirectory(argPath(args[0])):
+			dir = argPath(args[0])
+		}
+		if *interactive {
+			g.interactive(os.Stdin, os.Stderr, cfg, patterns)
+			configName := *configFile
+			if len(configName) == 0 {
+				configName = filepath.Join(dir, "type2kaitai.yaml")
+			}
+			writeConfig(configName, cfg)
+			patterns = cfg.Types
+		}
+		generated, ok := g.run(dir, patterns, ext)
+		if generated {
+			g.recordPackage()
+		}
+		exit(exitStatus(generated, ok, g))
+	}
+
+	// Batch mode: generate the output of each package in parallel, writing one
+	// output file per package with matching types to the package directory.
+	if len(*output) > 0 {
+		usagef("-output option applies only to a single package")
+	}
+	if *interactive {
+		usagef("-interactive option applies only to a single package")
+	}
+	type result struct {
+		generated bool
+		ok        bool
+		g         *Generator
+	}
+	results := make([]result, len(pkgs))
+	var wg sync.WaitGroup
+	for i, pkg := range pkgs {
+		wg.Add(1)
+		go func(i int, pkg *packages.Package) {
+			defer wg.Done()
+			g := newGenerator(renames, cfg)
+			g.batch = true
+			g.addPackage(pkg)
+			generated, ok := g.run(g.pkgDir(), patterns, ext)
+			if generated {
+				g.recordPackage()
+			}
+			results[i] = result{generated: generated, ok: ok, g: g}
+		}(i, pkg)
+	}
+	wg.Wait()
+	generated, ok := false, true
+	var gens []*Generator
+	for _, res := range results {
+		if res.generated {
+			generated = true
+		}
+		if !res.ok {
+			ok = false
+		}
+		gens = append(gens, res.g)
+	}
+	if !generated {
+		logf(levelError, "no_types", logAttrs{"packages": len(pkgs)}, "no matching types found in %d packages", len(pkgs))
+	}
+	exit(exitStatus(generated, ok, gens...))
+}
+
+// newGenerator returns a new generator configured by the command line flags
+// and the given -config file, if any.
+func newGenerator(renames map[string]string, cfg *config) *Generator {
+	g := &Generator{
+		namedTypeDeps: make(map[string]bool),
+		arch:          *arch,
+		sizes:         archSizes(*arch),
+		endian:        *endian,
+		bitEndian:     *bitEndian,
+		fbsStructs:    *fbsStructs,
+		rustDerive:    *rustDerive,
+		flagsAsBits:   *flagsAsBits,
+		slicePrefix:   *slicePrefix,
+		onUnsupported: *onUnsupported,
+		unexported:    *unexported,
+		runtimeFields: *runtimeFields,
+		compatRaw:     *compatRaw,
+		opaque:        *opaque,
+		cgoPadding:    *cgoPaddingFlag,
+		profile:       profiles[*profileName],
+		naming:        *namingFlag,
+		docMethods:    *docMethods,
+		constInsts:    *constInstances,
+		enumDocs:      *enumDocs,
+		header:        readHeaderFile(*headerFile),
+		renames:       renames,
+		config:        cfg,
+	}
+	if len(g.endian) == 0 && cfg != nil {
+		g.endian = cfg.Endian
+	}
+	// Substitutions of -substitute take precedence over the -config file.
+	g.substitutes = make(map[string]string)
+	if cfg != nil {
+		for oldType, newType := range cfg.Substitute {
+			g.substitutes[oldType] = newType
+		}
+	}
+	substitutes, _ := parseSubstitutes(*substitute)
+	for oldType, newType := range substitutes {
+		g.substitutes[oldType] = newType
+	}
+	return g
+}
+
+// run generates the output of the types of the package selected by the given
+// -type patterns, and writes it to the given directory (or -output). run
+// reports whether any types were selected, and whether generation succeeded
+// (i.e. the output is up to date with -check, and no problems were found with
+// -strict).
+func (g *Generator) run(dir string, patterns []string, ext string) (generated, ok bool) {
+	defined, types := g.analyzePackage(patterns)
+	if len(defined) == 0 {
+		return false, !g.reportErrors()
+	}
+	return true, g.write(dir, defined, types, ext)
+}
+
+// write generates the output of the given analyzed types, and writes it to the
+// given directory (or -output), with the -matrix architecture suffix of the
+// generator, if any; see analyzePackage for the defined and analyzed types.
+// write reports whether generation succeeded.
+func (g *Generator) write(dir string, defined, types []string, ext string) bool {
+	outputName := *output
+	if outputName == "" {
+		outputName = filepath.Join(dir, g.outputBaseName(types, ext))
+	}
+	if len(g.archSuffix) > 0 {
+		outputName = archOutputName(outputName, ext, g.archSuffix)
+	}
+	if *merge {
+		g.merge = outputName
+	}
+	start := time.Now()
+	g.generateOutput()
+	elapsed := time.Since(start)
+	logf(levelDebug, "timing", logAttrs{"phase": "generate", "package": g.pkg.path, "elapsed": elapsed}, "generated output of package %s in %v", g.pkg.path, elapsed)
+
+	// Write to file.
+	switch {
+	case *check:
+		return g.checkUpToDate(outputName)
+	case *diffOutput:
+		return g.diffUpToDate(outputName)
+	}
+	if err := writeOutput(outputName, g.buf.Bytes(), *writeIfChanged); err != nil {
+		fatalf("writing output: %s", err)
+	}
+
+	// Write sample binary files.
+	if *genSample {
+		g.writeSamples(dir, types)
+	}
+	if *genTests {
+		g.writeTests(dir, outputName, defined)
+	}
+	if *genFuzz {
+		g.writeFuzzTests(dir, outputName, defined)
+	}
+
+	// Write additional Go code of the package.
+	if genEnabled(genStringer) {
+		g.writeStringers(dir)
+	}
+	if genEnabled(genEnumText) || genEnabled(genEnumJSON) {
+		g.writeEnumTexts(dir, genEnabled(genEnumJSON))
+	}
+	if genEnabled(genEnumValues) {
+		g.writeEnumValues(dir)
+	}
+
+	// Write stub implementations of opaque types.
+	g.writeOpaqueStubs(dir)
+
+	// Display problems.
+	failed := g.reportErrors() && *strict || g.failed
+	return !failed
+}
+
+// analyzePackage analyzes the types of the package selected by the given -type
+// patterns, and records their IR in g.mod. analyzePackage returns the selected
+// types defined in the package, and the analyzed types; i.e. the selected types
+// and the cgo struct types of their fields. No types are analyzed if none of
+// the selected types is defined.
+func (g *Generator) analyzePackage(patterns []string) (defined, types []string) {
+	g.parseDirectives()
+	g.excludeFields(splitList(*excludeType), splitList(*excludeField))
+	g.resolveSubstitutes()
+
+	// Skip type names not defined in the package.
+	for _, typeName := range g.selectTypes(patterns, *allExported) {
+		if g.batch && !g.defines(typeName) {
+			// Packages without the type are skipped silently in batch mode.
+			continue
 		}
-		dir = filepath.Dir(args[0])
+		if g.lookupType(typeName) != nil {
+			defined = append(defined, typeName)
+		}
+	}
+	if len(defined) == 0 {
+		return nil, nil
+	}
+	types = append(defined, g.cgoStructs(defined)...)
+	g.excludeRuntimeFields(types)
+	if len(g.endian) == 0 {
+		g.endian = g.inferEndian(types)
+	}
+	g.checkCollisions(types)
+	g.cycles = g.typeCycles(types)
+	start := time.Now()
+	g.mod = g.analyze(types)
+	elapsed := time.Since(start)
+	if len(g.archField) > 0 && !*matrix && !isFlagSet("arch") {
+		warnf("arch", logAttrs{"field": g.archField, "arch": g.arch}, "%s: size of field %s depends on the target architecture; using -arch %s (use -matrix to generate the output of each architecture)", g.archFieldPos, g.archField, g.arch)
 	}
+	logf(levelDebug, "timing", logAttrs{"phase": "analyze", "package": g.pkg.path, "elapsed": elapsed}, "analyzed %d types of package %s in %v", len(g.mod.Structs)+len(g.mod.Enums), g.pkg.path, elapsed)
+	g.applyProfile(g.mod)
+	return defined, types
+}
 
-	g.parsePackage(args, tags)
+// outputBaseName returns the default base name of the output file of the given
+// types with the given file extension; e.g. header_type.ksy, or
+// <pkg>_types.ksy with -all-exported.
+func (g *Generator) outputBaseName(types []string, ext string) string {
+	baseName := fmt.Sprintf("%s_type%s", types[0], ext)
+	if *allExported {
+		baseName = fmt.Sprintf("%s_types%s", g.pkg.name, ext)
+	}
+	return strings.ToLower(baseName)
+}
 
-	// Print the header and package clause.
-	g.Printf("# Code generated by \"enum2kaitai %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
-	g.Printf("\n")
+// generateOutput outputs the IR of the selected types as JSON with -emit-ir,
+// and the -format output otherwise.
+func (g *Generator) generateOutput() {
+	if *emitIR {
+		g.generateIR()
+	} else {
+		g.generateFormat(*outputFormat)
+	}
+	g.reportOutput()
+}
 
-	// Run generate for each type.
-	g.Printf("types:\n")
-	for _, typeName := range types {
-		g.generate(typeName)
+// generateFormat outputs the selected types in the given output format.
+func (g *Generator) generateFormat(format string) {
+	switch {
+	case strings.HasPrefix(format, execPrefix):
+		g.generateExec()
+	case format == "kaitai":
+		g.generateKaitai()
+	case format == "go":
+		g.generateGo()
+	case format == "proto3":
+		g.generateProto()
+	case format == "fbs":
+		g.generateFlatBuffers()
+	case format == "capnp":
+		g.generateCapnp()
+	case format == "rust":
+		g.generateRust()
+	case format == "c":
+		g.generateC()
+	case format == "construct":
+		g.generateConstruct()
+	case format == "jsonschema":
+		g.generateJSONSchema()
+	case format == "dot":
+		g.generateDot()
+	case format == "mermaid":
+		g.generateMermaid()
+	case format == "magic":
+		g.generateMagic()
 	}
+}
+
+// reportOutput displays the skipped fields, dependency edges and named type
+// dependencies of the generated output.
+func (g *Generator) reportOutput() {
+	// Display skipped fields.
+	g.reportSkipped()
+
+	// Display dependency edges between the types, with -v.
+	g.logEdges()
 
 	// Display named type dependencies.
 	var namedTypeDeps []string
@@ -86,39 +603,206 @@ func main() {
 	}
 	sort.Strings(namedTypeDeps)
 	for _, namedTypeDep := range namedTypeDeps {
-		log.Println("depends on named Go type:", namedTypeDep)
+		logf(levelInfo, "dependency", logAttrs{"name": namedTypeDep}, "depends on named Go type: %s", namedTypeDep)
 	}
+}
 
-	// Get output.
-	src := g.buf.Bytes()
+// checkUpToDate displays the problems found during generation, and reports
+// whether the named output file is up to date with the generated output (and
+// no problems were found with -strict).
+func (g *Generator) checkUpToDate(outputName string) bool {
+	failed := g.reportErrors() && *strict || g.failed
+	if !checkOutput(outputName, g.buf.Bytes()) {
+		logf(levelWarn, "stale", logAttrs{"file": outputName}, "%s is out of date", outputName)
+		failed = true
+	}
+	return !failed
+}
 
-	// Write to file.
-	outputName := *output
-	if outputName == "" {
-		baseName := fmt.Sprintf("%s_type.ksy", types[0])
-		outputName = filepath.Join(dir, strings.ToLower(baseName))
+// splitList returns the elements of the given comma-separated list.
+func splitList(s string) []string {
+	if len(s) == 0 {
+		return nil
 	}
-	err := ioutil.WriteFile(outputName, src, 0644)
-	if err != nil {
-		log.Fatalf("writing output: %s", err)
+	return strings.Split(s, ",")
+}
+
+// formatExts maps from supported output format to output file extension.
+var formatExts = map[string]string{
+	"kaitai":     ".ksy",
+	"go":         ".go",
+	"proto3":     ".proto",
+	"fbs":        ".fbs",
+	"capnp":      ".capnp",
+	"rust":       ".rs",
+	"c":          ".h",
+	"construct":  ".py",
+	"jsonschema": ".schema.json",
+	"dot":        ".dot",
+	"mermaid":    ".mmd",
+	"magic":      ".magic",
+}
+
+// argPath returns the path of the given command line argument, relative to the
+// -dir directory of the go command, if set.
+func argPath(arg string) string {
+	if len(*loadDir) == 0 || filepath.IsAbs(arg) {
+		return arg
 	}
+	return filepath.Join(*loadDir, arg)
 }
 
-// isDirectory reports whether the named file is a directory.
+// isDirectory reports whether the named file is a directory. Arguments not
+// present in the file system are import paths (e.g. github.com/foo/bar/format).
 func isDirectory(name string) bool {
 	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return false
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	return info.IsDir()
 }
 
+// filterPackages returns the packages with import paths matching the given
+// -pkg patterns; import paths, glob patterns (e.g. */format) or regular
+// expressions enclosed in slashes. filterPackages exits if no package matches.
+func filterPackages(pkgs []*packages.Package, patterns []string) []*packages.Package {
+	if len(patterns) == 0 {
+		return pkgs
+	}
+	var matchers []func(name string) bool
+	for _, pattern := range patterns {
+		match, err := typeMatcher(pattern)
+		if err != nil {
+			usagef("invalid package pattern %q; %v", pattern, err)
+		}
+		if match == nil {
+			importPath := pattern
+			match = func(s string) bool { return s == importPath }
+		}
+		matchers = append(matchers, match)
+	}
+	var filtered []*packages.Package
+	for _, pkg := range pkgs {
+		if matchAny(matchers, pkg.PkgPath) {
+			filtered = append(filtered, pkg)
+		}
+	}
+	if len(filtered) == 0 {
+		fatalf("error: none of %d packages match -pkg %s", len(pkgs), strings.Join(patterns, ","))
+	}
+	return filtered
+}
+
 // Generator holds the state of the analysis. Primarily used to buffer
 // the output for format.Source.
 type Generator struct {
 	buf           bytes.Buffer // Accumulated output.
 	pkg           *Package     // Package we are scanning.
 	namedTypeDeps map[string]bool
+	arch          string      // Target architecture.
+	sizes         types.Sizes // Type sizes of the target architecture.
+	archSuffix    string      // Suffix of the output file name with -matrix; e.g. _386.
+	archField     string      // First field whose size depends on the target architecture; see archDependent.
+	archFieldPos  string      // Position of archField.
+	endian        string      // Byte order of the binary format; le or be, or empty to infer.
+	bitEndian     string      // Bit order of bit fields; le or be, or empty to follow endian.
+	fbsStructs    bool        // Emit fixed-size types as FlatBuffers structs.
+	rustDerive    string      // Derive attributes of Rust structs; binrw or deku.
+	slicePrefix   string      // Unsigned integer type of slice length prefixes.
+	onUnsupported string      // Policy for fields of non-serializable types.
+	unexported    string      // Policy for unexported fields.
+	runtimeFields string      // Policy for runtime-only fields.
+	compatRaw     bool        // Write Kaitai specs in the legacy unquoted format.
+	opaque        bool        // Reference unrepresentable types as opaque types.
+	cgoPadding    bool        // Pad cgo struct types to the C struct layout.
+	profile       *profile    // Built-in profile of standard library types.
+	naming        string      // Naming strategy of identifiers.
+	docMethods    bool        // List the Go methods of types in their doc key.
+	constInsts    bool        // Output array length constants as instances.
+	enumDocs      bool        // Output doc comments and Go names of enum values.
+	header        string      // Header of generated files, as given by -header-file.
+	// cycles maps from recursive struct types to a description of their
+	// cycle.
+	cycles map[*types.Named]string
+	// mod holds the IR of the generated types, as consumed by the backends.
+	mod *ir.Module
+	// renames maps from Go type names (Type) and field names (Type.Field) to
+	// identifiers in the generated output, overriding the naming strategy.
+	renames map[string]string
+	// fieldIDs maps from field names (Type.Field) to the identifiers of their
+	// id directives, overriding the naming strategy unless renamed.
+	fieldIDs map[string]string
+	// substitutes maps from Go type names to the Go type expressions of their
+	// substitutes, as given by -substitute and the -config file; see
+	// resolveSubstitutes.
+	substitutes map[string]string
+	// substTypes maps from Go type names to their resolved substitutes.
+	substTypes map[string]types.Type
+	// complexTypes tracks the complex number types referenced by the generated
+	// types, keyed by size in bytes, each of which is output once as a
+	// two-field sub-type.
+	complexTypes map[int64]bool
+	// flagTypes tracks the flag-style enum types referenced by the generated
+	// types, in order of discovery, each of which is output once as a bit field
+	// sub-type if -flags-as-bits is set.
+	flagTypes   []*ir.EnumDef
+	flagsAsBits bool
+	// anonTypes tracks the synthetic types of unnamed struct types output by
+	// the generated types; see generateAnonTypes.
+	anonTypes map[string]bool
+	// opaqueTypes tracks the opaque external types referenced by the generated
+	// types if -opaque is set, in order of discovery.
+	opaqueTypes []string
+	// endianVariants tracks the byte order variants of the struct types
+	// referenced by the generated types, in order of discovery; see variantID.
+	endianVariants []*endianVariant
+	// typeEndian specifies the byte order of the variant being generated, if
+	// any.
+	typeEndian string
+	// attrEndian specifies the byte order of the struct types referenced by
+	// the attribute being generated; see tagSpec.
+	attrEndian string
+	// imports tracks the specs of the Kaitai format library imported by the
+	// generated types (e.g. /common/vlq_base128_le of varint fields).
+	imports map[string]bool
+	// skipped tracks the fields of non-serializable types skipped by the
+	// -on-unsupported policy, in order of discovery.
+	skipped []skippedField
+	// errs records the problems encountered during generation.
+	errs []string
+	// excluded tracks the struct fields omitted by -exclude-type,
+	// -exclude-field and the //kaitai:skip directive.
+	excluded map[*types.Var]bool
+	// excludeTypes holds the type name matchers of -exclude-type.
+	excludeTypes []func(name string) bool
+	// typeDirectives maps from type names to the options of the //kaitai:
+	// comment directives of the type declarations; see parseDirectives.
+	typeDirectives map[string]structtag.Tag
+	// fieldDirectives maps from struct fields to the options of their
+	// //kaitai: comment directives.
+	fieldDirectives map[*types.Var]structtag.Tag
+	// fieldOrders maps from struct types to the indices of their fields in the
+	// order of the binary format, as given by the //kaitai:order directive of
+	// the type declaration.
+	fieldOrders map[*types.Struct][]int
+	// config holds the choices of the -config file or -interactive session, if
+	// any.
+	config *config
+	// configOptions maps from struct fields to their kaitai options of the
+	// -config file; see parseConfigOptions.
+	configOptions map[*types.Var]structtag.Tag
+	// merge specifies the existing Kaitai spec merged into the generated
+	// output with -merge; empty if not set.
+	merge string
+	// failed specifies whether a field was rejected by the fail policy of
+	// -on-unsupported.
+	failed bool
+	// batch specifies whether the package is one of several processed in
+	// batch mode, in which case packages without matching types are skipped.
+	batch bool
 }
 
 func (g *Generator) Printf(format string, args ...interface{}) {
@@ -135,32 +819,36 @@ type File struct {
 
 type Package struct {
 	name  string
+	path  string
+	fset  *token.FileSet
 	defs  map[*ast.Ident]types.Object
+	types *types.Package
+	info  *types.Info
 	files []*File
 }
 
-// parsePackage analyzes the single package constructed from the patterns and tags.
-// parsePackage exits if there is an error.
-func (g *Generator) parsePackage(patterns []string, tags []string) {
-	cfg := &packages.Config{
-		Mode:       packages.LoadSyntax,
-		BuildFlags: []string{fmt.Sprintf("-tags=%s", strings.Join(tags, " "))},
-	}
-	pkgs, err := packages.Load(cfg, patterns...)
+// loadPackages loads the packages constructed from the patterns, as
+// configured. loadPackages exits if there is an error.
+func loadPackages(patterns []string, cfg *load.Config) []*packages.Package {
+	pkgs, err := load.Load(cfg, patterns...)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	if len(pkgs) != 1 {
-		log.Fatalf("error: %d packages found", len(pkgs))
+	if len(pkgs) == 0 {
+		fatalf("error: no packages found")
 	}
-	g.addPackage(pkgs[0])
+	return pkgs
 }
 
 // addPackage adds a type checked Package and its syntax files to the generator.
 func (g *Generator) addPackage(pkg *packages.Package) {
 	g.pkg = &Package{
 		name: pkg.Name,
+		path: pkg.PkgPath,
+		fset: pkg.Fset,
 		//defs:  pkg.TypesInfo.Defs,
+		info:  pkg.TypesInfo,
+		types: pkg.Types,
 		files: make([]*File, len(pkg.Syntax)),
 	}
 
@@ -170,6 +858,11 @@ func (g *Generator) addPackage(pkg *packages.Package) {
 			pkg:  g.pkg,
 		}
 	}
+	// Order files by name, so that declarations spread across files (e.g.
+	// instance methods) are output in a stable order.
+	sort.SliceStable(g.pkg.files, func(i, j int) bool {
+		return g.position(g.pkg.files[i].file.Package).Filename < g.position(g.pkg.files[j].file.Package).Filename
+	})
 	topLevelDefs := make(map[*ast.Ident]types.Object)
 	for _, file := range g.pkg.files {
 		for _, decl := range file.file.Decls {
@@ -180,7 +873,7 @@ func (g *Generator) addPackage(pkg *packages.Package) {
 					case *ast.TypeSpec:
 						def, ok := pkg.TypesInfo.Defs[spec.Name]
 						if !ok {
-							log.Fatalf("unable to locate top-level definition of type %q", spec.Name)
+							fatalf("%s: unable to locate top-level definition of type %q", pkg.Fset.Position(spec.Name.Pos()), spec.Name)
 						}
 						topLevelDefs[spec.Name] = def
 					}
@@ -191,148 +884,361 @@ func (g *Generator) addPackage(pkg *packages.Package) {
 	g.pkg.defs = topLevelDefs
 }
 
-// generate produces the String method for the named type.
-func (g *Generator) generate(typeName string) {
-	var underlying types.Type
-	for ident, def := range g.pkg.defs {
-		if ident.Name == typeName {
-			underlying = def.Type().Underlying()
-			break
+// generate adds the Kaitai type of the given named type to the given mapping
+// of Kaitai types.
+func (g *Generator) generate(kaiTypes *yaml.Node, t *ir.Type) {
+	s := g.mod.Struct(t)
+	if !t.IsNamedStruct() || s == nil {
+		g.errorAt(t.Pos, "type %s: support for underlying type %s not yet implemented", t.Name, t.Under().Go)
+		return
+	}
+	logf(levelInfo, "type", logAttrs{"id": s.ID, "name": s.Name}, "generating type: %q", s.ID)
+	spec := ksyMap()
+	key := ksyAdd(kaiTypes, s.ID, spec)
+	if len(s.Recursive) > 0 {
+		key.HeadComment = ksyComment("recursive type; " + s.Recursive)
+	}
+	g.generateSpec(kaiTypes, spec, s)
+}
+
+// generateSpec adds the attributes of the given struct type to the given
+// Kaitai type specification, and the synthetic types of its unnamed struct
+// types to the given mapping of Kaitai types.
+func (g *Generator) generateSpec(kaiTypes, spec *yaml.Node, s *ir.StructDef) {
+	if g.docMethods && len(s.Methods) > 0 {
+		ksyAdd(spec, "doc", methodsDoc(s))
+	}
+	if len(s.Representation) > 0 {
+		ksyAdd(spec, "-webide-representation", ksyValue(s.Representation, ""))
+	}
+	g.generateParams(spec, s)
+	seq := ksySeq()
+	seqKey := ksyAdd(spec, "seq", seq)
+	seqKey.FootComment = g.generateType(seq, s)
+	instances := ksyMap()
+	g.constInstances(instances, s)
+	g.offsetInstances(instances, s)
+	g.generateInstances(instances, s)
+	if len(instances.Content) > 0 {
+		ksyAdd(spec, "instances", instances)
+	}
+	g.generateAnonTypes(kaiTypes, s)
+}
+
+// generateAnonTypes adds the synthetic types of the unnamed struct types of the
+// fields of the given struct type to the given mapping of Kaitai types,
+// following the struct type; e.g. header__info of the field Info of Header.
+// Synthetic types shared by structurally identical unnamed struct types are
+// output once.
+func (g *Generator) generateAnonTypes(kaiTypes *yaml.Node, s *ir.StructDef) {
+	for _, f := range s.Fields {
+		t := f.Type
+		for (t.Kind == ir.Array || t.Kind == ir.Slice || t.Kind == ir.Pointer) && t.Elem != nil {
+			t = t.Elem
 		}
+		if t.Kind != ir.Struct || len(t.ID) == 0 || g.anonTypes[t.ID] {
+			continue
+		}
+		if g.anonTypes == nil {
+			g.anonTypes = make(map[string]bool)
+		}
+		g.anonTypes[t.ID] = true
+		anon := &ir.StructDef{
+			Name:    s.Name + "." + f.Name,
+			Package: s.Package,
+			ID:      t.ID,
+			Pos:     f.Pos,
+			Fields:  t.Fields,
+		}
+		spec := ksyMap()
+		ksyAdd(kaiTypes, anon.ID, spec)
+		seq := ksySeq()
+		seqKey := ksyAdd(spec, "seq", seq)
+		seqKey.FootComment = g.generateType(seq, anon)
+		instances := ksyMap()
+		g.constInstances(instances, anon)
+		if len(instances.Content) > 0 {
+			ksyAdd(spec, "instances", instances)
+		}
+		g.generateAnonTypes(kaiTypes, anon)
 	}
-	if underlying == nil {
-		log.Fatalf("unable to locate type definition of type name %q", typeName)
+}
+
+// generateComplexTypes adds the sub-types of the complex number types
+// referenced by the generated types to the given mapping of Kaitai types; each
+// consisting of a real and an imaginary part.
+func (g *Generator) generateComplexTypes(kaiTypes *yaml.Node) {
+	for _, size := range []int64{8, 16} {
+		if !g.complexTypes[size] {
+			continue
+		}
+		part := &ir.Type{Kind: ir.Float, Go: fmt.Sprintf("float%d", 4*size), Size: size / 2}
+		seq := ksySeq()
+		for _, id := range []string{"real", "imag"} {
+			attr := ksyMap()
+			ksyAdd(attr, "id", ksyValue(id, ""))
+			ksyAdd(attr, "type", ksyValue(basicToKai(part), part.Go))
+			seq.Content = append(seq.Content, attr)
+		}
+		spec := ksyMap()
+		ksyAdd(spec, "seq", seq)
+		ksyAdd(kaiTypes, basicToKai(&ir.Type{Kind: ir.Complex, Size: size}), spec)
 	}
-	log.Printf("generating type: %q", snakeCase(typeName))
-	g.Printf("  %s:\n", snakeCase(typeName))
-	g.Printf("    seq:\n")
-	g.generateType(underlying)
 }
 
-func (g *Generator) generateType(t types.Type) {
-	switch t := t.(type) {
-	case *types.Struct:
-		for i := 0; i < t.NumFields(); i++ {
-			field := t.Field(i)
-			g.Printf("      - id: %s\n", snakeCase(field.Name()))
-			for _, s := range strings.Split(g.kaiType(field.Type()), "\n") {
-				g.Printf("        %s\n", s)
+// generateType adds the seq attributes of the given struct type to the given
+// sequence node, and returns the comments following the last attribute.
+//
+// Attributes are output in source declaration order of the fields, as recorded
+// by the type checker (unless given by the order directive of the type), and
+// unexported fields omitted by -unexported comment are output as comments in
+// their place; embedded structs are output as a single attribute at the
+// position of the embedded field, so regenerated specs only change where the
+// struct definition changes.
+func (g *Generator) generateType(seq *yaml.Node, s *ir.StructDef) string {
+	// Comments preceding the next attribute.
+	var comments []string
+	for _, f := range s.Fields {
+		comments = append(comments, omittedComments(s, f.Name)...)
+		if len(f.OffsetField) > 0 {
+			// Located at offset; output as instance.
+			continue
+		}
+		if f.Type.IsUnsupported() && len(f.SwitchOn) == 0 && !g.opaque {
+			if comment := g.unsupportedField(s, f); len(comment) > 0 {
+				comments = append(comments, comment)
 			}
+			continue
 		}
-	default:
-		panic(fmt.Errorf("support for type %T not yet implemented", t))
+		if len(f.TagErr) > 0 {
+			// Reported during analysis.
+			comments = append(comments, fmt.Sprintf("TODO: add field %s; %s", f.ID, f.TagErr))
+			continue
+		}
+		spec, err := g.tagSpec(s, f)
+		if err != nil {
+			g.errorAt(f.Pos, "field %s.%s: %v", s.Name, f.Name, err)
+			comments = append(comments, fmt.Sprintf("TODO: add field %s; %v", f.ID, err))
+			continue
+		}
+		attr := ksyMap()
+		attr.HeadComment = ksyComments(comments)
+		comments = nil
+		ksyAdd(attr, "id", ksyValue(f.ID, ""))
+		attr.Content = append(attr.Content, spec.Content...)
+		seq.Content = append(seq.Content, attr)
+	}
+	comments = append(comments, omittedComments(s, "")...)
+	return ksyComments(comments)
+}
+
+// tagSpec returns the Kaitai attribute specification of the given field of the
+// struct type, taking into account the options of the kaitai struct tag of the
+// field which refer to other fields or parameters (size, repeat, args, if,
+// switch-on), replace the type of the field (terminator) or process its bytes
+// (process).
+func (g *Generator) tagSpec(s *ir.StructDef, f *ir.Field) (*yaml.Node, error) {
+	// Struct types referenced by the field are output in the byte order of
+	// the field; see variantID.
+	g.attrEndian = g.typeEndian
+	if len(f.Endian) > 0 {
+		g.attrEndian = f.Endian
+	}
+	spec, err := g.tagType(s, f)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Args) > 0 {
+		if err := g.withArgs(spec, s, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := withProcess(spec, f); err != nil {
+		return nil, err
+	}
+	if len(f.If) > 0 {
+		ksyAdd(spec, "if", ksyValue(f.If, ""))
+	}
+	return spec, nil
+}
+
+// tagType returns the Kaitai type of the given field of the struct type, as
+// specified by the kaitai struct tag of the field.
+func (g *Generator) tagType(s *ir.StructDef, f *ir.Field) (*yaml.Node, error) {
+	if spec, ok, err := g.switchType(s, f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.substream(s, f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.repetition(s, f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.terminated(f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.bigInt(f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.varint(f); ok {
+		return spec, err
+	}
+	if spec, ok, err := g.checksum(s, f); ok {
+		return spec, err
+	}
+	return g.fieldSpec(f)
+}
+
+// fieldSpec returns the Kaitai attribute specification of the given struct
+// field, excluding the id.
+func (g *Generator) fieldSpec(f *ir.Field) (*yaml.Node, error) {
+	if f.Contents != nil {
+		return ksySpec("contents", kaiContents(f.Contents), f.Type.Go), nil
+	}
+	kaiType, ok, err := g.fixedString(f)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		kaiType, err = g.kaiType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f.Endian) > 0 {
+		withEndian(kaiType, f.Endian)
 	}
+	return kaiType, nil
 }
 
-func (g *Generator) kaiType(t types.Type) string {
-	buf := &strings.Builder{}
-	switch t := t.(type) {
-	case *types.Basic:
-		return fmt.Sprintf("type: %s # %s", basicKindToKai(t.Kind()), t.Name())
-	case *types.Named:
-		name := t.Obj().Name()
-		g.namedTypeDeps[name] = true
-		if underlying, ok := t.Underlying().(*types.Basic); ok {
+// kaiType returns the Kaitai type specification of the given type.
+func (g *Generator) kaiType(t *ir.Type) (*yaml.Node, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		if t.Kind == ir.Complex {
+			if g.complexTypes == nil {
+				g.complexTypes = make(map[int64]bool)
+			}
+			g.complexTypes[t.Size] = true
+		}
+		if t.Kind == ir.String && g.opaque {
+			g.addOpaqueType(basicToKai(t))
+		}
+		return ksySpec("type", basicToKai(t), t.Go), nil
+	case ir.Named:
+		switch {
+		case strings.HasPrefix(t.Go, "C."):
+			// cgo struct types are generated along with the types referring
+			// to them; see cgoStructs.
+		case t.Underlying.IsBasic() && !g.compatRaw:
+			// Enums are output with the types; see kaiEnums.
+		default:
+			g.namedTypeDeps[t.Name] = true
+		}
+		if e := g.mod.Enum(t); g.flagsAsBits && e != nil && e.Flags {
+			g.addFlagType(e)
+			return ksySpec("type", g.flagTypeName(e), t.Name), nil
+		}
+		if t.Underlying.IsBasic() {
 			// enum?
-			buf := &strings.Builder{}
-			fmt.Fprintf(buf, "type: %s\n", basicKindToKai(underlying.Kind()))
-			fmt.Fprintf(buf, "enum: %s", snakeCase(t.Obj().Name()))
-			return buf.String()
+			spec := ksySpec("type", basicToKai(t.Underlying), "")
+			ksyAdd(spec, "enum", ksyValue(t.ID, ""))
+			return spec, nil
+		}
+		if t.Underlying.Kind != ir.Struct {
+			if g.opaque {
+				return ksySpec("type", g.opaqueType(t), t.Name), nil
+			}
+			return nil, fmt.Errorf("support for underlying type %s of %s not yet implemented", t.Underlying.Go, t.Name)
+		}
+		return ksySpec("type", g.variantID(t), t.Go), nil
+	case ir.Array:
+		// Fixed-size byte buffers.
+		if t.Elem.IsByte() {
+			return ksySpec("size", g.arrayLen(t), arrayComment(t)), nil
 		}
-		return fmt.Sprintf("type: %s # %s", snakeCase(name), name)
-	case *types.Array:
 		// TODO: figure out a better way to handle arrays of arrays and slices of
 		// slices.
-		fmt.Fprintf(buf, "%s\n", g.kaiType(t.Elem()))
-		fmt.Fprintf(buf, "repeat: expr\n")
-		fmt.Fprintf(buf, "repeat-expr: %d # %s", t.Len(), types.TypeString(t, skipQualifier))
-	case *types.Slice:
-		fmt.Fprintf(buf, "%s\n", g.kaiType(t.Elem()))
-		fmt.Fprintf(buf, "repeat: expr\n")
-		fmt.Fprintf(buf, "repeat-expr: todo_add_slice_len # %s", types.TypeString(t, skipQualifier))
-	case *types.Pointer:
-		fmt.Fprintf(buf, "type: pointer # %s", types.TypeString(t, skipQualifier))
-		// TODO: add skip bytes?
-	case *types.Signature:
-		fmt.Fprintf(buf, "type: func_signature # %s", types.TypeString(t, skipQualifier))
-		// TODO: add skip bytes?
+		spec, err := g.kaiType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		ksyAdd(spec, "repeat", ksyValue("expr", ""))
+		ksyAdd(spec, "repeat-expr", ksyValue(g.arrayLen(t), arrayComment(t)))
+		return spec, nil
+	case ir.Slice:
+		spec, err := g.kaiType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		ksyAdd(spec, "repeat", ksyValue("expr", ""))
+		ksyAdd(spec, "repeat-expr", ksyValue("todo_add_slice_len", t.Go))
+		return spec, nil
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture, and
+		// skipped.
+		return ksySpec("size", strconv.FormatInt(t.Size, 10), t.Go), nil
+	case ir.Struct:
+		if len(t.ID) > 0 {
+			// Unnamed struct type of a field; see generateAnonTypes.
+			return ksySpec("type", g.variantID(t), "struct"), nil
+		}
+		fallthrough
 	default:
-		panic(fmt.Errorf("support for type %T not yet implemented", t))
+		if g.opaque {
+			return ksySpec("type", g.opaqueType(t), t.Go), nil
+		}
+		return nil, fmt.Errorf("support for type %s not yet implemented", t.Go)
 	}
-	return buf.String()
+}
+
+// position returns the source position of the given position.
+func (g *Generator) position(pos token.Pos) token.Position {
+	return g.pkg.fset.Position(pos)
 }
 
 func skipQualifier(pkg *types.Package) string {
 	return ""
 }
 
-func basicKindToKai(kind types.BasicKind) string {
-	switch kind {
-	// predeclared types
-	case types.Bool:
+// basicToKai returns the Kaitai type corresponding to the given basic type, as
+// sized by the target architecture.
+func basicToKai(t *ir.Type) string {
+	switch t.Kind {
+	case ir.Bool:
 		return "b8" // bool 8-bit
-	case types.Int:
-		return "s8" // signed int 64-bit
-	case types.Int8:
-		return "s1" // signed int 8-bit
-	case types.Int16:
-		return "s2" // signed int 16-bit
-	case types.Int32:
-		return "s4" // signed int 32-bit
-	case types.Int64:
-		return "s8" // signed int 64-bit
-	case types.Uint:
-		return "u8" // unsigned int 64-bit
-	case types.Uint8:
-		return "u1" // unsigned int 8-bit
-	case types.Uint16:
-		return "u2" // unsigned int 16-bit
-	case types.Uint32:
-		return "u4" // unsigned int 32-bit
-	case types.Uint64:
-		return "u8" // unsigned int 64-bit
-	case types.Uintptr:
-		return "u8" // unsigned int 64-bit
-	case types.Float32:
-		return "f2" // single-precision float
-	case types.Float64:
-		return "f4" // double-precision float
-	case types.Complex64:
-		return "go_complex64" // single-precision complex
-	case types.Complex128:
-		return "go_complex128" // double-precision complex
-	case types.String:
+	case ir.Int:
+		return fmt.Sprintf("s%d", t.Size) // signed int 8-, 16-, 32- or 64-bit
+	case ir.Uint:
+		return fmt.Sprintf("u%d", t.Size) // unsigned int 8-, 16-, 32- or 64-bit
+	case ir.Float:
+		return fmt.Sprintf("f%d", t.Size) // single- or double-precision float
+	case ir.Complex:
+		return fmt.Sprintf("go_complex%d", 8*t.Size) // single- or double-precision complex
+	case ir.String:
 		return "go_string"
-	case types.UnsafePointer:
-		return "go_unsafe_ptr"
-	// types for untyped values
-	//case types.UntypedBool:
-	//case types.UntypedInt:
-	//case types.UntypedRune:
-	//case types.UntypedFloat:
-	//case types.UntypedComplex:
-	//case types.UntypedString:
-	//case types.UntypedNil:
 	default:
-		panic(fmt.Errorf("support for basic kind %v not yet implemented", kind))
+		panic(fmt.Errorf("support for type kind %v not yet implemented", t.Kind))
 	}
 }
 
-// snakeCase returns the snake_case version of the given string.
-func snakeCase(s string) string {
-	out := &strings.Builder{}
-	prevUpper := true
-	for _, r := range s {
-		if unicode.IsUpper(r) {
-			if !prevUpper {
-				out.WriteRune('_')
-			}
-			out.WriteRune(unicode.ToLower(r))
-			prevUpper = true
-		} else {
-			out.WriteRune(r)
-			prevUpper = false
+// archs specifies the supported target architectures.
+var archs = []string{"amd64", "386", "arm64", "arm", "wasm"}
+
+// archSizes returns the type sizes of the given target architecture. archSizes
+// exits if the architecture is not supported.
+func archSizes(arch string) types.Sizes {
+	for _, a := range archs {
+		if a == arch {
+			return types.SizesFor("gc", arch)
 		}
 	}
-	return out.String()
+	usagef("unsupported target architecture %q; valid options: %s", arch, strings.Join(archs, ", "))
+	panic("unreachable")
+}
+
+// sizeof returns the size in bytes of the given basic kind on the target
+// architecture.
+func (g *Generator) sizeof(kind types.BasicKind) int64 {
+	return g.sizes.Sizeof(types.Typ[kind])
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/load"
+)
+
+// runMatrix generates the output of the types of the single package of the
+// given package arguments with -matrix, for each target architecture of archs.
+// The package is loaded and analyzed per architecture, selecting the source
+// files of the architecture. If the IR of the types is the same on every
+// architecture (i.e. no sizes differ by the size of int, uint and uintptr), one
+// output is written as without -matrix. Otherwise, the output of each
+// architecture is written, with the architecture as suffix of the output file
+// name; e.g. header_type_386.ksy.
+//
+// runMatrix reports whether any types were selected, and whether generation
+// succeeded, along with the generators of the written outputs.
+func runMatrix(args []string, loadCfg *load.Config, patterns []string, renames map[string]string, cfg *config, ext string) (generated, ok bool, gens []*Generator) {
+	type variant struct {
+		g       *Generator
+		defined []string
+		types   []string
+	}
+	var variants []*variant
+	minLevel := logMinLevel
+	for i, arch := range archs {
+		c := *loadCfg
+		c.Arch = arch
+		pkgs := filterPackages(loadPackages(args, &c), splitList(*pkgFilter))
+		if len(pkgs) != 1 {
+			usagef("-matrix option applies only to a single package")
+		}
+		g := newGenerator(copyRenames(renames), cfg)
+		g.arch, g.sizes = arch, archSizes(arch)
+		g.addPackage(pkgs[0])
+		if i > 0 && logMinLevel < levelError {
+			// Log the analysis of the first architecture only, as the
+			// analyses of the other architectures only differ in size.
+			logMinLevel = levelError
+		}
+		defined, types := g.analyzePackage(patterns)
+		logMinLevel = minLevel
+		if len(defined) == 0 {
+			return false, !g.reportErrors(), []*Generator{g}
+		}
+		variants = append(variants, &variant{g: g, defined: defined, types: types})
+	}
+	dir := variants[0].g.pkgDir()
+	if len(args) == 1 && isDirectory(argPath(args[0])) {
+		dir = argPath(args[0])
+	}
+	same := true
+	key := archKey(variants[0].g.mod)
+	for _, v := range variants[1:] {
+		if archKey(v.g.mod) != key {
+			same = false
+			break
+		}
+	}
+	if same {
+		logf(levelInfo, "matrix", logAttrs{"archs": strings.Join(archs, ",")}, "sizes of the types are the same on all target architectures (%s); writing one output", strings.Join(archs, ", "))
+		v := variants[0]
+		return true, v.g.write(dir, v.defined, v.types, ext), []*Generator{v.g}
+	}
+	ok = true
+	for _, v := range variants {
+		v.g.archSuffix = "_" + v.g.arch
+		if !v.g.write(dir, v.defined, v.types, ext) {
+			ok = false
+		}
+		gens = append(gens, v.g)
+	}
+	return true, ok, gens
+}
+
+// archKey returns the IR of the given module as JSON, not including the target
+// architecture, for comparison of the IR of different architectures.
+func archKey(m *ir.Module) string {
+	mod := *m
+	mod.Arch = ""
+	buf := &bytes.Buffer{}
+	if err := mod.Encode(buf); err != nil {
+		fatalf("unable to encode IR; %v", err)
+	}
+	return buf.String()
+}
+
+// archOutputName returns the given output file name with the given -matrix
+// architecture suffix inserted before the given file extension (or the
+// extension of the name); e.g. header_type_386.ksy.
+func archOutputName(name, ext, suffix string) string {
+	if !strings.HasSuffix(name, ext) {
+		ext = filepath.Ext(name)
+	}
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// copyRenames returns a copy of the given rename map, as the rename map of a
+// generator is extended by the identifiers of collisions; see checkCollisions.
+func copyRenames(renames map[string]string) map[string]string {
+	m := make(map[string]string, len(renames))
+	for name, id := range renames {
+		m[name] = id
+	}
+	return m
+}
+
+// archDependent records the given field of a struct type as the first field of
+// the analyzed types whose size depends on the target architecture, if of type
+// int, uint or uintptr (or an array thereof), and no such field is recorded.
+func (a *analyzer) archDependent(typeName string, field *types.Var) {
+	if len(a.g.archField) > 0 || !isArchDependent(field.Type()) {
+		return
+	}
+	a.g.archField = typeName + "." + field.Name()
+	a.g.archFieldPos = a.g.posString(field.Pos())
+}
+
+// isArchDependent reports whether the size of the given type depends on the
+// target architecture; i.e. int, uint and uintptr, and arrays thereof.
+func isArchDependent(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Int, types.Uint, types.Uintptr:
+			return true
+		}
+	case *types.Array:
+		return isArchDependent(u.Elem())
+	}
+	return false
+}
+
+// isFlagSet reports whether the command line flag of the given name was set.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"go/token"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// With -merge, the generated Kaitai spec is merged with the existing output
+// file, so that hand-edits survive regeneration. The structure derived from
+// the Go types is owned by the generator: the meta keys, seq attributes and
+// parameters of generated types, and generated enums and instances. Anything
+// else in the existing spec is owned by the user, and kept:
+//
+//   - root keys, meta keys, and keys of types and attributes not output by the
+//     generator (e.g. doc, doc-ref, valid or id of meta);
+//   - types, enums and instances not output by the generator;
+//   - values replacing generated placeholders (e.g. repeat-expr of
+//     todo_add_slice_len or value of todo_translate_method);
+//   - attributes replacing the comments of fields which could not be
+//     generated (e.g. of unsupported types).
+//
+// Seq attributes of fields no longer present in the Go types are dropped. As
+// merged specs are edited by hand, the header of the spec does not mark it as
+// generated code.
+
+// mergeKsy merges the existing Kaitai spec of the -merge output file, if
+// present, into the given generated root mapping.
+func (g *Generator) mergeKsy(root *yaml.Node) {
+	buf, err := ioutil.ReadFile(g.merge)
+	if os.IsNotExist(err) {
+		// Initial generation.
+		return
+	}
+	if err != nil {
+		g.errorf(token.NoPos, "unable to merge Kaitai spec; %v", err)
+		return
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		g.errorf(token.NoPos, "unable to merge Kaitai spec %s; %v", g.merge, err)
+		return
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		g.errorf(token.NoPos, "unable to merge Kaitai spec %s; root is not a mapping", g.merge)
+		return
+	}
+	old := doc.Content[0]
+	for i := 0; i < len(old.Content); i += 2 {
+		key, value := old.Content[i], old.Content[i+1]
+		genValue := ksyLookup(root, key.Value)
+		switch {
+		case genValue == nil:
+			root.Content = append(root.Content, key, value)
+		case key.Value == "meta":
+			mergeUserKeys(genValue, value)
+		case key.Value == "types":
+			g.mergeTypes(genValue, value)
+		case key.Value == "enums":
+			mergeUserKeys(genValue, value)
+		}
+	}
+}
+
+// mergeTypes merges the existing Kaitai types into the generated types. Types
+// not output by the generator are kept.
+func (g *Generator) mergeTypes(gen, old *yaml.Node) {
+	if old.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(old.Content); i += 2 {
+		key, value := old.Content[i], old.Content[i+1]
+		genType := ksyLookup(gen, key.Value)
+		if genType == nil {
+			logf(levelInfo, "merge", logAttrs{"id": key.Value}, "merge: keeping type %s not generated from Go source", key.Value)
+			gen.Content = append(gen.Content, key, value)
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			k, v := value.Content[j], value.Content[j+1]
+			genValue := ksyLookup(genType, k.Value)
+			switch {
+			case genValue == nil:
+				genType.Content = append(genType.Content, k, v)
+			case k.Value == "seq":
+				g.mergeSeq(genType, genValue, v)
+			case k.Value == "instances":
+				mergeUserKeys(genValue, v)
+			}
+		}
+	}
+}
+
+// reTodoField matches the comments of fields which could not be generated (TODO
+// comments, fields of unsupported types skipped by -on-unsupported and
+// unexported fields omitted by -unexported), capturing the identifier of the
+// field.
+var reTodoField = regexp.MustCompile(`^# (?:TODO: add field|skipped field|omitted field) ([a-zA-Z0-9_]+);`)
+
+// mergeSeq merges the existing seq attributes, identified by id, into the
+// generated seq attributes of the given type.
+func (g *Generator) mergeSeq(genType, gen, old *yaml.Node) {
+	if old.Kind != yaml.SequenceNode {
+		return
+	}
+	oldAttrs := make(map[string]*yaml.Node)
+	for _, attr := range old.Content {
+		if id := ksyLookup(attr, "id"); id != nil {
+			oldAttrs[id.Value] = attr
+		}
+	}
+	// replaceTodos returns the given comment without the TODO comments of
+	// fields replaced by existing attributes, and the existing attributes.
+	replaceTodos := func(comment string) (string, []*yaml.Node) {
+		if len(comment) == 0 {
+			return comment, nil
+		}
+		var lines []string
+		var attrs []*yaml.Node
+		for _, line := range strings.Split(comment, "\n") {
+			if m := reTodoField.FindStringSubmatch(line); m != nil && oldAttrs[m[1]] != nil {
+				attrs = append(attrs, oldAttrs[m[1]])
+				continue
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n"), attrs
+	}
+	var attrs []*yaml.Node
+	for _, attr := range gen.Content {
+		var replaced []*yaml.Node
+		attr.HeadComment, replaced = replaceTodos(attr.HeadComment)
+		attrs = append(attrs, replaced...)
+		if id := ksyLookup(attr, "id"); id != nil && oldAttrs[id.Value] != nil {
+			mergeAttr(attr, oldAttrs[id.Value])
+		}
+		attrs = append(attrs, attr)
+	}
+	// Comments following the last attribute are recorded as foot comment of
+	// the seq key.
+	for i := 0; i < len(genType.Content); i += 2 {
+		if key := genType.Content[i]; key.Value == "seq" {
+			var replaced []*yaml.Node
+			key.FootComment, replaced = replaceTodos(key.FootComment)
+			attrs = append(attrs, replaced...)
+		}
+	}
+	gen.Content = attrs
+}
+
+// mergeAttr merges the keys of the existing seq attribute into the generated
+// attribute; keys not output by the generator are kept, as are values
+// replacing generated placeholders.
+func mergeAttr(gen, old *yaml.Node) {
+	for i := 0; i < len(old.Content); i += 2 {
+		key, value := old.Content[i], old.Content[i+1]
+		genValue := ksyLookup(gen, key.Value)
+		switch {
+		case genValue == nil:
+			gen.Content = append(gen.Content, key, value)
+		case isPlaceholder(genValue) && !isPlaceholder(value):
+			*genValue = *value
+		}
+	}
+}
+
+// mergeUserKeys adds the keys of the existing mapping not present in the
+// generated mapping to the generated mapping, and replaces generated
+// placeholder values (e.g. of instances) by existing values.
+func mergeUserKeys(gen, old *yaml.Node) {
+	if gen.Kind != yaml.MappingNode || old.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(old.Content); i += 2 {
+		key, value := old.Content[i], old.Content[i+1]
+		genValue := ksyLookup(gen, key.Value)
+		if genValue == nil {
+			gen.Content = append(gen.Content, key, value)
+			continue
+		}
+		if genValue.Kind == yaml.MappingNode {
+			for j := 0; j < len(genValue.Content); j += 2 {
+				v := genValue.Content[j+1]
+				if oldValue := ksyLookup(value, genValue.Content[j].Value); isPlaceholder(v) && oldValue != nil && !isPlaceholder(oldValue) {
+					*v = *oldValue
+				}
+			}
+		}
+	}
+}
+
+// isPlaceholder reports whether the given node is a placeholder value output
+// by the generator (e.g. todo_add_slice_len).
+func isPlaceholder(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && strings.HasPrefix(n.Value, "todo_")
+}
+
+// ksyLookup returns the value of the given key of the mapping node, or nil if
+// not present.
+func ksyLookup(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// typeMethods returns the exported methods of the given named type and of
+// pointers to the type, in sorted order; e.g. "String() string" and
+// "(*) Validate() error" of a method with a pointer receiver. Types of other
+// packages are qualified by package name.
+func typeMethods(t *types.Named) []string {
+	qualifier := func(pkg *types.Package) string {
+		if pkg == t.Obj().Pkg() {
+			return ""
+		}
+		return pkg.Name()
+	}
+	var methods []string
+	mset := types.NewMethodSet(types.NewPointer(t))
+	for i := 0; i < mset.Len(); i++ {
+		fn := mset.At(i).Obj().(*types.Func)
+		if !fn.Exported() {
+			continue
+		}
+		sig := fn.Type().(*types.Signature)
+		// Strip the leading "func" of the signature.
+		method := fn.Name() + strings.TrimPrefix(types.TypeString(sig, qualifier), "func")
+		if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+			method = "(*) " + method
+		}
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+// methodsDoc returns the doc value of the given struct type with -doc-methods,
+// listing the Go methods of the type, so that readers of the spec know the
+// behaviour of the reference implementation; e.g.
+//
+//	doc: |
+//	  Go methods of Header:
+//	    String() string
+//	    (*) Validate() error
+func methodsDoc(s *ir.StructDef) *yaml.Node {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "Go methods of %s:\n", s.Name)
+	for _, method := range s.Methods {
+		fmt.Fprintf(buf, "  %s\n", method)
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: buf.String()}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/naming"
+)
+
+// Naming strategies of identifiers in the generated output.
+const (
+	// snake_case; e.g. HTTPHeader becomes http_header.
+	namingSnake = naming.StrategySnake
+	// Go identifiers as is.
+	namingKeep = naming.StrategyKeep
+	// lowerCamelCase; e.g. HTTPHeader becomes httpHeader.
+	namingCamel = naming.StrategyCamel
+)
+
+// typeID returns the identifier of the given Go type name in the generated
+// output, based on the -rename map and -naming strategy. cgo types are
+// identified by their C names; e.g. struct_point.
+func (g *Generator) typeID(typeName string) string {
+	if id, ok := g.renames[typeName]; ok {
+		return id
+	}
+	return g.ident(cgoName(typeName))
+}
+
+// fieldID returns the identifier of the given field of the named Go struct
+// type in the generated output, based on the -rename map and -naming
+// strategy.
+func (g *Generator) fieldID(typeName, fieldName string) string {
+	if id, ok := g.renames[typeName+"."+fieldName]; ok {
+		return id
+	}
+	if id, ok := g.fieldIDs[typeName+"."+fieldName]; ok {
+		return id
+	}
+	return g.ident(fieldName)
+}
+
+// ident returns the identifier of the given Go identifier in the generated
+// output, based on the -naming strategy.
+func (g *Generator) ident(name string) string {
+	return naming.Ident(g.naming, name)
+}
+
+// parseRenames parses the given comma-separated list of renames of the form
+// Type=id or Type.Field=id.
+func parseRenames(s string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, rename := range splitList(s) {
+		pos := strings.Index(rename, "=")
+		if pos == -1 {
+			return nil, fmt.Errorf("invalid rename %q; expected Type=id or Type.Field=id", rename)
+		}
+		name, id := strings.TrimSpace(rename[:pos]), strings.TrimSpace(rename[pos+1:])
+		if len(name) == 0 || len(id) == 0 {
+			return nil, fmt.Errorf("invalid rename %q; expected Type=id or Type.Field=id", rename)
+		}
+		renames[name] = id
+	}
+	return renames, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// offsetInstances adds the Kaitai instances of the given struct type located
+// at the offsets stored in fields tagged with the offset-to option to the given
+// mapping; e.g.
+//
+//	BodyOffset uint32 `kaitai:"offset-to=Body,whence=start"`
+//	Body       Body
+//
+// produces the instance
+//
+//	body:
+//	  pos: body_offset
+//	  io: _root._io
+//	  type: body # Body
+//
+// The target of the offset is either a field of the struct type, which is
+// omitted from the seq attributes, or a type of the package.
+func (g *Generator) offsetInstances(instances *yaml.Node, s *ir.StructDef) {
+	for _, f := range s.Fields {
+		if len(f.OffsetTo) == 0 {
+			continue
+		}
+		if !f.Type.IsInteger() {
+			g.errorAt(f.Pos, "field %s.%s: offset-to option only valid for integer fields; got %s", s.Name, f.Name, f.Type.Go)
+			continue
+		}
+		spec, id, err := g.offsetTarget(s, f)
+		if err != nil {
+			g.errorAt(f.Pos, "field %s.%s: %v", s.Name, f.Name, err)
+			continue
+		}
+		instance := ksyMap()
+		ksyAdd(instance, "pos", ksyValue(f.ID, ""))
+		if f.Whence == "start" {
+			ksyAdd(instance, "io", ksyValue("_root._io", ""))
+		}
+		instance.Content = append(instance.Content, spec.Content...)
+		ksyAdd(instances, id, instance)
+	}
+}
+
+// offsetTarget returns the Kaitai attribute specification and instance
+// identifier of the target of the offset-to option of the given field; either a
+// field of the given struct type or a type of the package, as resolved by the
+// analysis.
+func (g *Generator) offsetTarget(s *ir.StructDef, f *ir.Field) (spec *yaml.Node, id string, err error) {
+	target := f.OffsetTo
+	if field := s.Field(target); field != nil {
+		if len(field.TagErr) > 0 {
+			return nil, "", errors.New(field.TagErr)
+		}
+		spec, err := g.tagSpec(s, field)
+		return spec, field.ID, err
+	}
+	if f.OffsetType != nil {
+		g.attrEndian = g.typeEndian
+		spec, err := g.kaiType(f.OffsetType)
+		return spec, f.OffsetID, err
+	}
+	return nil, "", fmt.Errorf("offset-to target %s is neither a field nor a type of the package", target)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// opaqueType returns the identifier of the opaque external type of the given
+// type, which is not representable in Kaitai, and records the opaque type to
+// be declared by the spec. Named types are referred to by their identifier,
+// and other types by their kind; e.g. go_map.
+func (g *Generator) opaqueType(t *ir.Type) string {
+	id := t.ID
+	if t.Kind != ir.Named {
+		switch {
+		case strings.HasPrefix(t.Go, "map["):
+			id = "go_map"
+		case strings.HasPrefix(t.Go, "func"):
+			id = "go_func"
+		case strings.HasPrefix(t.Go, "interface"):
+			id = "go_interface"
+		case strings.HasPrefix(t.Go, "chan"), strings.HasPrefix(t.Go, "<-chan"):
+			id = "go_chan"
+		default:
+			id = "go_opaque"
+		}
+	}
+	g.addOpaqueType(id)
+	return id
+}
+
+// addOpaqueType records the given opaque type, unless already present.
+func (g *Generator) addOpaqueType(id string) {
+	for _, prev := range g.opaqueTypes {
+		if prev == id {
+			return
+		}
+	}
+	g.opaqueTypes = append(g.opaqueTypes, id)
+}
+
+// writeOpaqueStubs writes stub implementations of the opaque types referenced
+// by the generated spec to the given directory, as Go, Java and Python
+// templates (e.g. go_map.go.tmpl, GoMap.java.tmpl and go_map.py.tmpl) to be
+// completed and renamed by the user.
+func (g *Generator) writeOpaqueStubs(dir string) {
+	for _, id := range g.opaqueTypes {
+		class := kaiClassName(id)
+		stubs := map[string]string{
+			id + ".go.tmpl":      fmt.Sprintf(opaqueGo, id, g.mod.Package, class),
+			class + ".java.tmpl": fmt.Sprintf(opaqueJava, id, class),
+			id + ".py.tmpl":      fmt.Sprintf(opaquePython, id, class),
+		}
+		for name, src := range stubs {
+			path := filepath.Join(dir, name)
+			if err := writeOutput(path, []byte(src), *writeIfChanged); err != nil {
+				fatalf("writing opaque type stub: %s", err)
+			}
+		}
+	}
+}
+
+// kaiClassName returns the class name of the given Kaitai type identifier, as
+// generated by the Kaitai compiler; e.g. GoMap for go_map.
+func kaiClassName(id string) string {
+	parts := strings.Split(id, "_")
+	for i, part := range parts {
+		if len(part) > 0 {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// opaqueGo is the Go stub implementation of an opaque type, formatted with the
+// type identifier, package name and class name.
+const opaqueGo = `// Stub implementation of the opaque Kaitai type %[1]s.
+
+package %[2]s
+
+import "github.com/kaitai-io/kaitai_struct_go_runtime/kaitai"
+
+type %[3]s struct {
+	_io     *kaitai.Stream
+	_parent interface{}
+	_root   interface{}
+}
+
+func New%[3]s() *%[3]s {
+	return &%[3]s{}
+}
+
+func (this *%[3]s) Read(io *kaitai.Stream, parent interface{}, root interface{}) (err error) {
+	this._io = io
+	this._parent = parent
+	this._root = root
+	// TODO: read the value of %[1]s from io.
+	return nil
+}
+`
+
+// opaqueJava is the Java stub implementation of an opaque type, formatted with
+// the type identifier and class name.
+const opaqueJava = `// Stub implementation of the opaque Kaitai type %[1]s.
+
+import io.kaitai.struct.KaitaiStream;
+import io.kaitai.struct.KaitaiStruct;
+
+public class %[2]s extends KaitaiStruct {
+    private KaitaiStruct _parent;
+
+    public %[2]s(KaitaiStream _io, KaitaiStruct _parent, KaitaiStruct _root) {
+        super(_io);
+        this._parent = _parent;
+        _read();
+    }
+
+    private void _read() {
+        // TODO: read the value of %[1]s from _io.
+    }
+
+    public KaitaiStruct _parent() { return _parent; }
+}
+`
+
+// opaquePython is the Python stub implementation of an opaque type, formatted
+// with the type identifier and class name.
+const opaquePython = `# Stub implementation of the opaque Kaitai type %[1]s.
+
+from kaitaistruct import KaitaiStruct
+
+
+class %[2]s(KaitaiStruct):
+    def __init__(self, _io, _parent=None, _root=None):
+        self._io = _io
+        self._parent = _parent
+        self._root = _root if _root else self
+        self._read()
+
+    def _read(self):
+        # TODO: read the value of %[1]s from self._io.
+        pass
+`
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/load"
+)
+
+// TestSeqOrder checks that the types, seq attributes and instances of the
+// generated Kaitai spec follow the source declaration order of the struct
+// types, their fields (embedded structs as a single attribute at the position
+// of the embedded field) and their instance methods, independent of the order
+// in which the files of the package are reported by the build system.
+func TestSeqOrder(t *testing.T) {
+	pkgs := loadPackages([]string{"./testdata/order"}, &load.Config{Arch: "amd64"})
+	pkg := pkgs[0]
+	want := generateKaitai(t, pkg)
+
+	// Files in reverse order.
+	rev := *pkg
+	rev.Syntax = nil
+	for i := len(pkg.Syntax) - 1; i >= 0; i-- {
+		rev.Syntax = append(rev.Syntax, pkg.Syntax[i])
+	}
+	if got := generateKaitai(t, &rev); got != want {
+		t.Errorf("output depends on the order of files; got\n%s\nwant\n%s", got, want)
+	}
+
+	wantSeqs := []struct {
+		typ       string
+		ids       []string
+		instances []string
+	}{
+		{typ: "common", ids: []string{"version", "flags"}},
+		{typ: "entry", ids: []string{"offset", "size"}},
+		{typ: "header", ids: []string{"magic", "common", "len", "word", "entries"}, instances: []string{"entry_count", "total_len"}},
+		{typ: "word", ids: []string{"lo", "hi"}},
+	}
+	var spec yaml.Node
+	if err := yaml.Unmarshal([]byte(want), &spec); err != nil {
+		t.Fatalf("unable to parse generated spec; %v", err)
+	}
+	typs := mapValue(spec.Content[0], "types")
+	if typs == nil || len(typs.Content) != 2*len(wantSeqs) {
+		t.Fatalf("expected %d types, got\n%s", len(wantSeqs), want)
+	}
+	for i, w := range wantSeqs {
+		if name := typs.Content[2*i].Value; name != w.typ {
+			t.Errorf("type %d: expected %s, got %s", i, w.typ, name)
+			continue
+		}
+		var ids []string
+		if seq := mapValue(typs.Content[2*i+1], "seq"); seq != nil {
+			for _, attr := range seq.Content {
+				if id := mapValue(attr, "id"); id != nil {
+					ids = append(ids, id.Value)
+				}
+			}
+		}
+		if !reflect.DeepEqual(ids, w.ids) {
+			t.Errorf("type %s: expected seq %v, got %v", w.typ, w.ids, ids)
+		}
+		var instances []string
+		if insts := mapValue(typs.Content[2*i+1], "instances"); insts != nil {
+			for j := 0; j < len(insts.Content); j += 2 {
+				instances = append(instances, insts.Content[j].Value)
+			}
+		}
+		if !reflect.DeepEqual(instances, w.instances) {
+			t.Errorf("type %s: expected instances %v, got %v", w.typ, w.instances, instances)
+		}
+	}
+}
+
+// generateKaitai returns the Kaitai spec of the struct types of the testdata
+// package.
+func generateKaitai(t *testing.T, pkg *packages.Package) string {
+	g := newGenerator(nil, nil)
+	g.addPackage(pkg)
+	defined, _ := g.analyzePackage([]string{"*"})
+	if len(defined) == 0 {
+		t.Fatalf("no types of package %s analyzed", pkg.PkgPath)
+	}
+	g.generateOutput()
+	return g.buf.String()
+}
+
+// mapValue returns the value of the given key of a YAML mapping node, or nil
+// if not present.
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
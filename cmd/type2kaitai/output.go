@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// cmdline returns the command line arguments recorded in the header of the
+// generated output. Flags which do not affect the output (-check, -diff,
+// -write-if-changed, -cache-dir, -report, -serve and the logging flags -v, -q
+// and -log) are omitted, so that the output is identical regardless of how it
+// is written.
+func cmdline() string {
+	var args []string
+	osArgs := os.Args[1:]
+	for i := 0; i < len(osArgs); i++ {
+		arg := osArgs[i]
+		name := strings.TrimLeft(arg, "-")
+		hasValue := false
+		if pos := strings.Index(name, "="); pos != -1 {
+			name = name[:pos]
+			hasValue = true
+		}
+		if strings.HasPrefix(arg, "-") {
+			switch name {
+			case "check", "diff", "write-if-changed", "v", "q":
+				continue
+			case "cache-dir", "log", "report", "serve":
+				if !hasValue {
+					// Skip flag value.
+					i++
+				}
+				continue
+			}
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// readHeaderFile returns the contents of the named -header-file file, or the
+// empty string if not set.
+func readHeaderFile(name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		fatal(err)
+	}
+	header := strings.Replace(string(buf), "\r\n", "\n", -1)
+	return strings.TrimRight(header, "\n")
+}
+
+// headerLines returns the lines of the header of the generated files; either
+// the "Code generated" line, or the lines of the -header-file file with
+// {cmdline} replaced by the command line (see cmdline). Headers are output as
+// comments in the syntax of each format; e.g.
+//
+//	// Copyright 2024 The Foo Authors. All rights reserved.
+//	// Generated by "type2kaitai -type Header"; see LICENSE.
+func (g *Generator) headerLines() []string {
+	if len(g.header) == 0 {
+		return []string{fmt.Sprintf("Code generated by \"type2kaitai %s\"; DO NOT EDIT.", cmdline())}
+	}
+	header := strings.Replace(g.header, "{cmdline}", cmdline(), -1)
+	return strings.Split(header, "\n")
+}
+
+// headerComment returns the header of the generated files as line comments
+// with the given comment prefix; e.g. "// ".
+func (g *Generator) headerComment(prefix string) string {
+	buf := &strings.Builder{}
+	for _, line := range g.headerLines() {
+		buf.WriteString(strings.TrimRight(prefix+line, " "))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// writeOutput writes the generated output to the named file. If
+// writeIfChanged is set, the file is left untouched (preserving its
+// modification time) when its contents are identical to the output. The file
+// is recorded in the -report summary.
+func writeOutput(name string, src []byte, writeIfChanged bool) error {
+	if writeIfChanged {
+		if old, err := ioutil.ReadFile(name); err == nil && bytes.Equal(old, src) {
+			logf(levelInfo, "unchanged", logAttrs{"file": name}, "%s unchanged", name)
+			recordOutput(name)
+			return nil
+		}
+	}
+	if err := ioutil.WriteFile(name, src, 0644); err != nil {
+		return err
+	}
+	recordOutput(name)
+	return nil
+}
+
+// checkOutput reports whether the named file is up to date; i.e. whether its
+// contents are identical to the generated output.
+func checkOutput(name string, src []byte) bool {
+	old, err := ioutil.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(old, src)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/kaitai"
+	"gopkg.in/yaml.v3"
+)
+
+// paramDirective is the comment directive which declares a parameter of a
+// Kaitai type, for struct types whose parsing depends on external values (e.g.
+// the version of the file format). The directive is followed by the
+// identifier and Kaitai type of the parameter. Parameters may be referenced by
+// the if, size and repeat options of the struct tags of the fields, and
+// arguments are passed by the args option of the struct tag of the parent
+// field, separated by semicolons.
+//
+// Example:
+//
+//	type File struct {
+//	    Version uint16
+//	    Body    Body `kaitai:"args=Version"`
+//	}
+//
+//	//kaitai:param version u2
+//	type Body struct {
+//	    Size  uint32
+//	    Flags uint32 `kaitai:"if=version >= 2"`
+//	}
+const paramDirective = "//kaitai:param"
+
+// reParam matches the arguments of the //kaitai:param directive.
+var reParam = regexp.MustCompile(`^([a-z][a-z0-9_]*)[ \t]+(\S+)$`)
+
+// parseParams parses the //kaitai:param directives of the doc comment of the
+// named type.
+func (g *Generator) parseParams(typeName string) []*ir.Param {
+	doc, pos := g.typeDoc(typeName)
+	if doc == nil {
+		return nil
+	}
+	var params []*ir.Param
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, paramDirective) {
+			continue
+		}
+		arg := strings.TrimPrefix(c.Text, paramDirective)
+		if len(arg) > 0 && arg[0] != ' ' && arg[0] != '\t' {
+			// Different directive sharing the same prefix.
+			continue
+		}
+		m := reParam.FindStringSubmatch(strings.TrimSpace(arg))
+		if m == nil {
+			g.errorf(pos, "type %s: invalid directive %q; expected %s <id> <type>", typeName, c.Text, paramDirective)
+			continue
+		}
+		params = append(params, &ir.Param{ID: m[1], Type: m[2]})
+	}
+	return params
+}
+
+// typeDoc returns the doc comment of the declaration of the named type, and
+// the position of the type name.
+func (g *Generator) typeDoc(typeName string) (*ast.CommentGroup, token.Pos) {
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				if spec.Name.Name != typeName {
+					continue
+				}
+				if spec.Doc != nil {
+					return spec.Doc, spec.Name.Pos()
+				}
+				return decl.Doc, spec.Name.Pos()
+			}
+		}
+	}
+	return nil, token.NoPos
+}
+
+// generateParams adds the params section of the given struct type, if any, to
+// the given Kaitai type specification.
+func (g *Generator) generateParams(spec *yaml.Node, s *ir.StructDef) {
+	if len(s.Params) == 0 {
+		return
+	}
+	seq := ksySeq()
+	for _, param := range s.Params {
+		attr := ksyMap()
+		ksyAdd(attr, "id", ksyValue(param.ID, ""))
+		ksyAdd(attr, "type", ksyValue(param.Type, ""))
+		seq.Content = append(seq.Content, attr)
+	}
+	ksyAdd(spec, "params", seq)
+}
+
+// reUserType matches the Kaitai types of user types.
+var reUserType = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// withArgs passes the arguments of the args option of the struct tag of the
+// given field of the struct type to the parameters of the field type, in the
+// given Kaitai type specification of the field; e.g. type: body(version).
+// Arguments are either fields of the struct type, or Kaitai expressions.
+func (g *Generator) withArgs(spec *yaml.Node, s *ir.StructDef, f *ir.Field) error {
+	deps := f.Type.Deps()
+	typ := kaitai.Lookup(spec, "type")
+	if len(deps) == 0 || typ == nil || !reUserType.MatchString(typ.Value) || !deps[0].IsNamedStruct() {
+		return fmt.Errorf("args option only valid for fields of struct types")
+	}
+	var exprs []string
+	for _, arg := range f.Args {
+		if len(arg) == 0 {
+			return fmt.Errorf("invalid args %q; empty argument", strings.Join(f.Args, ";"))
+		}
+		if field := s.Field(arg); field != nil {
+			arg = field.ID
+		}
+		exprs = append(exprs, arg)
+	}
+	fieldType := deps[0].Name
+	params := 0
+	if t := g.mod.Struct(deps[0]); t != nil {
+		params = len(t.Params)
+	}
+	if params != len(exprs) {
+		return fmt.Errorf("%d arguments passed to type %s with %d parameters", len(exprs), fieldType, params)
+	}
+	typ.Value = fmt.Sprintf("%s(%s)", typ.Value, strings.Join(exprs, ", "))
+	return nil
+}
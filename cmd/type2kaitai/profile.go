@@ -0,0 +1,249 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// The specs of the built-in profiles are kept as a regression corpus of the
+// generator; check them with -check after changes to the generator.
+//
+//go:generate go run . -profile elf -output testdata/profiles/elf.ksy
+//go:generate go run . -profile pe -output testdata/profiles/pe.ksy
+//go:generate go run . -profile macho -output testdata/profiles/macho.ksy
+
+// profile is a built-in set of options generating the Kaitai spec of the
+// header types of a standard library package (-profile).
+type profile struct {
+	// Import path of the package.
+	pkg string
+	// Names of the generated types.
+	types []string
+	// Byte order of the binary format.
+	endian string
+	// enums maps from fields (Type.Field) declared as plain integers to the
+	// enums of their values; either a named integer type of the package (e.g.
+	// Machine), or a group of constants of the package, given by the enum name
+	// and a constant of the group (e.g. Machine=IMAGE_FILE_MACHINE_UNKNOWN).
+	enums map[string]string
+	// magic maps from fields (Type.Field) to the magic signature at the start
+	// of the field.
+	magic map[string]string
+}
+
+// profiles maps from profile names to built-in profiles.
+var profiles = map[string]*profile{
+	"elf": {
+		pkg:    "debug/elf",
+		types:  []string{"Header32", "Header64", "Prog32", "Prog64", "Section32", "Section64"},
+		endian: "le",
+		enums: map[string]string{
+			"Header32.Type":    "Type",
+			"Header32.Machine": "Machine",
+			"Header32.Version": "Version",
+			"Header64.Type":    "Type",
+			"Header64.Machine": "Machine",
+			"Header64.Version": "Version",
+			"Prog32.Type":      "ProgType",
+			"Prog32.Flags":     "ProgFlag",
+			"Prog64.Type":      "ProgType",
+			"Prog64.Flags":     "ProgFlag",
+			"Section32.Type":   "SectionType",
+			"Section32.Flags":  "SectionFlag",
+			"Section64.Type":   "SectionType",
+			"Section64.Flags":  "SectionFlag",
+		},
+		magic: map[string]string{
+			"Header32.Ident": "\x7fELF",
+			"Header64.Ident": "\x7fELF",
+		},
+	},
+	"pe": {
+		pkg:    "debug/pe",
+		types:  []string{"FileHeader", "OptionalHeader32", "OptionalHeader64", "DataDirectory", "SectionHeader32"},
+		endian: "le",
+		enums: map[string]string{
+			"FileHeader.Machine":                  "Machine=IMAGE_FILE_MACHINE_UNKNOWN",
+			"FileHeader.Characteristics":          "Characteristics=IMAGE_FILE_RELOCS_STRIPPED",
+			"OptionalHeader32.Subsystem":          "Subsystem=IMAGE_SUBSYSTEM_UNKNOWN",
+			"OptionalHeader32.DllCharacteristics": "DllCharacteristics=IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA",
+			"OptionalHeader64.Subsystem":          "Subsystem=IMAGE_SUBSYSTEM_UNKNOWN",
+			"OptionalHeader64.DllCharacteristics": "DllCharacteristics=IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA",
+			"SectionHeader32.Characteristics":     "SectionCharacteristics=IMAGE_SCN_CNT_CODE",
+		},
+		magic: map[string]string{
+			"OptionalHeader32.Magic": "\x0b\x01",
+			"OptionalHeader64.Magic": "\x0b\x02",
+		},
+	},
+	"macho": {
+		pkg:    "debug/macho",
+		types:  []string{"FileHeader", "Segment32", "Segment64", "Section32", "Section64"},
+		endian: "le",
+		enums: map[string]string{
+			"FileHeader.Magic": "Magic=Magic32",
+			"FileHeader.Flags": "Flags=FlagNoUndefs",
+		},
+	},
+}
+
+// profileNames returns the names of the built-in profiles, in sorted order.
+func profileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProfile returns the built-in profile of the given name. lookupProfile
+// exits if the profile is not found.
+func lookupProfile(name string) *profile {
+	p, ok := profiles[name]
+	if !ok {
+		usagef("unknown profile %q; valid options: %s", name, strings.Join(profileNames(), ", "))
+	}
+	return p
+}
+
+// applyProfile applies the enums and magic signatures of the -profile, if
+// any, to the fields of the structs of the given module. Fields of the size of
+// their magic signature store it as contents; longer byte arrays are split
+// into the signature and the remaining bytes.
+func (g *Generator) applyProfile(m *ir.Module) {
+	if g.profile == nil {
+		return
+	}
+	a := &analyzer{g: g, named: make(map[*types.Named]*ir.Type)}
+	for _, s := range m.Structs {
+		var fields []*ir.Field
+		for _, f := range s.Fields {
+			key := s.Name + "." + f.Name
+			if spec, ok := g.profile.enums[key]; ok {
+				if e := g.profileEnum(a, m, spec, f); e != nil {
+					f.Type = &ir.Type{
+						Kind:       ir.Named,
+						Go:         f.Type.Go,
+						Name:       e.Name,
+						Package:    e.Package,
+						ID:         e.ID,
+						Pos:        e.Pos,
+						Underlying: f.Type,
+					}
+				}
+			}
+			magic, ok := g.profile.magic[key]
+			if !ok {
+				fields = append(fields, f)
+				continue
+			}
+			size, fixed := g.packedSize(f.Type)
+			n := int64(len(magic))
+			switch {
+			case fixed && size == n:
+				f.Contents = []byte(magic)
+				fields = append(fields, f)
+			case fixed && size > n && f.Type.Kind == ir.Array && f.Type.Elem.IsByte():
+				sig := &ir.Field{
+					Name:     "_",
+					ID:       g.ident("magic"),
+					Index:    -1,
+					Pos:      f.Pos,
+					Type:     byteArray(n),
+					Contents: []byte(magic),
+				}
+				rest := *f
+				rest.Type = byteArray(size - n)
+				fields = append(fields, sig, &rest)
+			default:
+				g.errorAt(f.Pos, "field %s: magic signature of %d bytes not valid for type %s", key, n, f.Type.Go)
+				fields = append(fields, f)
+			}
+		}
+		s.Fields = fields
+	}
+}
+
+// profileEnum returns the enum of the given -profile enum specification of
+// the field, and adds it to the enums of the module if not yet present.
+func (g *Generator) profileEnum(a *analyzer, m *ir.Module, spec string, f *ir.Field) *ir.EnumDef {
+	name, member := spec, ""
+	if pos := strings.Index(spec, "="); pos != -1 {
+		name, member = spec[:pos], spec[pos+1:]
+	}
+	for _, e := range m.Enums {
+		if e.Name == name {
+			return e
+		}
+	}
+	var e *ir.EnumDef
+	if len(member) == 0 {
+		t := g.lookupType(name)
+		if t == nil {
+			return nil
+		}
+		if !isEnum(t) {
+			g.errorAt(f.Pos, "profile: enum of field %s: type %s is not a named integer type", f.Name, name)
+			return nil
+		}
+		e = a.enumDef(t)
+	} else {
+		consts := g.constGroup(member)
+		if len(consts) == 0 {
+			g.errorAt(f.Pos, "profile: enum of field %s: constant %s not found", f.Name, member)
+			return nil
+		}
+		e = &ir.EnumDef{
+			Name:       name,
+			Package:    g.pkg.path,
+			ID:         g.typeID(name),
+			Pos:        g.posString(consts[0].Pos()),
+			Underlying: f.Type,
+			Flags:      isFlagConsts(consts),
+			Values:     g.enumValueDefs(consts),
+		}
+	}
+	m.Enums = append(m.Enums, e)
+	return e
+}
+
+// constGroup returns the constants declared in the same group (i.e. const
+// declaration) as the named constant of the package, in source order.
+func (g *Generator) constGroup(name string) []*types.Const {
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.CONST || !declares(decl, name) {
+				continue
+			}
+			var consts []*types.Const
+			for _, spec := range decl.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					if c, ok := g.pkg.info.Defs[ident].(*types.Const); ok && ident.Name != "_" {
+						consts = append(consts, c)
+					}
+				}
+			}
+			return consts
+		}
+	}
+	return nil
+}
+
+// declares reports whether the given declaration declares the given name.
+func declares(decl *ast.GenDecl, name string) bool {
+	for _, spec := range decl.Specs {
+		for _, ident := range spec.(*ast.ValueSpec).Names {
+			if ident.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/naming"
+)
+
+// generateProto outputs a proto3 schema of the selected types and their
+// dependencies; mapping structs to messages, enums to enums, and slices and
+// arrays to repeated fields.
+func (g *Generator) generateProto() {
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	g.Printf("syntax = \"proto3\";\n")
+	g.Printf("\n")
+	g.Printf("package %s;\n", g.mod.Package)
+	for _, s := range g.mod.Structs {
+		g.Printf("\n")
+		g.protoMessage(s)
+	}
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.protoEnum(e)
+	}
+}
+
+// protoMessage outputs the message definition of the given struct type. Field
+// numbers follow the field index, so that unsupported fields leave a gap
+// rather than renumbering subsequent fields.
+func (g *Generator) protoMessage(s *ir.StructDef) {
+	g.Printf("message %s {\n", s.Name)
+	for _, f := range s.Fields {
+		if f.Index < 0 {
+			// Fields inserted by the generator (e.g. padding), without
+			// representation in protobuf.
+			continue
+		}
+		typ, err := protoType(f.Type)
+		if err != nil {
+			g.Printf("  // TODO: add field %s; %v\n", f.Name, err)
+			g.Printf("  reserved %d;\n", f.Index+1)
+			continue
+		}
+		g.Printf("  %s %s = %d;\n", typ, f.ID, f.Index+1)
+	}
+	g.Printf("}\n")
+}
+
+// protoEnum outputs the enum definition of the given enum type. As proto3
+// requires the first enum value to be zero, an UNSPECIFIED value is added if
+// no constant of the enum has the value zero.
+func (g *Generator) protoEnum(e *ir.EnumDef) {
+	prefix := strings.ToUpper(naming.Snake(e.Name))
+	hasZero := false
+	hasAlias := false
+	seen := make(map[string]bool)
+	for _, v := range e.Values {
+		if v.Value == "0" {
+			hasZero = true
+		}
+		if seen[v.Value] {
+			hasAlias = true
+		}
+		seen[v.Value] = true
+	}
+	g.Printf("enum %s {\n", e.Name)
+	if hasAlias {
+		g.Printf("  option allow_alias = true;\n")
+	}
+	if !hasZero {
+		g.Printf("  %s_UNSPECIFIED = 0;\n", prefix)
+	}
+	for _, v := range e.Values {
+		name := strings.ToUpper(naming.Snake(v.Name))
+		if !strings.HasPrefix(name, prefix) {
+			name = prefix + "_" + name
+		}
+		x, exact := v.Int64()
+		if !exact || int64(int32(x)) != x {
+			g.Printf("  // TODO: add value %s = %s; out of int32 range\n", name, v.Value)
+			continue
+		}
+		g.Printf("  %s = %d;\n", name, x)
+	}
+	g.Printf("}\n")
+}
+
+// protoType returns the proto3 field type corresponding to the given type.
+func protoType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return protoBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct || t.IsEnum() {
+			return t.Name, nil
+		}
+		return protoType(t.Underlying)
+	case ir.Array, ir.Slice:
+		return protoRepeatedType(t.Elem)
+	case ir.Pointer:
+		if t.Elem == nil {
+			// unsafe.Pointer, stored as an address of the target architecture.
+			return protoBasicType(addrType(t))
+		}
+		return protoType(t.Elem)
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// protoRepeatedType returns the proto3 field type of an array or slice with
+// the given element type. Byte arrays and slices are mapped to bytes.
+func protoRepeatedType(elem *ir.Type) (string, error) {
+	if elem.IsByte() {
+		return "bytes", nil
+	}
+	typ, err := protoType(elem)
+	if err != nil {
+		return "", err
+	}
+	if typ == "bytes" || strings.HasPrefix(typ, "repeated ") {
+		return "", fmt.Errorf("nested repeated field of element type %s not supported", elem.Go)
+	}
+	return "repeated " + typ, nil
+}
+
+// protoBasicType returns the proto3 scalar type corresponding to the given
+// basic type.
+func protoBasicType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return "bool", nil
+	case ir.Int:
+		if t.Size <= 4 {
+			return "sint32", nil
+		}
+		return "sint64", nil
+	case ir.Uint:
+		if t.Size <= 4 {
+			return "uint32", nil
+		}
+		return "uint64", nil
+	case ir.Float:
+		if t.Size == 4 {
+			return "float", nil
+		}
+		return "double", nil
+	case ir.String:
+		return "string", nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
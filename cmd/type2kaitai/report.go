@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exit codes of type2kaitai.
+const (
+	// Output generated without problems.
+	exitOK = 0
+	// Generation failed; e.g. fatal errors, no matching types, output out of
+	// date with -check or -diff, or problems found with -strict.
+	exitErrors = 1
+	// Invalid command line; e.g. unsupported flag values or combinations.
+	exitUsage = 2
+	// Output generated with problems (e.g. fields of unsupported types), which
+	// may thus be incomplete.
+	exitPartial = 3
+)
+
+// exitStatusNames maps from exit codes to the status of the -report file.
+var exitStatusNames = map[int]string{
+	exitOK:      "ok",
+	exitErrors:  "errors",
+	exitUsage:   "usage",
+	exitPartial: "partial",
+}
+
+// report is the machine-readable summary of the generation written to the
+// -report file on exit; including on fatal errors.
+type report struct {
+	// Exit code of the command.
+	ExitCode int `json:"exit_code"`
+	// Status of the exit code; ok, errors, usage or partial.
+	Status string `json:"status"`
+	// Packages processed, in order of completion.
+	Packages []*packageReport `json:"packages,omitempty"`
+	// Files written, in sorted order.
+	Outputs []string `json:"outputs,omitempty"`
+	// Warnings output during generation, in order.
+	Warnings []string `json:"warnings,omitempty"`
+	// Fatal errors and usage errors.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// packageReport is the summary of the generation of a package.
+type packageReport struct {
+	// Import path of the package.
+	Path string `json:"path,omitempty"`
+	// Go names of the generated types, in order.
+	Types []string `json:"types,omitempty"`
+	// Fields of unsupported types skipped by -on-unsupported.
+	Unsupported []*unsupportedReport `json:"unsupported,omitempty"`
+	// Problems found during generation.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// unsupportedReport is a field of an unsupported type.
+type unsupportedReport struct {
+	// Source position of the field declaration.
+	Pos string `json:"pos,omitempty"`
+	// Go name of the struct type of the field.
+	Type string `json:"type"`
+	// Go field name.
+	Field string `json:"field"`
+	// Go type of the field.
+	Go string `json:"go"`
+}
+
+var (
+	// rep holds the summary of the generation, written by -report.
+	rep = &report{}
+	// repMu guards rep, which is updated by the packages processed in
+	// parallel in batch mode.
+	repMu sync.Mutex
+)
+
+// recordWarning records the given warning in the -report summary.
+func recordWarning(msg string) {
+	repMu.Lock()
+	defer repMu.Unlock()
+	rep.Warnings = append(rep.Warnings, msg)
+}
+
+// recordOutput records the given written file in the -report summary.
+func recordOutput(name string) {
+	repMu.Lock()
+	defer repMu.Unlock()
+	rep.Outputs = append(rep.Outputs, name)
+}
+
+// recordPackage records the summary of the generation of the package in the
+// -report summary.
+func (g *Generator) recordPackage() {
+	p := &packageReport{
+		Problems: g.errs,
+	}
+	if g.pkg != nil {
+		p.Path = g.pkg.path
+	} else if g.mod != nil {
+		p.Path = g.mod.Path
+	}
+	if g.mod != nil {
+		for _, t := range g.mod.Roots {
+			p.Types = append(p.Types, t.Name)
+		}
+	}
+	for _, s := range g.skipped {
+		p.Unsupported = append(p.Unsupported, &unsupportedReport{
+			Pos:   s.field.Pos,
+			Type:  s.typeName,
+			Field: s.field.Name,
+			Go:    s.field.Type.Go,
+		})
+	}
+	repMu.Lock()
+	defer repMu.Unlock()
+	rep.Packages = append(rep.Packages, p)
+}
+
+// exitStatus returns the exit code of the generation of the given packages,
+// based on whether any types were generated and whether generation succeeded
+// (see run).
+func exitStatus(generated, ok bool, gens ...*Generator) int {
+	if !generated || !ok {
+		return exitErrors
+	}
+	for _, g := range gens {
+		if len(g.errs) > 0 {
+			return exitPartial
+		}
+	}
+	return exitOK
+}
+
+// exit writes the -report file, if set, and exits with the given exit code.
+func exit(code int) {
+	if len(*reportFile) > 0 {
+		repMu.Lock()
+		rep.ExitCode = code
+		rep.Status = exitStatusNames[code]
+		sort.Strings(rep.Outputs)
+		buf, err := json.MarshalIndent(rep, "", "\t")
+		repMu.Unlock()
+		if err != nil {
+			log.Printf("unable to encode report; %v", err)
+			os.Exit(exitErrors)
+		}
+		if err := ioutil.WriteFile(*reportFile, append(buf, '\n'), 0644); err != nil {
+			log.Printf("writing report: %v", err)
+			os.Exit(exitErrors)
+		}
+	}
+	os.Exit(code)
+}
+
+// fatalf outputs the error message of the given format and arguments, and
+// exits with exitErrors.
+func fatalf(format string, args ...interface{}) {
+	fatalCode(exitErrors, fmt.Sprintf(format, args...))
+}
+
+// fatal outputs the given error, and exits with exitErrors.
+func fatal(args ...interface{}) {
+	fatalCode(exitErrors, fmt.Sprint(args...))
+}
+
+// usagef outputs the usage error message of the given format and arguments,
+// and exits with exitUsage.
+func usagef(format string, args ...interface{}) {
+	fatalCode(exitUsage, fmt.Sprintf(format, args...))
+}
+
+// fatalCode outputs the given error message, records it in the -report
+// summary, and exits with the given exit code.
+func fatalCode(code int, msg string) {
+	log.Print(msg)
+	repMu.Lock()
+	rep.Errors = append(rep.Errors, strings.TrimPrefix(msg, "error: "))
+	repMu.Unlock()
+	exit(code)
+}
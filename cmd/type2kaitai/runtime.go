@@ -0,0 +1,210 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// Policies for runtime-only fields.
+const (
+	// Output the field like other fields.
+	runtimeKeep = "keep"
+	// Output the field, and suggest skipping it.
+	runtimeSuggest = "suggest"
+	// Omit the field from the output.
+	runtimeSkip = "skip"
+)
+
+// decodeFuncPrefixes specifies the prefixes of the names of the functions and
+// methods inspected for the fields set when decoding the types of the package;
+// matched regardless of case (e.g. ReadHeader or readBody).
+var decodeFuncPrefixes = []string{"read", "unmarshal", "decode", "parse"}
+
+// isDecodeFunc reports whether the given function name is the name of a
+// decoding function.
+func isDecodeFunc(name string) bool {
+	name = strings.ToLower(name)
+	for _, prefix := range decodeFuncPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeRuntimeFields handles the runtime-only fields of the struct types
+// reachable from the given types based on the -runtime-fields policy; either
+// reporting them as candidates for the //kaitai:skip directive, or omitting
+// them from the output. See decodedFields.
+func (g *Generator) excludeRuntimeFields(typeNames []string) {
+	if g.runtimeFields == runtimeKeep || len(g.runtimeFields) == 0 {
+		return
+	}
+	set := g.decodedFields()
+	structs, _ := g.typeGraph(typeNames)
+	for _, t := range structs {
+		if pkg := t.Obj().Pkg(); pkg == nil || pkg.Path() != g.pkg.path {
+			continue
+		}
+		st := t.Underlying().(*types.Struct)
+		decoded := false
+		for i := 0; i < st.NumFields(); i++ {
+			decoded = decoded || set[st.Field(i)]
+		}
+		if !decoded {
+			// No decoding functions of the type found.
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			field := st.Field(i)
+			if set[field] || g.excluded[field] || field.Name() == "_" || len(g.fieldTag(st, i)) > 0 {
+				continue
+			}
+			typeName := t.Obj().Name()
+			attrs := logAttrs{"pos": g.posString(field.Pos()), "type": typeName, "field": field.Name()}
+			if g.runtimeFields == runtimeSkip {
+				g.excluded[field] = true
+				logf(levelInfo, "runtime_field", attrs, "skipping runtime-only field %s.%s; not set by decoding functions", typeName, field.Name())
+				continue
+			}
+			warnf("runtime_field", attrs, "%s: field %s.%s not set by decoding functions; likely runtime-only (skip with //kaitai:skip or -runtime-fields skip)", g.posString(field.Pos()), typeName, field.Name())
+		}
+	}
+}
+
+// decodedFields returns the struct fields set by the decoding functions of the
+// package (see isDecodeFunc). Fields are set by
+//
+//	v.F = x                      assignment (also v.F[i] = x and v.F.G = x)
+//	v.F++                        increment or decrement
+//	binary.Read(r, order, &v.F)  address taken
+//	v.F.read(r)                  method of pointer receiver
+//	T{F: x}                      composite literal
+//
+// and all fields of a struct value are set by taking its address or passing a
+// pointer to it (e.g. binary.Read(r, order, v)), and by unkeyed composite
+// literals. Fields not set by the decoding functions of their struct type are
+// likely runtime-only (e.g. caches and mutexes), assigned by other code of the
+// package.
+func (g *Generator) decodedFields() map[*types.Var]bool {
+	set := make(map[*types.Var]bool)
+	// setAll marks the fields of the struct types of the given type, through
+	// arrays, slices and nested structs.
+	seen := make(map[types.Type]bool)
+	var setAll func(t types.Type)
+	setAll = func(t types.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch u := t.Underlying().(type) {
+		case *types.Struct:
+			for i := 0; i < u.NumFields(); i++ {
+				set[u.Field(i)] = true
+				setAll(u.Field(i).Type())
+			}
+		case *types.Array:
+			setAll(u.Elem())
+		case *types.Slice:
+			setAll(u.Elem())
+		}
+	}
+	// setExpr marks the fields selected by the given assigned expression.
+	setExpr := func(expr ast.Expr) {
+		for {
+			switch e := expr.(type) {
+			case *ast.ParenExpr:
+				expr = e.X
+				continue
+			case *ast.IndexExpr:
+				expr = e.X
+				continue
+			case *ast.StarExpr:
+				expr = e.X
+				continue
+			case *ast.SelectorExpr:
+				if field, ok := g.fieldVar(e); ok {
+					set[field] = true
+				}
+				expr = e.X
+				continue
+			}
+			return
+		}
+	}
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil || !isDecodeFunc(fn.Name.Name) {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					if n.Tok == token.DEFINE {
+						break
+					}
+					for _, lhs := range n.Lhs {
+						setExpr(lhs)
+						if star, ok := lhs.(*ast.StarExpr); ok {
+							// *v = T{...}
+							setAll(g.pkg.info.TypeOf(star))
+						}
+					}
+				case *ast.IncDecStmt:
+					setExpr(n.X)
+				case *ast.UnaryExpr:
+					if _, ok := n.X.(*ast.CompositeLit); n.Op != token.AND || ok {
+						// Fields of &T{...} are set by the composite literal.
+						break
+					}
+					setExpr(n.X)
+					if t := g.pkg.info.TypeOf(n.X); t != nil {
+						setAll(t)
+					}
+				case *ast.CallExpr:
+					for _, arg := range n.Args {
+						if ptr, ok := g.pkg.info.TypeOf(arg).(*types.Pointer); ok {
+							setAll(ptr.Elem())
+						}
+					}
+					if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+						if selection, ok := g.pkg.info.Selections[sel]; ok && selection.Kind() == types.MethodVal {
+							recv := selection.Obj().Type().(*types.Signature).Recv()
+							if _, ok := recv.Type().(*types.Pointer); ok {
+								setExpr(sel.X)
+							}
+						}
+					}
+				case *ast.CompositeLit:
+					t := g.pkg.info.TypeOf(n)
+					if t == nil {
+						break
+					}
+					st, ok := t.Underlying().(*types.Struct)
+					if !ok {
+						break
+					}
+					for i, elt := range n.Elts {
+						kv, ok := elt.(*ast.KeyValueExpr)
+						if !ok {
+							set[st.Field(i)] = true
+							continue
+						}
+						if key, ok := kv.Key.(*ast.Ident); ok {
+							for j := 0; j < st.NumFields(); j++ {
+								if st.Field(j).Name() == key.Name {
+									set[st.Field(j)] = true
+								}
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return set
+}
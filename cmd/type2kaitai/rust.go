@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// generateRust outputs Rust definitions of the selected types and their
+// dependencies, annotated with either binrw or deku derive attributes to
+// mirror the Kaitai semantics (endianness and element counts).
+func (g *Generator) generateRust() {
+	if g.rustDerive != "binrw" && g.rustDerive != "deku" {
+		usagef("unsupported Rust derive attributes %q; valid options: binrw, deku", g.rustDerive)
+	}
+	g.Printf("%s", g.headerComment("// "))
+	g.Printf("\n")
+	switch g.rustDerive {
+	case "binrw":
+		g.Printf("use binrw::{BinRead, BinWrite};\n")
+	case "deku":
+		g.Printf("use deku::prelude::*;\n")
+	}
+	for _, s := range g.mod.Structs {
+		g.Printf("\n")
+		g.rustStruct(s)
+	}
+	for _, e := range g.mod.Enums {
+		g.Printf("\n")
+		g.rustEnum(e)
+	}
+}
+
+// rustStruct outputs the struct definition of the given struct type. Fields of
+// magic contents are omitted; leading magic contents are output as magic of the
+// struct, and other magic contents as magic of the next field, or of a unit
+// field if trailing.
+func (g *Generator) rustStruct(s *ir.StructDef) {
+	endian := "little"
+	if g.endian == "be" {
+		endian = "big"
+	}
+	switch g.rustDerive {
+	case "binrw":
+		g.Printf("#[derive(Debug, BinRead, BinWrite)]\n")
+		g.Printf("#[brw(%s)]\n", endian)
+	case "deku":
+		g.Printf("#[derive(Debug, DekuRead, DekuWrite)]\n")
+		g.Printf("#[deku(endian = %q)]\n", endian)
+	}
+	fields := s.Fields
+	var magic []byte
+	for len(fields) > 0 && fields[0].Contents != nil {
+		magic = append(magic, fields[0].Contents...)
+		fields = fields[1:]
+	}
+	g.rustMagic("", magic)
+	g.Printf("pub struct %s {\n", s.Name)
+	magic = nil
+	for _, f := range fields {
+		if f.Contents != nil {
+			magic = append(magic, f.Contents...)
+			continue
+		}
+		typ, err := g.rustType(f.Type)
+		if err != nil {
+			g.Printf("    // TODO: add field %s; %v\n", f.Name, err)
+			continue
+		}
+		g.rustMagic("    ", magic)
+		magic = nil
+		if f.Type.Under().Kind == ir.Slice {
+			switch g.rustDerive {
+			case "binrw":
+				g.Printf("    #[br(count = %s)]\n", sliceLen(s, f))
+			case "deku":
+				g.Printf("    #[deku(count = \"%s\")]\n", sliceLen(s, f))
+			}
+		}
+		if f.Type.Under().Kind == ir.Bool && g.rustDerive == "binrw" {
+			g.Printf("    #[br(map = |x: u8| x != 0)]\n")
+			g.Printf("    #[bw(map = |x: &bool| *x as u8)]\n")
+		}
+		g.Printf("    pub %s: %s, // %s\n", f.ID, typ, f.Type.Go)
+	}
+	if len(magic) > 0 {
+		g.rustMagic("    ", magic)
+		g.Printf("    pub _magic: (),\n")
+	}
+	g.Printf("}\n")
+}
+
+// rustMagic outputs the magic attributes of the given magic contents, if any,
+// with the given indentation.
+func (g *Generator) rustMagic(indent string, magic []byte) {
+	if len(magic) == 0 {
+		return
+	}
+	switch g.rustDerive {
+	case "binrw":
+		g.Printf("%s#[br(magic = %s)]\n", indent, rustBytes(magic))
+		g.Printf("%s#[bw(magic = %s)]\n", indent, rustBytes(magic))
+	case "deku":
+		g.Printf("%s#[deku(magic = %s)]\n", indent, rustBytes(magic))
+	}
+}
+
+// rustBytes returns the Rust byte string literal of the given bytes; e.g.
+// b"\x7fELF".
+func rustBytes(buf []byte) string {
+	var sb strings.Builder
+	sb.WriteString(`b"`)
+	for _, b := range buf {
+		switch {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case ' ' <= b && b <= '~':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, b)
+		}
+	}
+	sb.WriteString(`"`)
+	return sb.String()
+}
+
+// rustEnum outputs the enum definition of the given enum type.
+func (g *Generator) rustEnum(e *ir.EnumDef) {
+	repr, _ := g.rustType(e.Underlying)
+	switch g.rustDerive {
+	case "binrw":
+		g.Printf("#[derive(Debug, BinRead, BinWrite)]\n")
+		g.Printf("#[brw(repr = %s)]\n", repr)
+	case "deku":
+		g.Printf("#[derive(Debug, DekuRead, DekuWrite)]\n")
+		g.Printf("#[deku(type = %q)]\n", repr)
+	}
+	g.Printf("pub enum %s {\n", e.Name)
+	for _, v := range e.Values {
+		name := strings.TrimPrefix(v.Name, e.Name)
+		if len(name) == 0 || !isIdentStart(name) {
+			name = v.Name
+		}
+		switch g.rustDerive {
+		case "binrw":
+			g.Printf("    %s = %s,\n", name, v.Value)
+		case "deku":
+			g.Printf("    #[deku(id = %q)]\n", v.Value)
+			g.Printf("    %s,\n", name)
+		}
+	}
+	g.Printf("}\n")
+}
+
+// isIdentStart reports whether the given string starts with a letter, and may
+// thus be used as an identifier.
+func isIdentStart(s string) bool {
+	c := s[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// rustType returns the Rust type corresponding to the given type.
+func (g *Generator) rustType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool, ir.Int, ir.Uint, ir.Float, ir.Complex, ir.String:
+		return g.rustBasicType(t)
+	case ir.Named:
+		if t.Underlying.Kind == ir.Struct || t.IsEnum() {
+			return t.Name, nil
+		}
+		return g.rustType(t.Underlying)
+	case ir.Array:
+		elem, err := g.rustType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("[%s; %d]", elem, t.Len), nil
+	case ir.Slice:
+		elem, err := g.rustType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		return "Vec<" + elem + ">", nil
+	case ir.Pointer:
+		// Pointers are stored as addresses of the target architecture.
+		return g.rustBasicType(addrType(t))
+	default:
+		return "", fmt.Errorf("support for type %s not yet implemented", t.Go)
+	}
+}
+
+// rustBasicType returns the Rust type corresponding to the given basic type.
+func (g *Generator) rustBasicType(t *ir.Type) (string, error) {
+	switch t.Kind {
+	case ir.Bool:
+		return "bool", nil
+	case ir.Int:
+		return fmt.Sprintf("i%d", 8*t.Size), nil
+	case ir.Uint:
+		return fmt.Sprintf("u%d", 8*t.Size), nil
+	case ir.Float:
+		return fmt.Sprintf("f%d", 8*t.Size), nil
+	default:
+		return "", fmt.Errorf("support for basic type %s not yet implemented", t.Go)
+	}
+}
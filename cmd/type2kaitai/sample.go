@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go/constant"
+	"go/types"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// writeSamples writes a sample binary file srcdir/<type>_sample.bin for each
+// of the given types, to be opened together with the generated Kaitai spec
+// (e.g. in the Kaitai Web IDE).
+//
+// Fields are filled with recognizable patterns:
+//
+//   - magic contents are output as is;
+//   - integers and floats are numbered sequentially (1, 2, 3, ...) in order of
+//     appearance;
+//   - enums hold their first non-zero value, and flag-style enums all flags;
+//   - booleans are true;
+//   - byte arrays hold ascending bytes (00 01 02 ...), and strings the field
+//     name;
+//   - slices sized by a length field (e.g. of repeat-expr, or as inferred
+//     from the encoding functions of the package) hold sampleSliceLen
+//     elements, stored in the length field.
+func (g *Generator) writeSamples(dir string, typeNames []string) {
+	for _, typeName := range typeNames {
+		samples, err := g.samples(typeName)
+		if err != nil {
+			warnf("truncated_sample", logAttrs{"name": typeName}, "sample of type %s truncated; %v", typeName, err)
+		}
+		buf := &bytes.Buffer{}
+		for _, sample := range samples {
+			buf.Write(sample.data)
+		}
+		sampleName := filepath.Join(dir, sampleFileName(typeName))
+		logf(levelInfo, "sample", logAttrs{"file": sampleName}, "writing sample: %q", sampleName)
+		if err := ioutil.WriteFile(sampleName, buf.Bytes(), 0644); err != nil {
+			fatalf("writing sample: %s", err)
+		}
+		recordOutput(sampleName)
+	}
+}
+
+// sampleSliceLen is the number of elements of slices in sample binary files.
+const sampleSliceLen = 2
+
+// sampleFileName returns the file name of the sample binary file of the given
+// type.
+func sampleFileName(typeName string) string {
+	return strings.ToLower(typeName + "_sample.bin")
+}
+
+// fieldSample is the sample data of a field of the binary layout of a type.
+type fieldSample struct {
+	field *layout.Field
+	data  []byte
+}
+
+// samples returns the sample data of the fields of the binary layout of the
+// given type. If the layout cannot be fully computed, the samples of the
+// fields preceding the offending field are returned along with an error.
+func (g *Generator) samples(typeName string) ([]fieldSample, error) {
+	l := &layout.Layout{Sizes: g.sizes, Endian: g.endian, Exclude: func(field *types.Var) bool {
+		return g.excluded[field]
+	}, Tag: g.fieldTag, Order: func(st *types.Struct) []int {
+		return g.fieldOrders[st]
+	}, Substitute: g.substitute}
+	lengths := g.sliceLengths()
+	l.Len = func(field *types.Var) (int64, bool) {
+		_, ok := lengths[field]
+		return sampleSliceLen, ok
+	}
+	counts := make(map[*types.Var]bool)
+	for _, count := range lengths {
+		counts[count] = true
+	}
+	fields, err := l.Fields(g.lookupType(typeName), typeName)
+	var samples []fieldSample
+	counter := uint64(0)
+	for _, f := range fields {
+		buf := &bytes.Buffer{}
+		order := byteOrder(f.Endian)
+		switch f.Kind {
+		case layout.Contents:
+			buf.Write(f.Contents)
+		case layout.Bool:
+			buf.WriteByte(1)
+		case layout.Int, layout.Uint:
+			if f.Var != nil && counts[f.Var] {
+				putUint(buf, order, f.Size, sampleSliceLen)
+				break
+			}
+			if named, ok := f.Type.(*types.Named); ok && len(enumValues(named)) > 0 {
+				putUint(buf, order, f.Size, enumSample(named))
+				break
+			}
+			counter++
+			putUint(buf, order, f.Size, counter)
+		case layout.Float:
+			counter++
+			if f.Size == 4 {
+				putUint(buf, order, f.Size, uint64(math.Float32bits(float32(counter))))
+			} else {
+				putUint(buf, order, f.Size, math.Float64bits(float64(counter)))
+			}
+		case layout.Bytes:
+			for i := int64(0); i < f.Size; i++ {
+				buf.WriteByte(byte(i))
+			}
+		case layout.Str, layout.Strz:
+			str := make([]byte, f.Size)
+			name := f.Path[strings.LastIndex(f.Path, ".")+1:]
+			unitSize := int(structtag.EncodingUnitSize(f.Encoding))
+			n := copy(str, encodeSample(name, f.Encoding))
+			n -= n % unitSize
+			if f.Kind == layout.Strz && n == len(str) && n > 0 {
+				// Keep room for the null terminator.
+				for i := n - unitSize; i < n; i++ {
+					str[i] = 0
+				}
+			}
+			buf.Write(str)
+		default:
+			// Pointers and blank fields.
+			buf.Write(make([]byte, f.Size))
+		}
+		samples = append(samples, fieldSample{field: f, data: buf.Bytes()})
+	}
+	sampleChecksums(samples)
+	return samples, err
+}
+
+// sliceLengths returns the slice fields of the analyzed struct types sized by
+// a preceding length field, as given by the repeat-expr or size option of the
+// slice field or inferred by inferLength, mapped to their length field.
+func (g *Generator) sliceLengths() map[*types.Var]*types.Var {
+	lengths := make(map[*types.Var]*types.Var)
+	if g.mod == nil {
+		return lengths
+	}
+	for _, s := range g.mod.Structs {
+		if s.Package != g.pkg.path || !g.defines(s.Name) {
+			continue
+		}
+		t := g.lookupType(s.Name)
+		if t == nil {
+			continue
+		}
+		st, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for _, f := range s.Fields {
+			if f.Index < 0 || f.Type.Under().Kind != ir.Slice {
+				continue
+			}
+			n := f.Size
+			if f.Repeat == "expr" {
+				n = f.RepeatExpr
+			}
+			if count := s.Field(n); len(n) > 0 && count != nil && count.Index >= 0 {
+				lengths[st.Field(f.Index)] = st.Field(count.Index)
+			}
+		}
+	}
+	return lengths
+}
+
+// sampleChecksums computes the checksums of the given field samples of fields
+// tagged with the checksum option, over the sample data of the fields of
+// their region.
+func sampleChecksums(samples []fieldSample) {
+	for i, sample := range samples {
+		f := sample.field
+		if len(f.Checksum) == 0 {
+			continue
+		}
+		var data []byte
+		for _, other := range samples {
+			for _, region := range f.Region {
+				path := other.field.Path
+				if path == region || strings.HasPrefix(path, region+".") || strings.HasPrefix(path, region+"[") {
+					data = append(data, other.data...)
+					break
+				}
+			}
+		}
+		buf := &bytes.Buffer{}
+		putUint(buf, byteOrder(f.Endian), f.Size, checksumOf(f.Checksum, data))
+		samples[i].data = buf.Bytes()
+	}
+}
+
+// encodeSample returns the given ASCII sample string in the given encoding;
+// UTF-16 and UTF-32 code units in the byte order of the encoding, and the
+// ASCII bytes otherwise.
+func encodeSample(s, encoding string) []byte {
+	unitSize := structtag.EncodingUnitSize(encoding)
+	if unitSize == 1 {
+		return []byte(s)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if strings.HasSuffix(encoding, "BE") {
+		order = binary.BigEndian
+	}
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		putUint(buf, order, unitSize, uint64(s[i]))
+	}
+	return buf.Bytes()
+}
+
+// putUint writes the size least significant bytes of x to buf, using the given
+// byte order.
+func putUint(buf *bytes.Buffer, order binary.ByteOrder, size int64, x uint64) {
+	var data [8]byte
+	order.PutUint64(data[:], x)
+	if order == binary.BigEndian {
+		buf.Write(data[8-size:])
+		return
+	}
+	buf.Write(data[:size])
+}
+
+// enumSample returns the sample value of the given enum type; the union of all
+// flags of flag-style enums, and the first non-zero value otherwise.
+func enumSample(t *types.Named) uint64 {
+	consts := enumValues(t)
+	flags := isFlagEnum(t)
+	var x uint64
+	for _, c := range consts {
+		v, ok := constant.Uint64Val(c.Val())
+		if !ok {
+			// Negative value.
+			i, _ := constant.Int64Val(c.Val())
+			v = uint64(i)
+		}
+		if flags {
+			if bits.OnesCount64(v) == 1 {
+				x |= v
+			}
+			continue
+		}
+		if v != 0 {
+			return v
+		}
+	}
+	return x
+}
+
+// byteOrder returns the byte order of the given endianness.
+func byteOrder(endian string) binary.ByteOrder {
+	if endian == "be" {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
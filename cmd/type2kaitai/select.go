@@ -0,0 +1,185 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// selectTypes returns the type names selected by the given -type patterns, in
+// order of the patterns. Each pattern is either a type name, a glob pattern
+// (e.g. Header*) or a regular expression enclosed in slashes (e.g.
+// /^(Elf|PE)Header$/). Glob patterns and regular expressions select the struct
+// types of the package with matching names, in source order. If allExported is
+// set, every exported struct type of the package is selected.
+func (g *Generator) selectTypes(patterns []string, allExported bool) []string {
+	structs := g.structTypes()
+	var typeNames []string
+	seen := make(map[string]bool)
+	add := func(typeName string) {
+		if matchAny(g.excludeTypes, typeName) || g.typeDirectives[typeName].Has("skip") {
+			return
+		}
+		if !seen[typeName] {
+			seen[typeName] = true
+			typeNames = append(typeNames, typeName)
+		}
+	}
+	if allExported {
+		for _, ident := range structs {
+			if ast.IsExported(ident.Name) {
+				add(ident.Name)
+			}
+		}
+	}
+	for _, pattern := range patterns {
+		match, err := typeMatcher(pattern)
+		if err != nil {
+			g.errorf(token.NoPos, "invalid type pattern %q; %v", pattern, err)
+			continue
+		}
+		if match == nil {
+			// Plain type name.
+			add(pattern)
+			continue
+		}
+		n := 0
+		for _, ident := range structs {
+			if match(ident.Name) {
+				add(ident.Name)
+				n++
+			}
+		}
+		if n == 0 && !g.batch {
+			g.errorf(token.NoPos, "no struct types of package %s match pattern %q", g.pkg.path, pattern)
+		}
+	}
+	return typeNames
+}
+
+// typeMatcher returns a function reporting whether a name matches the given
+// pattern, or nil if the pattern is a plain name.
+func typeMatcher(pattern string) (func(typeName string) bool, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return nil, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(typeName string) bool {
+		ok, _ := path.Match(pattern, typeName)
+		return ok
+	}, nil
+}
+
+// structTypes returns the identifiers of the top-level struct type definitions
+// of the package, in source order; i.e. ordered by file name, and by offset
+// within each file. The order is thus independent of the order in which files
+// are reported by the build system, e.g. for declarations split across files
+// selected by build tags.
+func (g *Generator) structTypes() []*ast.Ident {
+	var idents []*ast.Ident
+	for ident, def := range g.pkg.defs {
+		named, ok := def.Type().(*types.Named)
+		if !ok || named.Obj() != def {
+			continue
+		}
+		if _, ok := named.Underlying().(*types.Struct); ok {
+			idents = append(idents, ident)
+		}
+	}
+	sort.Slice(idents, func(i, j int) bool {
+		pi, pj := g.position(idents[i].Pos()), g.position(idents[j].Pos())
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	return idents
+}
+
+// excludeFields records the struct fields of the package excluded from the
+// generated output; i.e. the fields matching one of the field patterns, and
+// the fields whose type matches one of the type patterns. Field patterns match
+// either the field name or the qualified field name (e.g. Header.cache), and
+// type patterns either the type name or the package-qualified type name (e.g.
+// sync.Mutex). Fields of arrays, slices and pointers of excluded types are
+// excluded as well.
+func (g *Generator) excludeFields(typePatterns, fieldPatterns []string) {
+	g.excludeTypes = g.matchers(typePatterns)
+	fieldMatch := g.matchers(fieldPatterns)
+	g.excluded = make(map[*types.Var]bool)
+	for _, ident := range g.structTypes() {
+		st := g.pkg.defs[ident].Type().Underlying().(*types.Struct)
+		for i := 0; i < st.NumFields(); i++ {
+			field := st.Field(i)
+			if matchAny(fieldMatch, field.Name()) || matchAny(fieldMatch, ident.Name+"."+field.Name()) || g.excludedType(field.Type()) || g.fieldDirectives[field].Has("skip") || g.omitUnexported(field) {
+				g.excluded[field] = true
+			}
+		}
+	}
+}
+
+// excludedType reports whether the given type, or the element type of arrays,
+// slices and pointers, is omitted by -exclude-type.
+func (g *Generator) excludedType(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Array:
+		return g.excludedType(t.Elem())
+	case *types.Slice:
+		return g.excludedType(t.Elem())
+	case *types.Pointer:
+		return g.excludedType(t.Elem())
+	case *types.Named:
+		name := t.Obj().Name()
+		if matchAny(g.excludeTypes, name) {
+			return true
+		}
+		if pkg := t.Obj().Pkg(); pkg != nil && pkg.Path() == g.pkg.path && g.typeDirectives[name].Has("skip") {
+			return true
+		}
+		if pkg := t.Obj().Pkg(); pkg != nil {
+			return matchAny(g.excludeTypes, pkg.Name()+"."+name)
+		}
+	}
+	return false
+}
+
+// matchers returns the name matching functions of the given patterns.
+func (g *Generator) matchers(patterns []string) []func(name string) bool {
+	var matchers []func(name string) bool
+	for _, pattern := range patterns {
+		match, err := typeMatcher(pattern)
+		if err != nil {
+			g.errorf(token.NoPos, "invalid pattern %q; %v", pattern, err)
+			continue
+		}
+		if match == nil {
+			name := pattern
+			match = func(s string) bool { return s == name }
+		}
+		matchers = append(matchers, match)
+	}
+	return matchers
+}
+
+// matchAny reports whether the name matches any of the given matchers.
+func matchAny(matchers []func(name string) bool, name string) bool {
+	for _, match := range matchers {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
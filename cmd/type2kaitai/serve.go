@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mewrev/tools/internal/load"
+	"golang.org/x/tools/go/packages"
+)
+
+// The -serve mode serves the generated output of the types of a package over
+// HTTP, e.g. to import the Kaitai spec into the Kaitai Web IDE by URL:
+//
+//	type2kaitai -serve :8080 -type Header ./format
+//
+// The output of each format is served at the default base name of its output
+// file (e.g. /header_type.ksy and /header_type.go), with the IR at
+// /header_type.ir.json, and an HTML view of the -format output at /. The Go
+// source files of the package are polled for changes, on which the package is
+// reloaded and the output regenerated; the HTML view reloads itself.
+
+// servePollInterval is the interval at which the Go source files of the package
+// are polled for changes with -serve.
+const servePollInterval = time.Second
+
+// irFormat is the output format of the IR of the types, as served with -serve.
+const irFormat = "ir"
+
+// server serves the generated output of the types of a package with -serve.
+type server struct {
+	// Package patterns of the command line, or import path of the package.
+	args []string
+	// Package load options.
+	loadCfg *load.Config
+	// -type patterns.
+	patterns []string
+	// Identifier renames and -config file of the generators.
+	renames map[string]string
+	cfg     *config
+	// Output formats served, with the -format output first.
+	formats []string
+
+	// mu guards the fields below, which are updated on reload.
+	mu sync.Mutex
+	// Loaded package; nil if the package failed to load.
+	pkg *packages.Package
+	// Problems loading the package.
+	loadErrs []string
+	// Directory of the package, as of the last load with source files.
+	dir string
+	// Fingerprint of the Go source files of the directory; see fingerprint.
+	files string
+	// Version of the package; incremented on reload.
+	version int
+	// outputs maps from output format to generated output, generated on first
+	// request after each reload.
+	outputs map[string]*servedOutput
+	// names maps from base names of output files to output format.
+	names map[string]string
+}
+
+// servedOutput is the generated output of an output format.
+type servedOutput struct {
+	// Output format.
+	format string
+	// Base name of the output file.
+	name string
+	// Generated output.
+	data []byte
+	// Problems found during generation.
+	problems []string
+}
+
+// serve serves the generated output of the types of the given package selected
+// by the given -type patterns on the given HTTP address, regenerating the
+// output when the Go source files of the package change. serve does not return.
+func serve(addr string, pkg *packages.Package, args []string, loadCfg *load.Config, patterns []string, renames map[string]string, cfg *config) {
+	s := &server{
+		loadCfg:  loadCfg,
+		patterns: patterns,
+		renames:  renames,
+		cfg:      cfg,
+	}
+	// Packages given as files are reloaded from the files; others by import
+	// path, as selected by -pkg.
+	s.args = []string{pkg.PkgPath}
+	if strings.HasSuffix(args[0], ".go") {
+		s.args = args
+	}
+	s.formats = []string{*outputFormat}
+	if *emitIR {
+		s.formats[0] = irFormat
+	}
+	for format := range formatExts {
+		if format != s.formats[0] {
+			s.formats = append(s.formats, format)
+		}
+	}
+	sort.Strings(s.formats[1:])
+	if s.formats[0] != irFormat {
+		s.formats = append(s.formats, irFormat)
+	}
+	s.update(pkg)
+	go s.watch()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveHTTP)
+	logf(levelInfo, "serve", logAttrs{"addr": addr}, "serving generated output of package %s on %s", pkg.PkgPath, addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fatal(err)
+	}
+}
+
+// update records the given package, loaded by the go command, discarding the
+// output generated from the previous version of the package. update returns
+// the version of the package.
+func (s *server) update(pkg *packages.Package) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.pkg = pkg
+	s.loadErrs = nil
+	for _, err := range pkg.Errors {
+		s.loadErrs = append(s.loadErrs, err.Error())
+	}
+	if len(s.loadErrs) > 0 {
+		s.pkg = nil
+	}
+	if len(pkg.GoFiles) > 0 {
+		s.dir = filepath.Dir(pkg.GoFiles[0])
+	}
+	s.files = fingerprint(s.dir)
+	s.outputs = make(map[string]*servedOutput)
+	s.names = make(map[string]string)
+	if s.pkg == nil {
+		return s.version
+	}
+	// Generate the -format output, to determine the base names of the output
+	// files.
+	out := s.output(s.formats[0])
+	if len(out.name) == 0 {
+		return s.version
+	}
+	base := strings.TrimSuffix(out.name, formatExt(s.formats[0]))
+	for _, format := range s.formats {
+		s.names[base+formatExt(format)] = format
+	}
+	return s.version
+}
+
+// watch polls the Go source files of the directory of the package for
+// changes, on which the package is reloaded and the output regenerated.
+func (s *server) watch() {
+	for range time.Tick(servePollInterval) {
+		s.mu.Lock()
+		dir, old := s.dir, s.files
+		s.mu.Unlock()
+		if fingerprint(dir) == old {
+			continue
+		}
+		start := time.Now()
+		pkgs, err := load.Load(s.loadCfg, s.args...)
+		switch {
+		case err != nil:
+			pkgs = []*packages.Package{{PkgPath: s.args[0], Errors: []packages.Error{{Msg: err.Error()}}}}
+		case len(pkgs) != 1:
+			pkgs = []*packages.Package{{PkgPath: s.args[0], Errors: []packages.Error{{Msg: fmt.Sprintf("expected one package; got %d", len(pkgs))}}}}
+		}
+		version := s.update(pkgs[0])
+		elapsed := time.Since(start)
+		logf(levelInfo, "reload", logAttrs{"package": pkgs[0].PkgPath, "version": version, "elapsed": elapsed}, "reloaded package %s in %v", pkgs[0].PkgPath, elapsed)
+		for _, err := range pkgs[0].Errors {
+			logf(levelError, "load_error", logAttrs{"package": pkgs[0].PkgPath}, "error: %v", err)
+		}
+	}
+}
+
+// output returns the generated output of the given output format, generating
+// it on first use. s.mu must be held.
+func (s *server) output(format string) *servedOutput {
+	if out, ok := s.outputs[format]; ok {
+		return out
+	}
+	out := &servedOutput{format: format}
+	s.outputs[format] = out
+	g := newGenerator(s.renames, s.cfg)
+	g.addPackage(s.pkg)
+	defined, types := g.analyzePackage(s.patterns)
+	if len(defined) == 0 {
+		out.problems = append(g.errs, "no matching types found")
+		return out
+	}
+	if format == irFormat {
+		g.generateIR()
+	} else {
+		g.generateFormat(format)
+	}
+	out.name = g.outputBaseName(types, formatExt(format))
+	out.data = g.buf.Bytes()
+	out.problems = g.errs
+	return out
+}
+
+// formatExt returns the output file extension of the given output format.
+func formatExt(format string) string {
+	switch {
+	case format == irFormat:
+		return ".ir.json"
+	case strings.HasPrefix(format, execPrefix):
+		return execExt(format)
+	}
+	return formatExts[format]
+}
+
+// serveHTTP serves the output file of the requested base name, or the HTML view
+// of the -format output at /.
+func (s *server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Allow the Kaitai Web IDE to import the spec by URL.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch name {
+	case "":
+		s.serveIndex(w)
+		return
+	case "version":
+		// Polled by the HTML view to reload on change.
+		fmt.Fprintln(w, s.version)
+		return
+	}
+	format, ok := s.names[name]
+	if !ok {
+		if s.pkg == nil {
+			http.Error(w, strings.Join(s.loadErrs, "\n"), http.StatusInternalServerError)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	out := s.output(format)
+	if out.data == nil {
+		http.Error(w, strings.Join(out.problems, "\n"), http.StatusInternalServerError)
+		return
+	}
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasSuffix(name, ".json") {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Type2kaitai-Version", strconv.Itoa(s.version))
+	w.Write(out.data)
+}
+
+// serveIndex serves the HTML view of the -format output, with links to the
+// output of each format. s.mu must be held.
+func (s *server) serveIndex(w http.ResponseWriter) {
+	data := &indexData{
+		Package:  s.args[0],
+		Version:  s.version,
+		Problems: s.loadErrs,
+	}
+	if s.pkg != nil {
+		data.Package = s.pkg.PkgPath
+		out := s.output(s.formats[0])
+		data.Name = out.name
+		data.Output = string(out.data)
+		data.Problems = out.problems
+	}
+	for name, format := range s.names {
+		data.Links = append(data.Links, indexLink{Name: name, Format: format})
+	}
+	sort.Slice(data.Links, func(i, j int) bool {
+		return data.Links[i].Name < data.Links[j].Name
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTmpl.Execute(w, data); err != nil {
+		logf(levelError, "serve", nil, "error: %v", err)
+	}
+}
+
+// indexData is the data of the HTML view.
+type indexData struct {
+	Package  string
+	Version  int
+	Name     string
+	Output   string
+	Problems []string
+	Links    []indexLink
+}
+
+// indexLink is a link to the output of an output format.
+type indexLink struct {
+	Name   string
+	Format string
+}
+
+// indexTmpl is the template of the HTML view.
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>type2kaitai: {{.Package}}</title>
+<style>body { font-family: sans-serif; } pre { background: #f4f4f4; padding: 1em; } .problem { color: #b00; }</style>
+</head>
+<body>
+<h1>{{.Package}}</h1>
+<p>{{range .Links}}<a href="/{{.Name}}">{{.Name}}</a> ({{.Format}}) {{end}}</p>
+{{range .Problems}}<p class="problem">{{.}}</p>
+{{end}}{{if .Name}}<h2>{{.Name}}</h2>
+<pre>{{.Output}}</pre>
+{{end}}<script>
+setInterval(function() {
+	fetch("/version").then(function(r) { return r.text(); }).then(function(v) {
+		if (parseInt(v, 10) !== {{.Version}}) {
+			location.reload();
+		}
+	});
+}, 1000);
+</script>
+</body>
+</html>
+`))
+
+// fingerprint returns the fingerprint of the Go source files of the given
+// directory; their names, modification times and sizes, in sorted order. Added
+// and removed files thus change the fingerprint, as well as modified files.
+func fingerprint(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	sort.Strings(files)
+	buf := &strings.Builder{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(buf, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return buf.String()
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// Additional generators of the -gen flag.
+const (
+	// String methods of enum types, as by x/tools stringer.
+	genStringer = "stringer"
+	// MarshalText and UnmarshalText methods of enum types.
+	genEnumText = "enumtext"
+	// MarshalJSON and UnmarshalJSON methods of enum types; implies enumtext.
+	genEnumJSON = "enumjson"
+	// Values functions and IsValid methods of enum types.
+	genEnumValues = "enumvalues"
+)
+
+// genNames lists the valid additional generators of the -gen flag.
+var genNames = []string{genStringer, genEnumText, genEnumJSON, genEnumValues}
+
+// validGen reports whether the given name is a valid generator of the -gen
+// flag.
+func validGen(name string) bool {
+	for _, genName := range genNames {
+		if name == genName {
+			return true
+		}
+	}
+	return false
+}
+
+// genEnabled reports whether the given additional generator is enabled by the
+// -gen flag.
+func genEnabled(name string) bool {
+	for _, genName := range splitList(*gen) {
+		if name == genName {
+			return true
+		}
+	}
+	return false
+}
+
+// stringerRunThreshold is the maximum number of runs of consecutive values of
+// enum types of which the String method switches on the runs; String methods
+// of enum types with more runs look up the name in a map.
+const stringerRunThreshold = 10
+
+// stringerValue is a constant of an enum type of a String method.
+type stringerValue struct {
+	// Go constant name.
+	name string
+	// Integer value, in decimal notation.
+	str string
+	// Bits of the value; the two's complement of signed values.
+	value uint64
+	// Signed underlying integer type.
+	signed bool
+}
+
+// writeStringers writes the String methods of the enum types of the package in
+// dir (-gen stringer), one file srcdir/<type>_string.go per enum type, as
+// written by x/tools stringer with -type=<type>; so the output of the two
+// generators may replace each other. Enum types which already have a String
+// method declared elsewhere are skipped.
+func (g *Generator) writeStringers(dir string) {
+	for _, e := range g.mod.Enums {
+		if e.Package != g.pkg.path {
+			// String methods may only be declared in the package of the type.
+			continue
+		}
+		outputName := filepath.Join(dir, strings.ToLower(e.Name)+"_string.go")
+		if pos, ok := g.declaredMethod(e.Name, outputName, "String"); ok {
+			warnf("stringer", logAttrs{"name": e.Name, "pos": pos}, "skipping String method of type %s; already declared at %s", e.Name, pos)
+			continue
+		}
+		values, ok := stringerValues(e)
+		if !ok {
+			warnf("stringer", logAttrs{"name": e.Name}, "skipping String method of type %s; constant values out of range", e.Name)
+			continue
+		}
+		src := &bytes.Buffer{}
+		src.WriteString(g.headerComment("// "))
+		fmt.Fprintf(src, "\n")
+		fmt.Fprintf(src, "package %s\n", g.mod.Package)
+		fmt.Fprintf(src, "\n")
+		fmt.Fprintf(src, "import \"strconv\"\n")
+		stringerFunc(src, e.Name, values)
+		out, err := format.Source(src.Bytes())
+		if err != nil {
+			warnf("internal_error", nil, "internal error: invalid Go String method generated: %s", err)
+			out = src.Bytes()
+		}
+		logf(levelInfo, "stringer", logAttrs{"file": outputName}, "writing String method: %q", outputName)
+		if err := writeOutput(outputName, out, *writeIfChanged); err != nil {
+			fatalf("writing String method: %s", err)
+		}
+	}
+}
+
+// declaredMethod returns the source position of the first of the given
+// methods of the named type of the package, and a boolean indicating whether
+// the method is declared in a file other than the given file name; i.e. not
+// generated by an earlier run.
+func (g *Generator) declaredMethod(typeName, fileName string, names ...string) (string, bool) {
+	t := g.lookupType(typeName)
+	if t == nil {
+		return "", false
+	}
+	for i := 0; i < t.NumMethods(); i++ {
+		m := t.Method(i)
+		for _, name := range names {
+			if m.Name() != name {
+				continue
+			}
+			pos := g.pkg.fset.Position(m.Pos())
+			if filepath.Base(pos.Filename) == filepath.Base(fileName) {
+				continue
+			}
+			return pos.String(), true
+		}
+	}
+	return "", false
+}
+
+// stringerValues returns the constants of the given enum type, in source
+// order, and a boolean indicating whether their values are representable by
+// the underlying integer type. Blank constants are omitted.
+func stringerValues(e *ir.EnumDef) ([]stringerValue, bool) {
+	signed := e.Underlying != nil && e.Underlying.Kind == ir.Int
+	var values []stringerValue
+	for _, v := range e.Values {
+		if v.Name == "_" {
+			continue
+		}
+		sv := stringerValue{name: v.Name, str: v.Value, signed: signed}
+		if signed {
+			x, err := strconv.ParseInt(v.Value, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			sv.value = uint64(x)
+		} else {
+			x, err := strconv.ParseUint(v.Value, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			sv.value = x
+		}
+		values = append(values, sv)
+	}
+	return values, true
+}
+
+// stringerFunc writes the String method of the named enum type of the given
+// constants to w, preceded by a compile-time check of the constant values and
+// the tables of constant names.
+func stringerFunc(w *bytes.Buffer, typeName string, values []stringerValue) {
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func _() {\n")
+	fmt.Fprintf(w, "\t// An \"invalid array index\" compiler error signifies that the constant values have changed.\n")
+	fmt.Fprintf(w, "\t// Re-run the type2kaitai command to generate them again.\n")
+	fmt.Fprintf(w, "\tvar x [1]struct{}\n")
+	for _, v := range values {
+		str := v.str
+		if strings.HasPrefix(str, "-") {
+			str = "(" + str + ")"
+		}
+		fmt.Fprintf(w, "\t_ = x[%s-%s]\n", v.name, str)
+	}
+	fmt.Fprintf(w, "}\n")
+	runs := stringerRuns(values)
+	switch {
+	case len(runs) == 0:
+		return
+	case len(runs) == 1:
+		stringerOneRun(w, typeName, runs[0])
+	case len(runs) <= stringerRunThreshold:
+		stringerMultipleRuns(w, typeName, runs)
+	default:
+		stringerMap(w, typeName, runs)
+	}
+}
+
+// stringerRuns sorts the given constants by value, omits duplicate values (of
+// all but the first constant of each value in source order), and returns the
+// runs of consecutive values.
+func stringerRuns(values []stringerValue) [][]stringerValue {
+	values = append([]stringerValue(nil), values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i].signed {
+			return int64(values[i].value) < int64(values[j].value)
+		}
+		return values[i].value < values[j].value
+	})
+	var uniq []stringerValue
+	for i, v := range values {
+		if i > 0 && v.value == values[i-1].value {
+			continue
+		}
+		uniq = append(uniq, v)
+	}
+	var runs [][]stringerValue
+	for len(uniq) > 0 {
+		i := 1
+		for i < len(uniq) && uniq[i].value == uniq[i-1].value+1 {
+			i++
+		}
+		runs = append(runs, uniq[:i])
+		uniq = uniq[i:]
+	}
+	return runs
+}
+
+// stringerNames returns the name and index table declarations of the given
+// run of constants, with the given suffix appended to their identifiers; e.g.
+// `_Kind_name_0 = "AB"` and `_Kind_index_0 = [...]uint8{0, 1, 2}`.
+func stringerNames(typeName, suffix string, run []stringerValue) (name, index string) {
+	names := &strings.Builder{}
+	ends := make([]string, len(run))
+	for i, v := range run {
+		names.WriteString(v.name)
+		ends[i] = strconv.Itoa(names.Len())
+	}
+	name = fmt.Sprintf("_%s_name%s = %q", typeName, suffix, names.String())
+	index = fmt.Sprintf("_%s_index%s = [...]uint%d{0, %s}", typeName, suffix, stringerIndexSize(names.Len()), strings.Join(ends, ", "))
+	return name, index
+}
+
+// stringerIndexSize returns the size in bits of the unsigned integer elements
+// of the index table of names of the given total length.
+func stringerIndexSize(n int) int {
+	switch {
+	case n < 1<<8:
+		return 8
+	case n < 1<<16:
+		return 16
+	default:
+		return 32
+	}
+}
+
+// stringerOneRun writes the String method of the named enum type of a single
+// run of consecutive values.
+func stringerOneRun(w *bytes.Buffer, typeName string, run []stringerValue) {
+	name, index := stringerNames(typeName, "", run)
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "const %s\n", name)
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "var %s\n", index)
+	fmt.Fprintf(w, "\n")
+	lessThanZero := ""
+	if run[0].signed {
+		lessThanZero = "i < 0 || "
+	}
+	fmt.Fprintf(w, "func (i %s) String() string {\n", typeName)
+	if run[0].value == 0 {
+		fmt.Fprintf(w, "\tif %si >= %s(len(_%s_index)-1) {\n", lessThanZero, typeName, typeName)
+		fmt.Fprintf(w, "\t\treturn \"%s(\" + strconv.FormatInt(int64(i), 10) + \")\"\n", typeName)
+	} else {
+		fmt.Fprintf(w, "\ti -= %s\n", run[0].str)
+		fmt.Fprintf(w, "\tif %si >= %s(len(_%s_index)-1) {\n", lessThanZero, typeName, typeName)
+		fmt.Fprintf(w, "\t\treturn \"%s(\" + strconv.FormatInt(int64(i+%s), 10) + \")\"\n", typeName, run[0].str)
+	}
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn _%s_name[_%s_index[i]:_%s_index[i+1]]\n", typeName, typeName, typeName)
+	fmt.Fprintf(w, "}\n")
+}
+
+// stringerMultipleRuns writes the String method of the named enum type of
+// the given runs of consecutive values, switching on the runs.
+func stringerMultipleRuns(w *bytes.Buffer, typeName string, runs [][]stringerValue) {
+	var names, indexes []string
+	for i, run := range runs {
+		name, index := stringerNames(typeName, fmt.Sprintf("_%d", i), run)
+		names = append(names, name)
+		if len(run) > 1 {
+			indexes = append(indexes, index)
+		}
+	}
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "const (\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+	fmt.Fprintf(w, ")\n")
+	if len(indexes) > 0 {
+		fmt.Fprintf(w, "\n")
+		fmt.Fprintf(w, "var (\n")
+		for _, index := range indexes {
+			fmt.Fprintf(w, "\t%s\n", index)
+		}
+		fmt.Fprintf(w, ")\n")
+	}
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (i %s) String() string {\n", typeName)
+	fmt.Fprintf(w, "\tswitch {\n")
+	for i, run := range runs {
+		first, last := run[0], run[len(run)-1]
+		if len(run) == 1 {
+			fmt.Fprintf(w, "\tcase i == %s:\n", first.str)
+			fmt.Fprintf(w, "\t\treturn _%s_name_%d\n", typeName, i)
+			continue
+		}
+		if first.value == 0 && !first.signed {
+			// For an unsigned lower bound of 0, "0 <= i" would be redundant.
+			fmt.Fprintf(w, "\tcase i <= %s:\n", last.str)
+		} else {
+			fmt.Fprintf(w, "\tcase %s <= i && i <= %s:\n", first.str, last.str)
+		}
+		if first.value != 0 {
+			fmt.Fprintf(w, "\t\ti -= %s\n", first.str)
+		}
+		fmt.Fprintf(w, "\t\treturn _%s_name_%d[_%s_index_%d[i]:_%s_index_%d[i+1]]\n", typeName, i, typeName, i, typeName, i)
+	}
+	fmt.Fprintf(w, "\tdefault:\n")
+	fmt.Fprintf(w, "\t\treturn \"%s(\" + strconv.FormatInt(int64(i), 10) + \")\"\n", typeName)
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// stringerMap writes the String method of the named enum type of the given
+// runs of consecutive values, looking up the name in a map.
+func stringerMap(w *bytes.Buffer, typeName string, runs [][]stringerValue) {
+	names := &strings.Builder{}
+	for _, run := range runs {
+		for _, v := range run {
+			names.WriteString(v.name)
+		}
+	}
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "const _%s_name = %q\n", typeName, names.String())
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "var _%s_map = map[%s]string{\n", typeName, typeName)
+	n := 0
+	for _, run := range runs {
+		for _, v := range run {
+			fmt.Fprintf(w, "\t%s: _%s_name[%d:%d],\n", v.str, typeName, n, n+len(v.name))
+			n += len(v.name)
+		}
+	}
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "func (i %s) String() string {\n", typeName)
+	fmt.Fprintf(w, "\tif str, ok := _%s_map[i]; ok {\n", typeName)
+	fmt.Fprintf(w, "\t\treturn str\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn \"%s(\" + strconv.FormatInt(int64(i), 10) + \")\"\n", typeName)
+	fmt.Fprintf(w, "}\n")
+}
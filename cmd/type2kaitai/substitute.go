@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// parseSubstitutes parses the given comma-separated list of type substitutions
+// of the form OldType=NewType; e.g. Handle=uint32,Stamp=[8]byte.
+func parseSubstitutes(s string) (map[string]string, error) {
+	substitutes := make(map[string]string)
+	for _, substitute := range splitList(s) {
+		pos := strings.Index(substitute, "=")
+		if pos == -1 {
+			return nil, fmt.Errorf("invalid substitute %q; expected OldType=NewType", substitute)
+		}
+		oldType, newType := strings.TrimSpace(substitute[:pos]), strings.TrimSpace(substitute[pos+1:])
+		if len(oldType) == 0 || len(newType) == 0 {
+			return nil, fmt.Errorf("invalid substitute %q; expected OldType=NewType", substitute)
+		}
+		substitutes[oldType] = newType
+	}
+	return substitutes, nil
+}
+
+// resolveSubstitutes resolves the types of the type substitutions of
+// -substitute and the -config file, which are Go type expressions evaluated
+// in the scope of the package; e.g. uint32, [8]byte or Header. Substituted
+// types are named types, given by their name if defined in the package (e.g.
+// Handle), and qualified by package name otherwise (e.g. time.Duration).
+func (g *Generator) resolveSubstitutes() {
+	g.substTypes = make(map[string]types.Type)
+	var oldTypes []string
+	for oldType := range g.substitutes {
+		oldTypes = append(oldTypes, oldType)
+	}
+	sort.Strings(oldTypes)
+	for _, oldType := range oldTypes {
+		newType := g.substitutes[oldType]
+		tv, err := types.Eval(g.pkg.fset, g.pkg.types, token.NoPos, newType)
+		if err != nil || !tv.IsType() {
+			g.errorf(token.NoPos, "invalid substitute %s=%s; %s is not a type of package %s", oldType, newType, newType, g.pkg.path)
+			continue
+		}
+		g.substTypes[oldType] = tv.Type
+	}
+	// Substitutes are not substituted in turn.
+	for _, oldType := range oldTypes {
+		if sub, ok := g.substTypes[oldType]; ok && g.substitute(sub) != sub {
+			g.errorf(token.NoPos, "invalid substitute %s=%s; %s is substituted in turn", oldType, g.substitutes[oldType], g.substitutes[oldType])
+			delete(g.substTypes, oldType)
+		}
+	}
+}
+
+// substitute returns the type substituted for the given type by -substitute,
+// or t if not substituted. Fields of substituted types are output as if they
+// were of the substitute type; e.g. a wrapper type as its underlying integer
+// type.
+func (g *Generator) substitute(t types.Type) types.Type {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || len(g.substTypes) == 0 {
+		return t
+	}
+	name := named.Obj().Name()
+	if pkg := named.Obj().Pkg(); pkg != nil && pkg.Path() != g.pkg.path {
+		name = pkg.Name() + "." + name
+	}
+	if sub, ok := g.substTypes[name]; ok {
+		return sub
+	}
+	return t
+}
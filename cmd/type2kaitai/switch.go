@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+	"go/types"
+	"strconv"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/structtag"
+	"gopkg.in/yaml.v3"
+)
+
+// switchType returns the Kaitai type specification of the given field of the
+// struct type, selected by the value of the field or parameter of the
+// switch-on option of the struct tag of the field; e.g. for versioned formats
+// whose layout changes by version. The boolean result indicates whether the
+// switch-on option is present.
+//
+// Example:
+//
+//	type File struct {
+//	    Version uint16
+//	    Body    interface{} `kaitai:"switch-on=Version,cases=1:BodyV1;2:BodyV2"`
+//	}
+//
+// is output as
+//
+//	id: body
+//	type:
+//	  switch-on: version
+//	  cases:
+//	    1: body_v1
+//	    2: body_v2
+func (g *Generator) switchType(s *ir.StructDef, f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.SwitchOn) == 0 {
+		return nil, false, nil
+	}
+	on := f.SwitchOn
+	switch field := s.Field(on); {
+	case field != nil:
+		if s.Precedes(f, field) {
+			return nil, true, fmt.Errorf("switch-on field %s must precede field %s", on, f.Name)
+		}
+		on = field.ID
+	case s.Param(on) != nil:
+	default:
+		return nil, true, fmt.Errorf("switch-on %s is neither a field of %s nor a parameter", on, s.Name)
+	}
+	cases := ksyMap()
+	seen := make(map[string]bool)
+	for _, c := range f.Cases {
+		if seen[c.Value] {
+			name := c.Value
+			if len(c.Const) > 0 {
+				name = c.Const
+			}
+			return nil, true, fmt.Errorf("duplicate case %s", name)
+		}
+		seen[c.Value] = true
+		g.namedTypeDeps[c.Type.Name] = true
+		ksyAdd(cases, c.Value, ksyValue(g.variantID(c.Type), c.Const))
+	}
+	typ := ksyMap()
+	ksyAdd(typ, "switch-on", ksyValue(on, ""))
+	ksyAdd(typ, "cases", cases)
+	spec := ksyMap()
+	ksyAdd(spec, "type", typ)
+	return spec, true, nil
+}
+
+// caseValue returns the Kaitai value of the given case of the switch-on option;
+// either an integer literal, the name of an integer constant of the package, or
+// "_" for the default case. The name of constants is returned as comment.
+func (g *Generator) caseValue(value string) (kaiValue, comment string, err error) {
+	if value == "_" {
+		return value, "", nil
+	}
+	if _, err := strconv.ParseInt(value, 0, 64); err == nil {
+		return value, "", nil
+	}
+	c, ok := g.lookupObject(value).(*types.Const)
+	if !ok || c.Val().Kind() != constant.Int {
+		return "", "", fmt.Errorf("invalid case %s; expected integer literal or integer constant", value)
+	}
+	return c.Val().ExactString(), value, nil
+}
+
+// caseType returns the named struct type of the given case of the switch-on
+// option.
+func (g *Generator) caseType(typeName string) (*types.Named, error) {
+	obj, ok := g.lookupObject(typeName).(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("case type %s not defined in package %s", typeName, g.pkg.path)
+	}
+	t, ok := obj.Type().(*types.Named)
+	if !ok {
+		return nil, fmt.Errorf("case type %s is not a struct type", typeName)
+	}
+	if _, ok := t.Underlying().(*types.Struct); !ok {
+		return nil, fmt.Errorf("case type %s is not a struct type", typeName)
+	}
+	return t, nil
+}
+
+// switchCases returns the named struct types of the cases of the switch-on
+// option of the struct tag of the given field, ignoring invalid cases.
+func (g *Generator) switchCases(tag structtag.Tag) []*types.Named {
+	_, cases, ok, err := tag.SwitchOn()
+	if !ok || err != nil {
+		return nil
+	}
+	var ts []*types.Named
+	for _, c := range cases {
+		if t, err := g.caseType(c.Type); err == nil {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// lookupObject returns the object of the given name, as defined at the
+// top-level of the package, or nil if not defined.
+func (g *Generator) lookupObject(name string) types.Object {
+	for ident, def := range g.pkg.info.Defs {
+		if ident.Name != name || def == nil || def.Pkg() == nil {
+			continue
+		}
+		if def.Parent() == def.Pkg().Scope() {
+			return def
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/ir"
+	"github.com/mewrev/tools/internal/kaitai"
+	"github.com/mewrev/tools/internal/structtag"
+	"gopkg.in/yaml.v3"
+)
+
+// fixedString returns the Kaitai type of a fixed-size array field of bytes, or
+// of UTF-16 or UTF-32 code units, tagged as a string (str) or null-terminated
+// string (strz), and a boolean indicating whether the field is tagged as such.
+func (g *Generator) fixedString(f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.Str) == 0 {
+		return nil, false, nil
+	}
+	arr := f.Type.Under()
+	if arr.Kind != ir.Array || !isCodeUnit(arr.Elem) {
+		return nil, true, fmt.Errorf("str and strz options only valid for arrays of bytes, uint16 or uint32; got %s", f.Type.Go)
+	}
+	encoding, err := g.strEncoding(f, arr.Elem.Size)
+	if err != nil {
+		return nil, true, err
+	}
+	size := g.arrayLen(arr)
+	if arr.Elem.Size > 1 {
+		// Size in bytes of the code units.
+		if _, err := strconv.ParseInt(size, 10, 64); err == nil {
+			size = strconv.FormatInt(arr.Len*arr.Elem.Size, 10)
+		} else {
+			size = fmt.Sprintf("%s * %d", size, arr.Elem.Size)
+		}
+	}
+	// strz is shorthand of str terminated by a null byte, with the default
+	// options of the terminator.
+	term := byte(0)
+	if f.Terminator != nil {
+		term = *f.Terminator
+	}
+	explicit := f.Str == "str" && f.Terminator != nil || f.Str == "strz" && (term != 0 || f.Include || f.NoConsume || f.NoEOSError)
+	if !explicit {
+		spec := ksySpec("type", f.Str, "")
+		ksyAdd(spec, "size", ksyValue(size, ""))
+		ksyAdd(spec, "encoding", ksyValue(encoding, f.Type.Go))
+		return spec, true, nil
+	}
+	if structtag.EncodingUnitSize(encoding) > 1 {
+		return nil, true, fmt.Errorf("terminator options not valid for encoding %s of multi-byte code units", encoding)
+	}
+	spec := ksySpec("type", "str", "")
+	ksyAdd(spec, "size", ksyValue(size, ""))
+	addTerminator(spec, term, f)
+	ksyAdd(spec, "encoding", ksyValue(encoding, f.Type.Go))
+	return spec, true, nil
+}
+
+// addTerminator adds the given terminator byte to the attribute specification,
+// along with the Kaitai keys of the include, consume and eos-error options of
+// the terminator of the given field, if not the default options.
+func addTerminator(spec *yaml.Node, term byte, f *ir.Field) {
+	ksyAdd(spec, "terminator", ksyValue(fmt.Sprintf("0x%02x", term), ""))
+	if f.Include {
+		ksyAdd(spec, "include", ksyValue("true", ""))
+	}
+	if f.NoConsume {
+		ksyAdd(spec, "consume", ksyValue("false", ""))
+	}
+	if f.NoEOSError {
+		ksyAdd(spec, "eos-error", ksyValue("false", ""))
+	}
+}
+
+// isCodeUnit reports whether the given type is the type of the code units of
+// fixed-size strings; bytes, and uint16 and uint32 of UTF-16 and UTF-32.
+func isCodeUnit(t *ir.Type) bool {
+	return t.IsByte() || t.Kind == ir.Uint && (t.Size == 2 || t.Size == 4)
+}
+
+// strEncoding returns the Kaitai encoding of the given string field with code
+// units of the given size in bytes, as specified by the encoding option (see
+// structtag.StrEncoding), with the byte order of the field, variant or binary
+// format.
+func (g *Generator) strEncoding(f *ir.Field, unitSize int64) (string, error) {
+	endian := f.Endian
+	if len(endian) == 0 {
+		// Byte order of the variant being generated; see variantID.
+		endian = g.typeEndian
+	}
+	if len(endian) == 0 {
+		endian = g.mod.Endian
+	}
+	if len(endian) == 0 {
+		endian = "le"
+	}
+	encoding, err := structtag.StrEncoding(f.Encoding, unitSize, endian)
+	if err != nil {
+		return "", fmt.Errorf("%v of %s", err, f.Type.Go)
+	}
+	return encoding, nil
+}
+
+// reEndianType matches the Kaitai types of multi-byte integers and floats,
+// which may have an endianness suffix.
+var reEndianType = regexp.MustCompile(`^([us][248]|f[48])$`)
+
+// withEndian appends the endianness suffix to the type of the given Kaitai type
+// specification, if the type of multi-byte integers and floats; e.g. u4be.
+func withEndian(kaiType *yaml.Node, endian string) {
+	if typ := kaitai.Lookup(kaiType, "type"); typ != nil && reEndianType.MatchString(typ.Value) {
+		typ.Value += endian
+	}
+}
+
+// kaiContents returns the Kaitai array notation of the given magic contents.
+func kaiContents(buf []byte) string {
+	var elems []string
+	for _, b := range buf {
+		elems = append(elems, fmt.Sprintf("0x%02x", b))
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// substream returns the Kaitai type of a field tagged with the size option,
+// parsed from a bounded substream (e.g. the value of a TLV record), and a
+// boolean indicating whether the field is tagged as such. The size is either
+// stored in another field of the given struct type, or an integer literal.
+func (g *Generator) substream(s *ir.StructDef, f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.Size) == 0 {
+		return nil, false, nil
+	}
+	expr, err := g.sizeExpr(s, f.Size)
+	if err != nil {
+		return nil, true, err
+	}
+	goType := f.Type.Go
+	switch u := f.Type.Under(); u.Kind {
+	case ir.Slice:
+		if u.Elem.IsByte() {
+			return ksySpec("size", expr, goType), true, nil
+		}
+	case ir.String:
+		encoding, err := g.strEncoding(f, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		spec := ksySpec("type", "str", "")
+		ksyAdd(spec, "size", ksyValue(expr, ""))
+		ksyAdd(spec, "encoding", ksyValue(encoding, goType))
+		return spec, true, nil
+	case ir.Struct:
+		if f.Type.Kind == ir.Named || len(f.Type.ID) > 0 {
+			spec, err := g.kaiType(f.Type)
+			if err != nil {
+				return nil, true, err
+			}
+			ksyAdd(spec, "size", ksyValue(expr, ""))
+			return spec, true, nil
+		}
+	}
+	return nil, true, fmt.Errorf("size option only valid for byte slices, strings and struct types; got %s", goType)
+}
+
+// sizeExpr returns the Kaitai expression of the given size option; either the
+// name of an integer field of the given struct type, a parameter of the type,
+// or an integer literal.
+func (g *Generator) sizeExpr(s *ir.StructDef, size string) (string, error) {
+	if _, err := strconv.ParseUint(size, 0, 64); err == nil {
+		return size, nil
+	}
+	if s.Param(size) != nil {
+		return size, nil
+	}
+	if field := s.Field(size); field != nil {
+		if !field.Type.IsInteger() {
+			return "", fmt.Errorf("size field %s not of integer type; got %s", size, field.Type.Go)
+		}
+		return field.ID + varintValue(field), nil
+	}
+	return "", fmt.Errorf("size %q is neither an integer, a field nor a parameter of %s", size, s.Name)
+}
+
+// repetition returns the Kaitai type of a slice field tagged with the repeat
+// option, and a boolean indicating whether the field is tagged as such.
+func (g *Generator) repetition(s *ir.StructDef, f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.Repeat) == 0 {
+		return nil, false, nil
+	}
+	goType := f.Type.Go
+	slice := f.Type.Under()
+	if slice.Kind != ir.Slice {
+		return nil, true, fmt.Errorf("repeat option only valid for slices; got %s", goType)
+	}
+	spec, err := g.kaiType(slice.Elem)
+	if err != nil {
+		return nil, true, err
+	}
+	switch f.Repeat {
+	case "until":
+		ksyAdd(spec, "repeat", ksyValue("until", ""))
+		ksyAdd(spec, "repeat-until", ksyValue(f.RepeatExpr, goType))
+	case "eos":
+		ksyAdd(spec, "repeat", ksyValue("eos", goType))
+	case "expr":
+		n, err := g.sizeExpr(s, f.RepeatExpr)
+		if err != nil {
+			return nil, true, err
+		}
+		ksyAdd(spec, "repeat", ksyValue("expr", ""))
+		ksyAdd(spec, "repeat-expr", ksyValue(n, goType))
+	}
+	return spec, true, nil
+}
+
+// terminated returns the Kaitai type of a byte slice or string field tagged
+// with the terminator option, and a boolean indicating whether the field is
+// tagged as such.
+func (g *Generator) terminated(f *ir.Field) (*yaml.Node, bool, error) {
+	if f.Terminator == nil || len(f.Str) > 0 {
+		// The terminator of fixed-size strings is handled by fixedString.
+		return nil, false, nil
+	}
+	term := *f.Terminator
+	goType := f.Type.Go
+	switch u := f.Type.Under(); u.Kind {
+	case ir.Slice:
+		if u.Elem.IsByte() {
+			spec := ksyMap()
+			addTerminator(spec, term, f)
+			ksyNote(spec, goType)
+			return spec, true, nil
+		}
+	case ir.String:
+		encoding, err := g.strEncoding(f, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		if structtag.EncodingUnitSize(encoding) > 1 {
+			return nil, true, fmt.Errorf("terminator option not valid for encoding %s of multi-byte code units; use the size option", encoding)
+		}
+		spec := ksySpec("type", "str", "")
+		addTerminator(spec, term, f)
+		ksyAdd(spec, "encoding", ksyValue(encoding, goType))
+		return spec, true, nil
+	}
+	return nil, true, fmt.Errorf("terminator option only valid for byte slices and strings; got %s", goType)
+}
+
+// withProcess adds the process routine of the process option of the field, if
+// any, to the given Kaitai attribute specification. Processing applies to a
+// known number of bytes; i.e. byte arrays and fields tagged with the size
+// option.
+func withProcess(spec *yaml.Node, f *ir.Field) error {
+	if len(f.Process) == 0 {
+		return nil
+	}
+	if kaitai.Lookup(spec, "size") == nil {
+		return fmt.Errorf("process option only valid for byte arrays and fields tagged with the size option")
+	}
+	ksyAdd(spec, "process", ksyValue(f.Process, ""))
+	return nil
+}
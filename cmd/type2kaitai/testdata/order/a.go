@@ -0,0 +1,22 @@
+// Package order is a package of struct types declared across files, for tests
+// of the order of the generated seq attributes.
+package order
+
+// Common is embedded by Header.
+type Common struct {
+	Version uint8
+	Flags   uint8
+}
+
+// Entry is declared before Header, in a different file.
+type Entry struct {
+	Offset uint32
+	Size   uint32
+}
+
+// EntryCount is declared in a different file than Header, before TotalLen.
+//
+//kaitai:instance
+func (h Header) EntryCount() uint16 {
+	return 2
+}
@@ -0,0 +1,16 @@
+package order
+
+type Header struct {
+	Magic [4]byte
+	Common
+	Len     uint16
+	Word    Word
+	Entries [2]Entry
+}
+
+// TotalLen is declared after EntryCount.
+//
+//kaitai:instance
+func (h Header) TotalLen() uint16 {
+	return h.Len + 4
+}
@@ -0,0 +1,7 @@
+package order
+
+// Word is declared per architecture, by build constraints of the file name.
+type Word struct {
+	Lo uint32
+	Hi uint32
+}
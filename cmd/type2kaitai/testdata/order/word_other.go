@@ -0,0 +1,9 @@
+//go:build !amd64
+
+package order
+
+// Word is declared per architecture, by build constraints of the file name.
+type Word struct {
+	Lo uint16
+	Hi uint16
+}
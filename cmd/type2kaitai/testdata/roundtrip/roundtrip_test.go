@@ -0,0 +1,59 @@
+package roundtrip
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	want := Record{
+		Magic:  [4]byte{'R', 'E', 'C', 'D'},
+		Flag:   true,
+		Scale:  1.5,
+		Len:    3,
+		Data:   []byte{1, 2, 3},
+		Count:  2,
+		Points: []Point{{X: 1, Y: -2}, {X: -3, Y: 4}},
+		Name:   "abcdefgh",
+		BigLen: 0x0102,
+	}
+	want.Info.Version = 7
+	want.Info.Words = [2]int16{-1, 1}
+	buf := &bytes.Buffer{}
+	if err := WriteRecord(buf, want); err != nil {
+		t.Fatalf("unable to write record; %v", err)
+	}
+	got, err := ParseRecord(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to parse record; %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch; expected %+v, got %+v", want, got)
+	}
+}
+
+// TestCorrupt checks that lengths and counts read from corrupt input are
+// reported as errors, rather than causing panics or huge allocations.
+func TestCorrupt(t *testing.T) {
+	golden := []struct {
+		name string
+		in   []byte
+	}{
+		{name: "truncated", in: []byte("REC")},
+		{name: "invalid magic", in: []byte("RECX")},
+		{name: "negative size", in: []byte("RECD\x00\x00\x00\x00\x00\xff\xff\xff\xff")},
+		{name: "huge size", in: []byte("RECD\x00\x00\x00\x00\x00\xff\xff\xff\x3f")},
+		{name: "huge count", in: []byte("RECD\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\xff\xff\xff\xff")},
+		{name: "large count", in: []byte("RECD\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xff\x3f\x00\x00\x00\x00")},
+	}
+	for _, g := range golden {
+		if _, err := ParseRecord(bytes.NewReader(g.in)); err == nil {
+			t.Errorf("%s: expected error, got nil", g.name)
+		}
+	}
+	// Negative sizes of values written.
+	if err := WriteRecord(&bytes.Buffer{}, Record{Len: -1}); err == nil {
+		t.Errorf("negative size written: expected error, got nil")
+	}
+}
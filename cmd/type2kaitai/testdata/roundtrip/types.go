@@ -0,0 +1,25 @@
+// Package roundtrip defines the struct types of the round-trip test of the
+// generated Go code.
+package roundtrip
+
+type Record struct {
+	Magic  [4]byte `kaitai:"contents=RECD"`
+	Flag   bool
+	Scale  float32
+	Len    int32
+	Data   []byte `kaitai:"size=Len"`
+	Count  uint64
+	Points []Point `kaitai:"repeat=expr,expr=Count"`
+	Name   string  `kaitai:"size=8"`
+	Info   struct {
+		Version uint16
+		Words   [2]int16
+	}
+	BigLen uint16 `kaitai:"endian=be"`
+	// Not serialized; skipped by the -on-unsupported policy.
+	OnRead func()
+}
+
+type Point struct {
+	X, Y int16
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/format"
+	"go/token"
+	"go/types"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mewrev/tools/internal/layout"
+)
+
+// writeTests writes a Go test file next to the generated Go output of the given
+// file name (-gen-tests); e.g. header_type_test.go of header_type.go. For each
+// of the given types, the test parses the sample binary file of the type in
+// dir (see writeSamples) using the generated ParseT function, checks the
+// decoded value of each field, and checks that the generated WriteT function
+// reproduces the sample. Types of which the sample cannot be fully computed
+// (e.g. of slices of unknown length) are skipped.
+func (g *Generator) writeTests(dir, outputName string, typeNames []string) {
+	buf := &bytes.Buffer{}
+	local := g.localStructs()
+	for _, typeName := range typeNames {
+		if !local[typeName] {
+			// Parse functions are generated for the types of the package.
+			continue
+		}
+		samples, err := g.samples(typeName)
+		if err != nil {
+			warnf("truncated_sample", logAttrs{"name": typeName}, "skipping test of type %s; sample truncated; %v", typeName, err)
+			continue
+		}
+		sampleName, err := samplePath(dir, outputName, typeName)
+		if err != nil {
+			g.errorf(token.NoPos, "unable to generate test of type %s; %v", typeName, err)
+			g.failed = true
+			continue
+		}
+		g.testFunc(buf, typeName, sampleName, samples)
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	testName := strings.TrimSuffix(outputName, filepath.Ext(outputName)) + "_test.go"
+	g.writeTestFile(testName, "", buf.Bytes())
+}
+
+// samplePath returns the path of the sample binary file of the given type in
+// dir (see writeSamples), relative to the directory of the Go test file next to
+// the given output file, in which go test runs the tests of the package.
+func samplePath(dir, outputName, typeName string) (string, error) {
+	testDir, err := filepath.Abs(filepath.Dir(outputName))
+	if err != nil {
+		return "", err
+	}
+	sampleName, err := filepath.Abs(filepath.Join(dir, sampleFileName(typeName)))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(testDir, sampleName)
+	if err != nil {
+		return "", fmt.Errorf("unable to locate sample %s from %s; %v", sampleName, testDir, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// writeTestFile writes the Go test file of the given name, consisting of the
+// given test functions, preceded by the given build constraints, if any. The
+// test functions may use the bytes, io/ioutil and testing packages.
+func (g *Generator) writeTestFile(testName, constraints string, funcs []byte) {
+	src := &bytes.Buffer{}
+	src.WriteString(g.headerComment("// "))
+	fmt.Fprintf(src, "\n")
+	if len(constraints) > 0 {
+		fmt.Fprintf(src, "%s\n", constraints)
+		fmt.Fprintf(src, "\n")
+	}
+	fmt.Fprintf(src, "package %s\n", g.mod.Package)
+	fmt.Fprintf(src, "\n")
+	fmt.Fprintf(src, "import (\n")
+	fmt.Fprintf(src, "\t\"bytes\"\n")
+	fmt.Fprintf(src, "\t\"io/ioutil\"\n")
+	fmt.Fprintf(src, "\t\"testing\"\n")
+	fmt.Fprintf(src, ")\n")
+	src.Write(funcs)
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		warnf("internal_error", nil, "internal error: invalid Go test generated: %s", err)
+		out = src.Bytes()
+	}
+	logf(levelInfo, "test", logAttrs{"file": testName}, "writing test: %q", testName)
+	if err := writeOutput(testName, out, *writeIfChanged); err != nil {
+		fatalf("writing test: %s", err)
+	}
+}
+
+// localStructs returns the names of the struct types of the package being
+// generated; i.e. the types with generated Parse and Write functions.
+func (g *Generator) localStructs() map[string]bool {
+	local := make(map[string]bool)
+	for _, s := range g.mod.Structs {
+		if s.Package == g.mod.Path {
+			local[s.Name] = true
+		}
+	}
+	return local
+}
+
+// testFunc outputs the TestParseT function of the given type, checking the
+// decoded values of the fields of the sample.
+func (g *Generator) testFunc(buf *bytes.Buffer, name, sampleName string, samples []fieldSample) {
+	fmt.Fprintf(buf, "\n")
+	fmt.Fprintf(buf, "func TestParse%s(t *testing.T) {\n", name)
+	fmt.Fprintf(buf, "buf, err := ioutil.ReadFile(%q)\n", sampleName)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "t.Fatal(err)\n")
+	fmt.Fprintf(buf, "}\n")
+	fmt.Fprintf(buf, "v, err := Parse%s(bytes.NewReader(buf))\n", name)
+	fmt.Fprintf(buf, "if err != nil {\n")
+	fmt.Fprintf(buf, "t.Fatalf(\"unable to parse sample; %%v\", err)\n")
+	fmt.Fprintf(buf, "}\n")
+	roundTrip := true
+	for _, sample := range samples {
+		f := sample.field
+		if isBigIntSample(f) {
+			// Arbitrary-precision integers are checked by the round trip.
+			continue
+		}
+		expr := sampleExpr(f.Path)
+		want, ok := sampleWant(sample)
+		if !ok {
+			// Pointers and blank fields are not decoded.
+			roundTrip = false
+			continue
+		}
+		if isSlice(f.Type) {
+			fmt.Fprintf(buf, "if got := %s; !bytes.Equal(got, %s) {\n", expr, want)
+		} else {
+			fmt.Fprintf(buf, "if got := %s; got != %s {\n", expr, want)
+		}
+		fmt.Fprintf(buf, "t.Errorf(\"%s: got %%v, want %%v\", got, %s)\n", strings.TrimPrefix(expr, "v."), want)
+		fmt.Fprintf(buf, "}\n")
+	}
+	if roundTrip {
+		fmt.Fprintf(buf, "out := &bytes.Buffer{}\n")
+		fmt.Fprintf(buf, "if err := Write%s(out, v); err != nil {\n", name)
+		fmt.Fprintf(buf, "t.Fatalf(\"unable to write sample; %%v\", err)\n")
+		fmt.Fprintf(buf, "}\n")
+		fmt.Fprintf(buf, "if !bytes.Equal(out.Bytes(), buf) {\n")
+		fmt.Fprintf(buf, "t.Errorf(\"written sample differs; got %%x, want %%x\", out.Bytes(), buf)\n")
+		fmt.Fprintf(buf, "}\n")
+	}
+	fmt.Fprintf(buf, "}\n")
+}
+
+// isBigIntSample reports whether the given sample field is an
+// arbitrary-precision integer (big.Int or *big.Int) of the bigint option,
+// stored as a byte array.
+func isBigIntSample(f *layout.Field) bool {
+	t := f.Type
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	return ok && isBigIntType(named)
+}
+
+// sampleExpr returns the Go expression of the given field path, relative to
+// the parsed value v; e.g. v.Entries[1].Offset of Header.Entries[1].Offset.
+// The real and imaginary parts of complex numbers are selected by real and
+// imag.
+func sampleExpr(path string) string {
+	expr := "v"
+	if pos := strings.IndexAny(path, ".["); pos != -1 {
+		expr += path[pos:]
+	}
+	switch {
+	case strings.HasSuffix(expr, ".real"):
+		return "real(" + strings.TrimSuffix(expr, ".real") + ")"
+	case strings.HasSuffix(expr, ".imag"):
+		return "imag(" + strings.TrimSuffix(expr, ".imag") + ")"
+	}
+	return expr
+}
+
+// sampleWant returns the Go expression of the expected value of the given
+// field sample, and a boolean indicating whether the field is decoded.
+func sampleWant(sample fieldSample) (string, bool) {
+	f, data := sample.field, sample.data
+	switch f.Kind {
+	case layout.Bool:
+		return "true", true
+	case layout.Int, layout.Uint:
+		x := sampleUint(data, f.Endian)
+		if f.Kind == layout.Int && f.Size < 8 && x&(1<<uint(8*f.Size-1)) != 0 {
+			// Sign extend.
+			return strconv.FormatInt(int64(x)-1<<uint(8*f.Size), 10), true
+		}
+		if f.Kind == layout.Int {
+			return strconv.FormatInt(int64(x), 10), true
+		}
+		if x > math.MaxInt64 {
+			// Typed, as untyped constants passed to t.Errorf default to int.
+			return "uint64(" + strconv.FormatUint(x, 10) + ")", true
+		}
+		return strconv.FormatUint(x, 10), true
+	case layout.Float:
+		x := sampleUint(data, f.Endian)
+		if f.Size == 4 {
+			return strconv.FormatFloat(float64(math.Float32frombits(uint32(x))), 'g', -1, 32), true
+		}
+		return strconv.FormatFloat(math.Float64frombits(x), 'g', -1, 64), true
+	case layout.Contents, layout.Bytes, layout.Str, layout.Strz:
+		var elems []string
+		for _, b := range data {
+			elems = append(elems, fmt.Sprintf("0x%02x", b))
+		}
+		if isSlice(f.Type) {
+			return fmt.Sprintf("[]byte{%s}", strings.Join(elems, ", ")), true
+		}
+		return fmt.Sprintf("[%d]byte{%s}", len(data), strings.Join(elems, ", ")), true
+	}
+	return "", false
+}
+
+// isSlice reports whether the given type is a slice type; e.g. of byte slices
+// sized by a length field (see layout.Layout.Len), which are not comparable.
+func isSlice(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Slice)
+	return ok
+}
+
+// sampleUint returns the unsigned integer of the given sample data, using the
+// byte order of the given endianness.
+func sampleUint(data []byte, endian string) uint64 {
+	var buf [8]byte
+	if byteOrder(endian) == binary.BigEndian {
+		copy(buf[8-len(data):], data)
+		return binary.BigEndian.Uint64(buf[:])
+	}
+	copy(buf[:], data)
+	return binary.LittleEndian.Uint64(buf[:])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// decodeHelper is a helper function of the package decoding or encoding a
+// value, returning multiple results; e.g.
+//
+//	func readUint32(b []byte, off int) (uint32, int, error)
+//	func readEntries(r io.Reader, n int) ([]Entry, error)
+type decodeHelper struct {
+	// Byte order of the encoding/binary calls of the helper (le or be), or an
+	// empty string if none or both.
+	endian string
+	// Index of the parameter controlling the length of the slice of the first
+	// result, or -1 if none.
+	lenParam int
+}
+
+// decodeHelpers returns the helper functions of the package returning multiple
+// results (e.g. (T, int, error) tuples), as called by the encoding and decoding
+// functions; see decodeHelper. The byte order of a helper is given by its calls
+// to encoding/binary, e.g.
+//
+//	binary.Read(r, binary.BigEndian, &x)
+//	binary.BigEndian.Uint32(b[off:])
+//
+// and the length parameter n of a helper returning a slice by the following
+// patterns:
+//
+//	s := make([]T, n)
+//	for i := 0; i < int(n); i++ { s = append(s, elem) }
+func (g *Generator) decodeHelpers() map[*types.Func]*decodeHelper {
+	helpers := make(map[*types.Func]*decodeHelper)
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			obj, ok := g.pkg.info.Defs[fn.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+			sig := obj.Type().(*types.Signature)
+			if sig.Results().Len() < 2 {
+				continue
+			}
+			h := &decodeHelper{lenParam: -1}
+			params := make(map[types.Object]int)
+			for i := 0; i < sig.Params().Len(); i++ {
+				params[sig.Params().At(i)] = i
+			}
+			lenParam := func(expr ast.Expr) {
+				ident, ok := g.unconvert(expr).(*ast.Ident)
+				if !ok {
+					return
+				}
+				if i, ok := params[g.pkg.info.Uses[ident]]; ok {
+					h.lenParam = i
+				}
+			}
+			_, isSlice := sig.Results().At(0).Type().Underlying().(*types.Slice)
+			count := make(map[string]int)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					if endian, ok := g.callByteOrder(n); ok {
+						count[endian]++
+					}
+					// s := make([]T, n)
+					if arg, ok := g.builtinArg(n, "make", 1); ok && isSlice && types.Identical(g.pkg.info.TypeOf(n), sig.Results().At(0).Type()) {
+						lenParam(arg)
+					}
+				case *ast.ForStmt:
+					// for i := 0; i < int(n); i++ { s = append(s, elem) }
+					if cond, ok := n.Cond.(*ast.BinaryExpr); ok && cond.Op == token.LSS && isSlice && g.appends(n.Body) {
+						lenParam(cond.Y)
+					}
+				}
+				return true
+			})
+			switch {
+			case count["le"] > 0 && count["be"] == 0:
+				h.endian = "le"
+			case count["be"] > 0 && count["le"] == 0:
+				h.endian = "be"
+			}
+			if len(h.endian) > 0 || h.lenParam >= 0 {
+				helpers[obj] = h
+			}
+		}
+	}
+	return helpers
+}
+
+// helperCall returns the decode helper called by the given expression, and a
+// boolean indicating whether expr is such a call.
+func (g *Generator) helperCall(helpers map[*types.Func]*decodeHelper, expr ast.Expr) (*ast.CallExpr, *decodeHelper, bool) {
+	call, ok := g.unconvert(expr).(*ast.CallExpr)
+	if !ok {
+		return nil, nil, false
+	}
+	var ident *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		ident = fun
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	default:
+		return nil, nil, false
+	}
+	fn, ok := g.pkg.info.Uses[ident].(*types.Func)
+	if !ok {
+		return nil, nil, false
+	}
+	h, ok := helpers[fn]
+	return call, h, ok
+}
+
+// callByteOrder returns the byte order (le or be) of the given call to
+// encoding/binary, and a boolean indicating whether the call is such a call;
+// e.g. binary.Read(r, binary.BigEndian, &x) or binary.BigEndian.Uint32(b).
+func (g *Generator) callByteOrder(call *ast.CallExpr) (string, bool) {
+	if g.isBinaryFunc(call.Fun) && len(call.Args) == 3 {
+		return g.byteOrder(call.Args[1])
+	}
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok && isByteOrderMethod(sel.Sel.Name) {
+		return g.byteOrder(sel.X)
+	}
+	return "", false
+}
+
+// isByteOrderMethod reports whether the given method name is the name of a
+// method of binary.ByteOrder or binary.AppendByteOrder; e.g. Uint32 or
+// PutUint16.
+func isByteOrderMethod(name string) bool {
+	for _, prefix := range []string{"Uint", "PutUint", "AppendUint"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldAliases returns the local variables of the given function body holding
+// the value of a struct field, by their assignments to and from the field;
+// e.g. n of
+//
+//	n, off, err := readUint32(b, off)
+//	v.N = uint32(n)
+//
+// or of n := int(v.N). Variables assigned to and from more than one field are
+// ambiguous, and omitted.
+func (g *Generator) fieldAliases(body *ast.BlockStmt) map[types.Object]*ast.SelectorExpr {
+	aliases := make(map[types.Object]*ast.SelectorExpr)
+	ambiguous := make(map[types.Object]bool)
+	add := func(ident *ast.Ident, sel *ast.SelectorExpr) {
+		obj := g.pkg.info.ObjectOf(ident)
+		if _, ok := obj.(*types.Var); !ok {
+			return
+		}
+		if _, ok := g.fieldVar(sel); !ok {
+			return
+		}
+		if prev, ok := aliases[obj]; ok && types.ExprString(prev) != types.ExprString(sel) {
+			ambiguous[obj] = true
+		}
+		aliases[obj] = sel
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			rhs := g.unconvert(assign.Rhs[i])
+			switch lhs := lhs.(type) {
+			case *ast.Ident:
+				// n := int(v.N)
+				if sel, ok := rhs.(*ast.SelectorExpr); ok {
+					add(lhs, sel)
+				}
+			case *ast.SelectorExpr:
+				// v.N = uint32(n)
+				if ident, ok := rhs.(*ast.Ident); ok {
+					add(ident, lhs)
+				}
+			}
+		}
+		return true
+	})
+	for obj := range ambiguous {
+		delete(aliases, obj)
+	}
+	return aliases
+}
+
+// fieldExpr returns the struct field selected by the given expression, looking
+// through type conversions and the given local variables holding the value of
+// a field (see fieldAliases), and a boolean indicating whether expr is such an
+// expression.
+func (g *Generator) fieldExpr(expr ast.Expr, aliases map[types.Object]*ast.SelectorExpr) (*ast.SelectorExpr, bool) {
+	switch e := g.unconvert(expr).(type) {
+	case *ast.SelectorExpr:
+		return e, true
+	case *ast.Ident:
+		sel, ok := aliases[g.pkg.info.Uses[e]]
+		return sel, ok
+	}
+	return nil, false
+}
+
+// appends reports whether the given loop body appends to a slice; i.e. s =
+// append(s, elem) for a local variable or field s.
+func (g *Generator) appends(body *ast.BlockStmt) bool {
+	for _, stmt := range body.List {
+		assign, ok := stmt.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+			continue
+		}
+		if arg, ok := g.builtinArg(assign.Rhs[0], "append", 0); ok && types.ExprString(arg) == types.ExprString(assign.Lhs[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// Policies for unexported fields.
+const (
+	// Output the field like exported fields.
+	unexportedInclude = "include"
+	// Omit the field from the output.
+	unexportedSkip = "skip"
+	// Omit the field from the output, leaving a comment in its place.
+	unexportedComment = "comment"
+)
+
+// omitUnexported reports whether the given struct field is omitted by the
+// -unexported policy. Blank fields (e.g. padding) are part of the binary format
+// and never omitted.
+func (g *Generator) omitUnexported(field *types.Var) bool {
+	return g.unexported != unexportedInclude && len(g.unexported) > 0 && !field.Exported() && field.Name() != "_"
+}
+
+// omittedComments returns the comments of the unexported fields of the given
+// struct type omitted by -unexported comment, which precede the field of the
+// given name; or follow the last field if name is empty.
+func omittedComments(s *ir.StructDef, name string) []string {
+	var comments []string
+	for _, o := range s.Omitted {
+		if o.Before == name {
+			comments = append(comments, fmt.Sprintf("omitted field %s; unexported field of type %s", o.ID, o.Go))
+		}
+	}
+	return comments
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// Policies for fields of non-serializable types.
+const (
+	// Omit the field from the output.
+	unsupportedSkip = "skip"
+	// Omit the field from the output, leaving a comment in its place.
+	unsupportedComment = "comment"
+	// Report the field as an error and exit with a non-zero status.
+	unsupportedFail = "fail"
+)
+
+// skippedField is a field of a non-serializable type skipped by the
+// -on-unsupported policy.
+type skippedField struct {
+	// Name of the struct type containing the field.
+	typeName string
+	// Struct field.
+	field *ir.Field
+}
+
+// unsupportedField handles the field of a non-serializable type of the given
+// struct type based on the -on-unsupported policy, and returns the comment to
+// output in place of the field, if any.
+func (g *Generator) unsupportedField(s *ir.StructDef, f *ir.Field) (comment string) {
+	switch g.onUnsupported {
+	case unsupportedSkip:
+		// nothing to do.
+	case unsupportedComment:
+		comment = fmt.Sprintf("skipped field %s; unsupported type %s", f.ID, f.Type.Go)
+	default:
+		g.errorAt(f.Pos, "field %s.%s: unsupported type %s", s.Name, f.Name, f.Type.Go)
+		g.failed = true
+	}
+	g.skipped = append(g.skipped, skippedField{typeName: s.Name, field: f})
+	return comment
+}
+
+// reportSkipped outputs a warning listing every field skipped by the
+// -on-unsupported policy, with its Go type and location.
+func (g *Generator) reportSkipped() {
+	if len(g.skipped) == 0 {
+		return
+	}
+	warnf("skipped_fields", logAttrs{"count": len(g.skipped)}, "%d fields of unsupported types skipped:", len(g.skipped))
+	for _, s := range g.skipped {
+		logf(levelWarn, "skipped_field", logAttrs{"pos": s.field.Pos, "type": s.typeName, "field": s.field.Name, "go": s.field.Type.Go}, "\t%s: %s.%s: %s", s.field.Pos, s.typeName, s.field.Name, s.field.Type.Go)
+	}
+}
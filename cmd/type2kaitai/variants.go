@@ -0,0 +1,84 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// endianVariant is a struct type referenced by fields with a byte order other
+// than that of the binary format, which is output as a distinct Kaitai type
+// with the byte order of the fields.
+type endianVariant struct {
+	// Identifier of the variant; e.g. header_be.
+	id string
+	// Struct type; either a named struct type or an unnamed struct type of a
+	// field (see generateAnonTypes).
+	t *ir.Type
+	// Byte order of the variant; either "le" or "be".
+	endian string
+}
+
+// variantID returns the identifier of the Kaitai type of the given struct type,
+// as referenced by the attribute being generated. If the byte order of the
+// attribute (the endian option of the field, or the byte order of the variant
+// being generated) differs from that of the binary format, the struct type is
+// output as a variant in that byte order, and the identifier of the variant is
+// returned; e.g.
+//
+//	type Packet struct {
+//	    Header Header `kaitai:"endian=be"`
+//	}
+//
+// is output with a header_be type, which has the meta endian key set to be.
+// Fields of the variant which are of struct types in turn reference variants
+// in the same byte order, unless given an endian option of their own; thus
+// fields of the same struct type with different byte orders each use a
+// specialized type, rather than the byte order of the binary format.
+func (g *Generator) variantID(t *ir.Type) string {
+	endian := g.attrEndian
+	if len(endian) == 0 || endian == g.endian || len(t.ID) == 0 {
+		return t.ID
+	}
+	if t.Kind == ir.Named && g.mod.Struct(t) == nil {
+		// Struct type not part of the module (e.g. cgo struct types).
+		return t.ID
+	}
+	id := t.ID + "_" + endian
+	for _, v := range g.endianVariants {
+		if v.id == id {
+			return id
+		}
+	}
+	g.endianVariants = append(g.endianVariants, &endianVariant{id: id, t: t, endian: endian})
+	return id
+}
+
+// generateEndianVariants adds the byte order variants of the struct types
+// referenced by the generated types (see variantID) to the given mapping of
+// Kaitai types. Variants referenced by other variants are added in turn.
+func (g *Generator) generateEndianVariants(kaiTypes *yaml.Node) {
+	for i := 0; i < len(g.endianVariants); i++ {
+		v := g.endianVariants[i]
+		s := g.mod.Struct(v.t)
+		if s == nil {
+			s = &ir.StructDef{Name: v.t.Go, ID: v.t.ID, Fields: v.t.Fields}
+		}
+		logf(levelInfo, "type", logAttrs{"id": v.id, "name": s.Name}, "generating type: %q", v.id)
+		spec := ksyMap()
+		key := ksyAdd(kaiTypes, v.id, spec)
+		key.LineComment = ksyComment(s.ID + " in " + endianNames[v.endian] + " byte order")
+		meta := ksyMap()
+		ksyAdd(meta, "endian", ksyValue(v.endian, ""))
+		ksyAdd(spec, "meta", meta)
+		g.typeEndian = v.endian
+		g.generateSpec(kaiTypes, spec, s)
+		g.typeEndian = ""
+	}
+}
+
+// endianNames maps from byte orders to their names in comments.
+var endianNames = map[string]string{
+	"le": "little-endian",
+	"be": "big-endian",
+}
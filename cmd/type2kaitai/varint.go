@@ -0,0 +1,160 @@
+package main
+
+import (
+	"go/types"
+
+	"github.com/mewrev/tools/internal/ir"
+	"gopkg.in/yaml.v3"
+)
+
+// varint returns the Kaitai type of a variable-length integer field tagged with
+// the varint option, and a boolean indicating whether the field is tagged as
+// such; the vlq_base128_le or vlq_base128_be type of the Kaitai format
+// library, which is imported by the spec. References to the field in
+// expressions use the value instance of the type; see varintValue.
+//
+// Example:
+//
+//	type Record struct {
+//	    Len  uint64 `kaitai:"varint"`
+//	    Data []byte `kaitai:"size=Len"`
+//	}
+//
+// is output as
+//
+//	id: len
+//	type: vlq_base128_le # uint64
+//
+// and
+//
+//	id: data
+//	size: len.value # []byte
+func (g *Generator) varint(f *ir.Field) (*yaml.Node, bool, error) {
+	if len(f.Varint) == 0 {
+		return nil, false, nil
+	}
+	id := "vlq_base128_" + f.Varint
+	if g.imports == nil {
+		g.imports = make(map[string]bool)
+	}
+	g.imports["/common/"+id] = true
+	return ksySpec("type", id, f.Type.Go), true, nil
+}
+
+// varintValue returns the suffix of references to the given field in Kaitai
+// expressions; the value instance of variable-length integers (value_signed
+// of signed integers), and the empty string otherwise.
+func varintValue(f *ir.Field) string {
+	switch {
+	case len(f.Varint) == 0:
+		return ""
+	case f.Type.Under().Kind == ir.Int:
+		return ".value_signed"
+	}
+	return ".value"
+}
+
+// varintTypeValue returns the suffix of references to the given field of the
+// struct type in Kaitai expressions, as by varintValue.
+func (g *Generator) varintTypeValue(st *types.Struct, field *types.Var) string {
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i) != field {
+			continue
+		}
+		if _, ok, err := g.fieldTag(st, i).Varint(); !ok || err != nil {
+			return ""
+		}
+		if basic, ok := field.Type().Underlying().(*types.Basic); ok && basic.Info()&types.IsUnsigned == 0 {
+			return ".value_signed"
+		}
+		return ".value"
+	}
+	return ""
+}
+
+// decodeVarint outputs the statements decoding the variable-length integer
+// field f into lhs. The base-128 groups are read up to the first group without
+// continuation bit; LEB128 is decoded by binary.Uvarint. Signed integers are
+// sign-extended from the most significant bit of the groups, as by the
+// value_signed instance of the Kaitai types.
+func (w *goWriter) decodeVarint(lhs string, f *ir.Field) {
+	signed := f.Type.Under().Kind == ir.Int
+	w.Printf("{\n")
+	w.Printf("var buf []byte\n")
+	w.Printf("for d.err == nil {\n")
+	w.Printf("b := d.u1()\n")
+	w.Printf("buf = append(buf, b)\n")
+	w.Printf("if b&0x80 == 0 {\n")
+	w.Printf("break\n")
+	w.Printf("}\n")
+	w.Printf("}\n")
+	if f.Varint == "le" {
+		w.Printf("x, n := binary.Uvarint(buf)\n")
+		w.Printf("if n <= 0 && d.err == nil {\n")
+		w.Printf("d.err = fmt.Errorf(\"invalid varint of %s\")\n", f.Name)
+		w.Printf("}\n")
+	} else {
+		w.Printf("if len(buf) > binary.MaxVarintLen64 && d.err == nil {\n")
+		w.Printf("d.err = fmt.Errorf(\"invalid varint of %s\")\n", f.Name)
+		w.Printf("}\n")
+		w.Printf("var x uint64\n")
+		w.Printf("for _, b := range buf {\n")
+		w.Printf("x = x<<7 | uint64(b&0x7f)\n")
+		w.Printf("}\n")
+		if signed {
+			w.Printf("n := len(buf)\n")
+		}
+	}
+	if signed {
+		w.Printf("if shift := 7 * uint(n); n > 0 && shift < 64 && x&(1<<(shift-1)) != 0 {\n")
+		w.Printf("x |= ^uint64(0) << shift\n")
+		w.Printf("}\n")
+	}
+	if lhs == "_" {
+		w.Printf("_ = x\n")
+	} else {
+		w.Printf("%s = %s(x)\n", lhs, f.Type.Go)
+	}
+	w.Printf("}\n")
+}
+
+// encodeVarint outputs the statements encoding the variable-length integer
+// field rhs of the field f. LEB128 of unsigned integers is encoded by
+// binary.PutUvarint.
+func (w *goWriter) encodeVarint(rhs string, f *ir.Field) {
+	signed := f.Type.Under().Kind == ir.Int
+	w.Printf("{\n")
+	if f.Varint == "le" && !signed {
+		w.Printf("buf := make([]byte, binary.MaxVarintLen64)\n")
+		w.Printf("n := binary.PutUvarint(buf, uint64(%s))\n", rhs)
+		w.Printf("e.write(buf[:n])\n")
+		w.Printf("}\n")
+		return
+	}
+	// 7-bit groups, least significant first.
+	w.Printf("var buf []byte\n")
+	if signed {
+		w.Printf("for x := int64(%s); ; {\n", rhs)
+		w.Printf("b := byte(x & 0x7f)\n")
+		w.Printf("x >>= 7\n")
+		w.Printf("buf = append(buf, b)\n")
+		w.Printf("if x == 0 && b&0x40 == 0 || x == -1 && b&0x40 != 0 {\n")
+	} else {
+		w.Printf("for x := uint64(%s); ; {\n", rhs)
+		w.Printf("buf = append(buf, byte(x&0x7f))\n")
+		w.Printf("x >>= 7\n")
+		w.Printf("if x == 0 {\n")
+	}
+	w.Printf("break\n")
+	w.Printf("}\n")
+	w.Printf("}\n")
+	if f.Varint == "be" {
+		w.reverseBytes("buf")
+	}
+	// Continuation bits of all but the last group.
+	w.Printf("for i := range buf[:len(buf)-1] {\n")
+	w.Printf("buf[i] |= 0x80\n")
+	w.Printf("}\n")
+	w.Printf("e.write(buf)\n")
+	w.Printf("}\n")
+}
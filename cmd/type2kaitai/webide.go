@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"regexp"
+
+	"github.com/mewrev/tools/internal/ir"
+)
+
+// reWebIDEPlaceholder matches the placeholders of webide-representation format
+// strings, capturing the identifier of the field; e.g. {value:hex} or
+// {header.len}.
+var reWebIDEPlaceholder = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)[^}]*\}`)
+
+// webIDERepresentation returns the format string of the webide-representation
+// directive of the given struct type, which is output as the
+// -webide-representation key of the Kaitai type; e.g.
+//
+//	//kaitai:webide-representation "{name}: {value:hex}"
+//	type Entry struct {
+//	    Name  [8]byte `kaitai:"strz"`
+//	    Value uint32
+//	}
+//
+// The placeholders of the format string reference the fields of the type by
+// their identifiers in the generated output, and are reported otherwise.
+func (g *Generator) webIDERepresentation(t *types.Named, s *ir.StructDef) string {
+	typeName := t.Obj().Name()
+	format, ok, err := g.typeDirectives[typeName].WebIDERepresentation()
+	if !ok {
+		return ""
+	}
+	if err != nil {
+		g.errorf(t.Obj().Pos(), "type %s: %v", typeName, err)
+		return ""
+	}
+	ids := make(map[string]bool)
+	for _, f := range s.Fields {
+		ids[f.ID] = true
+	}
+	for _, inst := range s.Instances {
+		ids[inst.ID] = true
+	}
+	for _, m := range reWebIDEPlaceholder.FindAllStringSubmatch(format, -1) {
+		if !ids[m[1]] {
+			g.errorf(t.Obj().Pos(), "type %s: %v", typeName, fmt.Errorf("webide-representation placeholder %s is not a field of %s", m[0], s.ID))
+		}
+	}
+	return format
+}
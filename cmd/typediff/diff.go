@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/load"
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// version is a loaded version of a package.
+type version struct {
+	pkg *packages.Package
+	// Comment directives of the types and fields of the package.
+	dirs *structtag.Directives
+}
+
+// newVersion returns the given version of the package, recording the comment
+// directives of its types and fields as type2kaitai does.
+func newVersion(pkg *packages.Package) *version {
+	return &version{
+		pkg:  pkg,
+		dirs: structtag.PackageDirectives(pkg.Syntax, pkg.TypesInfo),
+	}
+}
+
+// fieldTag returns the kaitai options of the i-th field of the given struct
+// type; the options of the struct tag of the field, and the options of its
+// comment directives not present in the struct tag.
+func (v *version) fieldTag(st *types.Struct, i int) structtag.Tag {
+	return v.dirs.FieldTag(st, i)
+}
+
+// lookup returns the named type of the given type name, or nil if not
+// defined by the package or skipped by a comment directive.
+func (v *version) lookup(typeName string) *types.Named {
+	if v.dirs.Types[typeName].Has("skip") {
+		return nil
+	}
+	return load.Type(v.pkg, typeName)
+}
+
+// wireFields returns the fields of the given struct type in the order of the
+// binary format, omitting skipped fields.
+func (v *version) wireFields(st *types.Struct) []*types.Var {
+	var fields []*types.Var
+	for pos := 0; pos < st.NumFields(); pos++ {
+		i := pos
+		if order, ok := v.dirs.Orders[st]; ok {
+			i = order[pos]
+		}
+		if v.dirs.Skipped[st.Field(i)] {
+			continue
+		}
+		fields = append(fields, st.Field(i))
+	}
+	return fields
+}
+
+// layout returns the binary layout of the types of the version.
+func (v *version) layout(sizes types.Sizes) *layout.Layout {
+	return &layout.Layout{
+		Sizes: sizes,
+		// The byte order does not affect sizes.
+		Endian: "le",
+		Exclude: func(field *types.Var) bool {
+			return v.dirs.Skipped[field]
+		},
+		Tag:   v.fieldTag,
+		Order: v.dirs.FieldOrder,
+	}
+}
+
+// enumValues returns the constants of the given enum type, sorted by value.
+func (v *version) enumValues(t *types.Named) []*types.Const {
+	var consts []*types.Const
+	scope := v.pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if ok && types.Identical(c.Type(), t) {
+			consts = append(consts, c)
+		}
+	}
+	sort.SliceStable(consts, func(i, j int) bool {
+		return constant.Compare(consts[i].Val(), token.LSS, consts[j].Val())
+	})
+	return consts
+}
+
+// isEnum reports whether the given named type is an enum type; an integer type
+// with constants.
+func (v *version) isEnum(t *types.Named) bool {
+	u, ok := t.Underlying().(*types.Basic)
+	return ok && u.Info()&types.IsInteger != 0 && len(v.enumValues(t)) > 0
+}
+
+// change is a change between two versions of a type.
+type change struct {
+	// Position of the change; in the new version if present.
+	pos token.Position
+	msg string
+	// Additive change, compatible with the binary format of the old version
+	// (e.g. an added enum value); otherwise a breaking change.
+	additive bool
+}
+
+// differ compares the types of two versions of a package.
+type differ struct {
+	old, new *version
+	sizes    types.Sizes
+	changes  []*change
+	// Types to compare, and compared types, by type name.
+	queue []string
+	done  map[string]bool
+}
+
+// diff returns the changes between the two versions of the given types, and
+// of the types they use; or of all exported struct and enum types if none
+// are given.
+func (d *differ) diff(typeNames []string) []*change {
+	d.done = make(map[string]bool)
+	explicit := len(typeNames) > 0
+	if !explicit {
+		typeNames = d.exportedTypes()
+	}
+	for _, typeName := range typeNames {
+		if explicit && d.old.lookup(typeName) == nil && d.new.lookup(typeName) == nil {
+			log.Fatalf("unable to locate type definition of type name %q", typeName)
+		}
+	}
+	d.queue = typeNames
+	for len(d.queue) > 0 {
+		typeName := d.queue[0]
+		d.queue = d.queue[1:]
+		if d.done[typeName] {
+			continue
+		}
+		d.done[typeName] = true
+		d.diffType(typeName)
+	}
+	return d.changes
+}
+
+// exportedTypes returns the names of the exported struct and enum types of
+// either version, sorted by name.
+func (d *differ) exportedTypes() []string {
+	seen := make(map[string]bool)
+	var typeNames []string
+	for _, v := range []*version{d.old, d.new} {
+		scope := v.pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || !obj.Exported() || seen[name] {
+				continue
+			}
+			t, ok := obj.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+			if _, ok := t.Underlying().(*types.Struct); ok || v.isEnum(t) {
+				seen[name] = true
+				typeNames = append(typeNames, name)
+			}
+		}
+	}
+	sort.Strings(typeNames)
+	return typeNames
+}
+
+// report records a change at the given position of the given version.
+func (d *differ) report(v *version, pos token.Pos, additive bool, format string, args ...interface{}) {
+	c := &change{
+		pos:      v.pkg.Fset.Position(pos),
+		msg:      fmt.Sprintf(format, args...),
+		additive: additive,
+	}
+	d.changes = append(d.changes, c)
+}
+
+// diffType records the changes between the two versions of the given type.
+func (d *differ) diffType(typeName string) {
+	ot, nt := d.old.lookup(typeName), d.new.lookup(typeName)
+	switch {
+	case ot == nil && nt == nil:
+		return
+	case ot == nil:
+		d.report(d.new, nt.Obj().Pos(), true, "%s: type added", typeName)
+		return
+	case nt == nil:
+		d.report(d.old, ot.Obj().Pos(), false, "%s: type removed", typeName)
+		return
+	}
+	okind, nkind := d.kind(d.old, ot), d.kind(d.new, nt)
+	if okind != nkind {
+		d.report(d.new, nt.Obj().Pos(), false, "%s: changed from %s type to %s type", typeName, okind, nkind)
+		return
+	}
+	switch okind {
+	case "struct":
+		d.diffStruct(typeName, ot, nt)
+	case "enum":
+		d.diffEnum(typeName, ot, nt)
+	default:
+		if ou, nu := typeString(ot.Underlying()), typeString(nt.Underlying()); ou != nu {
+			d.report(d.new, nt.Obj().Pos(), false, "%s: underlying type changed from %s to %s", typeName, ou, nu)
+		}
+		d.use(d.old, ot.Underlying())
+		d.use(d.new, nt.Underlying())
+	}
+}
+
+// kind returns the kind of the given type; struct, enum or other.
+func (d *differ) kind(v *version, t *types.Named) string {
+	if _, ok := t.Underlying().(*types.Struct); ok {
+		return "struct"
+	}
+	if v.isEnum(t) {
+		return "enum"
+	}
+	return "other"
+}
+
+// diffStruct records the changes between the two versions of the given struct
+// type.
+func (d *differ) diffStruct(typeName string, ot, nt *types.Named) {
+	ost, nst := ot.Underlying().(*types.Struct), nt.Underlying().(*types.Struct)
+	ofields, nfields := d.old.wireFields(ost), d.new.wireFields(nst)
+	oindex, nindex := fieldIndices(ost), fieldIndices(nst)
+	osizes, nsizes := d.fieldSizes(d.old, ot), d.fieldSizes(d.new, nt)
+	var ocommon, ncommon []string
+	for _, f := range ofields {
+		if i, ok := nindex[f.Name()]; !ok || d.new.dirs.Skipped[nst.Field(i)] {
+			d.report(d.new, nt.Obj().Pos(), false, "%s.%s: field removed", typeName, f.Name())
+			continue
+		}
+		ocommon = append(ocommon, f.Name())
+	}
+	for _, f := range nfields {
+		i, ok := oindex[f.Name()]
+		if !ok || d.old.dirs.Skipped[ost.Field(i)] {
+			d.report(d.new, f.Pos(), false, "%s.%s: field added", typeName, f.Name())
+			continue
+		}
+		ncommon = append(ncommon, f.Name())
+		of, oi, ni := ost.Field(i), i, nindex[f.Name()]
+		name := typeName + "." + f.Name()
+		otype, ntype := typeString(of.Type()), typeString(f.Type())
+		osize, ook := osizes[f.Name()]
+		nsize, nok := nsizes[f.Name()]
+		switch {
+		case ook && nok && osize != nsize && otype == ntype:
+			// Resized by a change of the type (e.g. of an array element type).
+			d.report(d.new, f.Pos(), false, "%s: resized from %d to %d bytes", name, osize, nsize)
+		case ook && nok && osize != nsize:
+			d.report(d.new, f.Pos(), false, "%s: resized from %d to %d bytes (%s to %s)", name, osize, nsize, otype, ntype)
+		case otype != ntype:
+			d.report(d.new, f.Pos(), false, "%s: type changed from %s to %s", name, otype, ntype)
+		}
+		if otag, ntag := tagString(d.old.fieldTag(ost, oi)), tagString(d.new.fieldTag(nst, ni)); otag != ntag {
+			d.report(d.new, f.Pos(), false, "%s: kaitai options changed from %q to %q", name, otag, ntag)
+		}
+		d.use(d.old, of.Type())
+		d.use(d.new, f.Type())
+	}
+	if strings.Join(ocommon, ",") != strings.Join(ncommon, ",") {
+		d.report(d.new, nt.Obj().Pos(), false, "%s: fields reordered from %s to %s", typeName, strings.Join(ocommon, ", "), strings.Join(ncommon, ", "))
+	}
+	osize, ook := d.old.layout(d.sizes).Size(ot)
+	nsize, nok := d.new.layout(d.sizes).Size(nt)
+	if ook && nok && osize != nsize {
+		d.report(d.new, nt.Obj().Pos(), false, "%s: binary layout size changed from %d to %d bytes", typeName, osize, nsize)
+	}
+}
+
+// diffEnum records the changes between the two versions of the given enum
+// type.
+func (d *differ) diffEnum(typeName string, ot, nt *types.Named) {
+	if ou, nu := typeString(ot.Underlying()), typeString(nt.Underlying()); ou != nu {
+		d.report(d.new, nt.Obj().Pos(), false, "%s: underlying type changed from %s to %s", typeName, ou, nu)
+	}
+	ovalues := make(map[string]*types.Const)
+	for _, c := range d.old.enumValues(ot) {
+		ovalues[c.Name()] = c
+	}
+	nvalues := make(map[string]*types.Const)
+	for _, c := range d.new.enumValues(nt) {
+		nvalues[c.Name()] = c
+	}
+	for _, oc := range d.old.enumValues(ot) {
+		nc, ok := nvalues[oc.Name()]
+		switch {
+		case !ok:
+			d.report(d.new, nt.Obj().Pos(), false, "%s: value %s removed (%s)", typeName, oc.Name(), oc.Val())
+		case !constant.Compare(oc.Val(), token.EQL, nc.Val()):
+			d.report(d.new, nc.Pos(), false, "%s: value %s changed from %s to %s", typeName, oc.Name(), oc.Val(), nc.Val())
+		}
+	}
+	for _, nc := range d.new.enumValues(nt) {
+		if _, ok := ovalues[nc.Name()]; !ok {
+			d.report(d.new, nc.Pos(), true, "%s: value %s added (%s)", typeName, nc.Name(), nc.Val())
+		}
+	}
+}
+
+// use queues the types of the package used by the given type for comparison.
+func (d *differ) use(v *version, t types.Type) {
+	switch t := t.(type) {
+	case *types.Named:
+		if t.Obj().Pkg() == v.pkg.Types && !d.done[t.Obj().Name()] {
+			d.queue = append(d.queue, t.Obj().Name())
+		}
+	case *types.Array:
+		d.use(v, t.Elem())
+	case *types.Slice:
+		d.use(v, t.Elem())
+	case *types.Pointer:
+		d.use(v, t.Elem())
+	}
+}
+
+// fieldSizes returns the sizes in bytes of the fields of the given struct type
+// in the binary layout, by field name. Fields of unknown size are omitted.
+func (d *differ) fieldSizes(v *version, t *types.Named) map[string]int64 {
+	fields, err := v.layout(d.sizes).Fields(t, "")
+	sizes := make(map[string]int64)
+	last := ""
+	for _, f := range fields {
+		name := f.Path
+		if pos := strings.IndexAny(name, ".["); pos != -1 {
+			name = name[:pos]
+		}
+		sizes[name] += f.Size
+		last = name
+	}
+	if err != nil {
+		// The layout of the last field may be incomplete.
+		delete(sizes, last)
+	}
+	return sizes
+}
+
+// fieldIndices returns the indices of the fields of the given struct type, by
+// field name.
+func fieldIndices(st *types.Struct) map[string]int {
+	indices := make(map[string]int)
+	for i := 0; i < st.NumFields(); i++ {
+		indices[st.Field(i).Name()] = i
+	}
+	return indices
+}
+
+// tagString returns the given kaitai options in canonical form; sorted by key.
+func tagString(tag structtag.Tag) string {
+	var opts []string
+	for key, value := range tag {
+		if len(value) > 0 {
+			key += "=" + value
+		}
+		opts = append(opts, key)
+	}
+	sort.Strings(opts)
+	return strings.Join(opts, ",")
+}
+
+// typeString returns the given type without package qualifiers.
+func typeString(t types.Type) string {
+	return types.TypeString(t, skipQualifier)
+}
+
+func skipQualifier(pkg *types.Package) string {
+	return ""
+}
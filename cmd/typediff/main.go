@@ -0,0 +1,161 @@
+// The typediff tool reports the changes between two versions of a package
+// that affect the binary format of its types, as laid out by the specs and
+// code generated by type2kaitai; e.g. for use as a format compatibility gate
+// in continuous integration.
+//
+// Struct types are compared field by field, following the kaitai options of
+// struct tags and comment directives (e.g. skipped fields and the order
+// directive); reported are added, removed, resized and reordered fields,
+// changed field types and kaitai options, and changed binary layout sizes.
+// Enum types are compared value by value; reported are removed, renumbered and
+// added enum values, and changed underlying types. The struct and enum types
+// of the package used by the compared types are compared as well.
+//
+// The versions are given as directories (e.g. worktrees of two commits), or as
+// module queries (e.g. github.com/mewrev/pe@v0.1.0), in which case the module
+// is downloaded to the module cache. The exit status is 1 if any change breaks
+// the binary format; added types and enum values are additive changes.
+//
+// A format version bump is suggested based on the changes; major for breaking
+// changes, and minor for additive changes only. Given the format version of
+// the old version (the -version flag), the next format version is suggested.
+// With the -changelog flag, a changelog fragment in Markdown is output instead
+// of the list of changes.
+//
+// Example output:
+//
+//	new/header.go:12:2: Header.Version: resized from 2 to 4 bytes (uint16 to uint32)
+//	new/header.go:9:6: Header: binary layout size changed from 14 to 16 bytes
+//	new/kind.go:8:2: Kind: value KindC added (3); additive
+//	suggested format version bump: major (1.4.0 to 2.0.0)
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/types"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewrev/tools/internal/load"
+)
+
+var (
+	typeNames     = flag.String("type", "", "comma-separated list of type names; default all exported struct and enum types")
+	pkgPath       = flag.String("pkg", ".", "directory of the package, relative to the directories and module roots of the versions")
+	buildTags     = flag.String("tags", "", "comma-separated list of build tags to apply")
+	arch          = flag.String("arch", "amd64", "target architecture; determines the size of int, uint and uintptr")
+	formatVersion = flag.String("version", "", "format version of OLD (e.g. 1.4.0); used to suggest the next format version")
+	changelog     = flag.Bool("changelog", false, "output changelog fragment in Markdown")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of typediff:\n")
+	fmt.Fprintf(os.Stderr, "\ttypediff [flags] OLD NEW # directories or module@version queries\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("typediff: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var tags []string
+	if len(*buildTags) > 0 {
+		tags = strings.Split(*buildTags, ",")
+	}
+	sizes := types.SizesFor("gc", *arch)
+	if sizes == nil {
+		log.Fatalf("unsupported target architecture %q", *arch)
+	}
+	from := loadVersion(flag.Arg(0), tags, *arch)
+	to := loadVersion(flag.Arg(1), tags, *arch)
+	var names []string
+	if len(*typeNames) > 0 {
+		names = strings.Split(*typeNames, ",")
+	}
+	d := &differ{old: from, new: to, sizes: sizes}
+	changes := d.diff(names)
+	b := suggestBump(changes)
+	next := ""
+	if len(*formatVersion) > 0 {
+		var err error
+		if next, err = nextVersion(*formatVersion, b); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *changelog {
+		writeChangelog(os.Stdout, changes, next)
+	} else {
+		for _, c := range changes {
+			if c.additive {
+				fmt.Printf("%s: %s; additive\n", c.pos, c.msg)
+				continue
+			}
+			fmt.Printf("%s: %s\n", c.pos, c.msg)
+		}
+		if len(next) > 0 {
+			fmt.Printf("suggested format version bump: %s (%s to %s)\n", b, *formatVersion, next)
+		} else {
+			fmt.Printf("suggested format version bump: %s\n", b)
+		}
+	}
+	if b == bumpMajor {
+		os.Exit(1)
+	}
+}
+
+// loadVersion loads the package of the given version; a directory or module
+// query. loadVersion exits if there is an error.
+func loadVersion(arg string, tags []string, arch string) *version {
+	dir := arg
+	if strings.Contains(arg, "@") {
+		var err error
+		if dir, err = moduleDir(arg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	c := &load.Config{Tags: tags, Arch: arch, Dir: filepath.Join(dir, *pkgPath)}
+	pkgs, err := load.Load(c, ".")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		log.Fatalf("%s: %d packages found", arg, len(pkgs))
+	}
+	if len(pkgs[0].Errors) > 0 {
+		log.Fatalf("%s: %v", arg, pkgs[0].Errors[0])
+	}
+	return newVersion(pkgs[0])
+}
+
+// moduleDir downloads the module of the given module query (e.g.
+// github.com/mewrev/pe@v0.1.0) to the module cache, and returns its
+// directory.
+func moduleDir(query string) (string, error) {
+	out, err := exec.Command("go", "mod", "download", "-json", query).Output()
+	var mod struct {
+		Dir   string
+		Error string
+	}
+	if jsonErr := json.Unmarshal(out, &mod); jsonErr != nil {
+		if err != nil {
+			return "", fmt.Errorf("unable to download module %q; %v", query, err)
+		}
+		return "", fmt.Errorf("unable to download module %q; %v", query, jsonErr)
+	}
+	if len(mod.Error) > 0 {
+		return "", fmt.Errorf("unable to download module %q; %s", query, mod.Error)
+	}
+	return mod.Dir, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// bump is a format version bump.
+type bump int
+
+// Format version bumps, in order of severity.
+const (
+	// No changes to the binary format.
+	bumpNone bump = iota
+	// Additive changes only.
+	bumpMinor
+	// Breaking changes.
+	bumpMajor
+)
+
+// String returns the name of the version bump.
+func (b bump) String() string {
+	switch b {
+	case bumpMinor:
+		return "minor"
+	case bumpMajor:
+		return "major"
+	}
+	return "none"
+}
+
+// suggestBump returns the format version bump suggested by the given changes;
+// major for breaking changes, minor for additive changes only.
+func suggestBump(changes []*change) bump {
+	b := bumpNone
+	for _, c := range changes {
+		if !c.additive {
+			return bumpMajor
+		}
+		b = bumpMinor
+	}
+	return b
+}
+
+// nextVersion returns the format version following the given semantic version
+// (e.g. 1.2.3 or v1.2.3) by the given bump. As in semantic versioning, formats
+// of major version 0 are unstable; breaking changes bump the minor version and
+// additive changes the patch version.
+func nextVersion(version string, b bump) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(version, "v") {
+		prefix = "v"
+	}
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid format version %q; expected MAJOR.MINOR.PATCH", version)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		x, err := strconv.Atoi(part)
+		if err != nil || x < 0 {
+			return "", fmt.Errorf("invalid format version %q; expected MAJOR.MINOR.PATCH", version)
+		}
+		nums[i] = x
+	}
+	major, minor, patch := nums[0], nums[1], nums[2]
+	switch {
+	case b == bumpNone:
+	case b == bumpMajor && major > 0:
+		major, minor, patch = major+1, 0, 0
+	case b == bumpMajor || b == bumpMinor && major > 0:
+		minor, patch = minor+1, 0
+	default:
+		patch++
+	}
+	return fmt.Sprintf("%s%d.%d.%d", prefix, major, minor, patch), nil
+}
+
+// writeChangelog writes a changelog fragment in Markdown of the given changes
+// to w, headed by the given next format version, if any.
+func writeChangelog(w io.Writer, changes []*change, next string) {
+	if len(next) > 0 {
+		fmt.Fprintf(w, "## Format version %s\n", next)
+	} else {
+		fmt.Fprintf(w, "## Format changes\n")
+	}
+	var breaking, additive []*change
+	for _, c := range changes {
+		if c.additive {
+			additive = append(additive, c)
+		} else {
+			breaking = append(breaking, c)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "\nNo changes to the binary format.\n")
+	}
+	for _, section := range []struct {
+		title   string
+		changes []*change
+	}{
+		{title: "Breaking changes", changes: breaking},
+		{title: "Additive changes", changes: additive},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n### %s\n\n", section.title)
+		for _, c := range section.changes {
+			fmt.Fprintf(w, "- %s\n", changelogEntry(c.msg))
+		}
+	}
+}
+
+// changelogEntry returns the changelog entry of the given change message, with
+// the changed type or field in code style; e.g. `Header.Version`: resized from
+// 2 to 4 bytes.
+func changelogEntry(msg string) string {
+	pos := strings.Index(msg, ": ")
+	if pos == -1 {
+		return msg
+	}
+	return "`" + msg[:pos] + "`" + msg[pos:]
+}
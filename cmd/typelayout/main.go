@@ -0,0 +1,192 @@
+// The typelayout tool prints the memory layout of Go struct types for a given
+// target architecture: the offset, size and alignment of each field, the
+// alignment holes between fields, and the total size of each type.
+//
+// Specs generated by type2kaitai lay out fields without padding (see package
+// layout). The spec column lists the offset of each field in that binary
+// layout, and the binary layout size is listed along with the size of each
+// type; every hole is a place where the memory layout of the Go type differs
+// from the binary layout.
+//
+// Example output:
+//
+//	Header: size 24, align 8, 10 bytes of holes; binary layout size 14
+//	offset  size  align  spec  field   type
+//	     0     1      1     0  Magic   uint8
+//	     1     3               (hole)
+//	     4     4      4     1  Length  uint32
+//	     8     8      8     5  Offset  uint64
+//	    16     1      1    13  Flags   uint8
+//	    17     7               (hole)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mewrev/tools/internal/layout"
+	"github.com/mewrev/tools/internal/load"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	buildTags = flag.String("tags", "", "comma-separated list of build tags to apply")
+	arch      = flag.String("arch", "amd64", "target architecture; determines the size and alignment of types")
+)
+
+// Usage is a replacement usage function for the flags package.
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of typelayout:\n")
+	fmt.Fprintf(os.Stderr, "\ttypelayout [flags] -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "\ttypelayout [flags] -type T files... # Must be a single package\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("typelayout: ")
+	flag.Usage = Usage
+	flag.Parse()
+	if len(*typeNames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var tags []string
+	if len(*buildTags) > 0 {
+		tags = strings.Split(*buildTags, ",")
+	}
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		// Default: process whole package in current directory.
+		patterns = []string{"."}
+	}
+	sizes := types.SizesFor("gc", *arch)
+	if sizes == nil {
+		log.Fatalf("unsupported target architecture %q", *arch)
+	}
+	pkgs, err := load.Packages(patterns, tags, *arch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		log.Fatalf("error: %d packages found", len(pkgs))
+	}
+
+	// Print layout of each type.
+	failed := false
+	for i, typeName := range strings.Split(*typeNames, ",") {
+		t := load.Type(pkgs[0], typeName)
+		if t == nil {
+			log.Printf("unable to locate type definition of type name %q", typeName)
+			failed = true
+			continue
+		}
+		if _, ok := t.Underlying().(*types.Struct); !ok {
+			log.Printf("type %s is not a struct type", typeName)
+			failed = true
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printLayout(os.Stdout, t, sizes)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printLayout prints the field offsets, sizes and alignments of the given
+// struct type, the alignment holes between its fields, and the offset of each
+// field in the binary layout of generated specs.
+func printLayout(w io.Writer, t *types.Named, sizes types.Sizes) {
+	typeName := t.Obj().Name()
+	st := t.Underlying().(*types.Struct)
+	fields := make([]*types.Var, st.NumFields())
+	for i := range fields {
+		fields[i] = st.Field(i)
+	}
+	offsets := sizes.Offsetsof(fields)
+	size := sizes.Sizeof(st)
+
+	// Offsets of the fields in the binary layout.
+	l := &layout.Layout{Sizes: sizes, Endian: "le"}
+	scalars, layoutErr := l.Fields(t, typeName)
+	specOffsets := make(map[string]int64)
+	for _, f := range scalars {
+		name := strings.TrimPrefix(f.Path, typeName+".")
+		if pos := strings.IndexAny(name, ".["); pos != -1 {
+			name = name[:pos]
+		}
+		if _, ok := specOffsets[name]; !ok {
+			specOffsets[name] = f.Offset
+		}
+	}
+	specSize := "unknown"
+	if layoutErr == nil {
+		end := int64(0)
+		if n := len(scalars); n > 0 {
+			end = scalars[n-1].Offset + scalars[n-1].Size
+		}
+		specSize = fmt.Sprint(end)
+	}
+
+	// Rows of fields and holes.
+	type row struct {
+		offset, size    int64
+		align, spec     string
+		name, fieldType string
+	}
+	var rows []row
+	holes := int64(0)
+	end := int64(0)
+	addHole := func(offset int64) {
+		if offset > end {
+			rows = append(rows, row{offset: end, size: offset - end, name: "(hole)"})
+			holes += offset - end
+		}
+	}
+	for i, field := range fields {
+		addHole(offsets[i])
+		r := row{
+			offset:    offsets[i],
+			size:      sizes.Sizeof(field.Type()),
+			align:     fmt.Sprint(sizes.Alignof(field.Type())),
+			spec:      "-",
+			name:      field.Name(),
+			fieldType: types.TypeString(field.Type(), skipQualifier),
+		}
+		if offset, ok := specOffsets[field.Name()]; ok {
+			r.spec = fmt.Sprint(offset)
+		}
+		rows = append(rows, r)
+		end = offsets[i] + r.size
+	}
+	addHole(size)
+
+	fmt.Fprintf(w, "%s: size %d, align %d, %d bytes of holes; binary layout size %s\n", typeName, size, sizes.Alignof(st), holes, specSize)
+	width := len("field")
+	for _, r := range rows {
+		if len(r.name) > width {
+			width = len(r.name)
+		}
+	}
+	fmt.Fprintf(w, "offset  size  align  spec  %-*s  type\n", width, "field")
+	for _, r := range rows {
+		line := fmt.Sprintf("%6d  %4d  %5s  %4s  %-*s  %s", r.offset, r.size, r.align, r.spec, width, r.name, r.fieldType)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	if layoutErr != nil {
+		fmt.Fprintf(w, "binary layout incomplete; %v\n", layoutErr)
+	}
+}
+
+func skipQualifier(pkg *types.Package) string {
+	return ""
+}
@@ -0,0 +1,224 @@
+// Package drift defines an analyzer reporting drift between Go struct types and
+// the Kaitai types of the Kaitai specs they reference; i.e. struct fields
+// missing from the seq of the Kaitai type, and seq attributes missing from the
+// struct type.
+//
+// Struct types reference their Kaitai spec by the //kaitai:spec directive of
+// their doc comment, with a path relative to the directory of the source file
+// and an optional type identifier; e.g.
+//
+//	//kaitai:spec header_type.ksy
+//	type Header struct { ... }
+//
+//	//kaitai:spec formats/elf.ksy#elf_header
+//	type ElfHeader struct { ... }
+//
+// The Kaitai type is identified by the identifier of the struct type by default
+// (e.g. header of Header), as generated by type2kaitai with the naming strategy
+// of the -naming flag, and seq attributes by the identifiers of the struct
+// fields; as given by the id directive of the field (e.g. //kaitai:id
+// legacy_name), or based on the naming strategy. The root type of specs whose
+// meta/id matches the identifier applies if there is no such type in the types
+// of the spec. Fields omitted by //kaitai:skip, and fields commented out by
+// type2kaitai ("TODO: add field", "skipped field" and "omitted field" comments)
+// are not reported.
+package drift
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mewrev/tools/internal/kaitai"
+	"github.com/mewrev/tools/internal/naming"
+	"github.com/mewrev/tools/internal/structtag"
+	"golang.org/x/tools/go/analysis"
+	"gopkg.in/yaml.v3"
+)
+
+// Analyzer reports drift between Go struct types and the Kaitai types of the
+// Kaitai specs they reference by //kaitai:spec directive.
+var Analyzer = &analysis.Analyzer{
+	Name: "kaitaidrift",
+	Doc:  "report drift between Go struct types and the Kaitai specs they reference\n\nStruct types reference a Kaitai spec by the //kaitai:spec file.ksy[#type_id] directive of their doc comment. Struct fields missing from the seq of the Kaitai type, and seq attributes missing from the struct type, are reported.",
+	Run:  run,
+}
+
+// namingFlag specifies the naming strategy of the Kaitai identifiers of Go
+// identifiers, as by the -naming flag of type2kaitai.
+var namingFlag string
+
+func init() {
+	Analyzer.Flags.StringVar(&namingFlag, "naming", "snake", "naming strategy of type and field identifiers (snake, keep or camel), as by type2kaitai")
+}
+
+// specDirective is the comment directive referencing the Kaitai spec of a
+// struct type.
+const specDirective = "spec"
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	switch namingFlag {
+	case "snake", "keep", "camel":
+		// valid naming strategy.
+	default:
+		return nil, fmt.Errorf("unsupported naming strategy %q; valid options: snake, keep, camel", namingFlag)
+	}
+	dirs := structtag.PackageDirectives(pass.Files, pass.TypesInfo)
+	for _, file := range pass.Files {
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				doc := spec.Doc
+				if doc == nil && len(decl.Specs) == 1 {
+					doc = decl.Doc
+				}
+				ref, ok := structtag.ParseDirectives(doc)[specDirective]
+				if !ok {
+					continue
+				}
+				checkStruct(pass, dirs, spec, doc, ref)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkStruct reports drift between the given struct type declaration and the
+// Kaitai type of the given //kaitai:spec reference, based on the comment
+// directives of the package.
+func checkStruct(pass *analysis.Pass, dirs *structtag.Directives, spec *ast.TypeSpec, doc *ast.CommentGroup, ref string) {
+	pos := directivePos(doc)
+	typeName := spec.Name.Name
+	var st *types.Struct
+	if def := pass.TypesInfo.Defs[spec.Name]; def != nil {
+		st, _ = def.Type().Underlying().(*types.Struct)
+	}
+	if st == nil {
+		pass.Reportf(pos, "%sspec directive of %s; not a struct type", structtag.DirectivePrefix, typeName)
+		return
+	}
+	path, id := ref, naming.Ident(namingFlag, typeName)
+	if i := strings.Index(ref, "#"); i != -1 {
+		path, id = ref[:i], ref[i+1:]
+	}
+	if len(path) == 0 {
+		pass.Reportf(pos, "missing path of %sspec directive of %s", structtag.DirectivePrefix, typeName)
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(pass.Fset.Position(spec.Pos()).Filename), path)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		pass.Reportf(pos, "unable to read Kaitai spec of %s; %v", typeName, err)
+		return
+	}
+	seq, err := lookupSeq(buf, id)
+	if err != nil {
+		pass.Reportf(pos, "%s: %v", filepath.Base(path), err)
+		return
+	}
+	attrs := make(map[string]bool)
+	for _, attrID := range seq {
+		attrs[attrID] = true
+	}
+	skipped := skippedFields(buf)
+
+	// Go fields missing from the Kaitai type.
+	fieldIDs := make(map[string]bool)
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if dirs.Skipped[field] {
+			continue
+		}
+		fieldID := fieldID(dirs, st, i)
+		fieldIDs[fieldID] = true
+		if !attrs[fieldID] && !skipped[fieldID] {
+			pass.Reportf(field.Pos(), "field %s.%s missing from Kaitai type %s of %s", typeName, field.Name(), id, filepath.Base(path))
+		}
+	}
+
+	// Kaitai attributes missing from the struct type.
+	for _, attrID := range seq {
+		if !fieldIDs[attrID] {
+			pass.Reportf(spec.Name.Pos(), "attribute %s of Kaitai type %s of %s missing from struct %s", attrID, id, filepath.Base(path), typeName)
+		}
+	}
+}
+
+// fieldID returns the Kaitai identifier of the i-th field of the given struct
+// type; as given by the id directive of the field (see structtag.Tag.ID), or
+// based on the -naming strategy.
+func fieldID(dirs *structtag.Directives, st *types.Struct, i int) string {
+	if id, ok, err := dirs.Fields[st.Field(i)].ID(); ok && err == nil {
+		return id
+	}
+	return naming.Ident(namingFlag, st.Field(i).Name())
+}
+
+// directivePos returns the position of the //kaitai:spec directive of the
+// given doc comment.
+func directivePos(doc *ast.CommentGroup) token.Pos {
+	for _, c := range doc.List {
+		if strings.HasPrefix(c.Text, structtag.DirectivePrefix+specDirective) {
+			return c.Pos()
+		}
+	}
+	return doc.Pos()
+}
+
+// lookupSeq returns the identifiers of the seq attributes of the Kaitai type of
+// the given identifier in the given Kaitai spec, in order; the type of the
+// types of the spec, or the root type if its meta/id matches.
+func lookupSeq(buf []byte, id string) ([]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("invalid Kaitai spec; %v", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid Kaitai spec; root is not a mapping")
+	}
+	root := doc.Content[0]
+	typ := kaitai.Lookup(kaitai.Lookup(root, "types"), id)
+	if typ == nil && kaitai.LookupValue(kaitai.Lookup(root, "meta"), "id") == id {
+		typ = root
+	}
+	if typ == nil {
+		return nil, fmt.Errorf("Kaitai type %s not found", id)
+	}
+	var attrs []string
+	if seq := kaitai.Lookup(typ, "seq"); seq != nil {
+		for _, attr := range seq.Content {
+			if attrID := kaitai.LookupValue(attr, "id"); len(attrID) > 0 {
+				attrs = append(attrs, attrID)
+			}
+		}
+	}
+	return attrs, nil
+}
+
+// reSkippedField matches the comments in place of the attributes of Kaitai
+// specs generated by type2kaitai of fields which could not be generated (TODO
+// comments, fields of unsupported types skipped by -on-unsupported and
+// unexported fields omitted by -unexported), capturing the identifier of the
+// field.
+var reSkippedField = regexp.MustCompile(`# (?:TODO: add field|skipped field|omitted field) ([a-zA-Z0-9_]+);`)
+
+// skippedFields returns the identifiers of the fields commented out in the
+// given Kaitai spec.
+func skippedFields(buf []byte) map[string]bool {
+	skipped := make(map[string]bool)
+	for _, m := range reSkippedField.FindAllSubmatch(buf, -1) {
+		skipped[string(m[1])] = true
+	}
+	return skipped
+}
@@ -0,0 +1,483 @@
+// Package ir defines the intermediate representation of binary formats used by
+// type2kaitai, between the analysis of Go types and the backends generating
+// output (Kaitai specs, schemas, parsers, diagrams, ...).
+//
+// The IR of a Go package is a Module, holding the struct and enum types
+// selected for generation and their dependencies. Types refer to each other by
+// name, so recursive types are represented without cycles. The options of the
+// kaitai struct tags of fields (sizes, repetitions, conditions, ...; see
+// package structtag) are recorded as parsed, with identifiers and constants
+// resolved, and left to the backends to interpret.
+package ir
+
+import (
+	"math/big"
+)
+
+// Module is the IR of the types of a Go package selected for generation.
+type Module struct {
+	// Go package name.
+	Package string `json:"package,omitempty"`
+	// Import path of the Go package.
+	Path string `json:"path,omitempty"`
+	// Byte order of the binary format; either "le" or "be".
+	Endian string `json:"endian,omitempty"`
+	// Bit order of bit fields; either "le" or "be", or empty to follow the byte
+	// order.
+	BitEndian string `json:"bitEndian,omitempty"`
+	// Target architecture, which determines the size of int, uint, uintptr and
+	// pointers.
+	Arch string `json:"arch,omitempty"`
+	// Named types selected for generation, in order.
+	Roots []*Type `json:"roots,omitempty"`
+	// Definitions of the struct types reachable from the roots, in order of
+	// discovery.
+	Structs []*StructDef `json:"structs,omitempty"`
+	// Definitions of the enum types reachable from the roots, in order of
+	// discovery.
+	Enums []*EnumDef `json:"enums,omitempty"`
+}
+
+// Struct returns the struct definition of the given named type, or nil if not
+// present.
+func (m *Module) Struct(t *Type) *StructDef {
+	for _, s := range m.Structs {
+		if s.Name == t.Name && s.Package == t.Package {
+			return s
+		}
+	}
+	return nil
+}
+
+// Enum returns the enum definition of the given named type, or nil if not
+// present.
+func (m *Module) Enum(t *Type) *EnumDef {
+	for _, e := range m.Enums {
+		if e.Name == t.Name && e.Package == t.Package {
+			return e
+		}
+	}
+	return nil
+}
+
+// Kind specifies the kind of a type.
+type Kind uint8
+
+// Type kinds.
+const (
+	// Boolean stored as a single byte.
+	Bool Kind = iota + 1
+	// Signed integer.
+	Int
+	// Unsigned integer.
+	Uint
+	// IEEE 754 floating-point number.
+	Float
+	// Complex number of two floating-point numbers.
+	Complex
+	// Variable-length string.
+	String
+	// Fixed-length array.
+	Array
+	// Variable-length sequence.
+	Slice
+	// Pointer, stored as an address of the target architecture.
+	Pointer
+	// Struct type; the fields of named struct types are defined by the
+	// StructDef of the module.
+	Struct
+	// Named type (struct, enum or other defined type).
+	Named
+	// Non-serializable type; i.e. funcs, interfaces and channels.
+	Unsupported
+	// Type not (yet) representable; e.g. maps.
+	Invalid
+)
+
+// kindNames maps from kinds to their names.
+var kindNames = map[Kind]string{
+	Bool:        "bool",
+	Int:         "int",
+	Uint:        "uint",
+	Float:       "float",
+	Complex:     "complex",
+	String:      "string",
+	Array:       "array",
+	Slice:       "slice",
+	Pointer:     "pointer",
+	Struct:      "struct",
+	Named:       "named",
+	Unsupported: "unsupported",
+	Invalid:     "invalid",
+}
+
+// String returns the name of the kind.
+func (kind Kind) String() string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Type is a type of the IR.
+type Type struct {
+	// Type kind.
+	Kind Kind `json:"kind,omitempty"`
+	// Go type, unqualified by package name; e.g. []Entry.
+	Go string `json:"go,omitempty"`
+	// Go type replaced by the type through type substitution, if any; e.g.
+	// Handle of Handle=uint32.
+	Subst string `json:"subst,omitempty"`
+	// Size in bytes of Bool, Int, Uint, Float and Complex types, and of the
+	// addresses stored for Pointer types.
+	Size int64 `json:"size,omitempty"`
+	// Length of Array types.
+	Len int64 `json:"len,omitempty"`
+	// Name of the constant defining the length of Array types, if any; e.g.
+	// HeaderSize of [HeaderSize]byte, or binary.MaxVarintLen64 of constants of
+	// other packages.
+	LenConst string `json:"lenConst,omitempty"`
+	// Element type of Array, Slice and Pointer types; nil for unsafe.Pointer.
+	Elem *Type `json:"elem,omitempty"`
+	// Name of Named types; the C name of cgo types (e.g. struct_point).
+	Name string `json:"name,omitempty"`
+	// Import path of the package of Named types.
+	Package string `json:"package,omitempty"`
+	// Identifier of Named types in the generated output, and of the synthetic
+	// types of unnamed Struct types of fields (e.g. header__info of the
+	// unnamed struct type of Header.Info).
+	ID string `json:"id,omitempty"`
+	// Source position of the declaration of Named types.
+	Pos string `json:"pos,omitempty"`
+	// Underlying type of Named types. The underlying type of named struct
+	// types has no fields; see Module.Struct.
+	Underlying *Type `json:"underlying,omitempty"`
+	// Fields of unnamed Struct types, with the options of their kaitai struct
+	// tags.
+	Fields []*Field `json:"fields,omitempty"`
+}
+
+// Under returns the underlying type of named types, and the type itself
+// otherwise.
+func (t *Type) Under() *Type {
+	if t.Kind == Named {
+		return t.Underlying
+	}
+	return t
+}
+
+// IsBasic reports whether the type is a boolean, numeric or string type, not
+// including named types.
+func (t *Type) IsBasic() bool {
+	switch t.Kind {
+	case Bool, Int, Uint, Float, Complex, String:
+		return true
+	}
+	return false
+}
+
+// IsByte reports whether the type is byte (or uint8), not including named
+// types.
+func (t *Type) IsByte() bool {
+	return t.Kind == Uint && t.Size == 1
+}
+
+// IsInteger reports whether the underlying type is an integer type.
+func (t *Type) IsInteger() bool {
+	u := t.Under()
+	return u.Kind == Int || u.Kind == Uint
+}
+
+// IsEnum reports whether the type is an enum; i.e. a named integer type.
+func (t *Type) IsEnum() bool {
+	return t.Kind == Named && t.IsInteger()
+}
+
+// IsNamedStruct reports whether the type is a named struct type.
+func (t *Type) IsNamedStruct() bool {
+	return t.Kind == Named && t.Underlying.Kind == Struct
+}
+
+// IsUnsupported reports whether the type is non-serializable, or an array or
+// slice thereof.
+func (t *Type) IsUnsupported() bool {
+	switch u := t.Under(); u.Kind {
+	case Unsupported:
+		return true
+	case Array, Slice:
+		return u.Elem.IsUnsupported()
+	}
+	return false
+}
+
+// Deps returns the named struct and enum types directly referenced by the
+// type, looking through arrays, slices and pointers.
+func (t *Type) Deps() []*Type {
+	switch t.Kind {
+	case Named:
+		if t.Underlying.Kind == Struct || t.IsEnum() {
+			return []*Type{t}
+		}
+		return t.Underlying.Deps()
+	case Array, Slice, Pointer:
+		if t.Elem != nil {
+			return t.Elem.Deps()
+		}
+	}
+	return nil
+}
+
+// StructDef is the definition of a named struct type.
+type StructDef struct {
+	// Go type name; the C name of cgo types (e.g. struct_point).
+	Name string `json:"name,omitempty"`
+	// Import path of the package of the type.
+	Package string `json:"package,omitempty"`
+	// Identifier in the generated output.
+	ID string `json:"id,omitempty"`
+	// Source position of the type declaration.
+	Pos string `json:"pos,omitempty"`
+	// Description of the cycle of recursive types through the fields of the
+	// types; e.g. "Node.Next -> Node". Empty if not recursive.
+	Recursive string `json:"recursive,omitempty"`
+	// Parameters of the type, as declared by //kaitai:param directives.
+	Params []*Param `json:"params,omitempty"`
+	// Representation of values of the type in the Kaitai Web IDE, as given by
+	// the //kaitai:webide-representation directive; e.g. "{name}: {value}".
+	Representation string `json:"representation,omitempty"`
+	// Fields in the order of the binary format (source order, unless given by
+	// the order directive of the type), excluding fields omitted by
+	// -exclude-type, -exclude-field and -unexported.
+	Fields []*Field `json:"fields,omitempty"`
+	// Unexported fields omitted by -unexported comment, which are output as
+	// comments in place of the fields.
+	Omitted []*OmittedField `json:"omitted,omitempty"`
+	// Instances computed from fields, as declared by //kaitai:instance
+	// directives on methods.
+	Instances []*Instance `json:"instances,omitempty"`
+	// Exported Go methods of the type (and of pointers to the type), in
+	// sorted order; e.g. "String() string" or "(*) Validate() error" of
+	// methods with pointer receivers.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// Field returns the field of the given Go name, or nil if not present.
+func (s *StructDef) Field(name string) *Field {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Precedes reports whether field a precedes field b in the fields of the
+// struct, which are in the order of the binary format.
+func (s *StructDef) Precedes(a, b *Field) bool {
+	for _, f := range s.Fields {
+		switch f {
+		case a:
+			return a != b
+		case b:
+			return false
+		}
+	}
+	return false
+}
+
+// Param returns the parameter of the given identifier, or nil if not present.
+func (s *StructDef) Param(id string) *Param {
+	for _, p := range s.Params {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Param is a parameter of a struct type, passed by the args option of the
+// fields of the type.
+type Param struct {
+	// Parameter identifier.
+	ID string `json:"id,omitempty"`
+	// Kaitai type of the parameter.
+	Type string `json:"type,omitempty"`
+}
+
+// Instance is a value computed from the fields of a struct type.
+type Instance struct {
+	// Identifier of the instance.
+	ID string `json:"id,omitempty"`
+	// Go method name.
+	Method string `json:"method,omitempty"`
+	// Source position of the method declaration.
+	Pos string `json:"pos,omitempty"`
+	// Kaitai expression of the value; empty if the method body could not be
+	// translated.
+	Value string `json:"value,omitempty"`
+	// Reason the method body could not be translated, if any.
+	Err string `json:"err,omitempty"`
+}
+
+// OmittedField is an unexported field of a struct type omitted from the binary
+// format by -unexported comment.
+type OmittedField struct {
+	// Go field name.
+	Name string `json:"name,omitempty"`
+	// Identifier in the generated output.
+	ID string `json:"id,omitempty"`
+	// Source position of the field.
+	Pos string `json:"pos,omitempty"`
+	// Go type of the field.
+	Go string `json:"go,omitempty"`
+	// Name of the field of the struct following the omitted field, or empty if
+	// no field follows.
+	Before string `json:"before,omitempty"`
+}
+
+// Field is a field of a struct type.
+type Field struct {
+	// Go field name; "_" for blank fields.
+	Name string `json:"name,omitempty"`
+	// Identifier in the generated output.
+	ID string `json:"id,omitempty"`
+	// Index of the field in the Go struct type, including omitted fields; -1
+	// for fields inserted by the generator (e.g. padding of C struct layouts
+	// and magic signatures).
+	Index int `json:"index,omitempty"`
+	// Source position of the field declaration.
+	Pos string `json:"pos,omitempty"`
+	// Field type.
+	Type *Type `json:"type,omitempty"`
+
+	// Options of the kaitai struct tag of the field; see package structtag.
+
+	// Byte order of the endian option; empty if not present.
+	Endian string `json:"endian,omitempty"`
+	// Magic contents of the contents option; nil if not present.
+	Contents []byte `json:"contents,omitempty"`
+	// String type of the str and strz options; either "str", "strz" or empty
+	// if not present.
+	Str string `json:"str,omitempty"`
+	// Character encoding of the encoding option (see structtag.Encodings);
+	// empty if not present.
+	Encoding string `json:"encoding,omitempty"`
+	// Size option; an integer literal, field name or parameter identifier.
+	Size string `json:"size,omitempty"`
+	// Kind of the repeat option; either "expr", "until", "eos" or empty if not
+	// present.
+	Repeat string `json:"repeat,omitempty"`
+	// Expression of repeat=expr (integer literal, field name or parameter
+	// identifier) and of repeat=until (Kaitai expression).
+	RepeatExpr string `json:"repeatExpr,omitempty"`
+	// Terminator byte of the terminator option; nil if not present.
+	Terminator *byte `json:"terminator,omitempty"`
+	// Include the terminator in the value (include option).
+	Include bool `json:"include,omitempty"`
+	// Leave the terminator in the stream (consume=false).
+	NoConsume bool `json:"noConsume,omitempty"`
+	// Accept the end of the stream before the terminator (eos-error=false).
+	NoEOSError bool `json:"noEosError,omitempty"`
+	// Size in bytes of the arbitrary-precision integer of the bigint option; 0
+	// if not present.
+	BigInt int64 `json:"bigint,omitempty"`
+	// Sign convention of the bigint option; see structtag.BigIntSigns.
+	BigIntSign string `json:"bigintSign,omitempty"`
+	// Byte order of the base-128 groups of the variable-length integer of the
+	// varint option (le or be); empty if not present.
+	Varint string `json:"varint,omitempty"`
+	// Algorithm of the checksum option (see structtag.Checksums); empty if not
+	// present.
+	Checksum string `json:"checksum,omitempty"`
+	// Names of the fields of the region of the checksum option, in order.
+	Region []string `json:"region,omitempty"`
+	// Process routine of the process option; e.g. zlib or xor(0x5f).
+	Process string `json:"process,omitempty"`
+	// Kaitai expression of the if option.
+	If string `json:"if,omitempty"`
+	// Arguments of the args option, passed to the parameters of the field
+	// type.
+	Args []string `json:"args,omitempty"`
+	// Field or parameter of the switch-on option.
+	SwitchOn string `json:"switchOn,omitempty"`
+	// Cases of the switch-on option.
+	Cases []*Case `json:"cases,omitempty"`
+	// Target of the offset-to option; a field name or type name.
+	OffsetTo string `json:"offsetTo,omitempty"`
+	// Origin of the offset of the offset-to option; either "start" or
+	// "stream".
+	Whence string `json:"whence,omitempty"`
+	// Named type of offset-to targets which are types rather than fields.
+	OffsetType *Type `json:"offsetType,omitempty"`
+	// Identifier of the instance of offset-to targets which are types.
+	OffsetID string `json:"offsetID,omitempty"`
+	// Name of the field holding the offset of fields located by the offset-to
+	// option of another field; empty otherwise.
+	OffsetField string `json:"offsetField,omitempty"`
+	// First problem encountered with the kaitai struct tag, if any.
+	TagErr string `json:"tagErr,omitempty"`
+}
+
+// Case is a case of the switch-on option of a field.
+type Case struct {
+	// Integer value, or "_" for the default case.
+	Value string `json:"value,omitempty"`
+	// Name of the constant of the case value, if any.
+	Const string `json:"const,omitempty"`
+	// Named struct type of the case.
+	Type *Type `json:"type,omitempty"`
+}
+
+// EnumDef is the definition of an enum type; i.e. a named integer type and its
+// constants.
+type EnumDef struct {
+	// Go type name.
+	Name string `json:"name,omitempty"`
+	// Import path of the package of the type.
+	Package string `json:"package,omitempty"`
+	// Identifier in the generated output.
+	ID string `json:"id,omitempty"`
+	// Source position of the type declaration.
+	Pos string `json:"pos,omitempty"`
+	// Underlying integer type.
+	Underlying *Type `json:"underlying,omitempty"`
+	// Flag-style enum; i.e. a set of single-bit flags.
+	Flags bool `json:"flags,omitempty"`
+	// Bit order of the bit field sub-type of a flag-style enum; either "le" or
+	// "be", or empty to follow the module.
+	BitEndian string `json:"bitEndian,omitempty"`
+	// Constants of the enum, in source order.
+	Values []*EnumValue `json:"values,omitempty"`
+}
+
+// EnumValue is a constant of an enum type.
+type EnumValue struct {
+	// Go constant name.
+	Name string `json:"name,omitempty"`
+	// Identifier in the generated output.
+	ID string `json:"id,omitempty"`
+	// Integer value, in decimal notation.
+	Value string `json:"value,omitempty"`
+	// Doc comment of the constant, if any.
+	Doc string `json:"doc,omitempty"`
+}
+
+// Int64 returns the value as an int64, and a boolean indicating whether the
+// value is exactly representable.
+func (v *EnumValue) Int64() (int64, bool) {
+	x, ok := new(big.Int).SetString(v.Value, 10)
+	if !ok || !x.IsInt64() {
+		return 0, false
+	}
+	return x.Int64(), true
+}
+
+// Uint64 returns the value as a uint64, and a boolean indicating whether the
+// value is exactly representable.
+func (v *EnumValue) Uint64() (uint64, bool) {
+	x, ok := new(big.Int).SetString(v.Value, 10)
+	if !ok || !x.IsUint64() {
+		return 0, false
+	}
+	return x.Uint64(), true
+}
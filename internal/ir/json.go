@@ -0,0 +1,153 @@
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// MarshalText implements encoding.TextMarshaler, encoding kinds by name in the
+// JSON representation of the IR.
+func (kind Kind) MarshalText() ([]byte, error) {
+	name, ok := kindNames[kind]
+	if !ok {
+		return nil, fmt.Errorf("invalid kind %d", uint8(kind))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (kind *Kind) UnmarshalText(text []byte) error {
+	for k, name := range kindNames {
+		if name == string(text) {
+			*kind = k
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid kind %q", text)
+}
+
+// Encode writes the JSON representation of the module to w.
+func (m *Module) Encode(w io.Writer) error {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
+
+// Decode reads a module from its JSON representation in r. The module is
+// checked to be well-formed; i.e. every type has the elements, underlying
+// types and definitions required by its kind.
+func Decode(r io.Reader) (*Module, error) {
+	m := &Module{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// check reports the first problem found with the module, if any.
+func (m *Module) check() error {
+	if m.Endian != "le" && m.Endian != "be" {
+		return fmt.Errorf("invalid endianness %q; valid options: le, be", m.Endian)
+	}
+	if len(m.Roots) == 0 {
+		return fmt.Errorf("no root types")
+	}
+	for _, t := range m.Roots {
+		if err := m.checkType(t); err != nil {
+			return fmt.Errorf("root type: %v", err)
+		}
+		if !t.IsNamedStruct() || m.Struct(t) == nil {
+			return fmt.Errorf("root type %s not a defined struct type", t.Name)
+		}
+	}
+	for _, s := range m.Structs {
+		for _, f := range s.Fields {
+			if err := m.checkType(f.Type); err != nil {
+				return fmt.Errorf("field %s.%s: %v", s.Name, f.Name, err)
+			}
+			for _, c := range f.Cases {
+				if err := m.checkType(c.Type); err != nil {
+					return fmt.Errorf("field %s.%s: case %s: %v", s.Name, f.Name, c.Value, err)
+				}
+			}
+			if f.OffsetType != nil {
+				if err := m.checkType(f.OffsetType); err != nil {
+					return fmt.Errorf("field %s.%s: offset type: %v", s.Name, f.Name, err)
+				}
+			}
+		}
+	}
+	for _, e := range m.Enums {
+		if e.Underlying == nil || !e.Underlying.IsInteger() {
+			return fmt.Errorf("enum %s: underlying type not an integer type", e.Name)
+		}
+		for _, v := range e.Values {
+			if _, ok := v.Int64(); !ok {
+				if _, ok := v.Uint64(); !ok {
+					return fmt.Errorf("enum %s: invalid value %q of %s", e.Name, v.Value, v.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkType reports the first problem found with the given type, if any.
+func (m *Module) checkType(t *Type) error {
+	if t == nil {
+		return fmt.Errorf("missing type")
+	}
+	switch t.Kind {
+	case Bool, Int, Uint, Float, Complex:
+		if t.Size <= 0 {
+			return fmt.Errorf("missing size of %s type %s", t.Kind, t.Go)
+		}
+	case Array, Slice, Pointer:
+		if t.Kind == Pointer && t.Size <= 0 {
+			return fmt.Errorf("missing address size of pointer type %s", t.Go)
+		}
+		if t.Elem == nil {
+			if t.Kind == Pointer {
+				// unsafe.Pointer.
+				return nil
+			}
+			return fmt.Errorf("missing element type of %s", t.Go)
+		}
+		return m.checkType(t.Elem)
+	case Named:
+		if t.Underlying == nil {
+			return fmt.Errorf("missing underlying type of %s", t.Name)
+		}
+		if t.Underlying.Kind == Struct {
+			if m.Struct(t) == nil {
+				return fmt.Errorf("struct type %s not defined", t.Name)
+			}
+			return nil
+		}
+		if t.IsEnum() && m.Enum(t) == nil {
+			return fmt.Errorf("enum type %s not defined", t.Name)
+		}
+		return m.checkType(t.Underlying)
+	case Struct:
+		for _, f := range t.Fields {
+			if err := m.checkType(f.Type); err != nil {
+				return err
+			}
+		}
+	case String, Unsupported, Invalid:
+		// no constituent types.
+	default:
+		return fmt.Errorf("invalid kind of type %s", t.Go)
+	}
+	return nil
+}
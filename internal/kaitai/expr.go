@@ -0,0 +1,644 @@
+package kaitai
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kaitai expressions are parsed into expression trees, evaluated in the
+// context of the value being parsed. Supported are integer, float, string and
+// boolean literals, enum references (e.g. kind::a), references to attributes,
+// instances and parameters (also of _parent and _root, and _ of repeat-until
+// and _index of repeated attributes), attribute access (e.g. header.len),
+// indexing, the unary operators -, ~ and not, arithmetic, bitwise, comparison
+// and logical operators, the ternary operator, and the methods to_i, length,
+// size, first and last, and the size, pos and eof of _io.
+
+// expr is a node of an expression tree.
+type expr interface{}
+
+type (
+	// lit is a literal; int64, float64, string or bool.
+	lit struct{ val interface{} }
+	// ident is an identifier; e.g. len, _parent or _io.
+	ident struct{ name string }
+	// enumRef is a reference to an enum value; e.g. kind::a.
+	enumRef struct{ enum, name string }
+	// unaryExpr is a unary operation; -, ~ or not.
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	// binaryExpr is a binary operation; e.g. + or ==.
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+	// condExpr is a ternary operation; cond ? x : y.
+	condExpr struct{ cond, x, y expr }
+	// attrExpr is an attribute access or method call; x.name.
+	attrExpr struct {
+		x    expr
+		name string
+	}
+	// indexExpr is an indexing operation; x[index].
+	indexExpr struct{ x, index expr }
+)
+
+// binaryPrec maps from binary operators to their precedence; higher binds
+// tighter.
+var binaryPrec = map[string]int{
+	"or":  1,
+	"and": 2,
+	"==":  3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"|":  4,
+	"^":  5,
+	"&":  6,
+	"<<": 7, ">>": 7,
+	"+": 8, "-": 8,
+	"*": 9, "/": 9, "%": 9,
+}
+
+// exprParser parses Kaitai expressions.
+type exprParser struct {
+	toks []string
+	pos  int
+}
+
+// parseExpr parses the given Kaitai expression.
+func parseExpr(src string) (expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	x, err := p.cond()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.toks[p.pos], src)
+	}
+	return x, nil
+}
+
+// peek returns the next token, or the empty string at the end of input.
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+// expect consumes the given token.
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q, got %q", tok, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// cond parses a ternary operation, or an operation of higher precedence.
+func (p *exprParser) cond() (expr, error) {
+	c, err := p.binary(1)
+	if err != nil || p.peek() != "?" {
+		return c, err
+	}
+	p.pos++
+	x, err := p.cond()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	y, err := p.cond()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond: c, x: x, y: y}, nil
+}
+
+// binary parses a binary operation of at least the given precedence.
+func (p *exprParser) binary(prec int) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		opPrec, ok := binaryPrec[op]
+		if !ok || opPrec < prec {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.binary(opPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+// unary parses a unary operation, or a postfix expression.
+func (p *exprParser) unary() (expr, error) {
+	switch op := p.peek(); op {
+	case "-", "~", "not":
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, x: x}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses an operand followed by attribute accesses and indexing.
+func (p *exprParser) postfix() (expr, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.pos++
+			name := p.peek()
+			if !isIdent(name) {
+				return nil, fmt.Errorf("expected attribute name after '.', got %q", name)
+			}
+			p.pos++
+			x = &attrExpr{x: x, name: name}
+		case "[":
+			p.pos++
+			index, err := p.cond()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// operand parses a literal, identifier, enum reference or parenthesized
+// expression.
+func (p *exprParser) operand() (expr, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		x, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case tok == "true" || tok == "false":
+		return &lit{val: tok == "true"}, nil
+	case tok[0] == '"' || tok[0] == '\'':
+		return &lit{val: tok[1 : len(tok)-1]}, nil
+	case unicode.IsDigit(rune(tok[0])):
+		s := strings.Replace(tok, "_", "", -1)
+		if x, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return &lit{val: x}, nil
+		}
+		if x, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return &lit{val: int64(x)}, nil
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		return &lit{val: x}, nil
+	case strings.Contains(tok, "::"):
+		pos := strings.LastIndex(tok, "::")
+		return &enumRef{enum: tok[:pos], name: tok[pos+2:]}, nil
+	case isIdent(tok):
+		return &ident{name: tok}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
+
+// isIdent reports whether the given token is an identifier.
+func isIdent(tok string) bool {
+	if len(tok) == 0 {
+		return false
+	}
+	for i, r := range tok {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits the given Kaitai expression into tokens.
+func tokenize(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string in expression %q", src)
+			}
+			toks = append(toks, src[i:i+end+2])
+			i += end + 2
+		case c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(src) {
+				if d := src[j]; d == '_' || unicode.IsLetter(rune(d)) || unicode.IsDigit(rune(d)) {
+					j++
+				} else if unicode.IsDigit(rune(c)) && d == '.' && j+1 < len(src) && unicode.IsDigit(rune(src[j+1])) {
+					// Decimal point of float literal.
+					j++
+				} else if !unicode.IsDigit(rune(c)) && strings.HasPrefix(src[j:], "::") {
+					// Enum reference or type path.
+					j += 2
+				} else {
+					break
+				}
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "<<", ">>":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%&|^~<>=!?:.[](),", op[:1]) || op == "=" || op == "!" {
+				return nil, fmt.Errorf("unexpected %q in expression %q", op, src)
+			}
+			toks = append(toks, op)
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+// env is the environment of evaluated expressions.
+type env struct {
+	spec *Spec
+	// Value being parsed.
+	self *Value
+	// Element of repeat-until expressions, and index of repeated attributes.
+	elem     *Value
+	index    int
+	hasIndex bool
+}
+
+// eval evaluates the given expression.
+func (e *env) eval(x expr) (interface{}, error) {
+	switch x := x.(type) {
+	case *lit:
+		return x.val, nil
+	case *ident:
+		return e.ident(x.name)
+	case *enumRef:
+		return e.spec.enumValue(e.self.Type, x.enum, x.name)
+	case *unaryExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case int64:
+			switch x.op {
+			case "-":
+				return -v, nil
+			case "~":
+				return ^v, nil
+			}
+		case float64:
+			if x.op == "-" {
+				return -v, nil
+			}
+		case bool:
+			if x.op == "not" {
+				return !v, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid operand %v of %s", v, x.op)
+	case *binaryExpr:
+		return e.binary(x)
+	case *condExpr:
+		c, err := e.eval(x.cond)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := c.(bool)
+		if !ok {
+			return nil, fmt.Errorf("condition %v is not a boolean", c)
+		}
+		if b {
+			return e.eval(x.x)
+		}
+		return e.eval(x.y)
+	case *attrExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		return e.attr(v, x.name)
+	case *indexExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		i, err := e.eval(x.index)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := i.(int64)
+		if !ok {
+			return nil, fmt.Errorf("index %v is not an integer", i)
+		}
+		switch v := v.(type) {
+		case *Value:
+			if v.Array && n >= 0 && n < int64(len(v.Elems)) {
+				return v.Elems[n].exprValue(), nil
+			}
+		case []byte:
+			if n >= 0 && n < int64(len(v)) {
+				return int64(v[n]), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid index %d of %v", n, v)
+	}
+	return nil, fmt.Errorf("unknown expression %T", x)
+}
+
+// ident evaluates the given identifier.
+func (e *env) ident(name string) (interface{}, error) {
+	switch name {
+	case "_":
+		if e.elem == nil {
+			return nil, fmt.Errorf("_ outside of repeat-until expression")
+		}
+		return e.elem.exprValue(), nil
+	case "_index":
+		if !e.hasIndex {
+			return nil, fmt.Errorf("_index outside of repeated attribute")
+		}
+		return int64(e.index), nil
+	}
+	return e.attr(e.self, name)
+}
+
+// attr evaluates the attribute or method of the given name of the given value.
+func (e *env) attr(v interface{}, name string) (interface{}, error) {
+	switch v := v.(type) {
+	case *Value:
+		if v.Array {
+			switch name {
+			case "length", "size":
+				return int64(len(v.Elems)), nil
+			case "first", "last":
+				if len(v.Elems) == 0 {
+					return nil, fmt.Errorf("%s of empty array", name)
+				}
+				if name == "first" {
+					return v.Elems[0].exprValue(), nil
+				}
+				return v.Elems[len(v.Elems)-1].exprValue(), nil
+			}
+			break
+		}
+		switch name {
+		case "_parent":
+			if v.parent == nil {
+				return nil, fmt.Errorf("_parent of root type")
+			}
+			return v.parent, nil
+		case "_root":
+			root := v
+			for root.parent != nil {
+				root = root.parent
+			}
+			return root, nil
+		case "_io":
+			return v.io, nil
+		}
+		return e.spec.member(v, name)
+	case *stream:
+		switch name {
+		case "size":
+			return int64(len(v.data)), nil
+		case "pos":
+			return int64(v.pos), nil
+		case "eof":
+			return v.eof(), nil
+		}
+	case int64:
+		if name == "to_i" {
+			return v, nil
+		}
+		if name == "to_s" {
+			return strconv.FormatInt(v, 10), nil
+		}
+	case float64:
+		if name == "to_i" {
+			return int64(v), nil
+		}
+	case bool:
+		if name == "to_i" {
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	case string:
+		switch name {
+		case "length", "size":
+			return int64(len(v)), nil
+		case "to_i":
+			x, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %q", v)
+			}
+			return x, nil
+		}
+	case []byte:
+		switch name {
+		case "length", "size":
+			return int64(len(v)), nil
+		case "first", "last":
+			if len(v) == 0 {
+				return nil, fmt.Errorf("%s of empty byte array", name)
+			}
+			if name == "first" {
+				return int64(v[0]), nil
+			}
+			return int64(v[len(v)-1]), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown attribute %s of %v", name, v)
+}
+
+// binary evaluates the given binary operation.
+func (e *env) binary(x *binaryExpr) (interface{}, error) {
+	a, err := e.eval(x.x)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit evaluation of logical operators.
+	if x.op == "and" || x.op == "or" {
+		ab, ok := a.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand %v of %s is not a boolean", a, x.op)
+		}
+		if ab == (x.op == "or") {
+			return ab, nil
+		}
+		b, err := e.eval(x.y)
+		if err != nil {
+			return nil, err
+		}
+		bb, ok := b.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand %v of %s is not a boolean", b, x.op)
+		}
+		return bb, nil
+	}
+	b, err := e.eval(x.y)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "==":
+		return equal(a, b), nil
+	case "!=":
+		return !equal(a, b), nil
+	}
+	if as, ok := a.(string); ok {
+		bs, ok := b.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid operands %q and %v of %s", as, b, x.op)
+		}
+		switch x.op {
+		case "+":
+			return as + bs, nil
+		case "<":
+			return as < bs, nil
+		case "<=":
+			return as <= bs, nil
+		case ">":
+			return as > bs, nil
+		case ">=":
+			return as >= bs, nil
+		}
+		return nil, fmt.Errorf("invalid operator %s of strings", x.op)
+	}
+	ai, aok := a.(int64)
+	bi, bok := b.(int64)
+	if aok && bok {
+		switch x.op {
+		case "+":
+			return ai + bi, nil
+		case "-":
+			return ai - bi, nil
+		case "*":
+			return ai * bi, nil
+		case "/", "%":
+			if bi == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			// Kaitai division and modulo round towards negative infinity.
+			q, r := ai/bi, ai%bi
+			if r != 0 && (r < 0) != (bi < 0) {
+				q--
+				r += bi
+			}
+			if x.op == "/" {
+				return q, nil
+			}
+			return r, nil
+		case "&":
+			return ai & bi, nil
+		case "|":
+			return ai | bi, nil
+		case "^":
+			return ai ^ bi, nil
+		case "<<":
+			return ai << uint64(bi), nil
+		case ">>":
+			return ai >> uint64(bi), nil
+		case "<":
+			return ai < bi, nil
+		case "<=":
+			return ai <= bi, nil
+		case ">":
+			return ai > bi, nil
+		case ">=":
+			return ai >= bi, nil
+		}
+	}
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	if aok && bok {
+		switch x.op {
+		case "+":
+			return af + bf, nil
+		case "-":
+			return af - bf, nil
+		case "*":
+			return af * bf, nil
+		case "/":
+			return af / bf, nil
+		case "<":
+			return af < bf, nil
+		case "<=":
+			return af <= bf, nil
+		case ">":
+			return af > bf, nil
+		case ">=":
+			return af >= bf, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operands %v and %v of %s", a, b, x.op)
+}
+
+// toFloat returns the given integer or float as a float.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal reports whether the given values are equal.
+func equal(a, b interface{}) bool {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		return ok && af == bf
+	}
+	if ab, ok := a.([]byte); ok {
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(ab, bb)
+	}
+	switch a.(type) {
+	case string, bool:
+		return a == b
+	}
+	return false
+}
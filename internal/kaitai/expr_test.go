@@ -0,0 +1,113 @@
+package kaitai
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	const ksy = `
+meta:
+  id: expr
+  endian: le
+seq:
+  - id: neg
+    type: s1
+  - id: two
+    type: u1
+  - id: magic
+    size: 2
+  - id: name
+    type: str
+    size: 3
+    encoding: ASCII
+enums:
+  kind:
+    1: kind_a
+    2: kind_b
+`
+	spec, err := ParseSpec([]byte(ksy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := spec.Parse(spec.DefaultType(), []byte{0xF9, 0x02, 'M', 'Z', 'a', 'b', 'c'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := []struct {
+		src  string
+		want interface{}
+		err  bool
+	}{
+		// Floored division and modulo.
+		{src: "7 / 2", want: int64(3)},
+		{src: "-7 / 2", want: int64(-4)},
+		{src: "7 / -2", want: int64(-4)},
+		{src: "-7 / -2", want: int64(3)},
+		{src: "-7 % 2", want: int64(1)},
+		{src: "7 % -2", want: int64(-1)},
+		{src: "-8 % 2", want: int64(0)},
+		{src: "neg / two", want: int64(-4)},
+		{src: "neg % two", want: int64(1)},
+		{src: "1 / 0", err: true},
+		{src: "1 % 0", err: true},
+		{src: "7.0 / 2", want: 3.5},
+		// Short-circuit logic; the right operand is not evaluated.
+		{src: "false and 1 / 0 == 0", want: false},
+		{src: "true or 1 / 0 == 0", want: true},
+		{src: "two == 2 or missing", want: true},
+		{src: "two != 2 and missing", want: false},
+		{src: "true and missing", err: true},
+		{src: "1 and true", err: true},
+		{src: "true and 1", err: true},
+		// Precedence and operators.
+		{src: "1 + 2 * 3", want: int64(7)},
+		{src: "1 << 2 + 1", want: int64(8)},
+		{src: "6 & 3 == 2", want: true},
+		{src: "two > 1 ? 10 : 20", want: int64(10)},
+		{src: "-neg", want: int64(7)},
+		{src: "~0", want: int64(-1)},
+		{src: "not (two == 2)", want: false},
+		// Strings, byte arrays and enums.
+		{src: "name + \"d\"", want: "abcd"},
+		{src: "name.length", want: int64(3)},
+		{src: "magic[1] == 0x5A", want: true},
+		{src: "magic.size", want: int64(2)},
+		{src: "kind::kind_b", want: int64(2)},
+		{src: "name - 1", err: true},
+		{src: "missing", err: true},
+	}
+	for _, g := range golden {
+		got, err := spec.eval(&env{spec: spec, self: v}, g.src)
+		if g.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", g.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error; %v", g.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("%q: expected %v (%T), got %v (%T)", g.src, g.want, g.want, got, got)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	golden := []string{
+		"",
+		"1 +",
+		"(1",
+		"1 2",
+		"a = 1",
+		"!a",
+		"true ? 1",
+	}
+	for _, src := range golden {
+		if _, err := parseExpr(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
@@ -0,0 +1,931 @@
+// Package kaitai interprets Kaitai specs (.ksy files) at runtime, parsing
+// binary data according to the types of a spec without a Kaitai compiler or
+// runtime.
+//
+// The commonly used subset of Kaitai is supported; seq attributes, instances,
+// parameters, enums, contents, sizes, terminators, repetitions, conditions,
+// switch types, the xor and zlib process routines and expressions (see
+// parseExpr). Calculated endianness, custom process routines and imports are
+// not supported.
+package kaitai
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a Kaitai spec, interpreted to parse binary data according to its
+// types.
+type Spec struct {
+	// Types of the spec, in order of declaration; the top-level type first.
+	Types []*Type
+	// Types by path.
+	types map[string]*Type
+	// Enums, by path; e.g. kind or header::kind.
+	enums map[string]*enumDef
+	// Default encoding of strings.
+	encoding string
+	// Parsed expressions, by source.
+	exprs map[string]expr
+}
+
+// Type is a type of a Kaitai spec.
+type Type struct {
+	// Path of the type; e.g. header or header::entry. The top-level type has
+	// the empty path.
+	Path string
+	// Name of the type; the path, or the meta/id of the top-level type (root if
+	// not set).
+	Name string
+	// Byte order and bit endianness, inherited from enclosing types; either
+	// "le", "be" or empty if unspecified.
+	Endian, BitEndian string
+	params            []*yaml.Node
+	seq               []*yaml.Node
+	// Instances, in order of declaration.
+	instances   []*yaml.Node
+	instanceIDs []string
+}
+
+// enumDef is an enum of a Kaitai spec.
+type enumDef struct {
+	// Names of the enum values, by value.
+	names map[int64]string
+	// Values of the enum, by name.
+	values map[string]int64
+}
+
+// Value is a value parsed from binary data; either a scalar, an array or a
+// user type.
+type Value struct {
+	// Identifier of the attribute; e.g. magic, or [3] for array elements.
+	ID string
+	// Byte range [Start, End) in the binary file, or -1 for value instances.
+	Start, End int
+	// Scalar value; uint64, int64, float64, string, []byte or bool.
+	Val interface{}
+	// Width of bit field values (b1 values are bool); 0 otherwise.
+	Bits int
+	// Enum of the value and name of the enum value, if any.
+	Enum, EnumName string
+	// Array elements.
+	Array bool
+	Elems []*Value
+	// User type, and its parsed attributes and instances.
+	Type   *Type
+	Fields []*Value
+	// Parameters of the user type.
+	params map[string]interface{}
+	// Enclosing user type value.
+	parent *Value
+	// Stream of the user type value.
+	io *stream
+	// Computed and in-progress instances, by identifier.
+	instances map[string]*Value
+	computing map[string]bool
+}
+
+// Field returns the attribute or instance of the given identifier of the user
+// type value, or nil if not present.
+func (v *Value) Field(id string) *Value {
+	for _, f := range v.Fields {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// exprValue returns the value for use in expressions.
+func (v *Value) exprValue() interface{} {
+	if v.Type != nil || v.Array {
+		return v
+	}
+	if x, ok := v.Val.(uint64); ok {
+		return int64(x)
+	}
+	return v.Val
+}
+
+// ParseSpec parses the given Kaitai spec.
+func ParseSpec(buf []byte) (*Spec, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("invalid Kaitai spec; %v", err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid Kaitai spec; root is not a mapping")
+	}
+	root := doc.Content[0]
+	spec := &Spec{
+		types:    make(map[string]*Type),
+		enums:    make(map[string]*enumDef),
+		encoding: LookupValue(Lookup(root, "meta"), "encoding"),
+		exprs:    make(map[string]expr),
+	}
+	if err := spec.collect(root); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Type returns the type of the given path, or nil if not present.
+func (spec *Spec) Type(path string) *Type {
+	return spec.types[path]
+}
+
+// DefaultType returns the type parsed by default; the top-level type if it has
+// seq attributes or instances, and otherwise the first user type (e.g. of the
+// specs generated by type2kaitai).
+func (spec *Spec) DefaultType() *Type {
+	root := spec.Types[0]
+	if len(root.seq) > 0 || len(root.instances) > 0 || len(spec.Types) < 2 {
+		return root
+	}
+	return spec.Types[1]
+}
+
+// collect adds the given top-level type spec, and its nested user types and
+// enums, to the spec. User types inherit the byte order and bit endianness of
+// their enclosing types.
+func (spec *Spec) collect(root *yaml.Node) error {
+	return Walk(root, "", spec.collectType, spec.collectEnum)
+}
+
+// collectType adds the given type spec at the given path to the spec.
+func (spec *Spec) collectType(path string, node *yaml.Node) error {
+	var endian, bitEndian string
+	if len(path) > 0 {
+		parent := spec.types[ParentPath(path)]
+		endian, bitEndian = parent.Endian, parent.BitEndian
+	}
+	meta := Lookup(node, "meta")
+	name := path
+	if len(name) == 0 {
+		name = LookupValue(meta, "id")
+		if len(name) == 0 {
+			name = "root"
+		}
+	}
+	if e := Lookup(meta, "endian"); e != nil {
+		if e.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s: support for calculated endianness not yet implemented", name)
+		}
+		endian = e.Value
+	}
+	if e := LookupValue(meta, "bit-endian"); len(e) > 0 {
+		bitEndian = e
+	}
+	t := &Type{Path: path, Name: name, Endian: endian, BitEndian: bitEndian}
+	if params := Lookup(node, "params"); params != nil {
+		t.params = params.Content
+	}
+	if seq := Lookup(node, "seq"); seq != nil {
+		t.seq = seq.Content
+	}
+	ForPairs(Lookup(node, "instances"), func(id, inst *yaml.Node) {
+		t.instances = append(t.instances, inst)
+		t.instanceIDs = append(t.instanceIDs, id.Value)
+	})
+	spec.types[path] = t
+	spec.Types = append(spec.Types, t)
+	return nil
+}
+
+// collectEnum adds the enum of the given values at the given path to the spec.
+func (spec *Spec) collectEnum(path string, values *yaml.Node) {
+	e := &enumDef{names: make(map[int64]string), values: make(map[string]int64)}
+	ForPairs(values, func(value, id *yaml.Node) {
+		x, err := strconv.ParseInt(strings.Replace(value.Value, "_", "", -1), 0, 64)
+		if err != nil {
+			return
+		}
+		name := id.Value
+		if id.Kind == yaml.MappingNode {
+			name = LookupValue(id, "id")
+		}
+		e.names[x] = name
+		e.values[name] = x
+	})
+	spec.enums[path] = e
+}
+
+// lookupType returns the user type referenced by the given type name from the
+// scope of the given type, or nil if not present; see ResolvePath.
+func (spec *Spec) lookupType(t *Type, name string) *Type {
+	if len(name) == 0 {
+		return nil
+	}
+	path, ok := ResolvePath(t.Path, name, func(path string) bool {
+		_, ok := spec.types[path]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+	return spec.types[path]
+}
+
+// lookupEnum returns the enum referenced by the given enum name from the scope
+// of the given type, or nil if not present; see ResolvePath.
+func (spec *Spec) lookupEnum(t *Type, name string) *enumDef {
+	path, ok := ResolvePath(t.Path, name, func(path string) bool {
+		_, ok := spec.enums[path]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+	return spec.enums[path]
+}
+
+// enumValue returns the value of the named enum value of the given enum,
+// referenced from the scope of the given type.
+func (spec *Spec) enumValue(t *Type, enum, name string) (int64, error) {
+	e := spec.lookupEnum(t, enum)
+	if e == nil {
+		return 0, fmt.Errorf("unknown enum %q", enum)
+	}
+	x, ok := e.values[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown value %q of enum %q", name, enum)
+	}
+	return x, nil
+}
+
+// eval evaluates the given Kaitai expression in the given environment.
+func (spec *Spec) eval(e *env, src string) (interface{}, error) {
+	x, ok := spec.exprs[src]
+	if !ok {
+		var err error
+		if x, err = parseExpr(src); err != nil {
+			return nil, err
+		}
+		spec.exprs[src] = x
+	}
+	return e.eval(x)
+}
+
+// evalInt evaluates the given Kaitai expression of integer type.
+func (spec *Spec) evalInt(e *env, src string) (int64, error) {
+	v, err := spec.eval(e, src)
+	if err != nil {
+		return 0, err
+	}
+	x, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expression %q is not an integer; got %v", src, v)
+	}
+	return x, nil
+}
+
+// evalBool evaluates the given Kaitai expression of boolean type.
+func (spec *Spec) evalBool(e *env, src string) (bool, error) {
+	v, err := spec.eval(e, src)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q is not a boolean; got %v", src, v)
+	}
+	return b, nil
+}
+
+// Parse parses the given data as the given type. The parsed value is returned
+// also on error, holding the attributes parsed up to the error. The end of the
+// value is the number of bytes consumed by its seq attributes.
+func (spec *Spec) Parse(t *Type, data []byte) (*Value, error) {
+	v := &Value{ID: t.Name}
+	err := spec.parseType(&stream{data: data}, v, t, nil, nil)
+	return v, err
+}
+
+// parseType parses a value of the given type from s into v. The value is
+// enclosed in the given parent value, and has the given parameters.
+func (spec *Spec) parseType(s *stream, v *Value, t *Type, parent *Value, args []interface{}) error {
+	v.Type = t
+	v.parent = parent
+	v.io = s
+	v.params = make(map[string]interface{})
+	v.instances = make(map[string]*Value)
+	v.computing = make(map[string]bool)
+	if len(args) != len(t.params) {
+		return fmt.Errorf("type %s expects %d parameters, got %d", t.Name, len(t.params), len(args))
+	}
+	for i, param := range t.params {
+		v.params[LookupValue(param, "id")] = args[i]
+	}
+	for _, a := range t.seq {
+		id := LookupValue(a, "id")
+		f, err := spec.parseAttr(s, v, a, id)
+		if f != nil {
+			v.Fields = append(v.Fields, f)
+		}
+		if err != nil {
+			v.End = s.offset()
+			return attrError(t, id, f, err).prefix(id)
+		}
+	}
+	v.End = s.offset()
+	for _, id := range t.instanceIDs {
+		if _, err := spec.instance(v, id); err != nil {
+			return attrError(t, id, nil, err).prefix(id)
+		}
+	}
+	return nil
+}
+
+// Error is an error of parsing an attribute.
+type Error struct {
+	// Type and identifier of the attribute.
+	Type *Type
+	ID   string
+	// Path of the attribute value from the parsed type; e.g.
+	// header.entries[3].data.
+	Path string
+	// Partially parsed value of the attribute, if any; e.g. the bytes of
+	// invalid contents.
+	Value *Value
+	Err   error
+}
+
+// Error returns the path of the attribute value and the error message.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// attrError returns the given error of parsing the given attribute of the
+// given type as an *Error, if not already.
+func attrError(t *Type, id string, f *Value, err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Type: t, ID: id, Value: f, Err: err}
+}
+
+// prefix prepends the given element (e.g. an identifier or array index) to
+// the path of the attribute value of the error.
+func (e *Error) prefix(elem string) *Error {
+	switch {
+	case len(e.Path) == 0:
+		e.Path = elem
+	case strings.HasPrefix(e.Path, "["):
+		e.Path = elem + e.Path
+	default:
+		e.Path = elem + "." + e.Path
+	}
+	return e
+}
+
+// member returns the attribute, instance or parameter of the given name of the
+// given user type value, for use in expressions.
+func (spec *Spec) member(v *Value, name string) (interface{}, error) {
+	if x, ok := v.params[name]; ok {
+		return x, nil
+	}
+	for _, f := range v.Fields {
+		if f.ID == name {
+			return f.exprValue(), nil
+		}
+	}
+	for _, id := range v.Type.instanceIDs {
+		if id == name {
+			inst, err := spec.instance(v, id)
+			if err != nil {
+				return nil, err
+			}
+			if x, ok := inst.Val.(*Value); ok {
+				return x, nil
+			}
+			return inst.exprValue(), nil
+		}
+	}
+	for _, a := range v.Type.seq {
+		if LookupValue(a, "id") == name {
+			// Attribute absent due to if, or not yet parsed.
+			return nil, fmt.Errorf("attribute %s not parsed", name)
+		}
+	}
+	return nil, fmt.Errorf("unknown attribute %s of type %s", name, v.Type.Name)
+}
+
+// instance returns the instance of the given identifier of the given user type
+// value, computing it on first use.
+func (spec *Spec) instance(v *Value, id string) (*Value, error) {
+	if inst, ok := v.instances[id]; ok {
+		return inst, nil
+	}
+	if v.computing[id] {
+		return nil, fmt.Errorf("recursive instance %s", id)
+	}
+	v.computing[id] = true
+	defer delete(v.computing, id)
+	var inst *yaml.Node
+	for i, instID := range v.Type.instanceIDs {
+		if instID == id {
+			inst = v.Type.instances[i]
+		}
+	}
+	e := &env{spec: spec, self: v}
+	if src := LookupValue(inst, "if"); len(src) > 0 {
+		ok, err := spec.evalBool(e, src)
+		if err != nil || !ok {
+			return &Value{ID: id, Start: -1, End: -1}, err
+		}
+	}
+	var f *Value
+	if src := LookupValue(inst, "value"); len(src) > 0 {
+		x, err := spec.eval(e, src)
+		if err != nil {
+			return nil, err
+		}
+		f = &Value{ID: id, Start: -1, End: -1, Val: x}
+		if err := spec.setEnum(v.Type, f, inst); err != nil {
+			return nil, err
+		}
+	} else {
+		s := v.io
+		if src := LookupValue(inst, "io"); len(src) > 0 {
+			x, err := spec.eval(e, src)
+			if err != nil {
+				return nil, err
+			}
+			io, ok := x.(*stream)
+			if !ok {
+				return nil, fmt.Errorf("io %q is not a stream", src)
+			}
+			s = io
+		}
+		// Parse the instance at the given position, leaving the position of
+		// the stream unchanged.
+		pos, cur, nbits := s.pos, s.cur, s.nbits
+		if src := LookupValue(inst, "pos"); len(src) > 0 {
+			x, err := spec.evalInt(e, src)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.seek(x); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		f, err = spec.parseAttr(s, v, inst, id)
+		s.pos, s.cur, s.nbits = pos, cur, nbits
+		if err != nil {
+			if f != nil {
+				v.Fields = append(v.Fields, f)
+			}
+			return nil, err
+		}
+	}
+	if f == nil {
+		f = &Value{ID: id, Start: -1, End: -1}
+	} else {
+		v.Fields = append(v.Fields, f)
+	}
+	v.instances[id] = f
+	return f, nil
+}
+
+// parseAttr parses the given attribute of the given user type value from s.
+// The parsed value is nil if the attribute is absent due to its if key.
+func (spec *Spec) parseAttr(s *stream, v *Value, a *yaml.Node, id string) (*Value, error) {
+	e := &env{spec: spec, self: v}
+	if src := LookupValue(a, "if"); len(src) > 0 {
+		ok, err := spec.evalBool(e, src)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+	repeat := LookupValue(a, "repeat")
+	if len(repeat) == 0 {
+		return spec.parseValue(s, v, a, id, e)
+	}
+	arr := &Value{ID: id, Start: s.offset(), Array: true}
+	var n int64
+	switch repeat {
+	case "expr":
+		var err error
+		if n, err = spec.evalInt(e, LookupValue(a, "repeat-expr")); err != nil {
+			return nil, err
+		}
+	case "eos", "until":
+	default:
+		return nil, fmt.Errorf("invalid repeat %q", repeat)
+	}
+	for i := 0; ; i++ {
+		if repeat == "expr" && int64(i) >= n || repeat == "eos" && s.eof() {
+			break
+		}
+		elemEnv := &env{spec: spec, self: v, index: i, hasIndex: true}
+		elem, err := spec.parseValue(s, v, a, fmt.Sprintf("[%d]", i), elemEnv)
+		if elem != nil {
+			arr.Elems = append(arr.Elems, elem)
+		}
+		arr.End = s.offset()
+		if err != nil {
+			return arr, attrError(v.Type, id, elem, err).prefix(fmt.Sprintf("[%d]", i))
+		}
+		if repeat == "until" {
+			elemEnv.elem = elem
+			done, err := spec.evalBool(elemEnv, LookupValue(a, "repeat-until"))
+			if err != nil {
+				return arr, err
+			}
+			if done {
+				break
+			}
+		}
+	}
+	arr.End = s.offset()
+	return arr, nil
+}
+
+var (
+	// reBits matches bit field types; e.g. b3.
+	reBits = regexp.MustCompile(`^b([1-9][0-9]*)(le|be)?$`)
+	// reNumber matches integer and float types; e.g. u4be.
+	reNumber = regexp.MustCompile(`^([usf])([1248])(le|be)?$`)
+)
+
+// parseValue parses a single value of the given attribute of the given user
+// type value from s.
+func (spec *Spec) parseValue(s *stream, v *Value, a *yaml.Node, id string, e *env) (*Value, error) {
+	typ, err := spec.attrType(e, a)
+	if err != nil {
+		return nil, err
+	}
+	if m := reBits.FindStringSubmatch(typ); m == nil {
+		s.align()
+	}
+	f := &Value{ID: id, Start: s.offset()}
+	if s.nbits > 0 {
+		// Partially read byte of bit fields.
+		f.Start--
+	}
+	defer func() { f.End = s.offset() }()
+	if contents := Lookup(a, "contents"); contents != nil {
+		want, err := contentsBytes(contents)
+		if err != nil {
+			return nil, err
+		}
+		got, err := s.read(len(want))
+		if err != nil {
+			return nil, err
+		}
+		f.Val = got
+		if !bytes.Equal(got, want) {
+			return f, fmt.Errorf("invalid contents; expected %X, got %X", want, got)
+		}
+		return f, nil
+	}
+	// Sized and terminated values.
+	var data []byte
+	sized := false
+	if src := LookupValue(a, "size"); len(src) > 0 {
+		n, err := spec.evalInt(e, src)
+		if err != nil {
+			return nil, err
+		}
+		if int64(int(n)) != n {
+			return nil, fmt.Errorf("invalid size %d", n)
+		}
+		if data, err = s.read(int(n)); err != nil {
+			return nil, err
+		}
+		sized = true
+	} else if LookupValue(a, "size-eos") == "true" {
+		data, _ = s.read(len(s.data) - s.pos)
+		sized = true
+	}
+	term, hasTerm := int64(0), typ == "strz"
+	if src := LookupValue(a, "terminator"); len(src) > 0 {
+		if term, err = spec.evalInt(e, src); err != nil {
+			return nil, err
+		}
+		hasTerm = true
+	}
+	include := LookupValue(a, "include") == "true"
+	if hasTerm {
+		if sized {
+			if pos := bytes.IndexByte(data, byte(term)); pos != -1 {
+				if include {
+					pos++
+				}
+				data = data[:pos]
+			}
+		} else {
+			consume := LookupValue(a, "consume") != "false"
+			eosError := LookupValue(a, "eos-error") != "false"
+			if data, err = s.readUntil(byte(term), include, consume, eosError); err != nil {
+				return nil, err
+			}
+		}
+		sized = true
+	}
+	if sized {
+		if process := LookupValue(a, "process"); len(process) > 0 {
+			if data, err = spec.process(e, process, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch {
+	case typ == "str" || typ == "strz":
+		if !sized {
+			return nil, fmt.Errorf("missing size of string")
+		}
+		encoding := LookupValue(a, "encoding")
+		if len(encoding) == 0 {
+			encoding = spec.encoding
+		}
+		if f.Val, err = decodeString(data, encoding); err != nil {
+			return nil, err
+		}
+	case len(typ) == 0:
+		if !sized {
+			return nil, fmt.Errorf("missing type and size")
+		}
+		f.Val = data
+	case reBits.MatchString(typ):
+		m := reBits.FindStringSubmatch(typ)
+		n, _ := strconv.Atoi(m[1])
+		if n > 64 {
+			return nil, fmt.Errorf("bit field width %d exceeds 64 bits", n)
+		}
+		bitEndian := m[2]
+		if len(bitEndian) == 0 {
+			bitEndian = v.Type.BitEndian
+		}
+		x, err := s.readBits(n, bitEndian)
+		if err != nil {
+			return nil, err
+		}
+		f.Bits = n
+		if n == 1 && Lookup(a, "enum") == nil {
+			f.Val = x == 1
+		} else {
+			f.Val = x
+		}
+	case reNumber.MatchString(typ):
+		m := reNumber.FindStringSubmatch(typ)
+		size, _ := strconv.Atoi(m[2])
+		endian := m[3]
+		if len(endian) == 0 {
+			endian = v.Type.Endian
+		}
+		if len(endian) == 0 && size > 1 {
+			return nil, fmt.Errorf("unspecified byte order of type %s", typ)
+		}
+		if !sized {
+			if data, err = s.read(size); err != nil {
+				return nil, err
+			}
+		} else if len(data) < size {
+			return nil, fmt.Errorf("size %d too small for type %s", len(data), typ)
+		}
+		var buf [8]byte
+		var x uint64
+		if endian == "be" {
+			copy(buf[8-size:], data[:size])
+			x = binary.BigEndian.Uint64(buf[:])
+		} else {
+			copy(buf[:], data[:size])
+			x = binary.LittleEndian.Uint64(buf[:])
+		}
+		f.Val = number(m[1], size, x)
+	default:
+		// User type.
+		name, args, err := spec.typeArgs(e, typ)
+		if err != nil {
+			return nil, err
+		}
+		t := spec.lookupType(v.Type, name)
+		if t == nil {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+		sub := s
+		if sized {
+			// Substream of sized user type.
+			sub = &stream{data: data, base: f.Start}
+		}
+		if err := spec.parseType(sub, f, t, v, args); err != nil {
+			return f, err
+		}
+		return f, nil
+	}
+	if err := spec.setEnum(v.Type, f, a); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// attrType returns the type of the given attribute, resolving switch types.
+func (spec *Spec) attrType(e *env, a *yaml.Node) (string, error) {
+	typ := Lookup(a, "type")
+	if typ == nil {
+		return "", nil
+	}
+	if typ.Kind == yaml.ScalarNode {
+		return typ.Value, nil
+	}
+	on, err := spec.eval(e, LookupValue(typ, "switch-on"))
+	if err != nil {
+		return "", err
+	}
+	var match, def string
+	ForPairs(Lookup(typ, "cases"), func(key, value *yaml.Node) {
+		if len(match) > 0 || err != nil {
+			return
+		}
+		if key.Value == "_" {
+			def = value.Value
+			return
+		}
+		var x interface{}
+		if x, err = spec.eval(e, key.Value); err == nil && equal(on, x) {
+			match = value.Value
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(match) > 0 {
+		return match, nil
+	}
+	return def, nil
+}
+
+// typeArgs splits the given user type reference (e.g. entry(4, len)) into the
+// type name and its evaluated arguments.
+func (spec *Spec) typeArgs(e *env, typ string) (string, []interface{}, error) {
+	pos := strings.Index(typ, "(")
+	if pos == -1 || !strings.HasSuffix(typ, ")") {
+		return strings.TrimSpace(typ), nil, nil
+	}
+	var args []interface{}
+	depth, start := 0, pos+1
+	body := typ[:len(typ)-1]
+	for i := start; i <= len(body); i++ {
+		if i < len(body) {
+			switch body[i] {
+			case '(', '[':
+				depth++
+				continue
+			case ')', ']':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if src := strings.TrimSpace(body[start:i]); len(src) > 0 {
+			arg, err := spec.eval(e, src)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, arg)
+		}
+		start = i + 1
+	}
+	return strings.TrimSpace(typ[:pos]), args, nil
+}
+
+// setEnum sets the enum value name of the given value, if its attribute has an
+// enum key.
+func (spec *Spec) setEnum(t *Type, f *Value, a *yaml.Node) error {
+	name := LookupValue(a, "enum")
+	if len(name) == 0 {
+		return nil
+	}
+	e := spec.lookupEnum(t, name)
+	if e == nil {
+		return fmt.Errorf("unknown enum %q", name)
+	}
+	var x int64
+	switch v := f.Val.(type) {
+	case uint64:
+		x = int64(v)
+	case int64:
+		x = v
+	default:
+		return fmt.Errorf("enum %q of non-integer value %v", name, f.Val)
+	}
+	f.Enum = name
+	f.EnumName = e.names[x]
+	return nil
+}
+
+// process applies the given process routine (xor(key) or zlib) to data.
+func (spec *Spec) process(e *env, process string, data []byte) ([]byte, error) {
+	switch {
+	case process == "zlib":
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case strings.HasPrefix(process, "xor(") && strings.HasSuffix(process, ")"):
+		key, err := spec.eval(e, process[len("xor("):len(process)-1])
+		if err != nil {
+			return nil, err
+		}
+		var keys []byte
+		switch key := key.(type) {
+		case int64:
+			keys = []byte{byte(key)}
+		case []byte:
+			keys = key
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("invalid xor key %v", key)
+		}
+		buf := make([]byte, len(data))
+		for i, b := range data {
+			buf[i] = b ^ keys[i%len(keys)]
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("support for process %q not yet implemented", process)
+}
+
+// decodeString decodes the given string data of the given encoding.
+func decodeString(data []byte, encoding string) (string, error) {
+	switch strings.ToUpper(encoding) {
+	case "", "ASCII", "UTF-8", "UTF8":
+		return string(data), nil
+	case "ISO-8859-1", "LATIN1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case "UTF-16LE", "UTF-16BE":
+		order := binary.ByteOrder(binary.LittleEndian)
+		if strings.HasSuffix(strings.ToUpper(encoding), "BE") {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return "", fmt.Errorf("support for encoding %q not yet implemented", encoding)
+}
+
+// number returns the value of the given integer or float type (u, s or f) of
+// the specified size, based on its raw bits x.
+func number(kind string, size int, x uint64) interface{} {
+	switch kind {
+	case "s":
+		shift := 64 - 8*uint(size)
+		return int64(x<<shift) >> shift
+	case "f":
+		if size == 4 {
+			return float64(math.Float32frombits(uint32(x)))
+		}
+		return math.Float64frombits(x)
+	}
+	return x
+}
+
+// contentsBytes returns the bytes of the given contents key; a string, or a
+// sequence of bytes and strings.
+func contentsBytes(contents *yaml.Node) ([]byte, error) {
+	if contents.Kind == yaml.SequenceNode {
+		var buf []byte
+		for _, elem := range contents.Content {
+			data, err := contentsBytes(elem)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, data...)
+		}
+		return buf, nil
+	}
+	if contents.Tag == "!!int" {
+		x, err := strconv.ParseUint(contents.Value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid contents byte %q", contents.Value)
+		}
+		return []byte{byte(x)}, nil
+	}
+	return []byte(contents.Value), nil
+}
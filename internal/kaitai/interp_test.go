@@ -0,0 +1,129 @@
+package kaitai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	golden := []struct {
+		// seq attribute of the top-level type, with byte order le.
+		attr string
+		data []byte
+		want interface{}
+		// Substring of the expected error, if any.
+		err string
+	}{
+		{attr: "type: u2", data: []byte{0x01, 0x02}, want: uint64(0x0201)},
+		{attr: "type: u2be", data: []byte{0x01, 0x02}, want: uint64(0x0102)},
+		{attr: "type: s2", data: []byte{0xFE, 0xFF}, want: int64(-2)},
+		{attr: "type: s8", data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, want: int64(1<<63 - 1)},
+		{attr: "type: f4", data: []byte{0x00, 0x00, 0xC0, 0x3F}, want: 1.5},
+		{attr: "type: b1", data: []byte{0x80}, want: true},
+		{attr: "type: b3\n    enum: kind", data: []byte{0x20}, want: uint64(1)},
+		{attr: "type: u4", data: []byte{0x01, 0x02}, err: "unexpected end of data"},
+		{attr: "type: u4\n    size: 2", data: []byte{0x01, 0x02}, err: "size 2 too small for type u4"},
+		{attr: "type: u2\n    size: 4", data: []byte{0x01, 0x02, 0x03, 0x04}, want: uint64(0x0201)},
+		{attr: "type: b65", data: make([]byte, 9), err: "exceeds 64 bits"},
+		// Sizes.
+		{attr: "size: 2", data: []byte{0x01, 0x02, 0x03}, want: []byte{0x01, 0x02}},
+		{attr: "size: 0", data: []byte{0x01}, want: []byte{}},
+		{attr: "size: -1", data: []byte{0x01}, err: "unexpected end of data"},
+		{attr: "size: 9223372036854775807", data: []byte{0x01}, err: "unexpected end of data"},
+		{attr: "size: 4", data: []byte{0x01}, err: "unexpected end of data"},
+		{attr: "size-eos: true", data: []byte{0x01, 0x02}, want: []byte{0x01, 0x02}},
+		{attr: "size: 1 / 0", data: []byte{0x01}, err: "division by zero"},
+		{attr: "size: true", data: []byte{0x01}, err: "is not an integer"},
+		{attr: "type: str\n    encoding: ASCII", data: []byte("ab"), err: "missing size of string"},
+		{attr: "doc: no type", data: []byte{0x01}, err: "missing type and size"},
+		// Contents.
+		{attr: "contents: [0x4D, 0x5A]", data: []byte("MZ"), want: []byte("MZ")},
+		{attr: "contents: MZ", data: []byte("MZ"), want: []byte("MZ")},
+		{attr: "contents: MZ", data: []byte("ZM"), err: "invalid contents"},
+		{attr: "contents: MZ", data: []byte("M"), err: "unexpected end of data"},
+		// Terminators.
+		{attr: "type: strz\n    encoding: ASCII", data: []byte("ab\x00c"), want: "ab"},
+		{attr: "type: strz\n    encoding: ASCII", data: []byte("ab"), err: "terminator 0x00 not found"},
+		{attr: "type: strz\n    encoding: ASCII\n    eos-error: false", data: []byte("ab"), want: "ab"},
+		{attr: "type: str\n    encoding: ASCII\n    size: 4\n    terminator: 0", data: []byte("ab\x00c"), want: "ab"},
+		{attr: "terminator: 0x2C\n    include: true", data: []byte("ab,c"), want: []byte("ab,")},
+		{attr: "type: str\n    size: 4\n    encoding: UTF-16LE", data: []byte{'h', 0, 'i', 0}, want: "hi"},
+		// User types.
+		{attr: "type: missing", data: []byte{0x01}, err: "unknown type"},
+	}
+	for _, g := range golden {
+		ksy := "meta:\n  id: test\n  endian: le\nseq:\n  - id: x\n    " + g.attr + "\nenums:\n  kind:\n    1: kind_a\n"
+		spec, err := ParseSpec([]byte(ksy))
+		if err != nil {
+			t.Errorf("%q: unable to parse spec; %v", g.attr, err)
+			continue
+		}
+		v, err := spec.Parse(spec.DefaultType(), g.data)
+		if len(g.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%q: expected error containing %q, got %v", g.attr, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error; %v", g.attr, err)
+			continue
+		}
+		f := v.Field("x")
+		if f == nil {
+			t.Errorf("%q: attribute x not parsed", g.attr)
+			continue
+		}
+		if !reflect.DeepEqual(f.Val, g.want) {
+			t.Errorf("%q: expected %#v, got %#v", g.attr, g.want, f.Val)
+		}
+	}
+}
+
+func TestParseInstance(t *testing.T) {
+	golden := []struct {
+		// Instance of the top-level type, with byte order le.
+		inst string
+		data []byte
+		want interface{}
+		// Substring of the expected error, if any.
+		err string
+	}{
+		{inst: "pos: 1\n    type: u1", data: []byte{0x01, 0x02}, want: uint64(2)},
+		{inst: "pos: 2\n    size: 0", data: []byte{0x01, 0x02}, want: []byte{}},
+		{inst: "pos: 2\n    type: u1", data: []byte{0x01, 0x02}, err: "unexpected end of data"},
+		{inst: "pos: -1\n    type: u1", data: []byte{0x01, 0x02}, err: "invalid position -1"},
+		{inst: "pos: 9223372036854775807\n    type: u1", data: []byte{0x01, 0x02}, err: "invalid position"},
+		{inst: "pos: 1\n    size: 9223372036854775807", data: []byte{0x01, 0x02}, err: "unexpected end of data"},
+		{inst: "value: 7 / -2", data: nil, want: int64(-4)},
+		{inst: "value: 1 % 0", data: nil, err: "division by zero"},
+	}
+	for _, g := range golden {
+		ksy := "meta:\n  id: test\n  endian: le\ninstances:\n  x:\n    " + g.inst + "\n"
+		spec, err := ParseSpec([]byte(ksy))
+		if err != nil {
+			t.Errorf("%q: unable to parse spec; %v", g.inst, err)
+			continue
+		}
+		v, err := spec.Parse(spec.DefaultType(), g.data)
+		if len(g.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%q: expected error containing %q, got %v", g.inst, g.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error; %v", g.inst, err)
+			continue
+		}
+		f := v.Field("x")
+		if f == nil {
+			t.Errorf("%q: instance x not parsed", g.inst)
+			continue
+		}
+		if !reflect.DeepEqual(f.Val, g.want) {
+			t.Errorf("%q: expected %#v, got %#v", g.inst, g.want, f.Val)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package kaitai
+
+import (
+	"fmt"
+)
+
+// stream reads bytes and bit fields from binary data; either the binary file
+// or a substream of sized attributes.
+type stream struct {
+	data []byte
+	// Offset of the stream in the binary file.
+	base int
+	// Current byte offset.
+	pos int
+	// Partially read byte of bit fields, and the number of unread bits left.
+	cur   byte
+	nbits int
+}
+
+// offset returns the current offset in the binary file.
+func (s *stream) offset() int {
+	return s.base + s.pos
+}
+
+// eof reports whether the end of the stream is reached.
+func (s *stream) eof() bool {
+	return s.nbits == 0 && s.pos >= len(s.data)
+}
+
+// align discards the unread bits of a partially read byte of bit fields.
+func (s *stream) align() {
+	s.nbits = 0
+}
+
+// seek moves to the given byte offset of the stream.
+func (s *stream) seek(pos int64) error {
+	if pos < 0 || pos > int64(len(s.data)) {
+		return fmt.Errorf("invalid position %d; outside of data of %d bytes", pos, len(s.data))
+	}
+	s.pos, s.nbits = int(pos), 0
+	return nil
+}
+
+// read reads n bytes, after aligning to the next byte boundary.
+func (s *stream) read(n int) ([]byte, error) {
+	s.align()
+	if n < 0 || n > len(s.data)-s.pos {
+		return nil, fmt.Errorf("unexpected end of data at offset 0x%X; need %d bytes, have %d", s.offset(), n, len(s.data)-s.pos)
+	}
+	buf := s.data[s.pos : s.pos+n]
+	s.pos += n
+	return buf, nil
+}
+
+// readUntil reads bytes up to the given terminator, after aligning to the next
+// byte boundary. The terminator is included in the result if include is set,
+// and consumed if consume is set. If eosError is not set, the end of the
+// stream terminates the bytes as well.
+func (s *stream) readUntil(term byte, include, consume, eosError bool) ([]byte, error) {
+	s.align()
+	for i := s.pos; i < len(s.data); i++ {
+		if s.data[i] != term {
+			continue
+		}
+		end := i
+		if include {
+			end++
+		}
+		buf := s.data[s.pos:end]
+		s.pos = i
+		if consume {
+			s.pos++
+		}
+		return buf, nil
+	}
+	if eosError {
+		return nil, fmt.Errorf("terminator 0x%02X not found before end of data at offset 0x%X", term, s.base+len(s.data))
+	}
+	buf := s.data[s.pos:]
+	s.pos = len(s.data)
+	return buf, nil
+}
+
+// readBits reads an n-bit unsigned integer, using the given bit endianness.
+func (s *stream) readBits(n int, bitEndian string) (uint64, error) {
+	var x uint64
+	for i := 0; i < n; i++ {
+		if s.nbits == 0 {
+			if s.pos >= len(s.data) {
+				return 0, fmt.Errorf("unexpected end of data at offset 0x%X", s.offset())
+			}
+			s.cur = s.data[s.pos]
+			s.pos++
+			s.nbits = 8
+		}
+		var bit uint64
+		if bitEndian == "le" {
+			// Least significant bit first.
+			bit = uint64(s.cur>>uint(8-s.nbits)) & 1
+			x |= bit << uint(i)
+		} else {
+			// Most significant bit first.
+			bit = uint64(s.cur>>uint(s.nbits-1)) & 1
+			x = x<<1 | bit
+		}
+		s.nbits--
+	}
+	return x, nil
+}
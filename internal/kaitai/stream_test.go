@@ -0,0 +1,147 @@
+package kaitai
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestStreamRead(t *testing.T) {
+	golden := []struct {
+		data []byte
+		// Bytes read before n.
+		skip int
+		n    int
+		want []byte
+		err  bool
+	}{
+		{data: []byte{1, 2, 3}, n: 2, want: []byte{1, 2}},
+		{data: []byte{1, 2, 3}, skip: 1, n: 2, want: []byte{2, 3}},
+		{data: []byte{1, 2, 3}, n: 0, want: []byte{}},
+		{data: []byte{1, 2, 3}, skip: 3, n: 0, want: []byte{}},
+		{data: []byte{1, 2, 3}, n: 4, err: true},
+		{data: []byte{1, 2, 3}, skip: 2, n: 2, err: true},
+		{data: []byte{1, 2, 3}, n: -1, err: true},
+		// Overflow of the end offset.
+		{data: []byte{1, 2, 3}, skip: 1, n: math.MaxInt, err: true},
+	}
+	for _, g := range golden {
+		s := &stream{data: g.data}
+		if _, err := s.read(g.skip); err != nil {
+			t.Errorf("%v: unable to skip %d bytes; %v", g.data, g.skip, err)
+			continue
+		}
+		got, err := s.read(g.n)
+		if g.err {
+			if err == nil {
+				t.Errorf("%v: read(%d) at offset %d: expected error, got %v", g.data, g.n, g.skip, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: read(%d) at offset %d: unexpected error; %v", g.data, g.n, g.skip, err)
+			continue
+		}
+		if !bytes.Equal(got, g.want) {
+			t.Errorf("%v: read(%d) at offset %d: expected %v, got %v", g.data, g.n, g.skip, g.want, got)
+		}
+	}
+}
+
+func TestStreamSeek(t *testing.T) {
+	golden := []struct {
+		pos  int64
+		want byte
+		err  bool
+	}{
+		{pos: 0, want: 1},
+		{pos: 2, want: 3},
+		{pos: 3, err: true}, // end of data; nothing left to read
+		{pos: 4, err: true},
+		{pos: -1, err: true},
+		{pos: math.MaxInt64, err: true},
+		{pos: math.MinInt64, err: true},
+	}
+	for _, g := range golden {
+		s := &stream{data: []byte{1, 2, 3}}
+		var buf []byte
+		err := s.seek(g.pos)
+		if err == nil {
+			buf, err = s.read(1)
+		}
+		if g.err {
+			if err == nil {
+				t.Errorf("seek(%d): expected error, got %v", g.pos, buf)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("seek(%d): unexpected error; %v", g.pos, err)
+			continue
+		}
+		if buf[0] != g.want {
+			t.Errorf("seek(%d): expected 0x%02X, got 0x%02X", g.pos, g.want, buf[0])
+		}
+	}
+}
+
+func TestStreamReadUntil(t *testing.T) {
+	golden := []struct {
+		include, consume, eosError bool
+		data                       []byte
+		want                       []byte
+		// Offset after reading.
+		pos int
+		err bool
+	}{
+		{data: []byte("ab\x00c"), want: []byte("ab"), pos: 2},
+		{data: []byte("ab\x00c"), consume: true, want: []byte("ab"), pos: 3},
+		{data: []byte("ab\x00c"), include: true, consume: true, want: []byte("ab\x00"), pos: 3},
+		{data: []byte("abc"), want: []byte("abc"), pos: 3},
+		{data: []byte("abc"), eosError: true, err: true},
+	}
+	for _, g := range golden {
+		s := &stream{data: g.data}
+		got, err := s.readUntil(0, g.include, g.consume, g.eosError)
+		if g.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", g.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error; %v", g.data, err)
+			continue
+		}
+		if !bytes.Equal(got, g.want) || s.pos != g.pos {
+			t.Errorf("%q: expected %q at offset %d, got %q at offset %d", g.data, g.want, g.pos, got, s.pos)
+		}
+	}
+}
+
+func TestStreamReadBits(t *testing.T) {
+	golden := []struct {
+		bitEndian string
+		widths    []int
+		want      []uint64
+	}{
+		{bitEndian: "be", widths: []int{1, 3, 4, 8}, want: []uint64{1, 0b010, 0b1100, 0xFF}},
+		{bitEndian: "le", widths: []int{1, 3, 4, 8}, want: []uint64{0, 0b110, 0b1010, 0xFF}},
+	}
+	for _, g := range golden {
+		s := &stream{data: []byte{0b1010_1100, 0xFF}}
+		for i, n := range g.widths {
+			got, err := s.readBits(n, g.bitEndian)
+			if err != nil {
+				t.Errorf("%s: bit field %d: unexpected error; %v", g.bitEndian, i, err)
+				break
+			}
+			if got != g.want[i] {
+				t.Errorf("%s: bit field %d: expected %b, got %b", g.bitEndian, i, g.want[i], got)
+			}
+		}
+		if _, err := s.readBits(1, g.bitEndian); err == nil {
+			t.Errorf("%s: expected error reading past end of data", g.bitEndian)
+		}
+	}
+}
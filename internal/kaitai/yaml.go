@@ -0,0 +1,95 @@
+package kaitai
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Walk calls typeFn for the given type spec at the given path (empty for the
+// top-level type) and its nested user types, and enumFn for the enums of the
+// types, in order of declaration; the types of a type spec follow the type,
+// and its enums follow its types. Walk stops at the first error of typeFn.
+func Walk(node *yaml.Node, path string, typeFn func(path string, node *yaml.Node) error, enumFn func(path string, values *yaml.Node)) error {
+	if err := typeFn(path, node); err != nil {
+		return err
+	}
+	var err error
+	ForPairs(Lookup(node, "types"), func(name, value *yaml.Node) {
+		if err == nil && value.Kind == yaml.MappingNode {
+			err = Walk(value, JoinPath(path, name.Value), typeFn, enumFn)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	ForPairs(Lookup(node, "enums"), func(name, values *yaml.Node) {
+		enumFn(JoinPath(path, name.Value), values)
+	})
+	return nil
+}
+
+// ResolvePath returns the path of the type or enum of the given name referenced
+// from the given scope, for which found reports true, and a boolean indicating
+// whether such a path was found. As in Kaitai, names are resolved in the scope,
+// and then in the enclosing scopes.
+func ResolvePath(scope, name string, found func(path string) bool) (string, bool) {
+	for ; ; scope = ParentPath(scope) {
+		if path := JoinPath(scope, name); found(path) {
+			return path, true
+		}
+		if len(scope) == 0 {
+			return "", false
+		}
+	}
+}
+
+// JoinPath returns the path of the given name in the given scope; e.g.
+// header::entry.
+func JoinPath(scope, name string) string {
+	if len(scope) == 0 {
+		return name
+	}
+	return scope + "::" + name
+}
+
+// ParentPath returns the enclosing scope of the given path.
+func ParentPath(path string) string {
+	if pos := strings.LastIndex(path, "::"); pos != -1 {
+		return path[:pos]
+	}
+	return ""
+}
+
+// ForPairs calls f for the key-value pairs of the given mapping, if any.
+func ForPairs(m *yaml.Node, f func(key, value *yaml.Node)) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		f(m.Content[i], m.Content[i+1])
+	}
+}
+
+// Lookup returns the value of the given key of the given YAML mapping, or nil
+// if not present.
+func Lookup(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// LookupValue returns the scalar value of the given key of the given YAML
+// mapping, or an empty string if not present.
+func LookupValue(m *yaml.Node, key string) string {
+	if v := Lookup(m, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
@@ -0,0 +1,408 @@
+// Package layout computes the binary layout of Go types, as serialized by the
+// parsers and writers generated by type2kaitai.
+//
+// Fields are laid out in order (or in the order given by Layout.Order) without
+// padding, using the type sizes of the target architecture. Multi-byte integers
+// and floats use the byte order of the binary format, unless overridden by the
+// endian option of the kaitai struct tag of the field (see package structtag),
+// which applies to the fields of struct-typed fields in turn, unless given an
+// endian option of their own. Fields located by the offset-to option of another
+// field are not part of the layout, and the size of fields parsed from
+// substreams (the size option) and of variable-length integers (the varint
+// option), the presence of conditional fields (the if option) and the type of
+// fields selected by value (the switch-on option) is not known.
+package layout
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/mewrev/tools/internal/structtag"
+)
+
+// Kind specifies the kind of a field.
+type Kind uint8
+
+// Field kinds.
+const (
+	// Magic contents.
+	Contents Kind = iota + 1
+	// Boolean stored as a single byte.
+	Bool
+	// Signed integer.
+	Int
+	// Unsigned integer.
+	Uint
+	// IEEE 754 floating-point number.
+	Float
+	// Fixed-size byte array.
+	Bytes
+	// Fixed-size string.
+	Str
+	// Fixed-size null-terminated string.
+	Strz
+	// Address of the target architecture.
+	Pointer
+	// Blank field, stored as zero bytes.
+	Blank
+)
+
+// Field is a scalar field of the binary layout of a Go type.
+type Field struct {
+	// Field path; e.g. Header.Entries[1].Offset.
+	Path string
+	// Offset in bytes from the start of the layout.
+	Offset int64
+	// Size in bytes.
+	Size int64
+	// Field kind.
+	Kind Kind
+	// Go type of the field.
+	Type types.Type
+	// Struct field of scalar fields stored directly in a struct (rather than
+	// e.g. as array elements); nil otherwise.
+	Var *types.Var
+	// Byte order of multi-byte integers and floats; either "le" or "be".
+	Endian string
+	// Magic contents of Contents fields.
+	Contents []byte
+	// Character encoding of Str and Strz fields; e.g. UTF-8 or UTF-16LE.
+	Encoding string
+	// Algorithm of the checksum stored in Uint fields tagged with the checksum
+	// option; e.g. crc32.
+	Checksum string
+	// Field paths of the region of the checksum.
+	Region []string
+}
+
+// Layout computes the binary layout of Go types.
+type Layout struct {
+	// Type sizes of the target architecture.
+	Sizes types.Sizes
+	// Byte order of the binary format; either "le" or "be".
+	Endian string
+	// Exclude reports whether the given struct field is omitted from the
+	// binary format; may be nil.
+	Exclude func(field *types.Var) bool
+	// Tag returns the kaitai options of the i-th field of the given struct
+	// type (e.g. including comment directives); may be nil, in which case the
+	// options of the struct tag of the field are used.
+	Tag func(st *types.Struct, i int) structtag.Tag
+	// Order returns the indices of the fields of the given struct type in the
+	// order of the binary format (e.g. as given by the order directive of the
+	// type); may be nil, and may return nil, in which case fields are laid out
+	// in declaration order.
+	Order func(st *types.Struct) []int
+	// Substitute returns the type laid out in place of the given type (e.g.
+	// an integer type in place of a wrapper type); may be nil.
+	Substitute func(t types.Type) types.Type
+	// Len returns the number of elements of the given slice field (e.g. as
+	// stored in the length field of the slice); may be nil, and may return
+	// false, in which case the length of the slice is not known.
+	Len func(field *types.Var) (int64, bool)
+}
+
+// Fields returns the scalar fields of the binary layout of the given type, in
+// order, where name is the field path prefix. Arrays and structs are expanded
+// into their elements and fields, and complex numbers into their real and
+// imaginary parts.
+//
+// If the layout cannot be fully computed (e.g. a slice not sized by Layout.Len
+// is encountered), the fields preceding the offending field are returned along
+// with an error.
+func (l *Layout) Fields(t types.Type, name string) ([]*Field, error) {
+	w := &walker{l: l}
+	err := w.walk(t, name, l.Endian)
+	return w.fields, err
+}
+
+// Size returns the size in bytes of the binary layout of the given type, and a
+// boolean indicating whether the type is fixed-size.
+func (l *Layout) Size(t types.Type) (int64, bool) {
+	fields, err := l.Fields(t, "")
+	if err != nil {
+		return 0, false
+	}
+	total := int64(0)
+	for _, f := range fields {
+		total += f.Size
+	}
+	return total, true
+}
+
+// walker accumulates the fields of a layout.
+type walker struct {
+	l      *Layout
+	fields []*Field
+	// Offset of the next field.
+	offset int64
+}
+
+// add appends a field of the given kind and size to the layout.
+func (w *walker) add(path string, kind Kind, t types.Type, size int64, endian string) *Field {
+	f := &Field{
+		Path:   path,
+		Offset: w.offset,
+		Size:   size,
+		Kind:   kind,
+		Type:   t,
+		Endian: endian,
+	}
+	w.fields = append(w.fields, f)
+	w.offset += size
+	return f
+}
+
+// walk adds the fields of the given type to the layout, using the given byte
+// order.
+func (w *walker) walk(t types.Type, path string, endian string) error {
+	if w.l.Substitute != nil {
+		t = w.l.Substitute(t)
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		return w.walkStruct(u, path, endian)
+	case *types.Array:
+		if isByte(u.Elem()) {
+			w.add(path, Bytes, t, u.Len(), endian)
+			return nil
+		}
+		for i := int64(0); i < u.Len(); i++ {
+			if err := w.walk(u.Elem(), fmt.Sprintf("%s[%d]", path, i), endian); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *types.Pointer:
+		w.add(path, Pointer, t, w.l.Sizes.Sizeof(types.Typ[types.Uintptr]), endian)
+		return nil
+	case *types.Basic:
+		switch {
+		case u.Kind() == types.Bool:
+			w.add(path, Bool, t, 1, endian)
+			return nil
+		case u.Info()&types.IsInteger != 0:
+			kind := Int
+			if u.Info()&types.IsUnsigned != 0 {
+				kind = Uint
+			}
+			w.add(path, kind, t, w.l.Sizes.Sizeof(u), endian)
+			return nil
+		case u.Info()&types.IsFloat != 0:
+			w.add(path, Float, t, w.l.Sizes.Sizeof(u), endian)
+			return nil
+		case u.Info()&types.IsComplex != 0:
+			part := types.Typ[types.Float32]
+			if u.Kind() == types.Complex128 {
+				part = types.Typ[types.Float64]
+			}
+			size := w.l.Sizes.Sizeof(part)
+			w.add(path+".real", Float, part, size, endian)
+			w.add(path+".imag", Float, part, size, endian)
+			return nil
+		}
+	case *types.Slice:
+		return fmt.Errorf("%s: slice length not known", path)
+	}
+	return fmt.Errorf("%s: support for type %s not yet implemented", path, types.TypeString(t, skipQualifier))
+}
+
+// walkStruct adds the fields of the given struct type to the layout, using the
+// given byte order for fields without an endian option of their own; i.e. the
+// byte order of a struct field applies to the fields of its struct type.
+func (w *walker) walkStruct(st *types.Struct, path string, endian string) error {
+	tagOf := func(i int) structtag.Tag {
+		if w.l.Tag != nil {
+			return w.l.Tag(st, i)
+		}
+		return structtag.Parse(st.Tag(i))
+	}
+	targets := structtag.OffsetTargetsFunc(st, tagOf)
+	var order []int
+	if w.l.Order != nil {
+		order = w.l.Order(st)
+	}
+	for pos := 0; pos < st.NumFields(); pos++ {
+		i := pos
+		if order != nil {
+			i = order[pos]
+		}
+		field := st.Field(i)
+		if w.l.Exclude != nil && w.l.Exclude(field) {
+			continue
+		}
+		if _, ok := targets[field]; ok {
+			// Located at offset, rather than inline.
+			continue
+		}
+		fieldPath := path + "." + field.Name()
+		if len(path) == 0 {
+			fieldPath = field.Name()
+		}
+		tag := tagOf(i)
+		if tag.Has("size") {
+			return fmt.Errorf("%s: size of substream not known", fieldPath)
+		}
+		if tag.Has("if") {
+			return fmt.Errorf("%s: presence of conditional field not known", fieldPath)
+		}
+		if tag.Has("switch-on") {
+			return fmt.Errorf("%s: type of field not known", fieldPath)
+		}
+		if tag.Has("varint") || tag.Has("vlq") {
+			return fmt.Errorf("%s: size of variable-length integer not known", fieldPath)
+		}
+		contents, ok, err := tag.Contents()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fieldPath, err)
+		}
+		if ok {
+			f := w.add(fieldPath, Contents, field.Type(), int64(len(contents)), w.l.Endian)
+			f.Contents = contents
+			continue
+		}
+		if tag.Has("str") || tag.Has("strz") {
+			arr, ok := field.Type().Underlying().(*types.Array)
+			unitSize := int64(0)
+			if ok {
+				unitSize = codeUnitSize(arr.Elem())
+			}
+			if unitSize == 0 {
+				return fmt.Errorf("%s: str and strz options only valid for arrays of bytes, uint16 or uint32", fieldPath)
+			}
+			kind := Str
+			if tag.Has("strz") {
+				kind = Strz
+			}
+			fieldEndian, ok, err := tag.Endian()
+			if err != nil {
+				return fmt.Errorf("%s: %v", fieldPath, err)
+			}
+			if !ok {
+				fieldEndian = endian
+			}
+			encoding, _, err := tag.Encoding()
+			if err != nil {
+				return fmt.Errorf("%s: %v", fieldPath, err)
+			}
+			encoding, err = structtag.StrEncoding(encoding, unitSize, fieldEndian)
+			if err != nil {
+				return fmt.Errorf("%s: %v", fieldPath, err)
+			}
+			f := w.add(fieldPath, kind, field.Type(), arr.Len()*unitSize, fieldEndian)
+			f.Encoding = encoding
+			continue
+		}
+		size, _, ok, err := tag.BigInt()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fieldPath, err)
+		}
+		if ok {
+			// Arbitrary-precision integer stored as a byte array.
+			w.add(fieldPath, Bytes, field.Type(), size, w.l.Endian)
+			continue
+		}
+		fieldEndian, ok, err := tag.Endian()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fieldPath, err)
+		}
+		if !ok {
+			fieldEndian = endian
+		}
+		if field.Name() == "_" {
+			size, ok := w.l.Size(field.Type())
+			if !ok {
+				return fmt.Errorf("%s: size of blank field not known", fieldPath)
+			}
+			w.add(fieldPath, Blank, field.Type(), size, fieldEndian)
+			continue
+		}
+		if err := w.walkField(field, fieldPath, fieldEndian); err != nil {
+			return err
+		}
+		algo, region, ok, err := tag.Checksum()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fieldPath, err)
+		}
+		if ok {
+			fields, err := structtag.ChecksumFields(st, order, i, region)
+			if err != nil {
+				return fmt.Errorf("%s: %v", fieldPath, err)
+			}
+			n := len(w.fields)
+			if n == 0 || w.fields[n-1].Path != fieldPath || w.fields[n-1].Kind != Uint {
+				return fmt.Errorf("%s: checksum option only valid for unsigned integers", fieldPath)
+			}
+			f := w.fields[n-1]
+			f.Checksum = algo
+			for _, j := range fields {
+				name := st.Field(j).Name()
+				if len(path) > 0 {
+					name = path + "." + name
+				}
+				f.Region = append(f.Region, name)
+			}
+		}
+	}
+	return nil
+}
+
+// walkField adds the given struct field to the layout, using the given byte
+// order.
+func (w *walker) walkField(field *types.Var, path string, endian string) error {
+	t := field.Type()
+	if w.l.Substitute != nil {
+		t = w.l.Substitute(t)
+	}
+	n := len(w.fields)
+	if slice, ok := t.Underlying().(*types.Slice); ok && w.l.Len != nil {
+		if length, ok := w.l.Len(field); ok {
+			if isByte(slice.Elem()) {
+				w.add(path, Bytes, t, length, endian)
+				return nil
+			}
+			for i := int64(0); i < length; i++ {
+				if err := w.walk(slice.Elem(), fmt.Sprintf("%s[%d]", path, i), endian); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if err := w.walk(t, path, endian); err != nil {
+		return err
+	}
+	if len(w.fields) == n+1 && w.fields[n].Path == path {
+		w.fields[n].Var = field
+	}
+	return nil
+}
+
+// isByte reports whether the given type is byte or uint8.
+func isByte(t types.Type) bool {
+	basic, ok := t.Underlying().(*types.Basic)
+	return ok && basic.Kind() == types.Uint8
+}
+
+// codeUnitSize returns the size in bytes of the given type as code unit of
+// fixed-size strings (bytes, and uint16 and uint32 of UTF-16 and UTF-32), or 0
+// if not a code unit type.
+func codeUnitSize(t types.Type) int64 {
+	basic, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch basic.Kind() {
+	case types.Uint8:
+		return 1
+	case types.Uint16:
+		return 2
+	case types.Uint32:
+		return 4
+	}
+	return 0
+}
+
+func skipQualifier(pkg *types.Package) string {
+	return ""
+}
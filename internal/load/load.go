@@ -0,0 +1,122 @@
+// Package load loads the type-checked Go packages processed by the tools, for
+// a given target architecture.
+package load
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Packages loads the packages constructed from the patterns and build tags,
+// using the source files of the given target architecture.
+func Packages(patterns, tags []string, arch string) ([]*packages.Package, error) {
+	return Load(&Config{Tags: tags, Arch: arch}, patterns...)
+}
+
+// Config specifies how packages are loaded by the go command. The GOFLAGS of
+// the environment apply (e.g. GOFLAGS=-mod=vendor), unless overridden by the
+// configuration; as do module workspaces (go.work) of the directory.
+type Config struct {
+	// Build tags.
+	Tags []string
+	// Target architecture (GOARCH), which selects the source files of the
+	// packages.
+	Arch string
+	// Directory in which to run the go command, and to which relative patterns
+	// are resolved; e.g. the root of a module or workspace in a monorepo. The
+	// current directory if empty.
+	Dir string
+	// Module download mode (-mod flag of the go command); readonly, vendor or
+	// mod. The default of the go command if empty; i.e. vendor if the main
+	// module has a vendor directory.
+	Mod string
+	// GOPATH of the go command; the GOPATH of the environment if empty.
+	GOPATH string
+	// Workspace file of the go command (GOWORK); the go.work file of the
+	// directory or its parents if empty, or off to disable workspaces.
+	GOWork string
+}
+
+// PackagesConfig returns the configuration of packages.Load of the given load
+// mode.
+func (c *Config) PackagesConfig(mode packages.LoadMode) *packages.Config {
+	buildFlags := []string{fmt.Sprintf("-tags=%s", strings.Join(c.Tags, " "))}
+	if len(c.Mod) > 0 {
+		buildFlags = append(buildFlags, "-mod="+c.Mod)
+	}
+	env := os.Environ()
+	if len(c.Arch) > 0 {
+		// Select the source files of the target architecture.
+		env = append(env, "GOARCH="+c.Arch)
+		if c.Arch == "wasm" && os.Getenv("GOOS") != "wasip1" {
+			// wasm is only supported by the js and wasip1 ports.
+			env = append(env, "GOOS=js")
+		}
+	}
+	if len(c.GOPATH) > 0 {
+		env = append(env, "GOPATH="+c.GOPATH)
+	}
+	if len(c.GOWork) > 0 {
+		env = append(env, "GOWORK="+c.GOWork)
+	}
+	return &packages.Config{
+		Mode:       mode,
+		Dir:        c.Dir,
+		BuildFlags: buildFlags,
+		Env:        env,
+	}
+}
+
+// Load loads the packages constructed from the patterns, as configured. The
+// dependencies of the packages are type-checked from source, as their export
+// data is not available to the go command in all configurations (e.g. in
+// module workspaces).
+func Load(c *Config, patterns ...string) ([]*packages.Package, error) {
+	return packages.Load(c.PackagesConfig(packages.LoadSyntax|packages.NeedDeps), patterns...)
+}
+
+// Type returns the named type with the given type name, as defined at the
+// top-level of the given package, or nil if the type is not defined.
+func Type(pkg *packages.Package, typeName string) *types.Named {
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec, ok := spec.(*ast.TypeSpec)
+				if !ok || spec.Name.Name != typeName {
+					continue
+				}
+				if named, ok := pkg.TypesInfo.Defs[spec.Name].Type().(*types.Named); ok {
+					return named
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// PackageType loads the single package constructed from the patterns and build
+// tags, using the source files of the given target architecture, and returns
+// the package and the named type with the given type name.
+func PackageType(patterns, tags []string, arch, typeName string) (*packages.Package, *types.Named, error) {
+	pkgs, err := Packages(patterns, tags, arch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, nil, fmt.Errorf("%d packages found", len(pkgs))
+	}
+	t := Type(pkgs[0], typeName)
+	if t == nil {
+		return nil, nil, fmt.Errorf("unable to locate type definition of type name %q", typeName)
+	}
+	return pkgs[0], t, nil
+}
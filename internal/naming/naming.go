@@ -0,0 +1,108 @@
+// Package naming converts Go identifiers to the naming conventions of
+// generated specs.
+//
+// Identifiers are split into words at lower-to-upper case transitions and
+// underscores, keeping initialisms together; e.g. "HTTPHeader" is split into
+// "HTTP" and "Header", "UserID" into "User" and "ID", and "IDs" is kept as a
+// single word.
+package naming
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Words returns the words of the given Go identifier.
+func Words(s string) []string {
+	var words []string
+	rs := []rune(s)
+	start := 0
+	for i, r := range rs {
+		if r == '_' {
+			if i > start {
+				words = append(words, string(rs[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r) {
+			continue
+		}
+		prev := rs[i-1]
+		switch {
+		case unicode.IsLower(prev) || unicode.IsDigit(prev):
+			// e.g. the H in "userHeader".
+		case unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1]) && !isPlural(rs, i+1):
+			// Last upper case letter of an initialism followed by a lower case
+			// letter; e.g. the H in "HTTPHeader".
+		default:
+			continue
+		}
+		words = append(words, string(rs[start:i]))
+		start = i
+	}
+	if start < len(rs) {
+		words = append(words, string(rs[start:]))
+	}
+	return words
+}
+
+// isPlural reports whether rs[i:] starts with a plural suffix "s" of an
+// initialism; e.g. the s in "IDs" or "IDsByName".
+func isPlural(rs []rune, i int) bool {
+	if rs[i] != 's' {
+		return false
+	}
+	return i+1 == len(rs) || !unicode.IsLower(rs[i+1])
+}
+
+// Snake returns the snake_case version of the given Go identifier; e.g.
+// "HTTPHeader" becomes "http_header".
+func Snake(s string) string {
+	words := Words(s)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, "_")
+}
+
+// LowerCamel returns the lowerCamelCase version of the given Go identifier.
+// Leading initialisms are lowercased in full (e.g. "IDCount" becomes
+// "idCount"), other initialisms are kept as is (e.g. "UserID" becomes
+// "userID"), and underscores are removed.
+func LowerCamel(s string) string {
+	words := Words(s)
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word)
+			continue
+		}
+		rs := []rune(word)
+		rs[0] = unicode.ToUpper(rs[0])
+		words[i] = string(rs)
+	}
+	return strings.Join(words, "")
+}
+
+// Naming strategies of identifiers in generated specs.
+const (
+	// snake_case; e.g. HTTPHeader becomes http_header.
+	StrategySnake = "snake"
+	// Go identifiers as is.
+	StrategyKeep = "keep"
+	// lowerCamelCase; e.g. HTTPHeader becomes httpHeader.
+	StrategyCamel = "camel"
+)
+
+// Ident returns the identifier of the given Go identifier in generated specs,
+// based on the given naming strategy; snake_case if unknown.
+func Ident(strategy, name string) string {
+	switch strategy {
+	case StrategyKeep:
+		return name
+	case StrategyCamel:
+		return LowerCamel(name)
+	default:
+		return Snake(name)
+	}
+}
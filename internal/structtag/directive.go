@@ -0,0 +1,55 @@
+package structtag
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// DirectivePrefix is the prefix of the comment directives specifying kaitai
+// options, as an alternative to struct tags (e.g. for fields whose tags are
+// owned by other encoders). Each directive specifies one option, with an
+// optional value separated by whitespace, e.g.
+//
+//	//kaitai:endian be
+//	//kaitai:size Len
+//	//kaitai:strz
+const DirectivePrefix = "//kaitai:"
+
+// ParseDirectives parses the kaitai options of the comment directives of the
+// given comment groups. Groups may be nil.
+func ParseDirectives(groups ...*ast.CommentGroup) Tag {
+	opts := make(Tag)
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, c := range group.List {
+			if !strings.HasPrefix(c.Text, DirectivePrefix) {
+				continue
+			}
+			directive := strings.TrimPrefix(c.Text, DirectivePrefix)
+			key, value := directive, ""
+			if pos := strings.IndexAny(directive, " \t"); pos != -1 {
+				key, value = directive[:pos], strings.TrimSpace(directive[pos+1:])
+			}
+			if len(key) == 0 {
+				continue
+			}
+			opts[key] = value
+		}
+	}
+	return opts
+}
+
+// Merge returns the options of the tag, and the options of other not present
+// in the tag.
+func (tag Tag) Merge(other Tag) Tag {
+	opts := make(Tag)
+	for key, value := range other {
+		opts[key] = value
+	}
+	for key, value := range tag {
+		opts[key] = value
+	}
+	return opts
+}
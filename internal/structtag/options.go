@@ -0,0 +1,222 @@
+package structtag
+
+// Option describes a kaitai option of struct tags and comment directives.
+type Option struct {
+	// Key of the option.
+	Key string
+	// Example usage of the option; e.g. size=Len.
+	Usage string
+	// Description of the option.
+	Doc string
+	// Option given on fields; by struct tag or comment directive.
+	Field bool
+	// Option given on type declarations; by comment directive.
+	Type bool
+	// Option only given by comment directive; e.g. //kaitai:skip.
+	DirectiveOnly bool
+}
+
+// Options maps from keys to the kaitai options supported by type2kaitai.
+var Options = map[string]*Option{
+	"args": {
+		Key:   "args",
+		Usage: "args=Version;Count",
+		Doc:   "Arguments passed to the parameters of the struct type of the field (see //kaitai:param), separated by semicolons; names of fields or Kaitai expressions.",
+		Field: true,
+	},
+	"bigint": {
+		Key:   "bigint",
+		Usage: "bigint=32",
+		Doc:   "Size in bytes of an arbitrary-precision integer field (big.Int or *big.Int), stored as a byte array; big-endian unless given the endian option.",
+		Field: true,
+	},
+	"bigint-sign": {
+		Key:   "bigint-sign",
+		Usage: "bigint=32,bigint-sign=twos-complement",
+		Doc:   "Sign convention of the bigint option; unsigned (the default), twos-complement, or sign-magnitude with the sign in the most significant bit.",
+		Field: true,
+	},
+	"bit-endian": {
+		Key:           "bit-endian",
+		Usage:         "//kaitai:bit-endian le",
+		Doc:           "Bit order of the bit field sub-type of a flag-style enum type (-flags-as-bits); le (least significant bit first) or be (most significant bit first). Default the bit order of -bit-endian, or the byte order of the binary format.",
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"cases": {
+		Key:   "cases",
+		Usage: "cases=1:BodyV1;2:BodyV2;_:BodyV2",
+		Doc:   "Cases of the switch-on option; semicolon-separated value:Type pairs, where the value is an integer literal, a constant name, or _ for the default case.",
+		Field: true,
+	},
+	"checksum": {
+		Key:   "checksum",
+		Usage: "checksum=crc32,region=Header",
+		Doc:   "Checksum of the bytes of the region of the field; crc32, crc32c, adler32 (uint32 fields) or crc64 (uint64 fields). Verified when parsing and computed when writing by the generated Go code.",
+		Field: true,
+	},
+	"consume": {
+		Key:   "consume",
+		Usage: "terminator=0x0a,consume=false",
+		Doc:   "Consume the terminator of a strz or terminator field from the stream; true (the default) or false, leaving the terminator to the next field.",
+		Field: true,
+	},
+	"contents": {
+		Key:   "contents",
+		Usage: "contents=0x7f454c46",
+		Doc:   "Magic contents of a byte array field; a 0x-prefixed hexadecimal byte string or a plain ASCII string.",
+		Field: true,
+	},
+	"encoding": {
+		Key:   "encoding",
+		Usage: "str,encoding=UTF-16LE",
+		Doc:   "Character encoding of a string field (str and strz byte arrays, arrays of uint16 or uint32 code units, and strings); e.g. UTF-16LE, Shift_JIS or windows-1252. UTF-16 and UTF-32 without byte order use the byte order of the field. Default UTF-8, or UTF-16 and UTF-32 for arrays of uint16 and uint32.",
+		Field: true,
+	},
+	"endian": {
+		Key:   "endian",
+		Usage: "endian=be",
+		Doc:   "Byte order of the field (or of the fields of the type, as directive on the type declaration); le or be.",
+		Field: true,
+		Type:  true,
+	},
+	"eos-error": {
+		Key:   "eos-error",
+		Usage: "strz,eos-error=false",
+		Doc:   "Fail if the end of the stream is reached before the terminator of a strz or terminator field; true (the default) or false, accepting unterminated values.",
+		Field: true,
+	},
+	"expr": {
+		Key:   "expr",
+		Usage: "repeat=until,expr=_.type == 0",
+		Doc:   "Expression of the repeat option; the Kaitai expression terminating repeat=until (or a method of the element type, e.g. IsLast(), translated to a Kaitai expression), or the field, parameter or integer literal of the number of elements of repeat=expr.",
+		Field: true,
+	},
+	"id": {
+		Key:           "id",
+		Usage:         "//kaitai:id legacy_name",
+		Doc:           "Identifier of the field in the generated output, overriding the naming strategy unless renamed by -rename; e.g. to keep an identifier that existing Kaitai consumers depend on.",
+		Field:         true,
+		DirectiveOnly: true,
+	},
+	"if": {
+		Key:   "if",
+		Usage: "if=version >= 2",
+		Doc:   "Kaitai expression of the condition under which the field is present, or a method of the struct type (e.g. HasBody()) translated to a Kaitai expression.",
+		Field: true,
+	},
+	"include": {
+		Key:   "include",
+		Usage: "strz,include",
+		Doc:   "Include the terminator of a strz or terminator field in the value; true if given without value.",
+		Field: true,
+	},
+	"offset-to": {
+		Key:   "offset-to",
+		Usage: "offset-to=Body,whence=start",
+		Doc:   "Field or type located at the offset stored in the integer field, rather than inline.",
+		Field: true,
+	},
+	"order": {
+		Key:           "order",
+		Usage:         "//kaitai:order Kind,Len,Flags",
+		Doc:           "Order of the fields of the struct type in the binary format, where it differs from the order of the Go struct type; comma-separated field names, followed by the fields not listed in declaration order.",
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"param": {
+		Key:           "param",
+		Usage:         "//kaitai:param version u2",
+		Doc:           "Parameter of the Kaitai type of the struct type; identifier and Kaitai type. Parameters may be referenced by the if, size and repeat options of the fields.",
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"process": {
+		Key:   "process",
+		Usage: "process=zlib",
+		Doc:   "Kaitai process routine applied to the bytes of the field before parsing; zlib, xor(key), rol(n) or ror(n).",
+		Field: true,
+	},
+	"region": {
+		Key:   "region",
+		Usage: "checksum=crc32,region=Magic..Size",
+		Doc:   "Region of the checksum option; a field name, or a range First..Last of fields of the struct type. Default the fields preceding the checksum field.",
+		Field: true,
+	},
+	"repeat": {
+		Key:   "repeat",
+		Usage: "repeat=eos",
+		Doc:   "Repetition of the elements of a slice field; until (the expr option is true), eos (end of stream) or expr (number of elements of the expr option).",
+		Field: true,
+	},
+	"size": {
+		Key:   "size",
+		Usage: "size=Len",
+		Doc:   "Size in bytes of the substream of the field; the name of a field or parameter storing the size, or an integer literal.",
+		Field: true,
+	},
+	"skip": {
+		Key:           "skip",
+		Usage:         "//kaitai:skip",
+		Doc:           "Omit the field (or type, and fields of the type) from the generated output.",
+		Field:         true,
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"spec": {
+		Key:           "spec",
+		Usage:         "//kaitai:spec header_type.ksy#header",
+		Doc:           "Kaitai spec of the struct type, relative to the directory of the source file, and optional identifier of the Kaitai type; checked for drift by kaitaivet.",
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"str": {
+		Key:   "str",
+		Usage: "str",
+		Doc:   "Byte array field parsed as a fixed-size UTF-8 string.",
+		Field: true,
+	},
+	"strz": {
+		Key:   "strz",
+		Usage: "strz",
+		Doc:   "Byte array field parsed as a fixed-size null-terminated UTF-8 string.",
+		Field: true,
+	},
+	"switch-on": {
+		Key:   "switch-on",
+		Usage: "switch-on=Version,cases=1:BodyV1;2:BodyV2",
+		Doc:   "Field or parameter selecting the type of the field from the cases option.",
+		Field: true,
+	},
+	"terminator": {
+		Key:   "terminator",
+		Usage: "terminator=0x00",
+		Doc:   "Sentinel byte terminating a byte slice or string field, or a str or strz byte array within its fixed size.",
+		Field: true,
+	},
+	"varint": {
+		Key:   "varint",
+		Usage: "varint",
+		Doc:   "Variable-length integer field of base-128 groups; le (the default) for LEB128 as by encoding/binary, or be for big-endian groups. Signed integers are sign-extended.",
+		Field: true,
+	},
+	"vlq": {
+		Key:   "vlq",
+		Usage: "vlq=be",
+		Doc:   "Alias of the varint option.",
+		Field: true,
+	},
+	"webide-representation": {
+		Key:           "webide-representation",
+		Usage:         "//kaitai:webide-representation \"{name}: {value:hex}\"",
+		Doc:           "Representation of values of the struct type in the Kaitai Web IDE, output as the -webide-representation key of the type; format string with placeholders of field identifiers and optional formats (e.g. {value:hex}), optionally quoted.",
+		Type:          true,
+		DirectiveOnly: true,
+	},
+	"whence": {
+		Key:   "whence",
+		Usage: "offset-to=Body,whence=stream",
+		Doc:   "Origin of the offset of the offset-to option; start (start of the file; the default) or stream (start of the stream of the enclosing type).",
+		Field: true,
+	},
+}
@@ -0,0 +1,107 @@
+package structtag
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// Directives holds the comment directives of the struct types of a package and
+// their fields, as applied by type2kaitai; see ParseDirectives.
+type Directives struct {
+	// Comment directives of type declarations, by type name.
+	Types map[string]Tag
+	// Comment directives of struct fields, with the endian directive of their
+	// type applied to fields without one.
+	Fields map[*types.Var]Tag
+	// Field orders of the valid order directives of struct types.
+	Orders map[*types.Struct][]int
+	// Struct fields skipped by their struct tag or comment directives.
+	Skipped map[*types.Var]bool
+}
+
+// PackageDirectives returns the comment directives of the struct types declared
+// in the given files of a package, and of their fields. Directives are given in
+// the doc comment of type declarations, and in the doc or line comment of
+// fields.
+func PackageDirectives(files []*ast.File, info *types.Info) *Directives {
+	d := &Directives{
+		Types:   make(map[string]Tag),
+		Fields:  make(map[*types.Var]Tag),
+		Orders:  make(map[*types.Struct][]int),
+		Skipped: make(map[*types.Var]bool),
+	}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				doc := spec.Doc
+				if doc == nil && len(decl.Specs) == 1 {
+					doc = decl.Doc
+				}
+				typeTag := ParseDirectives(doc)
+				d.Types[spec.Name.Name] = typeTag
+				d.parseFields(spec, info, typeTag)
+			}
+		}
+	}
+	return d
+}
+
+// parseFields records the comment directives of the fields of the given struct
+// type declaration, and the field order of the order directive of the type, if
+// valid.
+func (d *Directives) parseFields(spec *ast.TypeSpec, info *types.Info, typeTag Tag) {
+	structType, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return
+	}
+	def, ok := info.Defs[spec.Name]
+	if !ok || def == nil {
+		return
+	}
+	st, ok := def.Type().Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+	if names, ok, err := typeTag.Order(); ok && err == nil {
+		if order, err := FieldOrder(st, names); err == nil {
+			d.Orders[st] = order
+		}
+	}
+	// The fields of the type checker are in order of the field names of the
+	// declaration; embedded fields have no names.
+	i := 0
+	for _, field := range structType.Fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		tag := ParseDirectives(field.Doc, field.Comment)
+		if endian, ok := typeTag["endian"]; ok && !tag.Has("endian") {
+			tag["endian"] = endian
+		}
+		for j := 0; j < n && i < st.NumFields(); j++ {
+			d.Fields[st.Field(i)] = tag
+			d.Skipped[st.Field(i)] = d.FieldTag(st, i).Has("skip")
+			i++
+		}
+	}
+}
+
+// FieldTag returns the kaitai options of the i-th field of the given struct
+// type; the options of the struct tag of the field, and the options of its
+// comment directives not present in the struct tag.
+func (d *Directives) FieldTag(st *types.Struct, i int) Tag {
+	return Parse(st.Tag(i)).Merge(d.Fields[st.Field(i)])
+}
+
+// FieldOrder returns the indices of the fields of the given struct type in the
+// order of its order directive, or nil if in declaration order.
+func (d *Directives) FieldOrder(st *types.Struct) []int {
+	return d.Orders[st]
+}
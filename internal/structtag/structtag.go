@@ -0,0 +1,698 @@
+// Package structtag parses the kaitai options of Go struct field tags.
+//
+// The kaitai tag of a struct field is a comma-separated list of key=value
+// pairs (or keys without values), e.g.
+//
+//	Magic [4]byte    `kaitai:"contents=0x7f454c46"`
+//	Name  [16]byte   `kaitai:"strz"`
+//	Title [32]uint16 `kaitai:"str,encoding=UTF-16LE"`
+//	Size  uint32     `kaitai:"endian=be"`
+//	Mod   *big.Int   `kaitai:"bigint=256"`
+//	Count uint64     `kaitai:"varint"`
+//	CRC   uint32     `kaitai:"checksum=crc32,region=Magic..Count"`
+//
+// Fields may be located at an offset stored in another field, rather than
+// inline, e.g.
+//
+//	BodyOffset uint32 `kaitai:"offset-to=Body,whence=start"`
+//	Body       Body
+//
+// or parsed from a substream of the size stored in another field, e.g.
+//
+//	Len  uint16
+//	Data []byte `kaitai:"size=Len"`
+//
+// Slices may be terminated by a sentinel, e.g.
+//
+//	Records []Record `kaitai:"repeat=until,expr=_.type == 0"`
+//	Name    []byte   `kaitai:"terminator=0xFF"`
+//	Line    string   `kaitai:"terminator=0x0a,consume=false,eos-error=false"`
+//
+// or processed before parsing, e.g.
+//
+//	Payload []byte `kaitai:"size=Len,process=zlib"`
+//
+// The type of a field may be selected by the value of another field, e.g.
+//
+//	Version uint16
+//	Body    interface{} `kaitai:"switch-on=Version,cases=1:BodyV1;2:BodyV2"`
+//
+// Options may also be given by comment directives on the field (see
+// ParseDirectives), e.g.
+//
+//	//kaitai:size Len
+//	Data []byte `json:"data"`
+package structtag
+
+import (
+	"encoding/hex"
+	"fmt"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tag holds the options of a kaitai struct field tag.
+type Tag map[string]string
+
+// Parse parses the kaitai options of the given struct field tag.
+func Parse(tag string) Tag {
+	opts := make(Tag)
+	s, ok := reflect.StructTag(tag).Lookup("kaitai")
+	if !ok {
+		return opts
+	}
+	for _, opt := range strings.Split(s, ",") {
+		opt = strings.TrimSpace(opt)
+		if len(opt) == 0 {
+			continue
+		}
+		pos := strings.Index(opt, "=")
+		if pos == -1 {
+			opts[opt] = ""
+			continue
+		}
+		opts[opt[:pos]] = opt[pos+1:]
+	}
+	return opts
+}
+
+// Has reports whether the tag has the given option.
+func (tag Tag) Has(key string) bool {
+	_, ok := tag[key]
+	return ok
+}
+
+// Contents returns the magic contents of the tag, as specified by the contents
+// option; either a 0x-prefixed hexadecimal byte string or a plain ASCII
+// string. The boolean result indicates whether the option is present.
+func (tag Tag) Contents() ([]byte, bool, error) {
+	s, ok := tag["contents"]
+	if !ok {
+		return nil, false, nil
+	}
+	if strings.HasPrefix(s, "0x") {
+		buf, err := hex.DecodeString(s[len("0x"):])
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid contents %q; %v", s, err)
+		}
+		return buf, true, nil
+	}
+	return []byte(s), true, nil
+}
+
+// Endian returns the byte order of the tag, as specified by the endian option;
+// either "le" or "be". The boolean result indicates whether the option is
+// present.
+func (tag Tag) Endian() (string, bool, error) {
+	s, ok := tag["endian"]
+	if !ok {
+		return "", false, nil
+	}
+	if s != "le" && s != "be" {
+		return "", true, fmt.Errorf("invalid endianness %q; valid options: le, be", s)
+	}
+	return s, true, nil
+}
+
+// BitEndian returns the bit order of the tag, as specified by the bit-endian
+// option; either "le" (least significant bit first) or "be" (most significant
+// bit first). The boolean result indicates whether the option is present.
+func (tag Tag) BitEndian() (string, bool, error) {
+	s, ok := tag["bit-endian"]
+	if !ok {
+		return "", false, nil
+	}
+	if s != "le" && s != "be" {
+		return "", true, fmt.Errorf("invalid bit endianness %q; valid options: le, be", s)
+	}
+	return s, true, nil
+}
+
+// Size returns the size option of the tag; either the name of the field
+// storing the size in bytes of the substream of the field, or an integer
+// literal. The boolean result indicates whether the option is present.
+func (tag Tag) Size() (string, bool, error) {
+	s, ok := tag["size"]
+	if !ok {
+		return "", false, nil
+	}
+	if len(s) == 0 {
+		return "", true, fmt.Errorf("missing value of size option")
+	}
+	return s, true, nil
+}
+
+// Repeat returns the repeat option of the tag; either "until" (repeat until
+// the Kaitai expression of the expr option is true, e.g. "_.type == end"),
+// "eos" (repeat until the end of the stream) or "expr" (repeat the number of
+// times stored in the field or integer literal of the expr option), along with
+// the expr option. The boolean result indicates whether the option is present.
+func (tag Tag) Repeat() (repeat, expr string, ok bool, err error) {
+	repeat, ok = tag["repeat"]
+	if !ok {
+		return "", "", false, nil
+	}
+	expr = tag["expr"]
+	switch repeat {
+	case "until", "expr":
+		if len(expr) == 0 {
+			return "", "", true, fmt.Errorf("missing expr option of repeat=%s", repeat)
+		}
+	case "eos":
+		// valid repeat.
+	default:
+		return "", "", true, fmt.Errorf("invalid repeat %q; valid options: until, eos, expr", repeat)
+	}
+	return repeat, expr, true, nil
+}
+
+// Terminator returns the terminator byte of the tag, as specified by the
+// terminator option (e.g. 0xFF). The boolean result indicates whether the
+// option is present.
+func (tag Tag) Terminator() (byte, bool, error) {
+	s, ok := tag["terminator"]
+	if !ok {
+		return 0, false, nil
+	}
+	x, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid terminator %q; %v", s, err)
+	}
+	return byte(x), true, nil
+}
+
+// Checksums lists the algorithms of the checksum option; CRC-32 (IEEE),
+// CRC-32C (Castagnoli), Adler-32 and CRC-64 (ECMA).
+var Checksums = []string{"crc32", "crc32c", "adler32", "crc64"}
+
+// ChecksumSize returns the size in bytes of the checksums of the given
+// algorithm.
+func ChecksumSize(algo string) int64 {
+	if algo == "crc64" {
+		return 8
+	}
+	return 4
+}
+
+// Checksum returns the algorithm (see Checksums) and region of a checksum
+// field, as specified by the checksum and region options; e.g.
+// "checksum=crc32,region=Header" or "checksum=crc32,region=Magic..Size". The
+// region is the name of a field or a range of fields of the struct type (see
+// ChecksumFields); empty if not present. The boolean result indicates whether
+// the checksum option is present.
+func (tag Tag) Checksum() (string, string, bool, error) {
+	algo, ok := tag["checksum"]
+	region, hasRegion := tag["region"]
+	if !ok {
+		if hasRegion {
+			return "", "", false, fmt.Errorf("region option only valid with checksum")
+		}
+		return "", "", false, nil
+	}
+	switch algo {
+	case "crc32", "crc32c", "adler32", "crc64":
+	default:
+		return "", "", true, fmt.Errorf("invalid checksum %q; valid options: %s", algo, strings.Join(Checksums, ", "))
+	}
+	if hasRegion && len(region) == 0 {
+		return "", "", true, fmt.Errorf("missing field name of region option")
+	}
+	return algo, region, true, nil
+}
+
+// ChecksumFields returns the indices of the fields of the given struct type in
+// the region of the checksum stored in its i-th field; the field of the given
+// name, the fields from First to Last of a range First..Last, or the fields
+// preceding the checksum field if region is empty. Fields are laid out in the
+// given order of field indices (see FieldOrder), or in declaration order if
+// order is nil. The checksum field may not be part of its region.
+func ChecksumFields(st *types.Struct, order []int, i int, region string) ([]int, error) {
+	if order == nil {
+		for j := 0; j < st.NumFields(); j++ {
+			order = append(order, j)
+		}
+	}
+	// Position of the checksum field in the layout.
+	pos := 0
+	for pos < len(order) && order[pos] != i {
+		pos++
+	}
+	if len(region) == 0 {
+		if pos == 0 {
+			return nil, fmt.Errorf("missing region of checksum; no preceding fields")
+		}
+		return append([]int(nil), order[:pos]...), nil
+	}
+	lookup := func(name string) (int, error) {
+		for j, index := range order {
+			if st.Field(index).Name() == name {
+				return j, nil
+			}
+		}
+		return 0, fmt.Errorf("region field %s not found", name)
+	}
+	first, last := region, region
+	if pos := strings.Index(region, ".."); pos != -1 {
+		first, last = region[:pos], region[pos+len(".."):]
+	}
+	start, err := lookup(first)
+	if err != nil {
+		return nil, err
+	}
+	end, err := lookup(last)
+	if err != nil {
+		return nil, err
+	}
+	if start > end {
+		return nil, fmt.Errorf("invalid region %s; %s follows %s", region, first, last)
+	}
+	if start <= pos && pos <= end {
+		return nil, fmt.Errorf("region %s includes the checksum field", region)
+	}
+	return append([]int(nil), order[start:end+1]...), nil
+}
+
+// Order returns the field names of the order directive of a struct type; the
+// order of the fields in the binary format, where it differs from the order
+// of the Go struct type (e.g. for alignment). The value is a comma-separated
+// list of field names; e.g.
+//
+//	//kaitai:order Kind,Len,Flags
+//
+// The boolean result indicates whether the option is present.
+func (tag Tag) Order() ([]string, bool, error) {
+	s, ok := tag["order"]
+	if !ok {
+		return nil, false, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			return nil, true, fmt.Errorf("invalid order %q; missing field name", s)
+		}
+		names = append(names, name)
+	}
+	return names, true, nil
+}
+
+// reID matches Kaitai identifiers.
+var reID = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ID returns the identifier of the field in the generated output, as specified
+// by the id directive, overriding the naming strategy (e.g. to keep a legacy
+// identifier); e.g.
+//
+//	//kaitai:id legacy_name
+//
+// The boolean result indicates whether the option is present.
+func (tag Tag) ID() (string, bool, error) {
+	s, ok := tag["id"]
+	if !ok {
+		return "", false, nil
+	}
+	if !reID.MatchString(s) {
+		return "", true, fmt.Errorf("invalid id %q; expected lowercase Kaitai identifier (e.g. legacy_name)", s)
+	}
+	return s, true, nil
+}
+
+// WebIDERepresentation returns the format string of the webide-representation
+// option of the tag; the representation of values of a struct type in the
+// Kaitai Web IDE, with placeholders of the fields of the type (e.g. "{name}:
+// {value:hex}"). The format string may be quoted as a Go string literal. The
+// boolean result indicates whether the option is present.
+func (tag Tag) WebIDERepresentation() (string, bool, error) {
+	s, ok := tag["webide-representation"]
+	if !ok {
+		return "", false, nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return "", true, fmt.Errorf("invalid webide-representation %s; %v", s, err)
+		}
+		s = unquoted
+	}
+	if len(s) == 0 {
+		return "", true, fmt.Errorf("missing format string of webide-representation option")
+	}
+	return s, true, nil
+}
+
+// FieldOrder returns the indices of the fields of the given struct type in the
+// order of the binary format, as given by the field names of the order
+// directive (see Order); the fields of the given names, followed by the
+// remaining fields in declaration order.
+func FieldOrder(st *types.Struct, names []string) ([]int, error) {
+	var order []int
+	listed := make(map[int]bool)
+	for _, name := range names {
+		index := -1
+		for j := 0; j < st.NumFields(); j++ {
+			if st.Field(j).Name() == name {
+				index = j
+				break
+			}
+		}
+		switch {
+		case index == -1:
+			return nil, fmt.Errorf("order field %s not found", name)
+		case listed[index]:
+			return nil, fmt.Errorf("order field %s listed more than once", name)
+		}
+		listed[index] = true
+		order = append(order, index)
+	}
+	for j := 0; j < st.NumFields(); j++ {
+		if !listed[j] {
+			order = append(order, j)
+		}
+	}
+	return order, nil
+}
+
+// Varint returns the byte order of the base-128 groups of a variable-length
+// integer field, as specified by the varint option or its alias vlq; either
+// "le" for LEB128 (the default, as by encoding/binary) or "be" for big-endian
+// groups (e.g. MIDI). The boolean result indicates whether the option is
+// present.
+func (tag Tag) Varint() (string, bool, error) {
+	s, ok := tag["varint"]
+	if vlq, found := tag["vlq"]; found {
+		if ok {
+			return "", true, fmt.Errorf("varint and vlq options are aliases; use one of them")
+		}
+		s, ok = vlq, true
+	}
+	if !ok {
+		return "", false, nil
+	}
+	switch s {
+	case "", "le":
+		return "le", true, nil
+	case "be":
+		return "be", true, nil
+	}
+	return "", true, fmt.Errorf("invalid varint %q; valid options: le, be", s)
+}
+
+// BigIntSigns lists the sign conventions of arbitrary-precision integers;
+// unsigned, two's complement, and sign-magnitude with the sign in the most
+// significant bit.
+var BigIntSigns = []string{"unsigned", "twos-complement", "sign-magnitude"}
+
+// BigInt returns the size in bytes and the sign convention (see BigIntSigns)
+// of an arbitrary-precision integer field (big.Int or *big.Int), as specified
+// by the bigint and bigint-sign options; e.g. "bigint=32" or
+// "bigint=32,bigint-sign=twos-complement". The sign convention is unsigned by
+// default. The boolean result indicates whether the bigint option is present.
+func (tag Tag) BigInt() (int64, string, bool, error) {
+	sign := "unsigned"
+	if s, ok := tag["bigint-sign"]; ok {
+		switch s {
+		case "unsigned", "twos-complement", "sign-magnitude":
+			sign = s
+		default:
+			return 0, "", true, fmt.Errorf("invalid bigint-sign %q; valid options: %s", s, strings.Join(BigIntSigns, ", "))
+		}
+	}
+	s, ok := tag["bigint"]
+	if !ok {
+		if tag.Has("bigint-sign") {
+			return 0, "", false, fmt.Errorf("bigint-sign option only valid with bigint")
+		}
+		return 0, "", false, nil
+	}
+	size, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || size <= 0 {
+		return 0, "", true, fmt.Errorf("invalid bigint %q; expected size in bytes", s)
+	}
+	return size, sign, true, nil
+}
+
+// TermOptions holds the options of the terminator of null-terminated strings
+// (strz option) and of fields tagged with the terminator option.
+type TermOptions struct {
+	// Include the terminator in the value.
+	Include bool
+	// Consume the terminator from the stream; true by default.
+	Consume bool
+	// Fail if the end of the stream is reached before the terminator; true by
+	// default.
+	EOSError bool
+}
+
+// termKeys lists the keys of the terminator options, in order.
+var termKeys = []string{"include", "consume", "eos-error"}
+
+// TermOptions returns the include, consume and eos-error options of the
+// terminator of the tag; e.g. "strz,include,consume=false". Options without
+// value are true. The boolean result indicates whether any of the options is
+// present.
+func (tag Tag) TermOptions() (TermOptions, bool, error) {
+	opts := TermOptions{Consume: true, EOSError: true}
+	dsts := map[string]*bool{
+		"include":   &opts.Include,
+		"consume":   &opts.Consume,
+		"eos-error": &opts.EOSError,
+	}
+	found := false
+	for _, key := range termKeys {
+		s, ok := tag[key]
+		if !ok {
+			continue
+		}
+		found = true
+		if len(s) == 0 {
+			*dsts[key] = true
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return opts, true, fmt.Errorf("invalid %s %q; valid options: true, false", key, s)
+		}
+		*dsts[key] = b
+	}
+	if found && !tag.Has("strz") && !tag.Has("terminator") {
+		return opts, true, fmt.Errorf("include, consume and eos-error options only valid with strz or terminator")
+	}
+	return opts, found, nil
+}
+
+// reProcess matches the supported Kaitai process routines; zlib, xor(key),
+// rol(n) and ror(n).
+var reProcess = regexp.MustCompile(`^(zlib|xor\((0x[0-9a-fA-F]+|[0-9]+)\)|ro[lr]\([0-9]+\))$`)
+
+// Process returns the Kaitai process routine applied to the bytes of a field,
+// as specified by the process option; e.g. zlib, xor(0x5a), rol(3) or ror(3).
+// The boolean result indicates whether the option is present.
+func (tag Tag) Process() (string, bool, error) {
+	s, ok := tag["process"]
+	if !ok {
+		return "", false, nil
+	}
+	if !reProcess.MatchString(s) {
+		return "", true, fmt.Errorf("invalid process %q; valid options: zlib, xor(key), rol(n), ror(n)", s)
+	}
+	return s, true, nil
+}
+
+// Encodings lists the canonical names of the character encodings of the
+// encoding option, as supported by Kaitai Struct.
+var Encodings = []string{
+	"ASCII", "UTF-8",
+	"UTF-16", "UTF-16LE", "UTF-16BE",
+	"UTF-32", "UTF-32LE", "UTF-32BE",
+	"ISO-8859-1", "ISO-8859-2", "ISO-8859-3", "ISO-8859-4", "ISO-8859-5",
+	"ISO-8859-6", "ISO-8859-7", "ISO-8859-8", "ISO-8859-9", "ISO-8859-10",
+	"ISO-8859-11", "ISO-8859-13", "ISO-8859-14", "ISO-8859-15", "ISO-8859-16",
+	"windows-1250", "windows-1251", "windows-1252", "windows-1253",
+	"windows-1254", "windows-1255", "windows-1256", "windows-1257",
+	"windows-1258",
+	"IBM437", "IBM866", "KOI8-R", "Shift_JIS", "EUC-JP", "EUC-KR", "GB2312",
+	"GBK", "Big5",
+}
+
+// encodingAliases maps from alternative names of character encodings,
+// normalized by normEncoding, to their canonical names.
+var encodingAliases = map[string]string{
+	"us-ascii": "ASCII",
+	"utf8":     "UTF-8",
+	"utf16":    "UTF-16",
+	"utf16le":  "UTF-16LE",
+	"utf16be":  "UTF-16BE",
+	"utf32":    "UTF-32",
+	"utf32le":  "UTF-32LE",
+	"utf32be":  "UTF-32BE",
+	"latin1":   "ISO-8859-1",
+	"cp437":    "IBM437",
+	"cp866":    "IBM866",
+	"cp1252":   "windows-1252",
+	"sjis":     "Shift_JIS",
+	"cp932":    "Shift_JIS",
+	"eucjp":    "EUC-JP",
+	"euckr":    "EUC-KR",
+	"big5":     "Big5",
+}
+
+// normEncoding returns the given encoding name in lower case, without
+// underscores and hyphens.
+func normEncoding(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// Encoding returns the canonical name of the character encoding of a string
+// field, as specified by the encoding option; e.g. UTF-16LE or Shift_JIS.
+// Names are matched regardless of case, underscores and hyphens (e.g.
+// SHIFT_JIS or utf16le). UTF-16 and UTF-32 without byte order are encoded in
+// the byte order of the field. The boolean result indicates whether the option
+// is present.
+func (tag Tag) Encoding() (string, bool, error) {
+	s, ok := tag["encoding"]
+	if !ok {
+		return "", false, nil
+	}
+	norm := normEncoding(s)
+	if name, ok := encodingAliases[norm]; ok {
+		return name, true, nil
+	}
+	for _, name := range Encodings {
+		if normEncoding(name) == norm {
+			return name, true, nil
+		}
+	}
+	return "", true, fmt.Errorf("unsupported encoding %q; valid options: %s", s, strings.Join(Encodings, ", "))
+}
+
+// EncodingUnitSize returns the size in bytes of the code units of the given
+// canonical encoding; 2 for UTF-16, 4 for UTF-32 and 1 otherwise (including
+// multi-byte encodings of variable length such as Shift_JIS).
+func EncodingUnitSize(name string) int64 {
+	switch {
+	case strings.HasPrefix(name, "UTF-16"):
+		return 2
+	case strings.HasPrefix(name, "UTF-32"):
+		return 4
+	}
+	return 1
+}
+
+// StrEncoding returns the encoding of a string of code units of the given size
+// in bytes; the given canonical encoding (e.g. of the encoding option), or
+// UTF-8 by default (UTF-16 and UTF-32 of 2- and 4-byte code units). The given
+// byte order, "le" or "be", is appended to UTF-16 and UTF-32 without byte
+// order.
+func StrEncoding(encoding string, unitSize int64, endian string) (string, error) {
+	if len(encoding) == 0 {
+		switch unitSize {
+		case 2:
+			encoding = "UTF-16"
+		case 4:
+			encoding = "UTF-32"
+		default:
+			encoding = "UTF-8"
+		}
+	}
+	if unitSize > 1 && EncodingUnitSize(encoding) != unitSize {
+		return "", fmt.Errorf("encoding %s not valid for %d-byte code units", encoding, unitSize)
+	}
+	if encoding == "UTF-16" || encoding == "UTF-32" {
+		encoding += strings.ToUpper(endian)
+	}
+	return encoding, nil
+}
+
+// Case is a case of the switch-on option, selecting the Go type of a field by
+// value.
+type Case struct {
+	// Integer literal, constant name, or "_" for the default case.
+	Value string
+	// Go type name.
+	Type string
+}
+
+// SwitchOn returns the field or parameter of the switch-on option, which
+// selects the type of a field (e.g. by version of the file format) from the
+// cases of the cases option; a semicolon-separated list of value:Type pairs,
+// e.g. "1:BodyV1;2:BodyV2;_:BodyV2". The boolean result indicates whether the
+// option is present.
+func (tag Tag) SwitchOn() (on string, cases []Case, ok bool, err error) {
+	on, ok = tag["switch-on"]
+	if !ok {
+		return "", nil, false, nil
+	}
+	if len(on) == 0 {
+		return "", nil, true, fmt.Errorf("missing value of switch-on option")
+	}
+	s, ok := tag["cases"]
+	if !ok || len(s) == 0 {
+		return "", nil, true, fmt.Errorf("missing cases option of switch-on")
+	}
+	for _, c := range strings.Split(s, ";") {
+		pos := strings.Index(c, ":")
+		if pos == -1 {
+			return "", nil, true, fmt.Errorf("invalid case %q; expected value:Type", c)
+		}
+		value, typ := strings.TrimSpace(c[:pos]), strings.TrimSpace(c[pos+1:])
+		if len(value) == 0 || len(typ) == 0 {
+			return "", nil, true, fmt.Errorf("invalid case %q; expected value:Type", c)
+		}
+		cases = append(cases, Case{Value: value, Type: typ})
+	}
+	return on, cases, true, nil
+}
+
+// OffsetTo returns the target of the offset-to option of an integer field
+// storing the offset of another structure, and the origin of the offset as
+// specified by the whence option; either "start" (start of the file; the
+// default) or "stream" (start of the stream of the enclosing type). The target
+// is the name of a struct field, or the name of a type. The boolean result
+// indicates whether the option is present.
+func (tag Tag) OffsetTo() (target, whence string, ok bool, err error) {
+	target, ok = tag["offset-to"]
+	if !ok {
+		return "", "", false, nil
+	}
+	if len(target) == 0 {
+		return "", "", true, fmt.Errorf("missing target of offset-to option")
+	}
+	whence, ok = tag["whence"]
+	if !ok {
+		whence = "start"
+	}
+	if whence != "start" && whence != "stream" {
+		return "", "", true, fmt.Errorf("invalid whence %q; valid options: start, stream", whence)
+	}
+	return target, whence, true, nil
+}
+
+// OffsetTargets returns the fields of the given struct type located by the
+// offset-to option of other fields, rather than stored inline, mapped to the
+// names of the fields storing their offsets.
+func OffsetTargets(st *types.Struct) map[*types.Var]string {
+	return OffsetTargetsFunc(st, func(i int) Tag {
+		return Parse(st.Tag(i))
+	})
+}
+
+// OffsetTargetsFunc is like OffsetTargets, but the options of the i-th field
+// of the struct type are given by tagOf (e.g. including comment directives).
+func OffsetTargetsFunc(st *types.Struct, tagOf func(i int) Tag) map[*types.Var]string {
+	targets := make(map[*types.Var]string)
+	for i := 0; i < st.NumFields(); i++ {
+		target, _, ok, err := tagOf(i).OffsetTo()
+		if !ok || err != nil {
+			continue
+		}
+		for j := 0; j < st.NumFields(); j++ {
+			if field := st.Field(j); field.Name() == target {
+				targets[field] = st.Field(i).Name()
+			}
+		}
+	}
+	return targets
+}